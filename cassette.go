@@ -0,0 +1,525 @@
+package claude
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Cassette is a recorded Claude CLI run: the arguments it was launched with,
+// every stdout and stdin line with its timing, and how it exited.
+//
+// Cassettes are written by CassetteRecorder and replayed by CassettePlayer,
+// letting regression tests, demos, and bug reports reproduce an exact run
+// without the CLI or network access.
+type Cassette struct {
+	// Args are the CLI arguments produced by buildArgs for the run, as
+	// recorded: the prompt is replaced by its length, secret values by
+	// "[REDACTED]", and per-run temp files (the MCP config and hook
+	// settings) by "<tmp>/..." placeholders.
+	Args []string
+
+	// RecordedAt is when the process was spawned.
+	RecordedAt time.Time
+
+	// Events are the stdout and stdin lines in the order they were seen.
+	Events []CassetteEvent
+
+	// Stderr is the process's complete standard error.
+	Stderr string
+
+	// ExitCode is the process exit code (-1 if killed by a signal).
+	ExitCode int
+}
+
+// CassetteEvent is one recorded line.
+type CassetteEvent struct {
+	// Offset is the time since the process was spawned.
+	Offset time.Duration
+
+	// Stdin is true for lines the SDK wrote to the CLI (stream-json input)
+	// and false for lines the CLI wrote to stdout.
+	Stdin bool
+
+	// Line is the raw line without its trailing newline.
+	Line string
+}
+
+// cassetteEntry is the on-disk form: one JSON object per line.
+type cassetteEntry struct {
+	Kind     string     `json:"kind"` // "start", "stdout", "stdin", "exit"
+	Run      int        `json:"run"`  // 1-based; distinguishes concurrent runs
+	OffsetMS int64      `json:"offset_ms,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+	Args     []string   `json:"args,omitempty"`
+	Line     string     `json:"line,omitempty"`
+	Code     int        `json:"code,omitempty"`
+	Stderr   string     `json:"stderr,omitempty"`
+}
+
+// ReadCassettes parses all runs from a cassette stream.
+//
+// A stream holds one run per "start" entry, so a recorder shared by several
+// sessions produces a multi-run cassette. Runs are returned in spawn order.
+func ReadCassettes(r io.Reader) ([]*Cassette, error) {
+	var runs []*Cassette
+	byID := make(map[int]*Cassette)
+
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var e cassetteEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("cassette entry %d: %w", n, err)
+		}
+
+		if e.Kind == "start" {
+			c := &Cassette{Args: e.Args}
+			if e.Time != nil {
+				c.RecordedAt = *e.Time
+			}
+			byID[e.Run] = c
+			runs = append(runs, c)
+			continue
+		}
+		cur, ok := byID[e.Run]
+		if !ok {
+			return nil, fmt.Errorf("cassette entry %d: %q for unknown run %d", n, e.Kind, e.Run)
+		}
+
+		offset := time.Duration(e.OffsetMS) * time.Millisecond
+		switch e.Kind {
+		case "stdout", "stdin":
+			cur.Events = append(cur.Events, CassetteEvent{
+				Offset: offset,
+				Stdin:  e.Kind == "stdin",
+				Line:   e.Line,
+			})
+		case "exit":
+			cur.ExitCode = e.Code
+			cur.Stderr = e.Stderr
+		default:
+			return nil, fmt.Errorf("cassette entry %d: unknown kind %q", n, e.Kind)
+		}
+	}
+
+	return runs, nil
+}
+
+// LoadCassettes reads all runs from a cassette file.
+func LoadCassettes(path string) ([]*Cassette, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCassettes(f)
+}
+
+// LoadCassette reads a single-run cassette file. If the file holds several
+// runs, the first is returned.
+func LoadCassette(path string) (*Cassette, error) {
+	runs, err := LoadCassettes(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("cassette %s: no recorded runs", path)
+	}
+	return runs[0], nil
+}
+
+// Messages parses the recorded stdout lines into StreamMessages, skipping
+// lines that are empty or not valid JSON.
+func (c *Cassette) Messages() []StreamMessage {
+	var msgs []StreamMessage
+	for _, ev := range c.Events {
+		if ev.Stdin || len(bytes.TrimSpace([]byte(ev.Line))) == 0 {
+			continue
+		}
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(ev.Line), &msg); err == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// ---------------------------------------------------------------------------
+// Recording
+// ---------------------------------------------------------------------------
+
+// CassetteRecorder is a Spawner that records every process it starts.
+//
+// It wraps another Spawner (nil means ExecSpawner) and tees each stdout and
+// stdin line, with its offset from spawn time, to the cassette writer. Each
+// spawned process becomes one run in the cassette. Environment variables are
+// never recorded, and arguments are redacted as described on Cassette.Args.
+//
+// Example:
+//
+//	f, _ := os.Create("testdata/run.cassette")
+//	defer f.Close()
+//
+//	rec := claude.NewCassetteRecorder(f, nil)
+//	session, _ := claude.NewSession(claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{Spawner: rec},
+//	})
+type CassetteRecorder struct {
+	inner Spawner
+
+	mu   sync.Mutex
+	enc  *json.Encoder
+	runs int
+	err  error
+}
+
+// NewCassetteRecorder creates a recorder writing to w. If inner is nil the
+// CLI is started with ExecSpawner.
+func NewCassetteRecorder(w io.Writer, inner Spawner) *CassetteRecorder {
+	if inner == nil {
+		inner = ExecSpawner{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &CassetteRecorder{inner: inner, enc: enc}
+}
+
+// Err returns the first error encountered writing the cassette, if any.
+func (r *CassetteRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Spawn implements Spawner.
+func (r *CassetteRecorder) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	proc, err := r.inner.Spawn(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.runs++
+	run := r.runs
+	r.mu.Unlock()
+
+	now := time.Now()
+	p := &recordingProcess{Process: proc, rec: r, run: run, start: now}
+	r.write(cassetteEntry{Kind: "start", Run: run, Time: &now, Args: cassetteArgs(cfg.Args)})
+
+	p.stdout = &lineTee{r: proc.Stdout(), emit: func(line string) { p.record("stdout", line) }}
+	p.stderr = io.TeeReader(proc.Stderr(), &p.stderrBuf)
+	if stdin := proc.Stdin(); stdin != nil {
+		p.stdin = &stdinTee{WriteCloser: stdin, emit: func(line string) { p.record("stdin", line) }}
+	}
+
+	return p, nil
+}
+
+func (r *CassetteRecorder) write(e cassetteEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	r.err = r.enc.Encode(e)
+}
+
+// recordingProcess tees a Process's streams into a CassetteRecorder.
+type recordingProcess struct {
+	Process
+	rec   *CassetteRecorder
+	run   int
+	start time.Time
+
+	stdin     io.WriteCloser
+	stdout    io.Reader
+	stderr    io.Reader
+	stderrBuf bytes.Buffer
+}
+
+func (p *recordingProcess) Stdin() io.WriteCloser { return p.stdin }
+func (p *recordingProcess) Stdout() io.Reader     { return p.stdout }
+func (p *recordingProcess) Stderr() io.Reader     { return p.stderr }
+
+// Wait records the exit entry once the process has been reaped. Launcher
+// drains stderr before calling Wait, so the recorded stderr is complete.
+func (p *recordingProcess) Wait() error {
+	err := p.Process.Wait()
+
+	code := 0
+	var coder exitCoder
+	if errors.As(err, &coder) {
+		code = coder.ExitCode()
+	}
+	p.rec.write(cassetteEntry{
+		Kind:     "exit",
+		Run:      p.run,
+		OffsetMS: time.Since(p.start).Milliseconds(),
+		Code:     code,
+		Stderr:   p.stderrBuf.String(),
+	})
+
+	return err
+}
+
+func (p *recordingProcess) record(kind, line string) {
+	p.rec.write(cassetteEntry{
+		Kind:     kind,
+		Run:      p.run,
+		OffsetMS: time.Since(p.start).Milliseconds(),
+		Line:     line,
+	})
+}
+
+// lineTee passes reads through and emits each complete line. A final line
+// without a trailing newline is emitted at EOF.
+type lineTee struct {
+	r       io.Reader
+	emit    func(string)
+	partial []byte
+}
+
+func (t *lineTee) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.partial = append(t.partial, p[:n]...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.emit(string(bytes.TrimSuffix(t.partial[:i], []byte("\r"))))
+		t.partial = t.partial[i+1:]
+	}
+	if err != nil && len(t.partial) > 0 {
+		t.emit(string(t.partial))
+		t.partial = nil
+	}
+	return n, err
+}
+
+// stdinTee records each line written to the CLI's stdin.
+type stdinTee struct {
+	io.WriteCloser
+	emit    func(string)
+	partial []byte
+}
+
+func (t *stdinTee) Write(p []byte) (int, error) {
+	n, err := t.WriteCloser.Write(p)
+	t.partial = append(t.partial, p[:n]...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.emit(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	return n, err
+}
+
+// ---------------------------------------------------------------------------
+// Replay
+// ---------------------------------------------------------------------------
+
+// CassettePlayer is a Spawner that replays recorded runs instead of
+// starting the CLI.
+//
+// Each Spawn replays the next run. Recorded stdin lines act as turn
+// boundaries: replay pauses until the SDK writes a line, so Conversation
+// replays turn by turn. The real process exit code and stderr are
+// reproduced, so Launcher surfaces the same *ExitError.
+//
+// Example:
+//
+//	cassette, err := claude.LoadCassette("testdata/run.cassette")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	session, _ := claude.NewSession(claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{Spawner: claude.NewCassettePlayer(cassette)},
+//	})
+type CassettePlayer struct {
+	// Speed scales recorded timing: 1 replays in real time, 2 twice as
+	// fast. Zero (the default) replays without delays.
+	Speed float64
+
+	// MatchArgs makes Spawn fail if the launch arguments differ from the
+	// recorded ones, catching option drift in regression tests. The
+	// arguments are redacted and normalized as on Cassette.Args before
+	// comparing, so per-run temp paths do not count as drift.
+	MatchArgs bool
+
+	mu        sync.Mutex
+	cassettes []*Cassette
+	next      int
+}
+
+// NewCassettePlayer creates a player that replays cassettes in order.
+func NewCassettePlayer(cassettes ...*Cassette) *CassettePlayer {
+	return &CassettePlayer{cassettes: cassettes}
+}
+
+// Spawn implements Spawner.
+func (cp *CassettePlayer) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	cp.mu.Lock()
+	if cp.next >= len(cp.cassettes) {
+		cp.mu.Unlock()
+		return nil, ErrCassetteExhausted
+	}
+	c := cp.cassettes[cp.next]
+	cp.next++
+	cp.mu.Unlock()
+
+	if cp.MatchArgs {
+		if args := cassetteArgs(cfg.Args); !slices.Equal(args, c.Args) {
+			return nil, fmt.Errorf("cassette args mismatch: recorded %q, got %q", c.Args, args)
+		}
+	}
+
+	return startReplay(ctx, c, cp.Speed), nil
+}
+
+// replayProcess is the Process produced by CassettePlayer.
+type replayProcess struct {
+	stdinR  *io.PipeReader
+	stdinW  *io.PipeWriter
+	stdoutR *io.PipeReader
+	stdoutW *io.PipeWriter
+	stderr  *io.PipeReader
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	code     int
+}
+
+func startReplay(ctx context.Context, c *Cassette, speed float64) *replayProcess {
+	p := &replayProcess{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	p.stdinR, p.stdinW = io.Pipe()
+	p.stdoutR, p.stdoutW = io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	p.stderr = stderrR
+
+	go func() {
+		defer close(p.done)
+		p.code = p.play(c, speed)
+		if p.code == c.ExitCode {
+			io.WriteString(stderrW, c.Stderr)
+		}
+		stderrW.Close()
+		p.stdoutW.Close()
+		p.stdinR.CloseWithError(io.ErrClosedPipe)
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Kill()
+		case <-p.done:
+		}
+	}()
+
+	return p
+}
+
+// play writes the recorded lines and returns the exit code to report.
+func (p *replayProcess) play(c *Cassette, speed float64) int {
+	in := bufio.NewReader(p.stdinR)
+	start := time.Now()
+
+	for _, ev := range c.Events {
+		if ev.Stdin {
+			if _, err := in.ReadString('\n'); err != nil {
+				return p.killedOr(c.ExitCode)
+			}
+			// Later output is timed relative to when the input arrived.
+			if speed > 0 {
+				start = time.Now().Add(-time.Duration(float64(ev.Offset) / speed))
+			}
+			continue
+		}
+
+		if speed > 0 {
+			due := time.Duration(float64(ev.Offset) / speed)
+			if wait := due - time.Since(start); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-p.stop:
+					timer.Stop()
+					return -1
+				}
+			}
+		}
+
+		if _, err := io.WriteString(p.stdoutW, ev.Line+"\n"); err != nil {
+			return p.killedOr(c.ExitCode)
+		}
+	}
+
+	// Like the CLI, stay alive until stdin is closed.
+	io.Copy(io.Discard, in)
+	return p.killedOr(c.ExitCode)
+}
+
+func (p *replayProcess) killedOr(code int) int {
+	select {
+	case <-p.stop:
+		return -1
+	default:
+		return code
+	}
+}
+
+func (p *replayProcess) Stdin() io.WriteCloser { return p.stdinW }
+func (p *replayProcess) Stdout() io.Reader     { return p.stdoutR }
+func (p *replayProcess) Stderr() io.Reader     { return p.stderr }
+func (p *replayProcess) Pid() int              { return 0 }
+
+// Signal stops the replay; recorded runs cannot react to signals.
+func (p *replayProcess) Signal(sig os.Signal) error {
+	return p.Kill()
+}
+
+func (p *replayProcess) Kill() error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.stdinR.CloseWithError(io.ErrClosedPipe)
+	p.stdoutW.Close()
+	return nil
+}
+
+func (p *replayProcess) Wait() error {
+	<-p.done
+	if p.code != 0 {
+		return replayExitError(p.code)
+	}
+	return nil
+}
+
+// replayExitError reports the recorded non-zero exit code.
+type replayExitError int
+
+func (e replayExitError) Error() string {
+	if e < 0 {
+		return "signal: killed"
+	}
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e replayExitError) ExitCode() int { return int(e) }
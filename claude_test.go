@@ -1,11 +1,34 @@
 package claude
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"github.com/MateoSegura/claudesdk-go/internal/hookshim"
+	"github.com/MateoSegura/claudesdk-go/mcp"
+	"github.com/MateoSegura/claudesdk-go/schema"
 )
 
 // ---------------------------------------------------------------------------
@@ -49,10 +72,34 @@ func TestBuildArgsBase(t *testing.T) {
 	assertContains(t, args, "stream-json")
 	assertContains(t, args, "--verbose")
 
-	// Prompt must be last
+	// Prompt must be last, after "--" so variadic flags cannot consume it
 	if args[len(args)-1] != "hello world" {
 		t.Errorf("prompt not last arg: got %q", args[len(args)-1])
 	}
+	if args[len(args)-2] != "--" {
+		t.Errorf("prompt should follow --, got %q", args[len(args)-2])
+	}
+}
+
+func TestBuildArgsPromptAfterVariadicFlags(t *testing.T) {
+	// --allowedTools and --mcp-config take any number of values; without
+	// "--" the CLI would read the prompt as one more tool or config.
+	args, err := buildArgs("-v fix the tests", LaunchOptions{
+		AllowedTools:    []string{"Read", "Bash"},
+		DisallowedTools: []string{"Write"},
+	}, "/tmp/mcp.json")
+	if err != nil {
+		t.Fatalf("buildArgs() error: %v", err)
+	}
+	sep := indexOfArg(args, "--")
+	if sep != len(args)-2 || args[len(args)-1] != "-v fix the tests" {
+		t.Fatalf("prompt should be the only arg after --: %q", args)
+	}
+	for _, flag := range []string{"--allowedTools", "--disallowedTools", "--mcp-config"} {
+		if i := indexOfArg(args, flag); i < 0 || i > sep {
+			t.Errorf("%s at %d, want before -- at %d", flag, i, sep)
+		}
+	}
 }
 
 func TestBuildArgsPermissionMode(t *testing.T) {
@@ -331,6 +378,14 @@ func TestBuildArgsIncludePartialMessages(t *testing.T) {
 func TestBuildArgsInputFormat(t *testing.T) {
 	args, _ := buildArgs("test", LaunchOptions{InputFormat: "stream-json"}, "")
 	assertContainsPair(t, args, "--input-format", "stream-json")
+
+	// Prompt is delivered over stdin in stream-json mode
+	assertNotContains(t, args, "test")
+
+	args, _ = buildArgs("test", LaunchOptions{InputFormat: InputFormatText}, "")
+	if args[len(args)-1] != "test" {
+		t.Errorf("prompt not last arg in text mode: got %q", args[len(args)-1])
+	}
 }
 
 func TestBuildArgsSettings(t *testing.T) {
@@ -947,6 +1002,262 @@ func TestStreamMessageJSONParsing(t *testing.T) {
 			t.Errorf("subtype = %q, want error_max_turns", msg.Subtype)
 		}
 	})
+
+	t.Run("tool result content", func(t *testing.T) {
+		raw := `{"type":"user","message":{"role":"user","content":[` +
+			`{"type":"tool_result","tool_use_id":"t1","content":"exit 0"},` +
+			`{"type":"tool_result","tool_use_id":"t2","content":[{"type":"text","text":"page 1"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}},{"type":"text","text":"page 2"}]},` +
+			`{"type":"tool_result","tool_use_id":"t3","content":""}]}}`
+
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if msg.Message == nil || len(msg.Message.Content) != 3 {
+			t.Fatal("unexpected message content")
+		}
+		if got := msg.Message.Content[0].ContentText(); got != "exit 0" {
+			t.Errorf("string content = %q, want %q", got, "exit 0")
+		}
+		array := msg.Message.Content[1]
+		if len(array.Content) != 3 {
+			t.Fatalf("array content = %+v", array.Content)
+		}
+		if got := array.ContentText(); got != "page 1\npage 2" {
+			t.Errorf("array ContentText() = %q", got)
+		}
+		if img := array.Content[1]; !img.IsImage() || img.Source == nil || img.Source.MediaType != "image/png" {
+			t.Errorf("image block = %+v", img)
+		}
+		if empty := msg.Message.Content[2]; empty.Content != nil {
+			t.Errorf("empty content = %+v, want nil", empty.Content)
+		}
+	})
+
+	t.Run("string message content", func(t *testing.T) {
+		raw := `{"type":"user","message":{"role":"user","content":"Fix the build"}}`
+
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if got := ExtractText(&msg); got != "Fix the build" {
+			t.Errorf("ExtractText() = %q, want %q", got, "Fix the build")
+		}
+	})
+
+	t.Run("server tools and redacted thinking", func(t *testing.T) {
+		raw := `{"type":"assistant","message":{"role":"assistant","content":[` +
+			`{"type":"redacted_thinking","data":"EmwKAhgB"},` +
+			`{"type":"server_tool_use","id":"srvtoolu_1","name":"web_search","input":{"query":"go 1.24"}},` +
+			`{"type":"web_search_tool_result","tool_use_id":"srvtoolu_1","content":[{"type":"web_search_result","url":"https://go.dev/doc/go1.24","title":"Go 1.24 Release Notes","encrypted_content":"Eqw=","page_age":"3 months ago"}]},` +
+			`{"type":"web_search_tool_result","tool_use_id":"srvtoolu_2","content":{"type":"web_search_tool_result_error","error_code":"max_uses_exceeded"}}]}}`
+
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatalf("unmarshal error: %v", err)
+		}
+		if msg.Message == nil || len(msg.Message.Content) != 4 {
+			t.Fatal("unexpected message content")
+		}
+		blocks := msg.Message.Content
+		if !blocks[0].IsRedactedThinking() || blocks[0].Data != "EmwKAhgB" {
+			t.Errorf("redacted thinking = %+v", blocks[0])
+		}
+		if !blocks[1].IsServerToolUse() || blocks[1].IsToolUse() || blocks[1].Input["query"] != "go 1.24" {
+			t.Errorf("server tool use = %+v", blocks[1])
+		}
+		if results := blocks[2].Content; len(results) != 1 || results[0].URL != "https://go.dev/doc/go1.24" ||
+			results[0].Title != "Go 1.24 Release Notes" || results[0].PageAge != "3 months ago" {
+			t.Errorf("web search results = %+v", results)
+		}
+		if errs := blocks[3].Content; len(errs) != 1 || errs[0].ErrorCode != "max_uses_exceeded" {
+			t.Errorf("web search error = %+v", errs)
+		}
+	})
+}
+
+func TestBlocksMarshal(t *testing.T) {
+	block := ContentBlock{
+		Type:      "tool_result",
+		ToolUseID: "t1",
+		Content:   Blocks{{Type: "text", Text: "ok"}},
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"ok"}]}`
+	if string(data) != want {
+		t.Errorf("Marshal() = %s, want %s", data, want)
+	}
+
+	var back ContentBlock
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.ContentText() != "ok" {
+		t.Errorf("round trip ContentText() = %q, want %q", back.ContentText(), "ok")
+	}
+
+	var bad Blocks
+	if err := json.Unmarshal([]byte(`42`), &bad); err == nil {
+		t.Error("Unmarshal(42) should fail")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Partial messages
+// ---------------------------------------------------------------------------
+
+// partialStream is a thinking block, a text block, and a tool_use block
+// streamed as stream_event lines, as emitted with --include-partial-messages.
+var partialStream = []string{
+	`{"type":"stream_event","event":{"type":"message_start","message":{"model":"claude-sonnet-4-20250514","id":"msg_1","type":"message","role":"assistant","content":[],"stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}},"session_id":"s1","parent_tool_use_id":null}`,
+	`{"type":"stream_event","event":{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Let me "}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"check."}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig=="}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_stop","index":0}}`,
+	`{"type":"stream_event","event":{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hello"}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":" world"}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_stop","index":1}}`,
+	`{"type":"stream_event","event":{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"tu_1","name":"Read","input":{}}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"file_"}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"path\":\"go.mod\"}"}}}`,
+	`{"type":"stream_event","event":{"type":"content_block_stop","index":2}}`,
+	`{"type":"stream_event","event":{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"input_tokens":10,"output_tokens":42}}}`,
+	`{"type":"stream_event","event":{"type":"message_stop"}}`,
+}
+
+func parseLines(t *testing.T, lines []string) []StreamMessage {
+	t.Helper()
+	msgs := make([]StreamMessage, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &msgs[i]); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+	}
+	return msgs
+}
+
+func TestStreamEventParsing(t *testing.T) {
+	msgs := parseLines(t, partialStream)
+
+	start := msgs[0]
+	if !IsStreamEvent(&start) || start.Event.Type != "message_start" {
+		t.Fatalf("message_start = %+v", start)
+	}
+	if start.Event.Message == nil || start.Event.Message.ID != "msg_1" || start.Event.Message.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("message_start message = %+v", start.Event.Message)
+	}
+
+	blockStart := msgs[6].Event
+	if blockStart.Index != 1 || blockStart.ContentBlock == nil || blockStart.ContentBlock.Type != "text" {
+		t.Errorf("content_block_start = %+v", blockStart)
+	}
+
+	if got := ExtractTextDelta(&msgs[7]); got != "Hello" {
+		t.Errorf("ExtractTextDelta() = %q, want Hello", got)
+	}
+	if got := ExtractTextDelta(&msgs[2]); got != "" {
+		t.Errorf("ExtractTextDelta(thinking) = %q, want empty", got)
+	}
+	if ExtractTextDelta(nil) != "" || IsStreamEvent(nil) {
+		t.Error("nil message should yield no delta")
+	}
+
+	msgDelta := msgs[14].Event
+	if msgDelta.Delta.StopReason != "tool_use" || msgDelta.Usage.OutputTokens != 42 {
+		t.Errorf("message_delta = %+v / %+v", msgDelta.Delta, msgDelta.Usage)
+	}
+}
+
+func TestPartialAccumulator(t *testing.T) {
+	acc := NewPartialAccumulator()
+	if acc.Message() != nil {
+		t.Error("Message() should be nil before message_start")
+	}
+
+	var deltas []string
+	for _, msg := range parseLines(t, partialStream) {
+		if d := acc.Add(&msg); d != nil && d.Type == "text_delta" {
+			deltas = append(deltas, d.Text)
+			if !strings.HasSuffix(acc.Text(), d.Text) {
+				t.Errorf("Text() = %q should end with delta %q", acc.Text(), d.Text)
+			}
+		}
+	}
+
+	if strings.Join(deltas, "|") != "Hello| world" {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if !acc.Done() {
+		t.Error("Done() should be true after message_stop")
+	}
+
+	m := acc.Message()
+	if m.ID != "msg_1" || m.Role != "assistant" || m.StopReason != "tool_use" || m.Usage.OutputTokens != 42 {
+		t.Errorf("message = %+v", m)
+	}
+	if len(m.Content) != 3 {
+		t.Fatalf("len(Content) = %d, want 3", len(m.Content))
+	}
+	if b := m.Content[0]; b.Thinking != "Let me check." || b.Signature != "sig==" {
+		t.Errorf("thinking block = %+v", b)
+	}
+	if b := m.Content[1]; b.Text != "Hello world" {
+		t.Errorf("text block = %+v", b)
+	}
+	if b := m.Content[2]; !b.IsToolUse() || b.ID != "tu_1" || b.Input["file_path"] != "go.mod" {
+		t.Errorf("tool_use block = %+v", b)
+	}
+
+	// A new message_start resets the accumulator.
+	acc.Add(&StreamMessage{Type: "stream_event", Event: &StreamEvent{Type: "message_start"}})
+	if acc.Done() || len(acc.Blocks()) != 0 {
+		t.Error("message_start should reset state")
+	}
+
+	// Non-event messages are ignored.
+	if acc.Add(&StreamMessage{Type: "assistant"}) != nil || acc.Add(nil) != nil {
+		t.Error("Add() should ignore non-event messages")
+	}
+}
+
+func TestSessionTextDeltas(t *testing.T) {
+	lines := append([]string{scriptInit}, partialStream...)
+	lines = append(lines, scriptAssistant, scriptResult)
+	sp := &scriptSpawner{lines: lines}
+
+	s, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{
+		IncludePartialMessages: true,
+		Spawner:                sp,
+	}})
+	if err := s.Run(context.Background(), "hi"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	<-s.Done()
+
+	var deltas []string
+	for d := range s.TextDeltas {
+		deltas = append(deltas, d)
+	}
+	var texts []string
+	for text := range s.Text {
+		texts = append(texts, text)
+	}
+
+	if strings.Join(deltas, "") != "Hello world" {
+		t.Errorf("TextDeltas = %q", deltas)
+	}
+	if len(texts) != 1 || texts[0] != "Hello" {
+		t.Errorf("Text = %q, want only the complete assistant text", texts)
+	}
+	if !containsString(sp.got.Args, "--include-partial-messages") {
+		t.Error("args should include --include-partial-messages")
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -1196,6 +1507,48 @@ func TestSessionConfigEmbedding(t *testing.T) {
 	}
 }
 
+func TestNewUserMessage(t *testing.T) {
+	data, err := json.Marshal(NewUserMessage("hello"))
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+
+	want := `{"type":"user","message":{"role":"user","content":[{"type":"text","text":"hello"}]}}`
+	if string(data) != want {
+		t.Errorf("NewUserMessage JSON = %s, want %s", data, want)
+	}
+}
+
+func TestLauncherInputNotStarted(t *testing.T) {
+	l := NewLauncher()
+	if err := l.SendMessage(NewUserMessage("hi")); err != ErrNotStarted {
+		t.Errorf("SendMessage() error = %v, want ErrNotStarted", err)
+	}
+	if err := l.CloseInput(); err != ErrNotStarted {
+		t.Errorf("CloseInput() error = %v, want ErrNotStarted", err)
+	}
+}
+
+func TestNewConversation(t *testing.T) {
+	c, err := NewConversation(SessionConfig{ID: "chat"})
+	if err != nil {
+		t.Fatalf("NewConversation() error: %v", err)
+	}
+	if c.ID != "chat" {
+		t.Errorf("conversation.ID = %q, want %q", c.ID, "chat")
+	}
+	if c.config.InputFormat != InputFormatStreamJSON {
+		t.Errorf("InputFormat = %q, want %q", c.config.InputFormat, InputFormatStreamJSON)
+	}
+
+	if _, err := c.Send(context.Background(), "hi"); err != ErrNotStarted {
+		t.Errorf("Send() before Start error = %v, want ErrNotStarted", err)
+	}
+	if err := c.Close(); err != ErrNotStarted {
+		t.Errorf("Close() before Start error = %v, want ErrNotStarted", err)
+	}
+}
+
 func TestSessionCurrentMetrics(t *testing.T) {
 	s, _ := NewSession(SessionConfig{})
 	m := s.CurrentMetrics()
@@ -1205,135 +1558,3772 @@ func TestSessionCurrentMetrics(t *testing.T) {
 }
 
 // ---------------------------------------------------------------------------
-// Integration tests (require Claude CLI)
+// Spawner / process transport
 // ---------------------------------------------------------------------------
 
-func skipIfNoCLI(t *testing.T) {
-	t.Helper()
-	if !CLIAvailable() {
-		t.Skip("Claude CLI not available - skipping integration test")
-	}
-}
+const (
+	scriptInit      = `{"type":"system","subtype":"init","session_id":"sess-1","model":"claude-sonnet-4-20250514"}`
+	scriptAssistant = `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello"}]}}`
+	scriptResult    = `{"type":"result","subtype":"success","result":"Hello","total_cost_usd":0.25,"num_turns":1}`
+)
 
-func TestIntegrationSimplePrompt(t *testing.T) {
-	skipIfNoCLI(t)
-	if testing.Short() {
-		t.Skip("skipping integration test in short mode")
+func TestLauncherSpawner(t *testing.T) {
+	sp := &scriptSpawner{
+		lines:    []string{scriptInit, "", scriptAssistant, scriptResult},
+		stderr:   "boom",
+		exitCode: 3,
 	}
 
-	session, err := NewSession(SessionConfig{
-		LaunchOptions: LaunchOptions{
-			SkipPermissions: true,
-			MaxTurns:        1,
-		},
-	})
-	if err != nil {
-		t.Fatalf("NewSession() error: %v", err)
+	opts := LaunchOptions{
+		BinaryPath: "/opt/claude/bin/claude",
+		APIKey:     "sk-test",
+		WorkDir:    "/work",
+		Model:      "sonnet",
+		Spawner:    sp,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
-
-	// A prompt designed to get a predictable, short answer
-	text, err := session.CollectAll(ctx, "What is 2+2? Reply with ONLY the number, nothing else.")
-	if err != nil {
-		t.Fatalf("CollectAll() error: %v", err)
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", opts); err != nil {
+		t.Fatalf("Start() error: %v", err)
 	}
 
-	t.Logf("Response: %q", text)
+	if sp.got.Path != "/opt/claude/bin/claude" {
+		t.Errorf("spawn path = %q", sp.got.Path)
+	}
+	if sp.got.Dir != "/work" {
+		t.Errorf("spawn dir = %q", sp.got.Dir)
+	}
+	wantArgs, _ := buildArgs("hi", opts, "")
+	if strings.Join(sp.got.Args, " ") != strings.Join(wantArgs, " ") {
+		t.Errorf("spawn args = %v, want %v", sp.got.Args, wantArgs)
+	}
+	if !containsString(sp.got.Env, "ANTHROPIC_API_KEY=sk-test") {
+		t.Error("spawn env missing ANTHROPIC_API_KEY")
+	}
+	if l.PID() != 4242 {
+		t.Errorf("PID() = %d, want 4242", l.PID())
+	}
 
-	// The response should contain "4"
-	if !strings.Contains(text, "4") {
-		t.Errorf("expected response to contain '4', got: %q", text)
+	var types []string
+	for {
+		msg, err := l.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error: %v", err)
+		}
+		if msg == nil {
+			break
+		}
+		types = append(types, msg.Type)
+	}
+	if strings.Join(types, ",") != "system,assistant,result" {
+		t.Errorf("message types = %v", types)
 	}
-}
 
-func TestIntegrationRunAndCollect(t *testing.T) {
-	skipIfNoCLI(t)
-	if testing.Short() {
-		t.Skip("skipping integration test in short mode")
+	err := l.Wait()
+	exitErr, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("Wait() error = %v, want *ExitError", err)
 	}
+	if exitErr.Code != 3 || exitErr.Stderr != "boom" {
+		t.Errorf("ExitError = %+v", exitErr)
+	}
+	if l.Running() {
+		t.Error("Running() = true after Wait")
+	}
+}
 
-	session, err := NewSession(SessionConfig{
-		LaunchOptions: LaunchOptions{
-			SkipPermissions: true,
-			MaxTurns:        1,
-		},
+func TestLauncherBinaryPathNotFound(t *testing.T) {
+	l := NewLauncher()
+	err := l.Start(context.Background(), "hi", LaunchOptions{
+		BinaryPath: "claude-binary-that-does-not-exist",
 	})
-	if err != nil {
-		t.Fatalf("NewSession() error: %v", err)
+	if err != ErrCLINotFound {
+		t.Errorf("Start() error = %v, want ErrCLINotFound", err)
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+func TestSessionWithSpawner(t *testing.T) {
+	sp := &scriptSpawner{lines: []string{scriptInit, scriptAssistant, scriptResult}}
 
-	result, err := session.RunAndCollect(ctx, "What is the capital of Japan? Reply in one word.")
+	s, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{Spawner: sp}})
+	result, err := s.RunAndCollect(context.Background(), "hi")
 	if err != nil {
 		t.Fatalf("RunAndCollect() error: %v", err)
 	}
 
-	t.Logf("Text: %q", result.Text)
-	t.Logf("TotalCost: $%.6f", result.TotalCost)
-	t.Logf("Model: %s", result.Model)
-	t.Logf("SessionID: %s", result.SessionID)
-	t.Logf("NumTurns: %d", result.NumTurns)
-	t.Logf("Duration: %s", result.Duration)
-	if result.Usage != nil {
-		t.Logf("Usage: in=%d out=%d", result.Usage.InputTokens, result.Usage.OutputTokens)
+	if result.Text != "Hello" {
+		t.Errorf("Text = %q, want Hello", result.Text)
 	}
+	if result.TotalCost != 0.25 || result.NumTurns != 1 {
+		t.Errorf("cost/turns = %f/%d", result.TotalCost, result.NumTurns)
+	}
+	if result.SessionID != "sess-1" || result.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("session/model = %q/%q", result.SessionID, result.Model)
+	}
+	if len(result.Messages) != 3 {
+		t.Errorf("len(Messages) = %d, want 3", len(result.Messages))
+	}
+}
 
-	// Validate response content
-	if !strings.Contains(strings.ToLower(result.Text), "tokyo") {
-		t.Errorf("expected response to contain 'tokyo', got: %q", result.Text)
+func TestConversationWithSpawner(t *testing.T) {
+	turn2 := `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Again"}]}}`
+	sp := &scriptSpawner{lines: []string{
+		scriptStdin, scriptInit, scriptAssistant, scriptResult,
+		scriptStdin, scriptInit, turn2, `{"type":"result","subtype":"success","total_cost_usd":0.5,"num_turns":2}`,
+	}}
+
+	conv, _ := NewConversation(SessionConfig{LaunchOptions: LaunchOptions{Spawner: sp}})
+	ctx := context.Background()
+	if err := conv.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
 	}
 
-	// Validate metrics
-	if result.TotalCost <= 0 {
-		t.Error("TotalCost should be positive")
+	r1, err := conv.Send(ctx, "one")
+	if err != nil {
+		t.Fatalf("Send() turn 1 error: %v", err)
 	}
-	if result.Model == "" {
-		t.Error("Model should not be empty")
+	r2, err := conv.Send(ctx, "two")
+	if err != nil {
+		t.Fatalf("Send() turn 2 error: %v", err)
 	}
-	if result.SessionID == "" {
-		t.Error("SessionID should not be empty")
+	if err := conv.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
 	}
-	if result.Duration <= 0 {
-		t.Error("Duration should be positive")
+
+	if r1.Text != "Hello" || r2.Text != "Again" {
+		t.Errorf("turn texts = %q, %q", r1.Text, r2.Text)
+	}
+	if len(r2.Messages) != 3 {
+		t.Errorf("turn 2 messages = %d, want 3", len(r2.Messages))
+	}
+	m := conv.CurrentMetrics()
+	if m.TotalCostUSD != 0.5 || m.SessionID != "sess-1" || m.Model == "" {
+		t.Errorf("metrics = %+v", m)
 	}
 
-	// Validate metrics snapshot
-	metrics := session.CurrentMetrics()
-	t.Logf("CurrentMetrics: cost=$%.6f tokens=%d+%d", metrics.TotalCostUSD, metrics.InputTokens, metrics.OutputTokens)
-	if metrics.TotalCostUSD <= 0 {
-		t.Error("CurrentMetrics().TotalCostUSD should be positive")
+	if len(sp.proc.input) != 2 || !strings.Contains(sp.proc.input[1], `"text":"two"`) {
+		t.Errorf("stdin lines = %v", sp.proc.input)
+	}
+	if !containsString(sp.got.Args, "--input-format") {
+		t.Error("conversation should launch with --input-format")
 	}
 }
 
-func TestIntegrationSystemPrompt(t *testing.T) {
-	skipIfNoCLI(t)
-	if testing.Short() {
-		t.Skip("skipping integration test in short mode")
-	}
+// ---------------------------------------------------------------------------
+// Cassettes
+// ---------------------------------------------------------------------------
 
-	session, err := NewSession(SessionConfig{
-		LaunchOptions: LaunchOptions{
-			SkipPermissions: true,
-			SystemPrompt:    "You must respond to every message with exactly the word PONG and nothing else. No punctuation, no explanation.",
-			MaxTurns:        1,
-		},
-	})
-	if err != nil {
-		t.Fatalf("NewSession() error: %v", err)
+func TestCassetteRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	sp := &scriptSpawner{
+		lines:    []string{scriptInit, scriptAssistant, scriptResult},
+		stderr:   "boom",
+		exitCode: 3,
 	}
+	rec := NewCassetteRecorder(&buf, sp)
+	opts := LaunchOptions{Model: "sonnet", Spawner: rec}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+	s, _ := NewSession(SessionConfig{LaunchOptions: opts})
+	recorded, err := s.RunAndCollect(context.Background(), "hi")
+	if _, ok := err.(*ExitError); !ok {
+		t.Fatalf("recorded run error = %v, want *ExitError", err)
+	}
+	if rec.Err() != nil {
+		t.Fatalf("recorder Err() = %v", rec.Err())
+	}
 
-	text, err := session.CollectAll(ctx, "PING")
+	runs, err := ReadCassettes(&buf)
 	if err != nil {
-		t.Fatalf("CollectAll() error: %v", err)
+		t.Fatalf("ReadCassettes() error: %v", err)
 	}
-
+	if len(runs) != 1 {
+		t.Fatalf("len(runs) = %d, want 1", len(runs))
+	}
+	c := runs[0]
+	wantArgs, _ := buildArgs("hi", LaunchOptions{Model: "sonnet"}, "")
+	wantArgs = cassetteArgs(wantArgs)
+	if strings.Join(c.Args, " ") != strings.Join(wantArgs, " ") {
+		t.Errorf("Args = %v, want %v", c.Args, wantArgs)
+	}
+	if len(c.Events) != 3 || c.Events[1].Line != scriptAssistant {
+		t.Errorf("Events = %+v", c.Events)
+	}
+	if c.ExitCode != 3 || c.Stderr != "boom" {
+		t.Errorf("exit = %d %q", c.ExitCode, c.Stderr)
+	}
+	if c.RecordedAt.IsZero() {
+		t.Error("RecordedAt should be set")
+	}
+	if len(c.Messages()) != 3 {
+		t.Errorf("len(Messages()) = %d, want 3", len(c.Messages()))
+	}
+
+	player := NewCassettePlayer(c)
+	player.MatchArgs = true
+	s, _ = NewSession(SessionConfig{LaunchOptions: LaunchOptions{Model: "sonnet", Spawner: player}})
+	replayed, err := s.RunAndCollect(context.Background(), "hi")
+
+	exitErr, ok := err.(*ExitError)
+	if !ok || exitErr.Code != 3 || exitErr.Stderr != "boom" {
+		t.Errorf("replay error = %v, want exit 3 with stderr", err)
+	}
+	if replayed.Text != recorded.Text || replayed.TotalCost != recorded.TotalCost {
+		t.Errorf("replay = %q/%f, recorded %q/%f",
+			replayed.Text, replayed.TotalCost, recorded.Text, recorded.TotalCost)
+	}
+	if len(replayed.Messages) != len(recorded.Messages) {
+		t.Errorf("replayed %d messages, recorded %d", len(replayed.Messages), len(recorded.Messages))
+	}
+
+	if _, err := player.Spawn(context.Background(), SpawnConfig{}); err != ErrCassetteExhausted {
+		t.Errorf("Spawn() after replay = %v, want ErrCassetteExhausted", err)
+	}
+}
+
+func TestCassetteConversationReplay(t *testing.T) {
+	var buf bytes.Buffer
+	turn2 := `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Again"}]}}`
+	sp := &scriptSpawner{lines: []string{
+		scriptStdin, scriptInit, scriptAssistant, scriptResult,
+		scriptStdin, scriptInit, turn2, scriptResult,
+	}}
+
+	run := func(spawner Spawner) []string {
+		t.Helper()
+		conv, _ := NewConversation(SessionConfig{LaunchOptions: LaunchOptions{Spawner: spawner}})
+		ctx := context.Background()
+		if err := conv.Start(ctx); err != nil {
+			t.Fatalf("Start() error: %v", err)
+		}
+		var texts []string
+		for _, prompt := range []string{"one", "two"} {
+			r, err := conv.Send(ctx, prompt)
+			if err != nil {
+				t.Fatalf("Send(%q) error: %v", prompt, err)
+			}
+			texts = append(texts, r.Text)
+		}
+		if err := conv.Close(); err != nil {
+			t.Fatalf("Close() error: %v", err)
+		}
+		return texts
+	}
+
+	recorded := run(NewCassetteRecorder(&buf, sp))
+
+	runs, err := ReadCassettes(&buf)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("ReadCassettes() = %d runs, %v", len(runs), err)
+	}
+	var stdin int
+	for _, ev := range runs[0].Events {
+		if ev.Stdin {
+			stdin++
+		}
+	}
+	if stdin != 2 {
+		t.Errorf("recorded %d stdin lines, want 2", stdin)
+	}
+
+	replayed := run(NewCassettePlayer(runs[0]))
+	if strings.Join(replayed, ",") != strings.Join(recorded, ",") {
+		t.Errorf("replayed %v, recorded %v", replayed, recorded)
+	}
+}
+
+func TestCassetteFileAndTiming(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.cassette")
+	data := `{"kind":"start","run":1,"args":["-p","hi"]}
+{"kind":"stdout","run":1,"offset_ms":5,"line":` + strconv.Quote(scriptInit) + `}
+{"kind":"stdout","run":1,"offset_ms":100,"line":` + strconv.Quote(scriptResult) + `}
+{"kind":"exit","run":1,"offset_ms":101}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette() error: %v", err)
+	}
+	if c.Events[1].Offset != 100*time.Millisecond {
+		t.Errorf("Offset = %v", c.Events[1].Offset)
+	}
+
+	player := NewCassettePlayer(c)
+	player.Speed = 1
+	start := time.Now()
+	s, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{Spawner: player}})
+	if _, err := s.RunAndCollect(context.Background(), "hi"); err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("real-time replay took %v, want >= 100ms", elapsed)
+	}
+
+	player = NewCassettePlayer(c)
+	player.MatchArgs = true
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "different", LaunchOptions{Spawner: player}); err == nil {
+		t.Error("Start() should fail on args mismatch")
+	}
+}
+
+func TestCassetteArgsNormalized(t *testing.T) {
+	// The MCP config is a new temp file per run and the prompt and
+	// settings carry secrets; replay must still match and the cassette
+	// must not leak them.
+	var buf bytes.Buffer
+	sp := &scriptSpawner{lines: []string{scriptInit, scriptAssistant, scriptResult}}
+	opts := func(spawner Spawner) LaunchOptions {
+		return LaunchOptions{
+			Spawner:    spawner,
+			MCPServers: map[string]MCPServer{"files": {Command: "mcp-files"}},
+			Settings:   `{"env":{"ANTHROPIC_API_KEY":"sk-ant-api03-abc"}}`,
+		}
+	}
+	const prompt = "the launch codes are 0000"
+
+	s, _ := NewSession(SessionConfig{LaunchOptions: opts(NewCassetteRecorder(&buf, sp))})
+	recorded, err := s.RunAndCollect(context.Background(), prompt)
+	if err != nil {
+		t.Fatalf("recorded run error: %v", err)
+	}
+	if idx := indexOfArg(sp.got.Args, "--mcp-config"); idx < 0 || !strings.Contains(sp.got.Args[idx+1], "claude-mcp-") {
+		t.Fatalf("spawned args = %q, want a temp --mcp-config", sp.got.Args)
+	}
+
+	data := buf.String()
+	for _, leak := range []string{prompt, "sk-ant-api03-abc", sp.got.Args[indexOfArg(sp.got.Args, "--mcp-config")+1]} {
+		if strings.Contains(data, leak) {
+			t.Errorf("cassette leaks %q:\n%s", leak, data)
+		}
+	}
+	runs, err := ReadCassettes(&buf)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("ReadCassettes() = %d runs, %v", len(runs), err)
+	}
+	if idx := indexOfArg(runs[0].Args, "--mcp-config"); idx < 0 || runs[0].Args[idx+1] != "<tmp>/claude-mcp.json" {
+		t.Errorf("recorded args = %q, want a <tmp> placeholder", runs[0].Args)
+	}
+
+	player := NewCassettePlayer(runs[0])
+	player.MatchArgs = true
+	s, _ = NewSession(SessionConfig{LaunchOptions: opts(player)})
+	replayed, err := s.RunAndCollect(context.Background(), prompt)
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	if replayed.Text != recorded.Text {
+		t.Errorf("replayed %q, recorded %q", replayed.Text, recorded.Text)
+	}
+
+	// Hook bridge settings live under a random claude-hooks-* dir.
+	settings := filepath.Join(os.TempDir(), "claude-hooks-123", "settings.json")
+	want := "<tmp>/claude-hooks" + string(filepath.Separator) + "settings.json"
+	if got := cassetteArgs([]string{"--settings", settings}); got[1] != want {
+		t.Errorf("cassetteArgs(%q) = %q, want %q", settings, got[1], want)
+	}
+}
+
+func TestReadCassettesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"invalid json", "{"},
+		{"line before start", `{"kind":"stdout","run":1,"line":"x"}`},
+		{"unknown kind", `{"kind":"start","run":1}` + "\n" + `{"kind":"bogus","run":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadCassettes(strings.NewReader(tt.data)); err == nil {
+				t.Error("ReadCassettes() should fail")
+			}
+		})
+	}
+
+	if _, err := LoadCassette(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadCassette() should fail for missing file")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Permission callback (CanUseTool)
+// ---------------------------------------------------------------------------
+
+func TestDecisionConstructors(t *testing.T) {
+	if d := Allow(); d.Behavior != "allow" || d.UpdatedInput != nil {
+		t.Errorf("Allow() = %+v", d)
+	}
+	if d := Deny("no"); d.Behavior != "deny" || d.Message != "no" {
+		t.Errorf("Deny() = %+v", d)
+	}
+	in := map[string]any{"command": "ls"}
+	if d := AllowWithInput(in); d.Behavior != "allow" || d.UpdatedInput["command"] != "ls" {
+		t.Errorf("AllowWithInput() = %+v", d)
+	}
+}
+
+func TestPermissionTool(t *testing.T) {
+	var gotTool string
+	var gotInput map[string]any
+	tool := permissionTool(func(ctx context.Context, name string, input map[string]any) (Decision, error) {
+		gotTool, gotInput = name, input
+		switch name {
+		case "Bash":
+			return Deny(""), nil
+		case "Write":
+			return AllowWithInput(map[string]any{"file_path": "/safe/out.txt"}), nil
+		case "Edit":
+			return Decision{}, fmt.Errorf("policy engine unavailable")
+		case "Glob":
+			return Decision{Behavior: "maybe"}, nil
+		}
+		return Allow(), nil
+	})
+
+	decide := func(name string, input map[string]any) map[string]any {
+		t.Helper()
+		args, _ := json.Marshal(permissionRequest{ToolName: name, Input: input, ToolUseID: "tu_1"})
+		res, err := tool.Handler(context.Background(), args)
+		if err != nil {
+			t.Fatalf("Handler(%s) error: %v", name, err)
+		}
+		var out map[string]any
+		if err := json.Unmarshal([]byte(res.Content[0].Text), &out); err != nil {
+			t.Fatalf("decision %q is not JSON: %v", res.Content[0].Text, err)
+		}
+		return out
+	}
+
+	out := decide("Read", map[string]any{"file_path": "a.go"})
+	if gotTool != "Read" || gotInput["file_path"] != "a.go" {
+		t.Errorf("callback got %q %v", gotTool, gotInput)
+	}
+	if out["behavior"] != "allow" || out["updatedInput"].(map[string]any)["file_path"] != "a.go" {
+		t.Errorf("Allow() decision = %v, want original input echoed", out)
+	}
+
+	out = decide("Write", map[string]any{"file_path": "/etc/passwd"})
+	if out["updatedInput"].(map[string]any)["file_path"] != "/safe/out.txt" {
+		t.Errorf("AllowWithInput() decision = %v", out)
+	}
+
+	out = decide("Bash", map[string]any{"command": "rm -rf /"})
+	if out["behavior"] != "deny" || !strings.Contains(out["message"].(string), "Bash") {
+		t.Errorf("Deny() decision = %v, want default message", out)
+	}
+
+	out = decide("Edit", nil)
+	if out["behavior"] != "deny" || out["message"] != "policy engine unavailable" {
+		t.Errorf("error decision = %v", out)
+	}
+
+	out = decide("Glob", nil)
+	if out["behavior"] != "deny" {
+		t.Errorf("invalid behavior decision = %v, want deny", out)
+	}
+}
+
+func TestStartLocalServersCanUseTool(t *testing.T) {
+	userServers := map[string]MCPServer{"github": {Command: "gh-mcp"}}
+	opts := LaunchOptions{
+		MCPServers: userServers,
+		CanUseTool: func(ctx context.Context, name string, input map[string]any) (Decision, error) {
+			return Allow(), nil
+		},
+	}
+
+	wired, closeFn, err := startLocalServers(opts)
+	if err != nil {
+		t.Fatalf("startLocalServers() error: %v", err)
+	}
+	defer closeFn()
+
+	if wired.PermissionPromptTool != "mcp__claudesdk__can_use_tool" {
+		t.Errorf("PermissionPromptTool = %q", wired.PermissionPromptTool)
+	}
+	sdk, ok := wired.MCPServers[sdkMCPServerName]
+	if !ok || sdk.Type != "http" || !strings.HasPrefix(sdk.URL, "http://127.0.0.1:") {
+		t.Errorf("sdk server = %+v", sdk)
+	}
+	if !strings.HasPrefix(sdk.Headers["Authorization"], "Bearer ") {
+		t.Error("sdk server should require a bearer token")
+	}
+	if _, ok := wired.MCPServers["github"]; !ok {
+		t.Error("user MCP servers should be preserved")
+	}
+	if len(userServers) != 1 {
+		t.Error("caller's MCPServers map should not be modified")
+	}
+
+	// No callback: options pass through untouched.
+	plain, closeFn2, err := startLocalServers(LaunchOptions{PermissionPromptTool: "mcp__x__y"})
+	if err != nil || closeFn2 != nil || plain.PermissionPromptTool != "mcp__x__y" {
+		t.Errorf("passthrough = %+v, %v, %v", plain, closeFn2 != nil, err)
+	}
+
+	opts.PermissionPromptTool = "mcp__x__y"
+	if _, _, err := startLocalServers(opts); err == nil {
+		t.Error("CanUseTool with PermissionPromptTool should fail")
+	}
+}
+
+// spawnerFunc adapts a function to Spawner.
+type spawnerFunc func(ctx context.Context, cfg SpawnConfig) (Process, error)
+
+func (f spawnerFunc) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	return f(ctx, cfg)
+}
+
+func TestLauncherCanUseTool(t *testing.T) {
+	var calls []string
+	opts := LaunchOptions{
+		CanUseTool: func(ctx context.Context, name string, input map[string]any) (Decision, error) {
+			calls = append(calls, name)
+			return Deny("blocked by test"), nil
+		},
+	}
+
+	var (
+		config   map[string]map[string]MCPServer
+		decision string
+	)
+	script := &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	opts.Spawner = spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+		assertContainsPair(t, cfg.Args, "--permission-prompt-tool", "mcp__claudesdk__can_use_tool")
+
+		// Read the MCP config the CLI would load, then call the
+		// permission tool the way the CLI does.
+		data, err := os.ReadFile(cfg.Args[indexOfArg(cfg.Args, "--mcp-config")+1])
+		if err != nil {
+			t.Fatalf("read mcp config: %v", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			t.Fatalf("mcp config %s: %v", data, err)
+		}
+		sdk := config["mcpServers"][sdkMCPServerName]
+
+		body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"can_use_tool","arguments":{"tool_name":"Bash","input":{"command":"ls"}}}}`
+		req, _ := http.NewRequest(http.MethodPost, sdk.URL, strings.NewReader(body))
+		req.Header.Set("Authorization", sdk.Headers["Authorization"])
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("call permission tool: %v", err)
+		}
+		defer resp.Body.Close()
+		var rpc struct {
+			Result struct {
+				Content []struct{ Text string } `json:"content"`
+			} `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&rpc)
+		if len(rpc.Result.Content) > 0 {
+			decision = rpc.Result.Content[0].Text
+		}
+
+		return script.Spawn(ctx, cfg)
+	})
+
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", opts); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	for {
+		msg, err := l.ReadMessage()
+		if err != nil || msg == nil {
+			break
+		}
+	}
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	if len(calls) != 1 || calls[0] != "Bash" {
+		t.Errorf("CanUseTool calls = %v", calls)
+	}
+	if !strings.Contains(decision, `"behavior":"deny"`) || !strings.Contains(decision, "blocked by test") {
+		t.Errorf("decision = %s", decision)
+	}
+
+	// The loopback server is stopped once the process exits.
+	sdk := config["mcpServers"][sdkMCPServerName]
+	if _, err := http.Post(sdk.URL, "application/json", strings.NewReader("{}")); err == nil {
+		t.Error("sdk MCP server should be closed after Wait")
+	}
+}
+
+func TestLauncherCanUseToolConflict(t *testing.T) {
+	l := NewLauncher()
+	err := l.Start(context.Background(), "hi", LaunchOptions{
+		PermissionPromptTool: "mcp__x__y",
+		CanUseTool: func(ctx context.Context, name string, input map[string]any) (Decision, error) {
+			return Allow(), nil
+		},
+		Spawner: &scriptSpawner{},
+	})
+	if _, ok := err.(*StartError); !ok {
+		t.Errorf("Start() error = %v, want *StartError", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Local MCP servers
+// ---------------------------------------------------------------------------
+
+func newInventoryServer() *mcp.Server {
+	srv := mcp.NewServer("inventory", "1.0.0")
+	srv.AddTool(mcp.Tool{
+		Name:        "stock_level",
+		Description: "Returns units in stock for a SKU",
+		InputSchema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"sku": map[string]any{"type": "string"}},
+			"required":   []string{"sku"},
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (*mcp.Result, error) {
+			var in struct {
+				SKU string `json:"sku"`
+			}
+			if err := json.Unmarshal(args, &in); err != nil {
+				return nil, err
+			}
+			if in.SKU != "WIDGET-42" {
+				return nil, fmt.Errorf("unknown sku %q", in.SKU)
+			}
+			return mcp.TextResult("STOCK-LEVEL-9183 units"), nil
+		},
+	})
+	return srv
+}
+
+func TestStartLocalServers(t *testing.T) {
+	userServers := map[string]MCPServer{"github": {Command: "gh-mcp"}}
+	opts := LaunchOptions{
+		MCPServers:      userServers,
+		LocalMCPServers: map[string]*mcp.Server{"inventory": newInventoryServer()},
+	}
+
+	wired, closeFn, err := startLocalServers(opts)
+	if err != nil {
+		t.Fatalf("startLocalServers() error: %v", err)
+	}
+	defer closeFn()
+
+	inv, ok := wired.MCPServers["inventory"]
+	if !ok || inv.Type != "http" || !strings.HasPrefix(inv.URL, "http://127.0.0.1:") {
+		t.Fatalf("inventory server = %+v", inv)
+	}
+	if _, ok := wired.MCPServers["github"]; !ok {
+		t.Error("user MCP servers should be preserved")
+	}
+	if len(userServers) != 1 {
+		t.Error("caller's MCPServers map should not be modified")
+	}
+	if wired.PermissionPromptTool != "" {
+		t.Errorf("PermissionPromptTool = %q, want unset without CanUseTool", wired.PermissionPromptTool)
+	}
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"stock_level","arguments":{"sku":"WIDGET-42"}}}`
+	req, _ := http.NewRequest(http.MethodPost, inv.URL, strings.NewReader(body))
+	req.Header.Set("Authorization", inv.Headers["Authorization"])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("call tool: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), "STOCK-LEVEL-9183") {
+		t.Errorf("tool response = %s", data)
+	}
+
+	closeFn()
+	if _, err := http.Post(inv.URL, "application/json", strings.NewReader("{}")); err == nil {
+		t.Error("local server should be unreachable after close")
+	}
+}
+
+func TestStartLocalServersConflicts(t *testing.T) {
+	allow := func(ctx context.Context, name string, input map[string]any) (Decision, error) {
+		return Allow(), nil
+	}
+	tests := []struct {
+		name string
+		opts LaunchOptions
+	}{
+		{"duplicate name", LaunchOptions{
+			MCPServers:      map[string]MCPServer{"inventory": {Command: "x"}},
+			LocalMCPServers: map[string]*mcp.Server{"inventory": newInventoryServer()},
+		}},
+		{"nil server", LaunchOptions{
+			LocalMCPServers: map[string]*mcp.Server{"inventory": nil},
+		}},
+		{"reserved local name", LaunchOptions{
+			CanUseTool:      allow,
+			LocalMCPServers: map[string]*mcp.Server{sdkMCPServerName: newInventoryServer()},
+		}},
+		{"reserved external name", LaunchOptions{
+			CanUseTool: allow,
+			MCPServers: map[string]MCPServer{sdkMCPServerName: {Command: "x"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, closeFn, err := startLocalServers(tt.opts); err == nil {
+				closeFn()
+				t.Error("startLocalServers() should fail")
+			}
+		})
+	}
+}
+
+func TestLauncherMCPConfigFile(t *testing.T) {
+	var data []byte
+	script := &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	l := NewLauncher()
+	err := l.Start(context.Background(), "hi", LaunchOptions{
+		MCPServers: map[string]MCPServer{"context7": {Command: "npx", Args: []string{"-y", "@upstash/context7-mcp"}}},
+		Spawner: spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+			var err error
+			if data, err = os.ReadFile(cfg.Args[indexOfArg(cfg.Args, "--mcp-config")+1]); err != nil {
+				t.Fatalf("read mcp config: %v", err)
+			}
+			return script.Spawn(ctx, cfg)
+		}),
+	})
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	for {
+		msg, err := l.ReadMessage()
+		if err != nil || msg == nil {
+			break
+		}
+	}
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	// The CLI expects the .mcp.json layout, with servers under "mcpServers".
+	want := `{"mcpServers":{"context7":{"command":"npx","args":["-y","@upstash/context7-mcp"]}}}`
+	if string(data) != want {
+		t.Errorf("mcp config = %s, want %s", data, want)
+	}
+}
+
+func TestLauncherLocalMCPServers(t *testing.T) {
+	var config map[string]map[string]MCPServer
+	script := &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	opts := LaunchOptions{
+		LocalMCPServers: map[string]*mcp.Server{"inventory": newInventoryServer()},
+		Spawner: spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+			data, err := os.ReadFile(cfg.Args[indexOfArg(cfg.Args, "--mcp-config")+1])
+			if err != nil {
+				t.Fatalf("read mcp config: %v", err)
+			}
+			if err := json.Unmarshal(data, &config); err != nil {
+				t.Fatalf("mcp config %s: %v", data, err)
+			}
+			return script.Spawn(ctx, cfg)
+		}),
+	}
+
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", opts); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	for {
+		msg, err := l.ReadMessage()
+		if err != nil || msg == nil {
+			break
+		}
+	}
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	inv, ok := config["mcpServers"]["inventory"]
+	if !ok || inv.URL == "" {
+		t.Fatalf("mcp config = %v", config)
+	}
+	if _, err := http.Post(inv.URL, "application/json", strings.NewReader("{}")); err == nil {
+		t.Error("local MCP server should be closed after Wait")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Session delivery policies
+// ---------------------------------------------------------------------------
+
+// deliveryScript returns init, n assistant messages, and a result.
+func deliveryScript(n int) []string {
+	lines := []string{scriptInit}
+	for i := 0; i < n; i++ {
+		lines = append(lines, fmt.Sprintf(`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"msg-%d"}]}}`, i))
+	}
+	return append(lines, scriptResult)
+}
+
+func newDeliverySession(t *testing.T, policy DeliveryPolicy, buffer, n int) *Session {
+	t.Helper()
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: &scriptSpawner{lines: deliveryScript(n)}},
+		ChannelBuffer: buffer,
+		Delivery:      policy,
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+	if err := session.Run(context.Background(), "hi"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	return session
+}
+
+func drainMessages(session *Session) []StreamMessage {
+	var msgs []StreamMessage
+	for msg := range session.Messages {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestDeliveryDrop(t *testing.T) {
+	session := newDeliverySession(t, "", 2, 20)
+	<-session.Done()
+
+	msgs := drainMessages(session)
+	m := session.CurrentMetrics()
+	if len(msgs) != 2 {
+		t.Errorf("delivered %d messages, want 2", len(msgs))
+	}
+	if m.DroppedMessages != 20 {
+		t.Errorf("DroppedMessages = %d, want 20", m.DroppedMessages)
+	}
+	if m.DroppedText == 0 || m.DroppedErrors == 0 {
+		t.Errorf("Text and Errors drops should be counted: %+v", m)
+	}
+	if m.NumTurns != 1 {
+		t.Errorf("result metrics lost: %+v", m)
+	}
+}
+
+func TestDeliveryBlock(t *testing.T) {
+	session := newDeliverySession(t, DeliveryBlock, 1, 50)
+
+	var msgs []StreamMessage
+	for msg := range session.Messages {
+		msgs = append(msgs, msg)
+		time.Sleep(time.Millisecond) // slow consumer
+	}
+	if err := session.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	if len(msgs) != 52 {
+		t.Errorf("delivered %d messages, want 52", len(msgs))
+	}
+	if !IsResult(&msgs[len(msgs)-1]) {
+		t.Error("last message should be the result")
+	}
+	if m := session.CurrentMetrics(); m.DroppedMessages != 0 {
+		t.Errorf("DroppedMessages = %d, want 0", m.DroppedMessages)
+	}
+}
+
+func TestDeliveryBlockKill(t *testing.T) {
+	session := newDeliverySession(t, DeliveryBlock, 1, 10)
+
+	// Nobody reads Messages; Kill must still release the reader.
+	time.Sleep(20 * time.Millisecond)
+	session.Kill()
+	select {
+	case <-session.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session did not finish after Kill")
+	}
+	if m := session.CurrentMetrics(); m.DroppedMessages == 0 {
+		t.Error("undelivered message should be counted as dropped")
+	}
+}
+
+func TestDeliveryBlockContextCancel(t *testing.T) {
+	session, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: &scriptSpawner{lines: deliveryScript(10)}},
+		ChannelBuffer: 1,
+		Delivery:      DeliveryBlock,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := session.Run(ctx, "hi"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-session.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session did not finish after context cancel")
+	}
+}
+
+func TestDeliveryDropOldest(t *testing.T) {
+	session := newDeliverySession(t, DeliveryDropOldest, 2, 10)
+	<-session.Done()
+
+	msgs := drainMessages(session)
+	if len(msgs) != 2 {
+		t.Fatalf("delivered %d messages, want 2", len(msgs))
+	}
+	if ExtractText(&msgs[0]) != "msg-9" || !IsResult(&msgs[1]) {
+		t.Errorf("kept %v, want the newest messages", msgs)
+	}
+	if m := session.CurrentMetrics(); m.DroppedMessages != 10 {
+		t.Errorf("DroppedMessages = %d, want 10", m.DroppedMessages)
+	}
+}
+
+func TestDeliveryUnbounded(t *testing.T) {
+	session := newDeliverySession(t, DeliveryUnbounded, 1, 200)
+
+	// The reader never blocks, so the session finishes unread.
+	select {
+	case <-session.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("unbounded session should finish without a consumer")
+	}
+
+	msgs := drainMessages(session)
+	if len(msgs) != 202 {
+		t.Errorf("delivered %d messages, want 202", len(msgs))
+	}
+	for i, msg := range msgs[1:201] {
+		if got, want := ExtractText(&msg), fmt.Sprintf("msg-%d", i); got != want {
+			t.Fatalf("message %d = %q, want %q", i, got, want)
+		}
+	}
+	if m := session.CurrentMetrics(); m.DroppedMessages != 0 {
+		t.Errorf("DroppedMessages = %d, want 0", m.DroppedMessages)
+	}
+}
+
+func TestDeliveryCollect(t *testing.T) {
+	// Collect helpers drain Messages, so blocking delivery cannot stall them.
+	for _, policy := range []DeliveryPolicy{DeliveryBlock, DeliveryUnbounded} {
+		t.Run(string(policy), func(t *testing.T) {
+			session, _ := NewSession(SessionConfig{
+				LaunchOptions: LaunchOptions{Spawner: &scriptSpawner{lines: deliveryScript(20)}},
+				ChannelBuffer: 1,
+				Delivery:      policy,
+			})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			text, err := session.CollectAll(ctx, "hi")
+			if err != nil {
+				t.Fatalf("CollectAll() error: %v", err)
+			}
+			var want strings.Builder
+			for i := 0; i < 20; i++ {
+				fmt.Fprintf(&want, "msg-%d", i)
+			}
+			if text != want.String() {
+				t.Errorf("text = %q, want %q", text, want.String())
+			}
+			if m := session.CurrentMetrics(); m.DroppedText != 0 {
+				t.Errorf("DroppedText = %d, want 0", m.DroppedText)
+			}
+		})
+	}
+
+	session, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: &scriptSpawner{lines: deliveryScript(20)}},
+		ChannelBuffer: 1,
+		Delivery:      DeliveryBlock,
+	})
+	result, err := session.RunAndCollect(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+	if len(result.Messages) != 22 {
+		t.Errorf("collected %d messages, want 22", len(result.Messages))
+	}
+	// RunAndCollect does not read Text, so it is not fed.
+	if result.Metrics.DroppedText != 0 {
+		t.Errorf("DroppedText = %d, want 0", result.Metrics.DroppedText)
+	}
+	if _, ok := <-session.Text; ok {
+		t.Error("Text should be empty and closed after RunAndCollect")
+	}
+}
+
+func TestDeliveryTextIsLossy(t *testing.T) {
+	// Delivery applies to Messages only: Text drops what does not fit.
+	session := newDeliverySession(t, DeliveryBlock, 2, 10)
+	msgs := drainMessages(session)
+	if err := session.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	var text []string
+	for s := range session.Text {
+		text = append(text, s)
+	}
+	if len(msgs) != 12 {
+		t.Errorf("delivered %d messages, want 12", len(msgs))
+	}
+	if strings.Join(text, ",") != "msg-0,msg-1" {
+		t.Errorf("Text = %q, want the first 2", text)
+	}
+	if m := session.CurrentMetrics(); m.DroppedText != 8 || m.DroppedMessages != 0 {
+		t.Errorf("DroppedText = %d, DroppedMessages = %d; want 8 and 0", m.DroppedText, m.DroppedMessages)
+	}
+}
+
+func TestDeliveryInvalidPolicy(t *testing.T) {
+	if _, err := NewSession(SessionConfig{Delivery: "sometimes"}); err == nil {
+		t.Error("unknown delivery policy should be rejected")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Lifecycle hook handlers
+// ---------------------------------------------------------------------------
+
+func TestHookResultOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		result HookResult
+		event  HookEvent
+		want   string
+	}{
+		{"no opinion", HookResult{}, HookPreToolUse, `{}`},
+		{"pre approve", HookApprove("trusted"), HookPreToolUse,
+			`{"hookSpecificOutput":{"hookEventName":"PreToolUse","permissionDecision":"allow","permissionDecisionReason":"trusted"}}`},
+		{"pre block", HookBlock("nope"), HookPreToolUse,
+			`{"hookSpecificOutput":{"hookEventName":"PreToolUse","permissionDecision":"deny","permissionDecisionReason":"nope"}}`},
+		{"post block", HookBlock("lint failed"), HookPostToolUse, `{"decision":"block","reason":"lint failed"}`},
+		{"post context", HookContext("3 tests ran"), HookPostToolUse,
+			`{"hookSpecificOutput":{"additionalContext":"3 tests ran","hookEventName":"PostToolUse"}}`},
+		{"prompt context", HookContext("today is Monday"), HookUserPromptSubmit,
+			`{"hookSpecificOutput":{"additionalContext":"today is Monday","hookEventName":"UserPromptSubmit"}}`},
+		{"stop block", HookBlock("run the tests first"), HookStop, `{"decision":"block","reason":"run the tests first"}`},
+		{"stop approve", HookApprove("done"), HookStop, `{}`},
+		{"stop context ignored", HookContext("x"), HookSubagentStop, `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(tt.result.output(tt.event))
+			if string(got) != tt.want {
+				t.Errorf("output = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// runHookShim invokes the bridge the way the CLI runs the shim command.
+func runHookShim(t *testing.T, b *hookBridge, id, input string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = hookshim.Run([]string{b.socket, id}, strings.NewReader(input), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+func TestStartHookBridge(t *testing.T) {
+	var seen HookInput
+	var hookErrs []error
+	opts := LaunchOptions{
+		HookShimPath: "/opt/shim dir/claude-hook-shim",
+		Settings:     `{"model":"sonnet","hooks":{"Stop":[{"hooks":[{"type":"command","command":"notify"}]}]}}`,
+		Hooks:        &Hooks{OnError: func(err error) { hookErrs = append(hookErrs, err) }},
+		HookHandlers: map[HookEvent][]HookMatcher{
+			HookPreToolUse: {{
+				Matcher: "Bash",
+				Timeout: 1500 * time.Millisecond,
+				Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+					seen = in
+					if strings.Contains(fmt.Sprint(in.ToolInput["command"]), "rm -rf") {
+						return HookBlock("destructive"), nil
+					}
+					return HookResult{}, nil
+				},
+			}},
+			HookStop: {{
+				Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+					return HookResult{}, errors.New("policy service down")
+				},
+			}},
+		},
+	}
+
+	wired, b, err := startHookBridge(opts)
+	if err != nil {
+		t.Fatalf("startHookBridge() error: %v", err)
+	}
+	defer b.Close()
+
+	// Settings are merged with the caller's and written to a file.
+	data, err := os.ReadFile(wired.Settings)
+	if err != nil {
+		t.Fatalf("read settings: %v", err)
+	}
+	var settings struct {
+		Model string
+		Hooks map[string][]struct {
+			Matcher string
+			Hooks   []struct {
+				Type    string
+				Command string
+				Timeout int
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		t.Fatalf("settings %s: %v", data, err)
+	}
+	if settings.Model != "sonnet" {
+		t.Errorf("existing settings lost: %s", data)
+	}
+	if stop := settings.Hooks["Stop"]; len(stop) != 2 || stop[0].Hooks[0].Command != "notify" {
+		t.Errorf("Stop hooks = %+v, want existing hook kept first", stop)
+	}
+	pre := settings.Hooks["PreToolUse"]
+	if len(pre) != 1 || pre[0].Matcher != "Bash" {
+		t.Fatalf("PreToolUse hooks = %+v", pre)
+	}
+	cmd := pre[0].Hooks[0]
+	want := `'/opt/shim dir/claude-hook-shim' '` + b.socket + `' 'PreToolUse/0'`
+	if cmd.Type != "command" || cmd.Command != want || cmd.Timeout != 2 {
+		t.Errorf("command = %+v, want %q with timeout 2", cmd, want)
+	}
+
+	stdout, _, code := runHookShim(t, b, "PreToolUse/0",
+		`{"hook_event_name":"PreToolUse","session_id":"s1","tool_name":"Bash","tool_input":{"command":"rm -rf /"},"extra":1}`)
+	if code != 0 || !strings.Contains(stdout, `"permissionDecision":"deny"`) {
+		t.Errorf("block: code %d, stdout %s", code, stdout)
+	}
+	if seen.SessionID != "s1" || seen.ToolName != "Bash" || !strings.Contains(string(seen.Raw), `"extra":1`) {
+		t.Errorf("HookInput = %+v", seen)
+	}
+
+	stdout, _, code = runHookShim(t, b, "PreToolUse/0", `{"hook_event_name":"PreToolUse","tool_name":"Bash","tool_input":{"command":"ls"}}`)
+	if code != 0 || stdout != "" {
+		t.Errorf("no opinion: code %d, stdout %q", code, stdout)
+	}
+
+	_, stderr, code := runHookShim(t, b, "Stop/0", `{"hook_event_name":"Stop"}`)
+	if code != hookshim.ExitError || !strings.Contains(stderr, "policy service down") {
+		t.Errorf("error: code %d, stderr %q", code, stderr)
+	}
+	if len(hookErrs) != 1 {
+		t.Errorf("OnError calls = %d, want 1", len(hookErrs))
+	}
+
+	if _, _, code := runHookShim(t, b, "Nope/9", `{}`); code != hookshim.ExitError {
+		t.Errorf("unknown handler code = %d", code)
+	}
+
+	b.Close()
+	if _, err := os.Stat(b.dir); !os.IsNotExist(err) {
+		t.Error("hook dir should be removed on Close")
+	}
+}
+
+func TestHookBridgeLongTempDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no /tmp fallback on windows")
+	}
+	long := filepath.Join(t.TempDir(), strings.Repeat("d", 100))
+	if err := os.Mkdir(long, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", long)
+
+	_, b, err := startHookBridge(LaunchOptions{
+		HookShimPath: "/bin/true",
+		HookHandlers: map[HookEvent][]HookMatcher{HookStop: {{Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+			return HookResult{}, nil
+		}}}},
+	})
+	if err != nil {
+		t.Fatalf("startHookBridge() error: %v", err)
+	}
+	defer b.Close()
+	if len(b.socket) > maxSocketPath || !strings.HasPrefix(b.socket, "/tmp/") {
+		t.Errorf("socket = %s (%d bytes), want a short path under /tmp", b.socket, len(b.socket))
+	}
+	if entries, _ := os.ReadDir(long); len(entries) != 0 {
+		t.Errorf("unused temp dir left behind in TMPDIR: %v", entries)
+	}
+}
+
+func TestHookBridgeCloseBounded(t *testing.T) {
+	defer func(d time.Duration) { hookCloseTimeout = d }(hookCloseTimeout)
+	hookCloseTimeout = 50 * time.Millisecond
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	hookErrs := make(chan error, 4)
+	_, b, err := startHookBridge(LaunchOptions{
+		HookShimPath: "/bin/true",
+		Hooks:        &Hooks{OnError: func(err error) { hookErrs <- err }},
+		HookHandlers: map[HookEvent][]HookMatcher{HookStop: {{Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+			close(entered)
+			<-release // ignores ctx
+			return HookResult{}, nil
+		}}}},
+	})
+	if err != nil {
+		t.Fatalf("startHookBridge() error: %v", err)
+	}
+
+	// A peer that connects and never sends a request.
+	silent, err := net.Dial("unix", b.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go runHookShim(t, b, "Stop/0", `{"hook_event_name":"Stop"}`)
+	<-entered
+
+	closed := make(chan error, 1)
+	go func() { closed <- b.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() blocked on a stuck handler")
+	}
+	select {
+	case err := <-hookErrs:
+		if !strings.Contains(err.Error(), "still running") {
+			t.Errorf("OnError(%v), want a leaked handler report", err)
+		}
+	default:
+		t.Error("leaked handler not reported through OnError")
+	}
+}
+
+func TestStartHookBridgeErrors(t *testing.T) {
+	handler := map[HookEvent][]HookMatcher{HookStop: {{Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+		return HookResult{}, nil
+	}}}}
+
+	// No handlers: options pass through untouched.
+	plain, b, err := startHookBridge(LaunchOptions{Settings: "x.json"})
+	if err != nil || b != nil || plain.Settings != "x.json" {
+		t.Errorf("passthrough = %+v, %v, %v", plain, b, err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, _, err := startHookBridge(LaunchOptions{HookHandlers: handler}); !errors.Is(err, ErrHookShimNotFound) {
+		t.Errorf("missing shim error = %v, want ErrHookShimNotFound", err)
+	}
+
+	if _, _, err := startHookBridge(LaunchOptions{
+		HookShimPath: "/bin/true",
+		HookHandlers: map[HookEvent][]HookMatcher{HookStop: {{}}},
+	}); err == nil {
+		t.Error("matcher without Func should fail")
+	}
+	if _, _, err := startHookBridge(LaunchOptions{
+		HookShimPath: "/bin/true",
+		HookHandlers: handler,
+		Settings:     filepath.Join(t.TempDir(), "missing.json"),
+	}); err == nil {
+		t.Error("unreadable Settings should fail")
+	}
+}
+
+func TestLauncherHookHandlers(t *testing.T) {
+	var settingsPath string
+	script := &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	opts := LaunchOptions{
+		HookShimPath: "/bin/true",
+		HookHandlers: map[HookEvent][]HookMatcher{HookStop: {{Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+			return HookResult{}, nil
+		}}}},
+		Spawner: spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+			settingsPath = cfg.Args[indexOfArg(cfg.Args, "--settings")+1]
+			if _, err := os.Stat(settingsPath); err != nil {
+				t.Errorf("settings file missing while running: %v", err)
+			}
+			return script.Spawn(ctx, cfg)
+		}),
+	}
+
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", opts); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	for {
+		msg, err := l.ReadMessage()
+		if err != nil || msg == nil {
+			break
+		}
+	}
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	if _, err := os.Stat(settingsPath); !os.IsNotExist(err) {
+		t.Error("hook settings should be removed after Wait")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Session pool
+// ---------------------------------------------------------------------------
+
+// gatedSpawner runs scriptInit/scriptResult once the gate is closed,
+// recording each spawn's arguments and the peak number held at the gate.
+type gatedSpawner struct {
+	gate chan struct{}
+
+	mu     sync.Mutex
+	active int
+	peak   int
+	calls  [][]string
+}
+
+func newGatedSpawner() *gatedSpawner {
+	return &gatedSpawner{gate: make(chan struct{})}
+}
+
+func (g *gatedSpawner) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	g.mu.Lock()
+	g.active++
+	g.peak = max(g.peak, g.active)
+	g.calls = append(g.calls, cfg.Args)
+	g.mu.Unlock()
+
+	<-g.gate
+
+	g.mu.Lock()
+	g.active--
+	g.mu.Unlock()
+	return (&scriptSpawner{lines: []string{scriptInit, scriptResult}}).Spawn(ctx, cfg)
+}
+
+func (g *gatedSpawner) prompts() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []string
+	for _, args := range g.calls {
+		out = append(out, args[len(args)-1])
+	}
+	return out
+}
+
+// waitForStats polls until cond holds for the pool's stats.
+func waitForStats(t *testing.T, p *Pool, cond func(PoolStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond(p.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for pool stats, last %+v", p.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolConcurrencyLimit(t *testing.T) {
+	spawner := newGatedSpawner()
+	pool, err := NewPool(PoolConfig{
+		MaxConcurrent: 2,
+		Session:       SessionConfig{LaunchOptions: LaunchOptions{Spawner: spawner}},
+	})
+	if err != nil {
+		t.Fatalf("NewPool() error: %v", err)
+	}
+	defer pool.Close()
+
+	const n = 6
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := pool.Run(context.Background(), fmt.Sprintf("task-%d", i))
+			errs <- err
+		}()
+	}
+
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Running == 2 && s.Queued == n-2 })
+	if s := pool.Stats(); s.Utilization != 1 || s.MaxConcurrent != 2 {
+		t.Errorf("Stats() = %+v, want full utilization", s)
+	}
+
+	close(spawner.gate)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Run() error: %v", err)
+		}
+	}
+
+	if spawner.peak > 2 {
+		t.Errorf("peak concurrency = %d, want <= 2", spawner.peak)
+	}
+	s := pool.Stats()
+	if s.Running != 0 || s.Queued != 0 || s.Completed != n || s.Failed != 0 {
+		t.Errorf("final Stats() = %+v", s)
+	}
+	if s.SpentUSD != 0.25*n || s.RemainingUSD != 0 {
+		t.Errorf("SpentUSD = %v, RemainingUSD = %v", s.SpentUSD, s.RemainingUSD)
+	}
+}
+
+func TestPoolNewHooks(t *testing.T) {
+	spawner := newGatedSpawner()
+	var mu sync.Mutex
+	var exits []int // OnExit calls per hooks value
+	var base int
+	pool, _ := NewPool(PoolConfig{
+		MaxConcurrent: 3,
+		Session: SessionConfig{LaunchOptions: LaunchOptions{
+			Spawner: spawner,
+			Hooks:   &Hooks{OnExit: func(int, time.Duration) { mu.Lock(); base++; mu.Unlock() }},
+		}},
+		NewHooks: func() *Hooks {
+			mu.Lock()
+			defer mu.Unlock()
+			i := len(exits)
+			exits = append(exits, 0)
+			return &Hooks{OnExit: func(int, time.Duration) { mu.Lock(); exits[i]++; mu.Unlock() }}
+		},
+	})
+	defer pool.Close()
+
+	const n = 3
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := pool.Run(context.Background(), "hi")
+			errs <- err
+		}()
+	}
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Running == n })
+	close(spawner.gate)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Run() error: %v", err)
+		}
+	}
+
+	// Each concurrent session got hooks of its own, after the shared ones.
+	if fmt.Sprint(exits) != "[1 1 1]" || base != n {
+		t.Errorf("per-task OnExit calls = %v, shared = %d", exits, base)
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	spawner := newGatedSpawner()
+	pool, _ := NewPool(PoolConfig{
+		MaxConcurrent: 1,
+		Session:       SessionConfig{LaunchOptions: LaunchOptions{Spawner: spawner}},
+	})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	submit := func(prompt string, priority int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Do(context.Background(), PoolTask{Prompt: prompt, Priority: priority}); err != nil {
+				t.Errorf("Do(%s) error: %v", prompt, err)
+			}
+		}()
+	}
+
+	// The first task holds the only slot while the rest queue up.
+	submit("first", 0)
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Running == 1 })
+	for i, task := range []struct {
+		prompt   string
+		priority int
+	}{{"low", 1}, {"high", 5}, {"mid", 3}, {"high-later", 5}} {
+		submit(task.prompt, task.priority)
+		waitForStats(t, pool, func(s PoolStats) bool { return s.Queued == i+1 })
+	}
+
+	close(spawner.gate)
+	wg.Wait()
+
+	got := strings.Join(spawner.prompts(), ",")
+	if want := "first,high,high-later,mid,low"; got != want {
+		t.Errorf("run order = %s, want %s", got, want)
+	}
+}
+
+func TestPoolBudget(t *testing.T) {
+	spawner := newGatedSpawner()
+	close(spawner.gate)
+	pool, _ := NewPool(PoolConfig{
+		MaxConcurrent: 1,
+		MaxBudgetUSD:  0.5,
+		Session:       SessionConfig{LaunchOptions: LaunchOptions{Spawner: spawner, MaxBudgetUSD: 10}},
+	})
+	defer pool.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := pool.Run(ctx, "spend"); err != nil {
+			t.Fatalf("Run %d error: %v", i, err)
+		}
+	}
+	if _, err := pool.Run(ctx, "over"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Run over budget error = %v, want ErrBudgetExceeded", err)
+	}
+
+	// Each session's own budget is capped at what the pool has left.
+	var caps []string
+	for _, args := range spawner.calls {
+		caps = append(caps, args[indexOfArg(args, "--max-budget-usd")+1])
+	}
+	if got := strings.Join(caps, ","); got != "0.50,0.25" {
+		t.Errorf("--max-budget-usd = %s, want 0.50,0.25", got)
+	}
+
+	s := pool.Stats()
+	if s.SpentUSD != 0.5 || s.RemainingUSD != 0 || s.Completed != 2 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestPoolBudgetFailsQueued(t *testing.T) {
+	spawner := newGatedSpawner()
+	pool, _ := NewPool(PoolConfig{
+		MaxConcurrent: 1,
+		MaxBudgetUSD:  0.2,
+		Session:       SessionConfig{LaunchOptions: LaunchOptions{Spawner: spawner}},
+	})
+	defer pool.Close()
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := pool.Run(context.Background(), "first")
+		first <- err
+	}()
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Running == 1 })
+
+	queued := make(chan error, 1)
+	go func() {
+		_, err := pool.Run(context.Background(), "queued")
+		queued <- err
+	}()
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Queued == 1 })
+
+	close(spawner.gate)
+	if err := <-first; err != nil {
+		t.Fatalf("first Run() error: %v", err)
+	}
+	if err := <-queued; !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("queued Run() error = %v, want ErrBudgetExceeded", err)
+	}
+	if len(spawner.calls) != 1 {
+		t.Errorf("spawned %d sessions, want 1", len(spawner.calls))
+	}
+}
+
+func TestPoolCancelAndClose(t *testing.T) {
+	spawner := newGatedSpawner()
+	pool, _ := NewPool(PoolConfig{
+		MaxConcurrent: 1,
+		Session:       SessionConfig{LaunchOptions: LaunchOptions{Spawner: spawner}},
+	})
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := pool.Run(context.Background(), "first")
+		first <- err
+	}()
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Running == 1 })
+
+	// A task cancelled while queued leaves the queue.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := pool.Run(ctx, "cancelled")
+		cancelled <- err
+	}()
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Queued == 1 })
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled Run() error = %v, want context.Canceled", err)
+	}
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Queued == 0 })
+
+	// Close fails queued tasks and rejects new ones; running tasks finish.
+	queued := make(chan error, 1)
+	go func() {
+		_, err := pool.Run(context.Background(), "queued")
+		queued <- err
+	}()
+	waitForStats(t, pool, func(s PoolStats) bool { return s.Queued == 1 })
+	pool.Close()
+	if err := <-queued; !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("queued Run() error = %v, want ErrPoolClosed", err)
+	}
+	if _, err := pool.Run(context.Background(), "late"); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Run() after Close error = %v, want ErrPoolClosed", err)
+	}
+
+	close(spawner.gate)
+	if err := <-first; err != nil {
+		t.Errorf("running task error: %v", err)
+	}
+	if s := pool.Stats(); s.Running != 0 || s.Completed != 1 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+func TestNewPoolValidation(t *testing.T) {
+	if _, err := NewPool(PoolConfig{MaxConcurrent: -1}); err == nil {
+		t.Error("negative MaxConcurrent should fail")
+	}
+	if _, err := NewPool(PoolConfig{MaxBudgetUSD: -1}); err == nil {
+		t.Error("negative MaxBudgetUSD should fail")
+	}
+	if _, err := NewPool(PoolConfig{Session: SessionConfig{Delivery: "sometimes"}}); err == nil {
+		t.Error("invalid delivery policy should fail")
+	}
+	pool, err := NewPool(PoolConfig{})
+	if err != nil {
+		t.Fatalf("NewPool() error: %v", err)
+	}
+	if got := pool.Stats().MaxConcurrent; got != DefaultPoolConcurrency {
+		t.Errorf("MaxConcurrent = %d, want %d", got, DefaultPoolConcurrency)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Error classification and retries
+// ---------------------------------------------------------------------------
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"overloaded", &ExitError{Code: 1, Stderr: `API Error: 529 {"type":"overloaded_error"}`}, ErrOverloaded},
+		{"rate limited", &ExitError{Code: 1, Stderr: "API Error: 429 rate_limit_error"}, ErrRateLimited},
+		{"usage limit", &ExitError{Code: 1, Stderr: "Claude AI usage limit reached"}, ErrRateLimited},
+		{"auth", &ExitError{Code: 1, Stderr: "Invalid API key · Please run /login"}, ErrAuth},
+		{"auth status", &ExitError{Code: 1, Stderr: `API Error: 401 {"type":"error","error":{"type":"authentication_error"}}`}, ErrAuth},
+		{"expired token", &ExitError{Code: 1, Stderr: "OAuth token has expired"}, ErrAuth},
+		{"auth words in logs", &ExitError{Code: 1, Stderr: "read 401 lines from auth.log\nauthentication plugin listening on :4401\nGET /admin -> unauthorized"}, nil},
+		{"status codes in logs", &ExitError{Code: 1, Stderr: "wrote 429 files, 529 skipped"}, nil},
+		{"network", &ExitError{Code: 1, Stderr: "Error: connect ECONNREFUSED 127.0.0.1:443"}, ErrNetwork},
+		{"unknown stderr", &ExitError{Code: 1, Stderr: "segmentation fault"}, nil},
+		{"wrapped", &StartError{Err: fmt.Errorf("attempt: %w", &ExitError{Code: 1, Stderr: "fetch failed"})}, ErrNetwork},
+		{"sentinel", fmt.Errorf("run: %w", ErrMaxTurns), ErrMaxTurns},
+		{"unrelated", errors.New("boom"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if errors.Is(&ExitError{Code: 1, Stderr: "overloaded"}, ErrRateLimited) {
+		t.Error("ExitError should only match its own class")
+	}
+}
+
+func TestResultErr(t *testing.T) {
+	resultMsg := func(raw string) *Result {
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return &Result{Text: "partial", Messages: []StreamMessage{msg}}
+	}
+	exitErr := &ExitError{Code: 1, Stderr: "rate limit"}
+
+	tests := []struct {
+		name    string
+		result  *Result
+		err     error
+		subtype string // expected ResultError subtype, or "" for none
+		is      []error
+	}{
+		{"success", resultMsg(scriptResult), nil, "", nil},
+		{"no result", &Result{}, exitErr, "", []error{ErrRateLimited}},
+		{"max turns", resultMsg(`{"type":"result","subtype":"error_max_turns","is_error":true,"num_turns":3}`), nil,
+			"error_max_turns", []error{ErrMaxTurns}},
+		{"budget", resultMsg(`{"type":"result","subtype":"error_max_budget_usd","is_error":true}`), nil,
+			"error_max_budget_usd", []error{ErrMaxBudget}},
+		{"structured output", resultMsg(`{"type":"result","subtype":"error_max_structured_output_retries","is_error":true}`), nil,
+			"error_max_structured_output_retries", []error{ErrStructuredOutputRetries}},
+		{"is_error text", resultMsg(`{"type":"result","subtype":"success","is_error":true,"result":"API Error: 529 Overloaded"}`), exitErr,
+			"success", []error{ErrOverloaded, ErrRateLimited}},
+		{"execution", resultMsg(`{"type":"result","subtype":"error_during_execution","is_error":true}`), exitErr,
+			"error_during_execution", []error{ErrExecution, ErrRateLimited}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.result.withErr(tt.err)
+			for _, target := range tt.is {
+				if !errors.Is(err, target) {
+					t.Errorf("errors.Is(%v, %v) = false", err, target)
+				}
+			}
+
+			var re *ResultError
+			if tt.subtype == "" {
+				if errors.As(err, &re) || tt.result.Err() != nil {
+					t.Errorf("unexpected ResultError: %v", err)
+				}
+				if err != tt.err {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.As(err, &re) || re.Subtype != tt.subtype || re.Text != "partial" {
+				t.Fatalf("err = %#v, want ResultError %s", err, tt.subtype)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Error("ResultError should wrap the process error")
+			}
+			if _, ok := tt.result.Err().(*ResultError); !ok {
+				t.Errorf("Result.Err() = %v", tt.result.Err())
+			}
+		})
+	}
+
+	// The subtype is preferred, the message refines it.
+	err := resultMsg(`{"type":"result","subtype":"error_during_execution","is_error":true,"result":"fetch failed"}`).withErr(nil)
+	if Classify(err) != ErrNetwork {
+		t.Errorf("Classify() = %v, want ErrNetwork", Classify(err))
+	}
+	if want := "claude: error_during_execution result after 0 turns: fetch failed"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestRunAndCollectResultError(t *testing.T) {
+	script := &scriptSpawner{
+		lines: []string{
+			scriptInit,
+			scriptAssistant,
+			`{"type":"result","subtype":"error_max_turns","is_error":true,"num_turns":2,"total_cost_usd":0.4}`,
+		},
+		exitCode: 1,
+	}
+	session, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{Spawner: script}})
+
+	result, err := session.RunAndCollect(context.Background(), "hi")
+	var re *ResultError
+	if !errors.As(err, &re) {
+		t.Fatalf("err = %v, want *ResultError", err)
+	}
+	if re.NumTurns != 2 || re.TotalCostUSD != 0.4 || re.Text != "Hello" {
+		t.Errorf("ResultError = %+v", re)
+	}
+	var exitErr *ExitError
+	if !errors.Is(err, ErrMaxTurns) || !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Errorf("err = %v, want ErrMaxTurns wrapping exit code 1", err)
+	}
+	if result == nil || result.Text != "Hello" || result.Err() == nil {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestConversationSendResultError(t *testing.T) {
+	sp := &scriptSpawner{lines: []string{
+		scriptStdin, scriptInit, `{"type":"result","subtype":"error_during_execution","is_error":true,"result":"API Error: 429"}`,
+		scriptStdin, scriptInit, scriptAssistant, scriptResult,
+	}}
+	conv, _ := NewConversation(SessionConfig{LaunchOptions: LaunchOptions{Spawner: sp}})
+	ctx := context.Background()
+	if err := conv.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer conv.Close()
+
+	if _, err := conv.Send(ctx, "one"); !errors.Is(err, ErrExecution) || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Send() turn 1 error = %v, want ResultError", err)
+	}
+	// The conversation stays usable after a failed turn.
+	if r, err := conv.Send(ctx, "two"); err != nil || r.Text != "Hello" {
+		t.Errorf("Send() turn 2 = %v, %v", r, err)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}.withDefaults()
+	var got []time.Duration
+	for attempt := 1; attempt <= 4; attempt++ {
+		got = append(got, p.delay(attempt))
+	}
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("delays = %v, want %v", got, want)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("jittered delay %v outside [5ms, 15ms]", d)
+		}
+	}
+
+	p.FallbackModels = []string{"sonnet", "haiku"}
+	var models []string
+	for attempt := 1; attempt <= 4; attempt++ {
+		models = append(models, p.model(attempt))
+	}
+	if got := strings.Join(models, ","); got != ",sonnet,haiku,haiku" {
+		t.Errorf("models = %s", got)
+	}
+
+	if _, err := NewSession(SessionConfig{Retry: &RetryPolicy{Jitter: 2}}); err == nil {
+		t.Error("Jitter above 1 should be rejected")
+	}
+}
+
+// flakySpawner fails the first failures runs with the given stderr and
+// result line, then succeeds.
+type flakySpawner struct {
+	failures int
+	stderr   string
+	result   string
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (f *flakySpawner) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	f.mu.Lock()
+	n := len(f.calls)
+	f.calls = append(f.calls, cfg.Args)
+	f.mu.Unlock()
+
+	if n < f.failures {
+		lines := []string{scriptInit}
+		if f.result != "" {
+			lines = append(lines, f.result)
+		}
+		return (&scriptSpawner{lines: lines, stderr: f.stderr, exitCode: 1}).Spawn(ctx, cfg)
+	}
+	return (&scriptSpawner{lines: []string{scriptInit, scriptAssistant, scriptResult}}).Spawn(ctx, cfg)
+}
+
+func TestRunAndCollectRetry(t *testing.T) {
+	spawner := &flakySpawner{
+		failures: 2,
+		result:   `{"type":"result","subtype":"success","is_error":true,"result":"API Error: 529 Overloaded","total_cost_usd":0.1}`,
+	}
+	type retry struct {
+		attempt int
+		class   error
+	}
+	var retries []retry
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{Model: "opus", Spawner: spawner},
+		Retry: &RetryPolicy{
+			InitialBackoff: time.Millisecond,
+			FallbackModels: []string{"sonnet"},
+			OnRetry: func(attempt int, err error, delay time.Duration) {
+				retries = append(retries, retry{attempt, Classify(err)})
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	result, err := session.RunAndCollect(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+	if result.Attempts != 3 || result.Text != "Hello" {
+		t.Errorf("result = %d attempts, text %q", result.Attempts, result.Text)
+	}
+	if fmt.Sprint(retries) != fmt.Sprint([]retry{{1, ErrOverloaded}, {2, ErrOverloaded}}) {
+		t.Errorf("OnRetry calls = %v", retries)
+	}
+
+	var models []string
+	for _, args := range spawner.calls {
+		models = append(models, args[indexOfArg(args, "--model")+1])
+	}
+	if got := strings.Join(models, ","); got != "opus,sonnet,sonnet" {
+		t.Errorf("models = %s, want opus,sonnet,sonnet", got)
+	}
+
+	// Cost includes the failed attempts.
+	if m := session.CurrentMetrics(); math.Abs(m.TotalCostUSD-0.45) > 1e-9 || result.Metrics.TotalCostUSD != m.TotalCostUSD {
+		t.Errorf("TotalCostUSD = %v (result %v), want 0.45", m.TotalCostUSD, result.Metrics.TotalCostUSD)
+	}
+	select {
+	case <-session.Done():
+	default:
+		t.Error("session should be done after RunAndCollect")
+	}
+	if _, err := session.RunAndCollect(context.Background(), "again"); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("second RunAndCollect() error = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestRunAndCollectRetryStops(t *testing.T) {
+	t.Run("not retryable", func(t *testing.T) {
+		spawner := &flakySpawner{failures: 5, stderr: "Invalid API key"}
+		session, _ := NewSession(SessionConfig{
+			LaunchOptions: LaunchOptions{Spawner: spawner},
+			Retry:         &RetryPolicy{InitialBackoff: time.Millisecond},
+		})
+		_, err := session.RunAndCollect(context.Background(), "hi")
+		if !errors.Is(err, ErrAuth) || len(spawner.calls) != 1 {
+			t.Errorf("err = %v after %d attempts, want ErrAuth after 1", err, len(spawner.calls))
+		}
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		spawner := &flakySpawner{failures: 5, stderr: "socket hang up"}
+		session, _ := NewSession(SessionConfig{
+			LaunchOptions: LaunchOptions{Spawner: spawner},
+			Retry:         &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		})
+		result, err := session.RunAndCollect(context.Background(), "hi")
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) || !errors.Is(err, ErrNetwork) {
+			t.Errorf("err = %v, want *ExitError matching ErrNetwork", err)
+		}
+		if len(spawner.calls) != 3 || result.Attempts != 3 {
+			t.Errorf("attempts = %d (result %d), want 3", len(spawner.calls), result.Attempts)
+		}
+		if !errors.Is(session.Err(), ErrNetwork) {
+			t.Errorf("session.Err() = %v", session.Err())
+		}
+	})
+
+	t.Run("context cancelled during backoff", func(t *testing.T) {
+		spawner := &flakySpawner{failures: 5, stderr: "overloaded"}
+		ctx, cancel := context.WithCancel(context.Background())
+		session, _ := NewSession(SessionConfig{
+			LaunchOptions: LaunchOptions{Spawner: spawner},
+			Retry: &RetryPolicy{
+				InitialBackoff: time.Hour,
+				OnRetry:        func(int, error, time.Duration) { cancel() },
+			},
+		})
+		if _, err := session.RunAndCollect(ctx, "hi"); !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+		if len(spawner.calls) != 1 {
+			t.Errorf("attempts = %d, want 1", len(spawner.calls))
+		}
+	})
+
+	t.Run("killed during backoff", func(t *testing.T) {
+		spawner := &flakySpawner{failures: 5, stderr: "overloaded"}
+		backoff := make(chan struct{})
+		session, _ := NewSession(SessionConfig{
+			LaunchOptions: LaunchOptions{Spawner: spawner},
+			Retry: &RetryPolicy{
+				InitialBackoff: time.Hour,
+				OnRetry:        func(int, error, time.Duration) { close(backoff) },
+			},
+		})
+		errc := make(chan error, 1)
+		go func() {
+			_, err := session.RunAndCollect(context.Background(), "hi")
+			errc <- err
+		}()
+		<-backoff
+		session.Kill()
+		select {
+		case err := <-errc:
+			if !errors.Is(err, ErrSessionClosed) {
+				t.Errorf("err = %v, want ErrSessionClosed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("RunAndCollect still waiting out the backoff after Kill")
+		}
+		if len(spawner.calls) != 1 {
+			t.Errorf("attempts = %d, want 1", len(spawner.calls))
+		}
+		select {
+		case <-session.Done():
+		default:
+			t.Error("session should be done after Kill")
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Typed structured output
+// ---------------------------------------------------------------------------
+
+type typedAddress struct {
+	City string `json:"city"`
+}
+
+func TestTypeSchema(t *testing.T) {
+	// typeSchema is schema.Reflect (tested in package schema) plus the
+	// CLI's requirement that the root be an object.
+	got, err := typeSchema(reflect.TypeOf(&typedAddress{}))
+	if err != nil {
+		t.Fatalf("typeSchema() error: %v", err)
+	}
+	want, _ := schema.For[typedAddress]()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("typeSchema() = %v, want schema.For() = %v", got, want)
+	}
+
+	for _, v := range []any{"", []typedAddress{}, map[string]string{}} {
+		if _, err := typeSchema(reflect.TypeOf(v)); err == nil {
+			t.Errorf("%T should be rejected", v)
+		}
+	}
+}
+
+func TestDecodeStructuredOutput(t *testing.T) {
+	schema, _ := typeSchema(reflect.TypeOf(typedAddress{}))
+
+	var addr typedAddress
+	if err := decodeStructuredOutput(map[string]any{"city": "Lima"}, schema, &addr); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if addr.City != "Lima" {
+		t.Errorf("City = %q", addr.City)
+	}
+
+	if err := decodeStructuredOutput(nil, schema, &addr); !errors.Is(err, ErrNoStructuredOutput) {
+		t.Errorf("nil output error = %v, want ErrNoStructuredOutput", err)
+	}
+
+	// Hand-written schemas with constraints are enforced too.
+	grade := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"score":   map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
+			"verdict": map[string]any{"type": "string", "enum": []string{"pass", "fail"}},
+			"notes":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"extra":   map[string]any{"type": "object"},
+		},
+		"required":             []string{"score", "verdict"},
+		"additionalProperties": false,
+	}
+	invalid := []map[string]any{
+		{"verdict": "pass"},
+		{"score": 5, "verdict": "maybe"},
+		{"score": 11, "verdict": "pass"},
+		{"score": 2.5, "verdict": "pass"},
+		{"score": "5", "verdict": "pass"},
+		{"score": 5, "verdict": "pass", "notes": []any{1}},
+		{"score": 5, "verdict": "pass", "unknown": true},
+	}
+	for _, out := range invalid {
+		var v map[string]any
+		if err := decodeStructuredOutput(out, grade, &v); !errors.Is(err, ErrInvalidOutput) {
+			t.Errorf("decode(%v) error = %v, want ErrInvalidOutput", out, err)
+		}
+	}
+
+	// Optional properties may be null.
+	var v map[string]any
+	if err := decodeStructuredOutput(map[string]any{"score": 5, "verdict": "pass", "extra": nil}, grade, &v); err != nil {
+		t.Errorf("null optional property: %v", err)
+	}
+}
+
+func TestRunTyped(t *testing.T) {
+	result := `{"type":"result","subtype":"success","result":"","total_cost_usd":0.1,"num_turns":1,` +
+		`"structured_output":{"city":"Lima"}}`
+	sp := &scriptSpawner{lines: []string{scriptInit, result}}
+
+	addr, res, err := RunTyped[typedAddress](context.Background(), SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: sp},
+	}, "where?")
+	if err != nil {
+		t.Fatalf("RunTyped() error: %v", err)
+	}
+	if addr.City != "Lima" {
+		t.Errorf("City = %q", addr.City)
+	}
+	if res == nil || res.TotalCost != 0.1 {
+		t.Errorf("result = %+v", res)
+	}
+
+	idx := indexOfArg(sp.got.Args, "--json-schema")
+	if idx < 0 {
+		t.Fatalf("--json-schema not passed: %v", sp.got.Args)
+	}
+	if !strings.Contains(sp.got.Args[idx+1], `"city"`) {
+		t.Errorf("derived schema = %s", sp.got.Args[idx+1])
+	}
+}
+
+func TestRunTypedInvalidOutput(t *testing.T) {
+	result := `{"type":"result","subtype":"success","result":"","total_cost_usd":0.1,"num_turns":1,` +
+		`"structured_output":{"town":"Lima"}}`
+	sp := &scriptSpawner{lines: []string{scriptInit, result}}
+
+	_, res, err := RunTyped[typedAddress](context.Background(), SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: sp},
+	}, "where?")
+	if !errors.Is(err, ErrInvalidOutput) {
+		t.Errorf("error = %v, want ErrInvalidOutput", err)
+	}
+	if res == nil {
+		t.Error("result should be returned alongside decode errors")
+	}
+
+	sp = &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	if _, _, err := RunTyped[typedAddress](context.Background(), SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: sp},
+	}, "where?"); !errors.Is(err, ErrNoStructuredOutput) {
+		t.Errorf("error = %v, want ErrNoStructuredOutput", err)
+	}
+
+	if _, _, err := RunTyped[string](context.Background(), SessionConfig{}, "x"); err == nil {
+		t.Error("non-struct type should be rejected")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Cost ledger
+// ---------------------------------------------------------------------------
+
+func TestPricingLookup(t *testing.T) {
+	pricing := DefaultPricing()
+	for _, model := range []string{"claude-haiku-4-5", "claude-haiku-4-5-20251001", "claude-haiku-4-5[1m]"} {
+		price, ok := pricing.Lookup(model)
+		if !ok || price.InputPerMTok != 1 {
+			t.Errorf("Lookup(%q) = %+v, %v", model, price, ok)
+		}
+	}
+	if price, _ := pricing.Lookup("claude-opus-4-1-20250805"); price.OutputPerMTok != 75 {
+		t.Errorf("Lookup(opus 4.1) = %+v", price)
+	}
+	for _, model := range []string{"claude-opus-4-6", "sonnet", ""} {
+		if _, ok := pricing.Lookup(model); ok {
+			t.Errorf("Lookup(%q) should not match", model)
+		}
+	}
+
+	// Numbers from a real haiku result, whose CLI cost was $0.0105216.
+	price, _ := pricing.Lookup("claude-haiku-4-5")
+	if got := price.Cost(7544, 104, 0, 24576); math.Abs(got-0.0105216) > 1e-9 {
+		t.Errorf("Cost() = %v, want 0.0105216", got)
+	}
+}
+
+func TestLedgerRecord(t *testing.T) {
+	ledger, _ := NewLedger(LedgerConfig{Pricing: Pricing{
+		"claude-sonnet-4-5": {InputPerMTok: 1, OutputPerMTok: 10, CacheWritePerMTok: 2, CacheReadPerMTok: 0.5},
+	}})
+	day := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	ledger.now = func() time.Time { return day }
+
+	// ModelUsage is cumulative: the second result only adds its increase.
+	turn1 := SessionMetrics{SessionID: "s1", ModelUsage: map[string]ModelUsage{
+		"claude-sonnet-4-5-20250929": {InputTokens: 1_000_000, CacheReadInputTokens: 2_000_000},
+		"claude-unknown":             {InputTokens: 10, CostUSD: 0.25},
+	}}
+	turn2 := SessionMetrics{SessionID: "s1", ModelUsage: map[string]ModelUsage{
+		"claude-sonnet-4-5-20250929": {InputTokens: 1_000_000, CacheReadInputTokens: 2_000_000, OutputTokens: 50_000, CacheCreationInputTokens: 250_000},
+		"claude-unknown":             {InputTokens: 10, CostUSD: 0.25},
+	}}
+	for _, m := range []SessionMetrics{turn1, turn2} {
+		if err := ledger.Record("review", m); err != nil {
+			t.Fatalf("Record() error: %v", err)
+		}
+	}
+
+	// Without ModelUsage, the turn's usage is billed to the session model,
+	// falling back to the reported cost for models without a price.
+	day = day.Add(2 * time.Hour)
+	ledger.Record("chat", SessionMetrics{Model: "claude-sonnet-4-5", InputTokens: 500_000})
+	ledger.Record("chat", SessionMetrics{Model: "custom", TotalCostUSD: 0.5})
+	ledger.Record("chat", SessionMetrics{Model: "custom"})
+
+	s := ledger.Summary()
+	if s.Records != 5 || math.Abs(s.TotalUSD-4.25) > 1e-9 {
+		t.Errorf("Summary() = %+v, want 5 records totalling 4.25", s)
+	}
+	want := map[string]float64{"review": 3.25, "chat": 1}
+	for label, cost := range want {
+		if math.Abs(s.ByLabel[label]-cost) > 1e-9 {
+			t.Errorf("ByLabel[%s] = %v, want %v", label, s.ByLabel[label], cost)
+		}
+	}
+	if math.Abs(s.ByModel["claude-sonnet-4-5-20250929"]-3) > 1e-9 || s.ByModel["claude-unknown"] != 0.25 || s.ByModel["custom"] != 0.5 {
+		t.Errorf("ByModel = %v", s.ByModel)
+	}
+	if math.Abs(s.ByDay["2026-03-01"]-3.25) > 1e-9 || s.ByDay["2026-03-02"] != 1 {
+		t.Errorf("ByDay = %v", s.ByDay)
+	}
+	if s.RemainingUSD != 0 || ledger.Check() != nil {
+		t.Errorf("ledger without limits: RemainingUSD = %v, Check() = %v", s.RemainingUSD, ledger.Check())
+	}
+
+	// Summaries are snapshots.
+	s.ByLabel["review"] = 0
+	if ledger.Summary().ByLabel["review"] == 0 {
+		t.Error("Summary() shares its maps with the ledger")
+	}
+}
+
+func TestLedgerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spend.jsonl")
+	ledger, err := NewLedger(LedgerConfig{Path: path})
+	if err != nil {
+		t.Fatalf("NewLedger() error: %v", err)
+	}
+	ledger.Record("a", SessionMetrics{Model: "claude-haiku-4-5", InputTokens: 1_000_000})
+	ledger.Record("b", SessionMetrics{Model: "custom", CostUSD: 2})
+	if err := ledger.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if err := ledger.Record("c", SessionMetrics{Model: "custom", CostUSD: 1}); err == nil {
+		t.Error("Record() after Close should fail")
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var rec LedgerRecord
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &rec) != nil {
+		t.Fatalf("ledger file = %s", data)
+	}
+	if rec.Label != "a" || rec.Model != "claude-haiku-4-5" || rec.InputTokens != 1_000_000 || rec.CostUSD != 1 || !rec.Priced {
+		t.Errorf("record = %+v", rec)
+	}
+
+	// A record cut off mid-line is dropped and the next one starts clean.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	f.WriteString(`{"label":"torn","cost_usd":`)
+	f.Close()
+
+	reopened, err := NewLedger(LedgerConfig{Path: path})
+	if err != nil {
+		t.Fatalf("reopen error: %v", err)
+	}
+	reopened.Record("c", SessionMetrics{Model: "custom", CostUSD: 0.5})
+	reopened.Close()
+	if s := reopened.Summary(); s.TotalUSD != 3.5 || s.ByLabel["a"] != 1 || s.Records != 3 {
+		t.Errorf("reopened Summary() = %+v", s)
+	}
+
+	final, err := NewLedger(LedgerConfig{Path: path})
+	if err != nil || final.Total() != 3.5 {
+		t.Errorf("final ledger = %v, %v; want 3.5", final.Total(), err)
+	}
+	final.Close()
+
+	bad := filepath.Join(t.TempDir(), "bad.jsonl")
+	os.WriteFile(bad, []byte("{}\nnot json\n"), 0o600)
+	if _, err := NewLedger(LedgerConfig{Path: bad}); err == nil || !strings.Contains(err.Error(), "bad.jsonl:2") {
+		t.Errorf("NewLedger(bad) error = %v, want line 2", err)
+	}
+	if _, err := NewLedger(LedgerConfig{HardLimitUSD: -1}); err == nil {
+		t.Error("NewLedger should reject a negative HardLimitUSD")
+	}
+}
+
+func TestLedgerLimits(t *testing.T) {
+	var fired []float64
+	ledger, _ := NewLedger(LedgerConfig{
+		SoftLimitUSD: 1,
+		OnSoftLimit:  func(total float64) { fired = append(fired, total) },
+		HardLimitUSD: 2,
+	})
+
+	opts, err := ledger.Attach("job", LaunchOptions{MaxBudgetUSD: 5})
+	if err != nil || opts.MaxBudgetUSD != 2 || opts.Hooks == nil {
+		t.Fatalf("Attach() = %+v, %v; want budget capped at 2", opts, err)
+	}
+	opts.Hooks.OnMetrics(SessionMetrics{Model: "custom", CostUSD: 0.5})
+	opts.Hooks.OnMetrics(SessionMetrics{Model: "custom", CostUSD: 0.75})
+	opts.Hooks.OnMetrics(SessionMetrics{Model: "custom", CostUSD: 0.25})
+	if len(fired) != 1 || fired[0] != 1.25 {
+		t.Errorf("OnSoftLimit calls = %v, want one at 1.25", fired)
+	}
+
+	opts, err = ledger.Attach("job", LaunchOptions{MaxBudgetUSD: 0.1})
+	if err != nil || opts.MaxBudgetUSD != 0.1 {
+		t.Errorf("Attach() = %v, %v; want a smaller budget kept", opts.MaxBudgetUSD, err)
+	}
+	opts.Hooks.OnMetrics(SessionMetrics{Model: "custom", CostUSD: 0.5})
+	if err := ledger.Check(); !errors.Is(err, ErrSpendLimit) {
+		t.Errorf("Check() = %v, want ErrSpendLimit", err)
+	}
+	if _, err := ledger.Attach("job", LaunchOptions{}); !errors.Is(err, ErrSpendLimit) {
+		t.Errorf("Attach() over limit error = %v, want ErrSpendLimit", err)
+	}
+	if s := ledger.Summary(); s.RemainingUSD != 0 || s.TotalUSD != 2 {
+		t.Errorf("Summary() = %+v", s)
+	}
+}
+
+func TestLedgerSession(t *testing.T) {
+	ledger, _ := NewLedger(LedgerConfig{})
+	var metrics []SessionMetrics
+	opts, _ := ledger.Attach("session", LaunchOptions{
+		Spawner: &scriptSpawner{lines: []string{
+			scriptInit,
+			scriptAssistant,
+			`{"type":"result","subtype":"success","session_id":"sess-1","total_cost_usd":0.25,"usage":{"input_tokens":1000,"output_tokens":100}}`,
+		}},
+		Hooks: &Hooks{OnMetrics: func(m SessionMetrics) { metrics = append(metrics, m) }},
+	})
+	session, _ := NewSession(SessionConfig{LaunchOptions: opts})
+	if _, err := session.RunAndCollect(context.Background(), "hi"); err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	// The result has no model, so the one from the init message is priced.
+	if len(metrics) != 1 || metrics[0].Model != "claude-sonnet-4-20250514" {
+		t.Errorf("chained OnMetrics = %+v", metrics)
+	}
+	if m := session.CurrentMetrics(); m.Model != "claude-sonnet-4-20250514" {
+		t.Errorf("CurrentMetrics().Model = %q, want init model kept", m.Model)
+	}
+	s := ledger.Summary()
+	if math.Abs(s.ByModel["claude-sonnet-4-20250514"]-0.0045) > 1e-9 || s.ByLabel["session"] != s.TotalUSD {
+		t.Errorf("Summary() = %+v", s)
+	}
+}
+
+func TestLedgerConversation(t *testing.T) {
+	ledger, _ := NewLedger(LedgerConfig{})
+	init := `{"type":"system","subtype":"init","session_id":"sess-1","model":"custom-model"}`
+	sp := &scriptSpawner{lines: []string{
+		scriptStdin, init, scriptAssistant, `{"type":"result","subtype":"success","session_id":"sess-1","total_cost_usd":0.25}`,
+		scriptStdin, scriptAssistant, `{"type":"result","subtype":"success","session_id":"sess-1","total_cost_usd":0.75}`,
+		scriptStdin, scriptAssistant, `{"type":"result","subtype":"success","session_id":"sess-1","total_cost_usd":1}`,
+	}}
+	opts, _ := ledger.Attach("chat", LaunchOptions{Spawner: sp})
+	conv, _ := NewConversation(SessionConfig{LaunchOptions: opts})
+	ctx := context.Background()
+	if err := conv.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	for _, prompt := range []string{"one", "two", "three"} {
+		if _, err := conv.Send(ctx, prompt); err != nil {
+			t.Fatalf("Send(%q) error: %v", prompt, err)
+		}
+	}
+	conv.Close()
+
+	// Each turn reports the cumulative total; only its increase is billed.
+	s := ledger.Summary()
+	if s.Records != 3 || math.Abs(s.TotalUSD-1) > 1e-9 {
+		t.Errorf("Summary() = %+v, want 3 records totalling 1", s)
+	}
+
+	// Another session starts from zero.
+	ledger.Record("chat", SessionMetrics{SessionID: "sess-2", Model: "custom-model", TotalCostUSD: 0.5})
+	if total := ledger.Total(); math.Abs(total-1.5) > 1e-9 {
+		t.Errorf("Total() = %v, want 1.5", total)
+	}
+}
+
+func TestLedgerAttachMinimumBudget(t *testing.T) {
+	ledger, _ := NewLedger(LedgerConfig{HardLimitUSD: 1})
+	ledger.Record("job", SessionMetrics{Model: "custom", CostUSD: 0.998})
+
+	opts, err := ledger.Attach("job", LaunchOptions{})
+	if err != nil || opts.MaxBudgetUSD != 0.01 {
+		t.Fatalf("Attach() = %v, %v; want budget floored at 0.01", opts.MaxBudgetUSD, err)
+	}
+	args, _ := buildArgs("hi", opts, "")
+	if i := indexOfArg(args, "--max-budget-usd"); i < 0 || args[i+1] != "0.01" {
+		t.Errorf("args = %v, want --max-budget-usd 0.01", args)
+	}
+}
+
+func TestChainHooks(t *testing.T) {
+	var calls []string
+	a := &Hooks{OnText: func(s string) { calls = append(calls, "a:"+s) }}
+	b := &Hooks{
+		OnText:  func(s string) { calls = append(calls, "b:"+s) },
+		OnStart: func(pid int) { calls = append(calls, fmt.Sprint("b:", pid)) },
+	}
+	h := ChainHooks(a, nil, b)
+	h.invokeText("x")
+	h.invokeStart(7)
+	h.invokeExit(0, 0)
+	if got := strings.Join(calls, ","); got != "a:x,b:x,b:7" {
+		t.Errorf("calls = %s", got)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Tracing
+// ---------------------------------------------------------------------------
+
+// spansByName indexes spans by name, keeping the last of each.
+func spansByName(spans []SpanData) map[string]SpanData {
+	m := make(map[string]SpanData)
+	for _, s := range spans {
+		m[s.Name] = s
+	}
+	return m
+}
+
+func TestSessionTrace(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	session, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			Model: "sonnet",
+			Spawner: &scriptSpawner{lines: []string{
+				scriptInit,
+				`{"type":"assistant","message":{"id":"m1","model":"claude-sonnet-4-5","content":[{"type":"text","text":"Reading."}],"usage":{"input_tokens":1000,"output_tokens":10}}}`,
+				`{"type":"assistant","message":{"id":"m1","model":"claude-sonnet-4-5","content":[{"type":"tool_use","id":"t1","name":"Read","input":{}}],"usage":{"input_tokens":1000,"output_tokens":20}}}`,
+				`{"type":"assistant","parent_tool_use_id":"t1","message":{"id":"sub","content":[{"type":"text","text":"subagent"}]}}`,
+				`{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"data"}]}}`,
+				`{"type":"assistant","message":{"id":"m2","model":"claude-sonnet-4-5","content":[{"type":"tool_use","id":"t2","name":"Bash","input":{}}]}}`,
+				`{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t2","content":"exit 1","is_error":true}]}}`,
+				`{"type":"assistant","message":{"id":"m3","model":"claude-sonnet-4-5","stop_reason":"end_turn","content":[{"type":"text","text":"Done."}]}}`,
+				`{"type":"result","subtype":"success","session_id":"sess-1","total_cost_usd":0.25,"num_turns":3,"usage":{"input_tokens":3000,"output_tokens":50}}`,
+			}},
+		},
+		Tracer: NewTracer(exporter),
+	})
+	if _, err := session.RunAndCollect(context.Background(), "hi"); err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	spans := exporter.Spans()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	want := "execute_tool Read,chat,execute_tool Bash,chat,chat,invoke_agent"
+	if got := strings.Join(names, ","); got != want {
+		t.Fatalf("spans = %s, want %s", got, want)
+	}
+
+	root, turn1, read, bash := spans[5], spans[1], spans[0], spans[2]
+	if root.ParentSpanID != "" || len(root.TraceID) != 32 || len(root.SpanID) != 16 {
+		t.Errorf("root = %+v", root)
+	}
+	for _, s := range spans[:5] {
+		if s.TraceID != root.TraceID {
+			t.Errorf("span %s in trace %s, want %s", s.Name, s.TraceID, root.TraceID)
+		}
+	}
+	if turn1.ParentSpanID != root.SpanID || read.ParentSpanID != turn1.SpanID || bash.ParentSpanID != spans[3].SpanID {
+		t.Error("spans are not nested run > turn > tool")
+	}
+	if turn1.Start.Before(root.Start) {
+		t.Error("first turn starts before the run")
+	}
+	if spans[3].Start != read.End || turn1.End != read.End {
+		t.Error("second turn should start when the first turn's tool result arrived")
+	}
+
+	a := root.Attributes
+	if a["gen_ai.request.model"] != "sonnet" || a["gen_ai.response.model"] != "claude-sonnet-4-20250514" ||
+		a["gen_ai.conversation.id"] != "sess-1" || a["claude.cost_usd"] != 0.25 ||
+		a["gen_ai.usage.input_tokens"] != 3000 || a["claude.num_turns"] != 3 || root.Error != "" {
+		t.Errorf("root attributes = %v", a)
+	}
+	a = turn1.Attributes
+	if a["gen_ai.response.id"] != "m1" || a["gen_ai.response.model"] != "claude-sonnet-4-5" || a["gen_ai.usage.output_tokens"] != 20 {
+		t.Errorf("turn attributes = %v", a)
+	}
+	if cost, _ := a["claude.cost_usd"].(float64); math.Abs(cost-0.0033) > 1e-9 {
+		t.Errorf("turn cost = %v, want 0.0033", a["claude.cost_usd"])
+	}
+	if spans[4].Attributes["gen_ai.response.finish_reasons"] != "end_turn" {
+		t.Errorf("last turn attributes = %v", spans[4].Attributes)
+	}
+	if read.Attributes["gen_ai.tool.call.id"] != "t1" || read.Attributes["claude.tool.is_error"] != false || read.Error != "" {
+		t.Errorf("Read span = %+v", read)
+	}
+	if bash.Attributes["claude.tool.is_error"] != true || bash.Error != "exit 1" {
+		t.Errorf("Bash span = %+v", bash)
+	}
+}
+
+func TestSessionTraceErrors(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	session, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: &scriptSpawner{
+			lines: []string{
+				scriptInit,
+				`{"type":"assistant","message":{"id":"m1","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{}}]}}`,
+				`{"type":"result","subtype":"error_max_turns","is_error":true,"num_turns":1}`,
+			},
+			exitCode: 1,
+		}},
+		Tracer: tracer,
+	})
+	session.RunAndCollect(context.Background(), "hi")
+
+	spans := spansByName(exporter.Spans())
+	if !strings.Contains(spans["invoke_agent"].Error, "error_max_turns") {
+		t.Errorf("root error = %q, want the result subtype", spans["invoke_agent"].Error)
+	}
+	if spans["execute_tool Bash"].Error == "" || spans["chat"].End.IsZero() {
+		t.Errorf("unfinished spans = %+v", spans)
+	}
+
+	// A run that fails to start still ends its span.
+	exporter.Reset()
+	failing := spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+		return nil, errors.New("no sandbox")
+	})
+	session, _ = NewSession(SessionConfig{LaunchOptions: LaunchOptions{Spawner: failing}, Tracer: tracer})
+	if err := session.Run(context.Background(), "hi"); err == nil {
+		t.Fatal("Run() should fail")
+	}
+	if spans := exporter.Spans(); len(spans) != 1 || !strings.Contains(spans[0].Error, "no sandbox") {
+		t.Errorf("spans = %+v", spans)
+	}
+}
+
+func TestTracerNesting(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx, parent := tracer.Start(context.Background(), "parent", start, Attribute{"k", "v"})
+	_, child := tracer.Start(ctx, "child", start.Add(time.Second))
+	_, other := tracer.Start(context.Background(), "other", start)
+	child.RecordError(errors.New("boom"))
+	child.End(start.Add(2 * time.Second))
+	child.End(start.Add(3 * time.Second)) // ignored
+	parent.End(start.Add(4 * time.Second))
+	parent.SetAttributes(Attribute{"k", "changed"})
+	other.End(start)
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("spans = %+v", spans)
+	}
+	c, p, o := spans[0], spans[1], spans[2]
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID || o.TraceID == p.TraceID {
+		t.Errorf("IDs: child %s/%s, parent %s/%s, other %s", c.TraceID, c.ParentSpanID, p.TraceID, p.SpanID, o.TraceID)
+	}
+	if c.Error != "boom" || c.End != start.Add(2*time.Second) || p.Attributes["k"] != "v" {
+		t.Errorf("child = %+v, parent = %+v", c, p)
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := NewJSONExporter(&buf)
+	tracer := NewTracer(exporter)
+	_, span := tracer.Start(context.Background(), "run", time.Now(), Attribute{"gen_ai.usage.input_tokens", 12})
+	span.End(time.Now())
+
+	var got SpanData
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output %q: %v", buf.String(), err)
+	}
+	if got.Name != "run" || got.Attributes["gen_ai.usage.input_tokens"] != float64(12) || exporter.Close() != nil {
+		t.Errorf("exported = %+v", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	file, err := NewJSONFileExporter(path)
+	if err != nil {
+		t.Fatalf("NewJSONFileExporter() error: %v", err)
+	}
+	file.ExportSpan(got)
+	file.ExportSpan(got)
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	file.ExportSpan(got)
+	if err := file.Close(); err == nil {
+		t.Error("Close() should report the write after close")
+	}
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("file has %d lines, want 2", n)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Logging
+// ---------------------------------------------------------------------------
+
+// logRecords decodes the JSON log records in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decode log record: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := []string{
+		"--print",
+		"--model", "sonnet",
+		"--settings", `{"env":{"ANTHROPIC_API_KEY":"sk-ant-api03-abc","LOG":"1"},"apiKeyHelper":"vault read x"}`,
+		"--mcp-config", `{"mcpServers":{"s":{"headers":{"Authorization":"Bearer xyz.123"}}}}`,
+		"--flag=GITHUB_TOKEN=ghp_123",
+		"--", "secret plans",
+	}
+	got := redactArgs(args)
+	joined := strings.Join(got, " ")
+	for _, secret := range []string{"sk-ant", "vault read", "xyz.123", "ghp_123", "secret plans"} {
+		if strings.Contains(joined, secret) {
+			t.Errorf("redacted args leak %q: %s", secret, joined)
+		}
+	}
+	for _, kept := range []string{"--model sonnet", `"LOG":"1"`, "GITHUB_TOKEN=[REDACTED]", "[12-byte prompt]"} {
+		if !strings.Contains(joined, kept) {
+			t.Errorf("redacted args missing %q: %s", kept, joined)
+		}
+	}
+	if args[len(args)-1] != "secret plans" {
+		t.Error("redactArgs modified its input")
+	}
+}
+
+func TestSessionLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	session, _ := NewSession(SessionConfig{
+		ID: "job-7",
+		LaunchOptions: LaunchOptions{
+			Logger: logger,
+			Env:    map[string]string{"SECRET": "hidden-value"},
+			Spawner: &scriptSpawner{
+				lines: []string{
+					scriptInit,
+					`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Bash","input":{}}]}}`,
+					`not json`,
+					`{"type":"result","subtype":"error_max_turns","is_error":true,"session_id":"sess-1","num_turns":2,"total_cost_usd":0.25,"duration_ms":1500,"usage":{"input_tokens":10,"output_tokens":5}}`,
+				},
+				stderr:   "warning: low disk\nfatal: stopped\n",
+				exitCode: 1,
+			},
+		},
+	})
+	session.RunAndCollect(context.Background(), "hi")
+
+	if strings.Contains(buf.String(), "hidden-value") {
+		t.Error("log leaks environment")
+	}
+	byMsg := make(map[string][]map[string]any)
+	for _, r := range logRecords(t, &buf) {
+		if r["session"] != "job-7" || r["pid"] != float64(4242) {
+			t.Errorf("record without session/pid: %v", r)
+		}
+		byMsg[r["msg"].(string)] = append(byMsg[r["msg"].(string)], r)
+	}
+
+	started := byMsg["claude: process started"]
+	if len(started) != 1 || started[0]["binary"] != "claude" || started[0]["level"] != "INFO" {
+		t.Fatalf("start records = %v", started)
+	}
+	if args, _ := started[0]["args"].([]any); len(args) == 0 || args[len(args)-1] != "[2-byte prompt]" {
+		t.Errorf("logged args = %v", started[0]["args"])
+	}
+	if r := byMsg["claude: tool call"]; len(r) != 1 || r[0]["tool"] != "Bash" || r[0]["tool_use_id"] != "t1" || r[0]["level"] != "DEBUG" {
+		t.Errorf("tool call records = %v", r)
+	}
+	if r := byMsg["claude: parse error"]; len(r) != 1 || r[0]["line"] != "not json" || r[0]["level"] != "WARN" {
+		t.Errorf("parse error records = %v", r)
+	}
+	result := byMsg["claude: result"]
+	if len(result) != 1 {
+		t.Fatalf("result records = %v", result)
+	}
+	r := result[0]
+	if r["level"] != "WARN" || r["session_id"] != "sess-1" || r["model"] != "claude-sonnet-4-20250514" ||
+		r["subtype"] != "error_max_turns" || r["num_turns"] != float64(2) || r["cost_usd"] != 0.25 ||
+		r["input_tokens"] != float64(10) || r["duration"] != float64(1500*time.Millisecond) || r["error"] == nil {
+		t.Errorf("result record = %v", r)
+	}
+	if r := byMsg["claude: stderr"]; len(r) != 2 || r[1]["line"] != "fatal: stopped" {
+		t.Errorf("stderr records = %v", r)
+	}
+	exited := byMsg["claude: process exited"]
+	if len(exited) != 1 || exited[0]["exit_code"] != float64(1) || exited[0]["level"] != "WARN" || exited[0]["duration"] == nil {
+		t.Errorf("exit records = %v", exited)
+	}
+}
+
+func TestLauncherLoggerStartFailure(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	failing := spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+		return nil, errors.New("no sandbox")
+	})
+	launcher := NewLauncher()
+	if err := launcher.Start(context.Background(), "hi", LaunchOptions{Spawner: failing, Logger: logger, BinaryPath: "/opt/claude"}); err == nil {
+		t.Fatal("Start() should fail")
+	}
+	records := logRecords(t, &buf)
+	if len(records) != 1 || records[0]["msg"] != "claude: process start failed" ||
+		records[0]["binary"] != "/opt/claude" || !strings.Contains(records[0]["error"].(string), "no sandbox") {
+		t.Errorf("records = %v", records)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Process groups and shutdown
+// ---------------------------------------------------------------------------
+
+// stubbornProcess blocks until killed. With exitOnInterrupt set it instead
+// writes a result and exits on SIGINT, as the CLI does.
+type stubbornProcess struct {
+	stdoutR         *io.PipeReader
+	stdoutW         *io.PipeWriter
+	exitOnInterrupt bool
+	done            chan struct{}
+	once            sync.Once
+
+	mu      sync.Mutex
+	signals []os.Signal
+	killed  bool
+}
+
+func newStubbornProcess(exitOnInterrupt bool) *stubbornProcess {
+	r, w := io.Pipe()
+	return &stubbornProcess{stdoutR: r, stdoutW: w, exitOnInterrupt: exitOnInterrupt, done: make(chan struct{})}
+}
+
+func (p *stubbornProcess) spawner() Spawner {
+	return spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) { return p, nil })
+}
+
+func (p *stubbornProcess) Stdin() io.WriteCloser { return nil }
+func (p *stubbornProcess) Stdout() io.Reader     { return p.stdoutR }
+func (p *stubbornProcess) Stderr() io.Reader     { return strings.NewReader("") }
+func (p *stubbornProcess) Pid() int              { return 4343 }
+
+func (p *stubbornProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	p.signals = append(p.signals, sig)
+	p.mu.Unlock()
+	if p.exitOnInterrupt {
+		go func() {
+			io.WriteString(p.stdoutW, scriptResult+"\n")
+			p.exit()
+		}()
+	}
+	return nil
+}
+
+func (p *stubbornProcess) Kill() error {
+	p.mu.Lock()
+	p.killed = true
+	p.mu.Unlock()
+	p.exit()
+	return nil
+}
+
+func (p *stubbornProcess) exit() {
+	p.once.Do(func() {
+		p.stdoutW.Close()
+		close(p.done)
+	})
+}
+
+func (p *stubbornProcess) Wait() error {
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.killed {
+		return scriptExitError(-1)
+	}
+	return nil
+}
+
+func TestLauncherShutdown(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	tests := []struct {
+		name            string
+		exitOnInterrupt bool
+		wantKilled      bool
+	}{
+		{"exits on interrupt", true, false},
+		{"killed after grace", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := newStubbornProcess(tt.exitOnInterrupt)
+			l := NewLauncher()
+			if err := l.Start(context.Background(), "hi", LaunchOptions{Spawner: proc.spawner()}); err != nil {
+				t.Fatalf("Start() error: %v", err)
+			}
+
+			// Read to EOF and then Wait, as callers do.
+			var types []string
+			waitErr := make(chan error, 1)
+			go func() {
+				for {
+					msg, _ := l.ReadMessage()
+					if msg == nil {
+						break
+					}
+					types = append(types, msg.Type)
+				}
+				waitErr <- l.Wait()
+			}()
+
+			start := time.Now()
+			if err := l.Shutdown(context.Background(), grace); err != nil {
+				t.Fatalf("Shutdown() error: %v", err)
+			}
+			elapsed := time.Since(start)
+			err := <-waitErr
+
+			select {
+			case <-l.Done():
+			default:
+				t.Error("Done() not closed after Shutdown")
+			}
+			if len(proc.signals) != 1 || proc.signals[0] != syscall.SIGINT {
+				t.Errorf("signals = %v, want [SIGINT]", proc.signals)
+			}
+			if proc.killed != tt.wantKilled {
+				t.Errorf("killed = %v, want %v", proc.killed, tt.wantKilled)
+			}
+			if tt.wantKilled {
+				if elapsed < grace {
+					t.Errorf("killed after %v, before the %v grace period", elapsed, grace)
+				}
+				var exitErr *ExitError
+				if !errors.As(err, &exitErr) || exitErr.Code != -1 {
+					t.Errorf("Wait() error = %v, want exit code -1", err)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Wait() error = %v", err)
+				}
+				if strings.Join(types, ",") != "result" {
+					t.Errorf("message types = %v, want the result", types)
+				}
+			}
+			if again := l.Wait(); again != err {
+				t.Errorf("second Wait() = %v, want %v", again, err)
+			}
+			if err := l.Shutdown(context.Background(), grace); err != nil {
+				t.Errorf("Shutdown() after exit error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLauncherShutdownContext(t *testing.T) {
+	proc := newStubbornProcess(false)
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", LaunchOptions{Spawner: proc.spawner()}); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer l.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Shutdown(ctx, time.Hour); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+	if !proc.killed {
+		t.Error("process should be killed when ctx ends")
+	}
+
+	if err := NewLauncher().Shutdown(context.Background(), 0); err != ErrNotStarted {
+		t.Errorf("Shutdown() before Start = %v, want ErrNotStarted", err)
+	}
+}
+
+func TestLauncherShutdownWithoutWait(t *testing.T) {
+	proc := newStubbornProcess(false)
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", LaunchOptions{Spawner: proc.spawner()}); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	// Nothing reads stdout or calls Wait.
+	done := make(chan error, 1)
+	go func() { done <- l.Shutdown(context.Background(), 10*time.Millisecond) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown() error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown() blocked without a caller in Wait")
+	}
+	select {
+	case <-l.Done():
+	default:
+		t.Error("Done() not closed after Shutdown")
+	}
+	var exitErr *ExitError
+	if err := l.Wait(); !errors.As(err, &exitErr) {
+		t.Errorf("Wait() after Shutdown = %v, want *ExitError", err)
+	}
+}
+
+func TestLauncherStartWriteFailure(t *testing.T) {
+	sp := &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	broken := spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+		p, err := sp.Spawn(ctx, cfg)
+		sp.proc.stdinR.CloseWithError(errors.New("broken pipe"))
+		return p, err
+	})
+	var started bool
+	l := NewLauncher()
+	err := l.Start(context.Background(), "hi", LaunchOptions{
+		Spawner:     broken,
+		InputFormat: InputFormatStreamJSON,
+		Hooks:       &Hooks{OnStart: func(int) { started = true }},
+	})
+	var startErr *StartError
+	if !errors.As(err, &startErr) {
+		t.Fatalf("Start() error = %v, want *StartError", err)
+	}
+
+	// The process was killed and reaped before Start returned.
+	select {
+	case <-sp.proc.done:
+	default:
+		t.Error("process still running after a failed Start")
+	}
+	select {
+	case <-l.Done():
+	default:
+		t.Error("Done() not closed after a failed Start")
+	}
+	if l.Running() || started {
+		t.Errorf("Running() = %v, OnStart called = %v; want neither", l.Running(), started)
+	}
+}
+
+func TestLauncherDoneOnStartFailure(t *testing.T) {
+	failing := spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+		return nil, errors.New("no sandbox")
+	})
+	l := NewLauncher()
+	if err := l.Start(context.Background(), "hi", LaunchOptions{Spawner: failing}); err == nil {
+		t.Fatal("Start() should fail")
+	}
+	select {
+	case <-l.Done():
+	default:
+		t.Error("Done() not closed after Start failure")
+	}
+	if err := l.Start(context.Background(), "hi", LaunchOptions{Spawner: failing}); err != ErrAlreadyStarted {
+		t.Errorf("second Start() error = %v, want ErrAlreadyStarted", err)
+	}
+	if err := l.Wait(); err != ErrNotStarted {
+		t.Errorf("Wait() error = %v, want ErrNotStarted", err)
+	}
+}
+
+func TestSessionShutdown(t *testing.T) {
+	proc := newStubbornProcess(false)
+	s, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{Spawner: proc.spawner()},
+		Delivery:      DeliveryBlock,
+	})
+	if err := s.Shutdown(context.Background(), 0); err != ErrNotStarted {
+		t.Errorf("Shutdown() before Run = %v, want ErrNotStarted", err)
+	}
+	if err := s.Run(context.Background(), "hi"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if err := s.Shutdown(context.Background(), 10*time.Millisecond); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session did not finish after Shutdown")
+	}
+	if !proc.killed {
+		t.Error("process should be killed after the grace period")
+	}
+}
+
+// TestExecSpawnerProcessGroup runs a shell script that leaves a background
+// child holding stdout, and checks the child dies with the script.
+func TestExecSpawnerProcessGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc")
+	}
+	script := filepath.Join(t.TempDir(), "claude")
+	body := `#!/bin/sh
+sleep 300 &
+echo '{"type":"system","session_id":"'$!'"}'
+`
+	tests := []struct {
+		name string
+		tail string
+		kill bool
+	}{
+		{"exit", "exit 0\n", false},
+		{"kill", "exec sleep 300\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(script, []byte(body+tt.tail), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			l := NewLauncher()
+			if err := l.Start(context.Background(), "hi", LaunchOptions{BinaryPath: script}); err != nil {
+				t.Fatalf("Start() error: %v", err)
+			}
+			msg, err := l.ReadMessage()
+			if err != nil || msg == nil {
+				t.Fatalf("ReadMessage() = %v, %v", msg, err)
+			}
+			child := msg.SessionID
+			if tt.kill {
+				if err := l.Kill(); err != nil {
+					t.Fatalf("Kill() error: %v", err)
+				}
+			}
+
+			// The background sleep shares stdout: EOF means it is gone.
+			eof := make(chan struct{})
+			go func() {
+				defer close(eof)
+				for {
+					if msg, _ := l.ReadMessage(); msg == nil {
+						return
+					}
+				}
+			}()
+			select {
+			case <-eof:
+			case <-time.After(5 * time.Second):
+				t.Fatal("stdout still open: background child outlived the CLI")
+			}
+			err = l.Wait()
+			if tt.kill == (err == nil) {
+				t.Errorf("Wait() error = %v", err)
+			}
+
+			// SIGKILL is asynchronous; the child may still be exiting.
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				stat, err := os.ReadFile("/proc/" + child + "/stat")
+				if err != nil || strings.Contains(string(stat), ") Z ") {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("background child %s still running: %s", child, stat)
+				}
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Stream decoder
+// ---------------------------------------------------------------------------
+
+func TestDecoder(t *testing.T) {
+	big := `{"type":"assistant","message":{"content":[{"type":"text","text":"` + strings.Repeat("x", 3<<20) + `"}]}}`
+	input := strings.Join([]string{scriptInit, "", "  ", "not json", big, scriptAssistant + "\r", scriptResult}, "\n")
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []string // message type, or the error type for a skipped line
+	}{
+		{"no limit", 0, []string{"system", "*claude.ParseError", "assistant", "assistant", "result"}},
+		{"limit", 1 << 20, []string{"system", "*claude.ParseError", "*claude.LineTooLongError", "assistant", "result"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A small reader exercises lines spanning many reads.
+			d := NewDecoder(iotest.HalfReader(strings.NewReader(input)), tt.limit)
+			var got []string
+			for {
+				msg, err := d.Decode()
+				if err != nil {
+					if !skippable(err) {
+						t.Fatalf("Decode() error: %v", err)
+					}
+					got = append(got, fmt.Sprintf("%T", err))
+					continue
+				}
+				if msg == nil {
+					break
+				}
+				got = append(got, msg.Type)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("decoded %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Exactly at the limit is allowed; a final line needs no newline.
+	d := NewDecoder(strings.NewReader(scriptResult+"\n"+scriptResult), len(scriptResult))
+	for i := 0; i < 2; i++ {
+		if msg, err := d.Decode(); err != nil || msg == nil {
+			t.Fatalf("Decode() #%d = %v, %v", i, msg, err)
+		}
+	}
+
+	d = NewDecoder(strings.NewReader(big+"\n"), 100)
+	_, err := d.Decode()
+	var tooLong *LineTooLongError
+	if !errors.As(err, &tooLong) {
+		t.Fatalf("Decode() error = %v, want *LineTooLongError", err)
+	}
+	if tooLong.Size != len(big) || tooLong.Limit != 100 || tooLong.Prefix != big[:100] {
+		t.Errorf("LineTooLongError = {%d %d %q}", tooLong.Size, tooLong.Limit, tooLong.Prefix)
+	}
+	if msg, err := d.Decode(); msg != nil || err != nil {
+		t.Errorf("Decode() at EOF = %v, %v", msg, err)
+	}
+}
+
+func TestSessionLineTooLong(t *testing.T) {
+	huge := `{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Write","input":{"content":"` +
+		strings.Repeat("y", 2<<20) + `"}}]}}`
+	sp := &scriptSpawner{lines: []string{scriptInit, huge, scriptResult}}
+
+	var hookErrs []error
+	s, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{
+		Spawner:      sp,
+		MaxLineBytes: 1 << 20,
+		Hooks:        &Hooks{OnError: func(err error) { hookErrs = append(hookErrs, err) }},
+	}})
+	result, err := s.RunAndCollect(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+	if n := len(result.Messages); n != 2 || result.Messages[1].Type != "result" {
+		t.Errorf("got %d messages, want init and the result after the skipped line", n)
+	}
+	var tooLong *LineTooLongError
+	if len(hookErrs) != 1 || !errors.As(hookErrs[0], &tooLong) || tooLong.Size != len(huge) {
+		t.Errorf("OnError got %v", hookErrs)
+	}
+
+	// Without a limit the same line is delivered.
+	s, _ = NewSession(SessionConfig{LaunchOptions: LaunchOptions{Spawner: &scriptSpawner{lines: sp.lines}}})
+	result, err = s.RunAndCollect(context.Background(), "hi")
+	if err != nil || len(result.Messages) != 3 {
+		t.Fatalf("RunAndCollect() without limit = %d messages, %v", len(result.Messages), err)
+	}
+	if name, input := GetToolCall(&result.Messages[1]); name != "Write" || len(input["content"].(string)) != 2<<20 {
+		t.Errorf("tool call = %s with %d-byte content", name, len(input["content"].(string)))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Prompt input and attachments
+// ---------------------------------------------------------------------------
+
+// pngPixel is a 1x1 PNG image.
+var pngPixel = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00\x90wS\xde" +
+	"\x00\x00\x00\x0cIDATx\x9cc\xf8\xcf\xc0\x00\x00\x03\x01\x01\x00\xc9\xfe\x92\xef\x00\x00\x00\x00IEND\xaeB`\x82")
+
+func TestBuildArgsPromptOnStdin(t *testing.T) {
+	long := strings.Repeat("x", DefaultMaxPromptArgBytes+1)
+	tests := []struct {
+		name   string
+		prompt string
+		opts   LaunchOptions
+		inArgs bool
+	}{
+		{"short", "hi", LaunchOptions{}, true},
+		{"at default limit", long[1:], LaunchOptions{}, true},
+		{"over default limit", long, LaunchOptions{}, false},
+		{"custom limit", "hello", LaunchOptions{MaxPromptArgBytes: 4}, false},
+		{"always stdin", "hi", LaunchOptions{MaxPromptArgBytes: -1}, false},
+		{"stream-json", "hi", LaunchOptions{InputFormat: InputFormatStreamJSON}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := buildArgs(tt.prompt, tt.opts, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inArgs := args[len(args)-1] == tt.prompt && indexOfArg(args, "--") == len(args)-2; inArgs != tt.inArgs {
+				t.Errorf("prompt in args = %v, want %v", inArgs, tt.inArgs)
+			}
+		})
+	}
+}
+
+func TestLauncherPromptOnStdin(t *testing.T) {
+	long := strings.Repeat("x", DefaultMaxPromptArgBytes+1)
+	for _, tt := range []struct {
+		name   string
+		prompt string
+		opts   LaunchOptions
+	}{
+		{"long prompt", long, LaunchOptions{}},
+		{"always stdin", "hi", LaunchOptions{MaxPromptArgBytes: -1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sp := &scriptSpawner{lines: []string{scriptStdin, scriptInit, scriptResult}}
+			tt.opts.Spawner = sp
+			s, _ := NewSession(SessionConfig{LaunchOptions: tt.opts})
+			if _, err := s.RunAndCollect(context.Background(), tt.prompt); err != nil {
+				t.Fatalf("RunAndCollect() error: %v", err)
+			}
+			if len(sp.proc.input) != 1 || sp.proc.input[0] != tt.prompt {
+				t.Errorf("stdin = %d lines, want the prompt", len(sp.proc.input))
+			}
+			if containsString(sp.got.Args, tt.prompt) || containsString(sp.got.Args, "--input-format") {
+				t.Errorf("args = %v", sp.got.Args)
+			}
+		})
+	}
+}
+
+func TestLauncherPromptOnStdinWriteError(t *testing.T) {
+	broken := errors.New("broken pipe")
+	sp := &scriptSpawner{lines: []string{scriptInit, scriptResult}}
+	l := NewLauncher()
+	err := l.Start(context.Background(), "hi", LaunchOptions{
+		MaxPromptArgBytes: -1,
+		Spawner: spawnerFunc(func(ctx context.Context, cfg SpawnConfig) (Process, error) {
+			p, err := sp.Spawn(ctx, cfg)
+			sp.proc.stdinR.CloseWithError(broken)
+			return p, err
+		}),
+	})
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	for {
+		msg, err := l.ReadMessage()
+		if err != nil || msg == nil {
+			break
+		}
+	}
+	if err := l.Wait(); !errors.Is(err, broken) || !strings.Contains(err.Error(), "write prompt") {
+		t.Errorf("Wait() error = %v, want the prompt write error", err)
+	}
+}
+
+func TestAttachmentContentBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment Attachment
+		blockType  string
+		sourceType string
+		mediaType  string
+	}{
+		{"png by extension", Attachment{Name: "pixel.png", Data: pngPixel}, "image", "base64", "image/png"},
+		{"png by content", Attachment{Name: "pixel", Data: pngPixel}, "image", "base64", "image/png"},
+		{"explicit type", Attachment{Name: "photo", MediaType: "image/webp", Data: []byte("RIFF")}, "image", "base64", "image/webp"},
+		{"pdf", Attachment{Name: "spec.pdf", Data: []byte("%PDF-1.7\n")}, "document", "base64", "application/pdf"},
+		{"text", Attachment{Name: "notes.txt", Data: []byte("hello")}, "document", "text", "text/plain"},
+		{"source code", Attachment{Name: "main.go", Data: []byte("package main\n")}, "document", "text", "text/plain"},
+		{"json", Attachment{Name: "data.json", Data: []byte(`{"a":1}`)}, "document", "text", "text/plain"},
+		{"explicit json", Attachment{Name: "data", MediaType: "application/ld+json", Data: []byte(`{}`)}, "document", "text", "text/plain"},
+		{"explicit xml", Attachment{Name: "feed", MediaType: "application/atom+xml", Data: []byte("<feed/>")}, "document", "text", "text/plain"},
+		{"text by content", Attachment{Name: "player.xyz-unknown", Data: []byte("let x = 1\n")}, "document", "text", "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.attachment.ContentBlock()
+			if err != nil {
+				t.Fatalf("ContentBlock() error: %v", err)
+			}
+			if b.Type != tt.blockType || b.Source == nil || b.Source.Type != tt.sourceType || b.Source.MediaType != tt.mediaType {
+				t.Errorf("block = %+v", b)
+			}
+			if b.Type == "document" && b.Title != tt.attachment.Name {
+				t.Errorf("title = %q", b.Title)
+			}
+			if tt.sourceType == "base64" && b.Source.Data != base64.StdEncoding.EncodeToString(tt.attachment.Data) {
+				t.Error("data not base64-encoded")
+			}
+		})
+	}
+
+	for _, a := range []Attachment{
+		{Name: "app.bin", Data: []byte{0xff, 0xfe, 0x00, 0x01}},
+		{Name: "logo.svg", Data: []byte("<svg/>")},
+		{Name: "latin1.txt", Data: []byte("caf\xe9")},
+		{Name: "notes", MediaType: "text/markdown", Data: []byte{0xff, 0xfe}},
+		{Name: "clip", MediaType: "video/mp4", Data: []byte("not really a video")},
+		{Name: "bundle", MediaType: "application/zip", Data: []byte("PK")},
+		{Name: "blob", MediaType: "application/octet-stream", Data: []byte("hello")},
+		{Name: "empty", Data: nil},
+		{Name: "empty.bin", Data: nil},
+	} {
+		if _, err := a.ContentBlock(); !errors.Is(err, ErrUnsupportedAttachment) {
+			t.Errorf("ContentBlock(%s) error = %v, want ErrUnsupportedAttachment", a.Name, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "pixel.png")
+	os.WriteFile(path, pngPixel, 0o600)
+	if a, err := AttachFile(path); err != nil || a.Name != "pixel.png" || !bytes.Equal(a.Data, pngPixel) {
+		t.Errorf("AttachFile() = %+v, %v", a, err)
+	}
+	if _, err := AttachFile(filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("AttachFile() of a missing file should fail")
+	}
+}
+
+func TestNewUserMessageBlocks(t *testing.T) {
+	img, _ := Attachment{Name: "a.png", Data: pngPixel}.ContentBlock()
+	var types []string
+	for _, b := range NewUserMessage("describe", img).Message.Content {
+		types = append(types, b.Type)
+	}
+	if strings.Join(types, ",") != "image,text" {
+		t.Errorf("content = %v, want the image before the text", types)
+	}
+	if c := NewUserMessage("", img).Message.Content; len(c) != 1 {
+		t.Errorf("empty text should be omitted, got %d blocks", len(c))
+	}
+}
+
+func TestSessionAttachments(t *testing.T) {
+	sp := &scriptSpawner{lines: []string{scriptStdin, scriptInit, scriptResult}}
+	s, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{
+		Spawner:     sp,
+		Attachments: []Attachment{{Name: "pixel.png", Data: pngPixel}, {Name: "notes.txt", Data: []byte("hello")}},
+	}})
+	if _, err := s.RunAndCollect(context.Background(), "describe"); err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	assertContainsPair(t, sp.got.Args, "--input-format", "stream-json")
+	if containsString(sp.got.Args, "describe") {
+		t.Error("prompt should not be an argument")
+	}
+	if len(sp.proc.input) != 1 {
+		t.Fatalf("stdin = %v, want one message", sp.proc.input)
+	}
+	var msg StreamMessage
+	if err := json.Unmarshal([]byte(sp.proc.input[0]), &msg); err != nil {
+		t.Fatalf("stdin message: %v", err)
+	}
+	var types []string
+	for _, b := range msg.Message.Content {
+		types = append(types, b.Type)
+	}
+	if strings.Join(types, ",") != "image,document,text" || msg.Message.Content[2].Text != "describe" {
+		t.Errorf("content = %v", types)
+	}
+
+	// Unsupported attachments fail before the process starts.
+	s, _ = NewSession(SessionConfig{LaunchOptions: LaunchOptions{
+		Spawner:     &scriptSpawner{},
+		Attachments: []Attachment{{Name: "app.bin", Data: []byte{0xff, 0x00}}},
+	}})
+	var startErr *StartError
+	if err := s.Run(context.Background(), "hi"); !errors.As(err, &startErr) || !errors.Is(err, ErrUnsupportedAttachment) {
+		t.Errorf("Run() error = %v, want StartError wrapping ErrUnsupportedAttachment", err)
+	}
+}
+
+func TestConversationSendAttachments(t *testing.T) {
+	sp := &scriptSpawner{lines: []string{scriptStdin, scriptInit, scriptResult}}
+	conv, _ := NewConversation(SessionConfig{LaunchOptions: LaunchOptions{
+		Spawner:     sp,
+		Attachments: []Attachment{{Name: "ignored.txt", Data: []byte("x")}},
+	}})
+	ctx := context.Background()
+	if err := conv.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	if _, err := conv.Send(ctx, "hi", Attachment{Name: "a.bin", Data: []byte{0xff}}); !errors.Is(err, ErrUnsupportedAttachment) {
+		t.Errorf("Send() error = %v, want ErrUnsupportedAttachment", err)
+	}
+	if _, err := conv.Send(ctx, "what is this?", Attachment{Name: "pixel.png", Data: pngPixel}); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	conv.Close()
+
+	if len(sp.proc.input) != 1 || !strings.Contains(sp.proc.input[0], `"type":"image"`) || strings.Contains(sp.proc.input[0], "ignored.txt") {
+		t.Errorf("stdin = %v", sp.proc.input)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Raw messages and system subtypes
+// ---------------------------------------------------------------------------
+
+func TestStreamMessageRaw(t *testing.T) {
+	raw := `{"type":"system","subtype":"status","session_id":"s1","status":"compacting","permissionMode":"default","future":{"a":1}}`
+
+	var msg StreamMessage
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Raw != nil {
+		t.Errorf("Raw = %s, want it kept only on request", msg.Raw)
+	}
+	if msg.SessionID != "s1" || msg.PermissionMode != "default" {
+		t.Errorf("typed fields = %+v", msg)
+	}
+	if len(msg.Extra) != 2 || string(msg.Extra["status"]) != `"compacting"` || string(msg.Extra["future"]) != `{"a":1}` {
+		t.Errorf("Extra = %v", msg.Extra)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["status"]) != `"compacting"` || string(fields["session_id"]) != `"s1"` || len(fields) != 6 {
+		t.Errorf("Marshal() = %s", data)
+	}
+
+	t.Run("known keys only", func(t *testing.T) {
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(`{"type":"result","result":"ok","num_turns":1}`), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Extra != nil {
+			t.Errorf("Extra = %v, want nil", msg.Extra)
+		}
+		data, _ := json.Marshal(msg)
+		if string(data) != `{"type":"result","result":"ok","num_turns":1}` {
+			t.Errorf("Marshal() = %s", data)
+		}
+	})
+
+	t.Run("unknown members", func(t *testing.T) {
+		// The key scan must agree with encoding/json on awkward input.
+		for _, line := range []string{
+			` { "type" : "system" , "a\"b" : "x\"}y" , "n":-1.5e3,"t":true }`,
+			`{"type":"user","arr":[1,{"x":"]"},[]],"\u0066oo":{"k":{"l":[null]}},"e":"","session_id":"s"}`,
+			"{\n\t\"type\": \"result\",\n\t\"z\": false\n}",
+			`{"type":"x","last":null}`,
+			`{}`,
+		} {
+			var want map[string]json.RawMessage
+			json.Unmarshal([]byte(line), &want)
+			for key := range want {
+				if streamMessageKeys()[key] {
+					delete(want, key)
+				}
+			}
+			var msg StreamMessage
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatalf("Unmarshal(%s) error: %v", line, err)
+			}
+			if len(msg.Extra) != len(want) || (msg.Extra == nil) != (len(want) == 0) {
+				t.Errorf("Extra of %s = %s, want %s", line, msg.Extra, want)
+			}
+			for key, value := range want {
+				if string(msg.Extra[key]) != string(value) {
+					t.Errorf("Extra[%q] of %s = %s, want %s", key, line, msg.Extra[key], value)
+				}
+			}
+		}
+	})
+
+	t.Run("decoder", func(t *testing.T) {
+		input := raw + "\n" + `{"type":"user","x":2}` + "\n"
+		d := NewDecoder(strings.NewReader(input), 0)
+		d.KeepRaw()
+		first, _ := d.Decode()
+		second, _ := d.Decode()
+		if first == nil || second == nil {
+			t.Fatal("Decode() returned nil")
+		}
+		if string(first.Raw) != raw || string(second.Raw) != `{"type":"user","x":2}` {
+			t.Errorf("Raw = %s, %s", first.Raw, second.Raw)
+		}
+
+		if msg, _ := NewDecoder(strings.NewReader(input), 0).Decode(); msg == nil || msg.Raw != nil || msg.Extra == nil {
+			t.Errorf("without KeepRaw: %+v", msg)
+		}
+	})
+
+	t.Run("launch option", func(t *testing.T) {
+		session, _ := NewSession(SessionConfig{LaunchOptions: LaunchOptions{
+			Spawner:         &scriptSpawner{lines: []string{scriptInit, scriptResult}},
+			KeepRawMessages: true,
+		}})
+		msgs, err := session.CollectMessages(context.Background(), "hi")
+		if err != nil || len(msgs) != 2 {
+			t.Fatalf("CollectMessages() = %d messages, %v", len(msgs), err)
+		}
+		if string(msgs[0].Raw) != scriptInit || string(msgs[1].Raw) != scriptResult {
+			t.Errorf("Raw = %s, %s", msgs[0].Raw, msgs[1].Raw)
+		}
+	})
+}
+
+func TestExtractSystemMessages(t *testing.T) {
+	decode := func(t *testing.T, raw string) *StreamMessage {
+		t.Helper()
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return &msg
+	}
+
+	t.Run("compact boundary", func(t *testing.T) {
+		msg := decode(t, `{"type":"system","subtype":"compact_boundary","session_id":"s1","compact_metadata":{"trigger":"auto","pre_tokens":167000,"post_tokens":12000}}`)
+		got := ExtractCompactBoundary(msg)
+		if got == nil || got.Trigger != "auto" || got.PreTokens != 167000 || got.PostTokens != 12000 {
+			t.Errorf("ExtractCompactBoundary() = %+v", got)
+		}
+		if ExtractAPIRetry(msg) != nil || ExtractHookResponse(msg) != nil {
+			t.Error("other extractors should return nil")
+		}
+	})
+
+	t.Run("hook response", func(t *testing.T) {
+		msg := decode(t, `{"type":"system","subtype":"hook_response","hook_id":"h1","hook_name":"UserPromptSubmit","hook_event":"UserPromptSubmit","output":"ready\n","stdout":"ready\n","stderr":"","exit_code":2,"outcome":"error"}`)
+		got := ExtractHookResponse(msg)
+		if got == nil || got.HookName != "UserPromptSubmit" || got.HookEvent != HookUserPromptSubmit ||
+			got.Stdout != "ready\n" || got.ExitCode != 2 || got.Outcome != "error" {
+			t.Errorf("ExtractHookResponse() = %+v", got)
+		}
+	})
+
+	t.Run("api retry", func(t *testing.T) {
+		msg := decode(t, `{"type":"system","subtype":"api_retry","attempt":2,"max_retries":10,"retry_delay_ms":1200,"error_status":null,"error":"server_error"}`)
+		got := ExtractAPIRetry(msg)
+		if got == nil || got.Attempt != 2 || got.MaxRetries != 10 || got.RetryDelayMS != 1200 || got.ErrorStatus != 0 || got.Error != "server_error" {
+			t.Errorf("ExtractAPIRetry() = %+v", got)
+		}
+	})
+
+	t.Run("built in Go", func(t *testing.T) {
+		msg := &StreamMessage{
+			Type:    "system",
+			Subtype: "api_retry",
+			Extra:   map[string]json.RawMessage{"attempt": json.RawMessage("3"), "error_status": json.RawMessage("529")},
+		}
+		if got := ExtractAPIRetry(msg); got == nil || got.Attempt != 3 || got.ErrorStatus != 529 {
+			t.Errorf("ExtractAPIRetry() = %+v", got)
+		}
+	})
+
+	t.Run("other messages", func(t *testing.T) {
+		if ExtractCompactBoundary(nil) != nil {
+			t.Error("nil message should return nil")
+		}
+		if ExtractHookResponse(&StreamMessage{Type: "system", Subtype: "init"}) != nil {
+			t.Error("init message should return nil")
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// Tool tracking
+// ---------------------------------------------------------------------------
+
+func TestToolTracker(t *testing.T) {
+	tracker := NewToolTracker()
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return clock }
+
+	add := func(raw string) []ToolInvocation {
+		t.Helper()
+		var msg StreamMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return tracker.Add(&msg)
+	}
+
+	if got := add(`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"go test"}},{"type":"tool_use","id":"t2","name":"Task","input":{}}]}}`); got != nil {
+		t.Errorf("tool_use Add() = %+v, want nil", got)
+	}
+	clock = clock.Add(time.Second)
+	add(`{"type":"assistant","parent_tool_use_id":"t2","message":{"content":[{"type":"tool_use","id":"s1","name":"Read","input":{"file_path":"go.mod"}}]}}`)
+	add(`{"type":"assistant","message":{"content":[{"type":"tool_use","id":"t3","name":"Grep","input":{}}]}}`)
+
+	clock = clock.Add(2 * time.Second)
+	done := add(`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"t1","content":"FAIL","is_error":true},{"type":"tool_result","tool_use_id":"unknown","content":"x"}]}}`)
+	if len(done) != 1 {
+		t.Fatalf("Add() = %+v, want 1 invocation", done)
+	}
+	bash := done[0]
+	if bash.ID != "t1" || bash.Name != "Bash" || bash.Input["command"] != "go test" || !bash.IsError || bash.ResultText() != "FAIL" {
+		t.Errorf("invocation = %+v", bash)
+	}
+	if bash.Duration != 3*time.Second || !bash.End.Equal(clock) || bash.ParentToolUseID != "" {
+		t.Errorf("timing = %v from %v to %v", bash.Duration, bash.Start, bash.End)
+	}
+
+	done = add(`{"type":"user","parent_tool_use_id":"t2","message":{"content":[{"type":"tool_result","tool_use_id":"s1","content":[{"type":"text","text":"module x"}]}]}}`)
+	if len(done) != 1 || done[0].ParentToolUseID != "t2" || done[0].ResultText() != "module x" || done[0].IsError || done[0].Duration != 2*time.Second {
+		t.Errorf("subagent invocation = %+v", done)
+	}
+
+	pending := tracker.Pending()
+	if len(pending) != 2 || pending[0].ID != "t2" || pending[1].ID != "t3" || !pending[0].End.IsZero() {
+		t.Errorf("Pending() = %+v, want t2 and t3", pending)
+	}
+	if completed := tracker.Completed(); len(completed) != 2 || completed[0].ID != "t1" || completed[1].ID != "s1" {
+		t.Errorf("Completed() = %+v", completed)
+	}
+
+	if tracker.Add(nil) != nil || tracker.Add(&StreamMessage{Type: "result"}) != nil {
+		t.Error("messages without content should complete nothing")
+	}
+}
+
+func TestToolTrackerServerTools(t *testing.T) {
+	tracker := NewToolTracker()
+	var msg StreamMessage
+	raw := `{"type":"assistant","message":{"content":[` +
+		`{"type":"server_tool_use","id":"srv1","name":"web_search","input":{"query":"go"}},` +
+		`{"type":"web_search_tool_result","tool_use_id":"srv1","content":{"type":"web_search_tool_result_error","error_code":"unavailable"}}]}}`
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		t.Fatal(err)
+	}
+	done := tracker.Add(&msg)
+	if len(done) != 1 || done[0].Name != "web_search" || !done[0].IsError || done[0].Result[0].ErrorCode != "unavailable" {
+		t.Errorf("Add() = %+v", done)
+	}
+	if len(tracker.Pending()) != 0 {
+		t.Errorf("Pending() = %+v, want none", tracker.Pending())
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Integration tests (require Claude CLI)
+// ---------------------------------------------------------------------------
+
+func skipIfNoCLI(t *testing.T) {
+	t.Helper()
+	if !CLIAvailable() {
+		t.Skip("Claude CLI not available - skipping integration test")
+	}
+}
+
+func TestIntegrationSimplePrompt(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			SkipPermissions: true,
+			MaxTurns:        1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// A prompt designed to get a predictable, short answer
+	text, err := session.CollectAll(ctx, "What is 2+2? Reply with ONLY the number, nothing else.")
+	if err != nil {
+		t.Fatalf("CollectAll() error: %v", err)
+	}
+
+	t.Logf("Response: %q", text)
+
+	// The response should contain "4"
+	if !strings.Contains(text, "4") {
+		t.Errorf("expected response to contain '4', got: %q", text)
+	}
+}
+
+func TestIntegrationRunAndCollect(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			SkipPermissions: true,
+			MaxTurns:        1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := session.RunAndCollect(ctx, "What is the capital of Japan? Reply in one word.")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	t.Logf("Text: %q", result.Text)
+	t.Logf("TotalCost: $%.6f", result.TotalCost)
+	t.Logf("Model: %s", result.Model)
+	t.Logf("SessionID: %s", result.SessionID)
+	t.Logf("NumTurns: %d", result.NumTurns)
+	t.Logf("Duration: %s", result.Duration)
+	if result.Usage != nil {
+		t.Logf("Usage: in=%d out=%d", result.Usage.InputTokens, result.Usage.OutputTokens)
+	}
+
+	// Validate response content
+	if !strings.Contains(strings.ToLower(result.Text), "tokyo") {
+		t.Errorf("expected response to contain 'tokyo', got: %q", result.Text)
+	}
+
+	// Validate metrics
+	if result.TotalCost <= 0 {
+		t.Error("TotalCost should be positive")
+	}
+	if result.Model == "" {
+		t.Error("Model should not be empty")
+	}
+	if result.SessionID == "" {
+		t.Error("SessionID should not be empty")
+	}
+	if result.Duration <= 0 {
+		t.Error("Duration should be positive")
+	}
+
+	// Validate metrics snapshot
+	metrics := session.CurrentMetrics()
+	t.Logf("CurrentMetrics: cost=$%.6f tokens=%d+%d", metrics.TotalCostUSD, metrics.InputTokens, metrics.OutputTokens)
+	if metrics.TotalCostUSD <= 0 {
+		t.Error("CurrentMetrics().TotalCostUSD should be positive")
+	}
+}
+
+func TestIntegrationSystemPrompt(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			SkipPermissions: true,
+			SystemPrompt:    "You must respond to every message with exactly the word PONG and nothing else. No punctuation, no explanation.",
+			MaxTurns:        1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	text, err := session.CollectAll(ctx, "PING")
+	if err != nil {
+		t.Fatalf("CollectAll() error: %v", err)
+	}
+
 	t.Logf("Response: %q", text)
 
 	// With the system prompt, Claude should respond with "PONG"
@@ -1552,6 +5542,357 @@ func TestIntegrationPermissionMode(t *testing.T) {
 	t.Error("no init message found to verify permission mode")
 }
 
+func TestIntegrationCanUseTool(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	target := filepath.Join(t.TempDir(), "out.txt")
+	var asked []string
+
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			MaxTurns: 3,
+			CanUseTool: func(ctx context.Context, name string, input map[string]any) (Decision, error) {
+				asked = append(asked, name)
+				if name == "Write" {
+					return Deny("WRITE-BLOCKED-7731"), nil
+				}
+				return Allow(), nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := session.RunAndCollect(ctx, "Use the Write tool to create "+target+
+		" containing the word hi. If the tool fails, reply with the exact error message.")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	t.Logf("Asked: %v, Text: %q", asked, result.Text)
+	if !containsString(asked, "Write") {
+		t.Errorf("CanUseTool was not consulted for Write: %v", asked)
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Error("denied Write should not create the file")
+	}
+	if !strings.Contains(result.Text, "WRITE-BLOCKED-7731") {
+		t.Errorf("denial message not surfaced to Claude: %q", result.Text)
+	}
+}
+
+func TestIntegrationLocalMCPServer(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			MaxTurns:        3,
+			LocalMCPServers: map[string]*mcp.Server{"inventory": newInventoryServer()},
+			AllowedTools:    []string{"mcp__inventory__stock_level"},
+			StrictMCP:       true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := session.RunAndCollect(ctx, "Use the stock_level tool to look up SKU WIDGET-42 "+
+		"and reply with the tool's exact output.")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	t.Logf("Text: %q", result.Text)
+	if !strings.Contains(result.Text, "STOCK-LEVEL-9183") {
+		t.Errorf("tool output not surfaced: %q", result.Text)
+	}
+}
+
+func TestIntegrationRunTyped(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	type capital struct {
+		Country string `json:"country"`
+		City    string `json:"city"`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	got, result, err := RunTyped[capital](ctx, SessionConfig{
+		LaunchOptions: LaunchOptions{MaxTurns: 3},
+	}, "What is the capital of France?")
+	if err != nil {
+		t.Fatalf("RunTyped() error: %v", err)
+	}
+
+	t.Logf("Got: %+v, Cost: $%.4f", got, result.TotalCost)
+	if !strings.EqualFold(got.City, "Paris") {
+		t.Errorf("City = %q, want Paris", got.City)
+	}
+}
+
+func TestIntegrationHookHandlers(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	shim := filepath.Join(dir, "claude-hook-shim")
+	if out, err := exec.Command(goBin, "build", "-o", shim, "./cmd/claude-hook-shim").CombinedOutput(); err != nil {
+		t.Fatalf("build shim: %v\n%s", err, out)
+	}
+
+	target := filepath.Join(dir, "out.txt")
+	var mu sync.Mutex
+	var events []HookEvent
+	record := func(in HookInput) {
+		mu.Lock()
+		events = append(events, in.Event)
+		mu.Unlock()
+	}
+
+	session, err := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			MaxTurns:     3,
+			HookShimPath: shim,
+			HookHandlers: map[HookEvent][]HookMatcher{
+				HookUserPromptSubmit: {{Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+					record(in)
+					return HookContext("The secret word is PAPAYA-88."), nil
+				}}},
+				HookPreToolUse: {{Matcher: "Write", Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+					record(in)
+					return HookBlock("HOOK-DENY-55"), nil
+				}}},
+				HookStop: {{Func: func(ctx context.Context, in HookInput) (HookResult, error) {
+					record(in)
+					return HookResult{}, nil
+				}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSession() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := session.RunAndCollect(ctx, "Use the Write tool to create "+target+
+		" containing hi. Then reply with the secret word and the exact error message you got, if any.")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	t.Logf("Events: %v, Text: %q", events, result.Text)
+	for _, want := range []HookEvent{HookUserPromptSubmit, HookPreToolUse, HookStop} {
+		found := false
+		for _, e := range events {
+			found = found || e == want
+		}
+		if !found {
+			t.Errorf("%s hook was not called: %v", want, events)
+		}
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Error("blocked Write should not create the file")
+	}
+	if !strings.Contains(result.Text, "PAPAYA-88") {
+		t.Errorf("additional context not surfaced: %q", result.Text)
+	}
+	if !strings.Contains(result.Text, "HOOK-DENY-55") {
+		t.Errorf("block reason not surfaced: %q", result.Text)
+	}
+}
+
+func TestIntegrationConversation(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	conv, err := NewConversation(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			SkipPermissions: true,
+			MaxTurns:        1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewConversation() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if err := conv.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	first, err := conv.Send(ctx, "Remember the word PINEAPPLE. Reply with only OK.")
+	if err != nil {
+		t.Fatalf("Send() turn 1 error: %v", err)
+	}
+	t.Logf("Turn 1: %q", first.Text)
+
+	second, err := conv.Send(ctx, "What word did I ask you to remember? Reply with only the word.")
+	if err != nil {
+		t.Fatalf("Send() turn 2 error: %v", err)
+	}
+	t.Logf("Turn 2: %q", second.Text)
+
+	if !strings.Contains(strings.ToUpper(second.Text), "PINEAPPLE") {
+		t.Errorf("expected turn 2 to recall PINEAPPLE, got: %q", second.Text)
+	}
+	if first.SessionID == "" || first.SessionID != second.SessionID {
+		t.Errorf("session IDs differ across turns: %q vs %q", first.SessionID, second.SessionID)
+	}
+	if conv.SessionID() != second.SessionID {
+		t.Errorf("conv.SessionID() = %q, want %q", conv.SessionID(), second.SessionID)
+	}
+
+	if err := conv.Close(); err != nil {
+		t.Errorf("Close() error: %v", err)
+	}
+	if _, err := conv.Send(ctx, "Are you there?"); err != ErrSessionClosed {
+		t.Errorf("Send() after Close error = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestIntegrationTracing(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	exporter := &InMemoryExporter{}
+	session, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			Model:        "haiku",
+			MaxTurns:     3,
+			AllowedTools: []string{"Bash"},
+		},
+		Tracer: NewTracer(exporter),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if _, err := session.RunAndCollect(ctx, "Run `echo traced` with the Bash tool, then reply with its output."); err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	spans := spansByName(exporter.Spans())
+	root, turn, tool := spans["invoke_agent"], spans["chat"], spans["execute_tool Bash"]
+	if root.SpanID == "" || turn.ParentSpanID != root.SpanID {
+		t.Fatalf("spans = %+v", exporter.Spans())
+	}
+	if cost, _ := root.Attributes["claude.cost_usd"].(float64); cost <= 0 {
+		t.Errorf("root attributes = %v", root.Attributes)
+	}
+	if turn.Attributes["gen_ai.response.model"] == nil || turn.Attributes["gen_ai.usage.output_tokens"] == nil {
+		t.Errorf("turn attributes = %v", turn.Attributes)
+	}
+	if tool.SpanID == "" || tool.End.IsZero() {
+		t.Errorf("no Bash tool span: %+v", exporter.Spans())
+	}
+	t.Logf("%d spans, root %v", len(exporter.Spans()), root.Attributes)
+}
+
+func TestIntegrationPromptInput(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	tests := []struct {
+		name   string
+		opts   LaunchOptions
+		prompt string
+		want   string
+	}{
+		{"stdin", LaunchOptions{MaxPromptArgBytes: -1}, "Reply with the word banana and nothing else.", "banana"},
+		{"attachment", LaunchOptions{Attachments: []Attachment{{Name: "notes.txt", Data: []byte("The secret word is pineapple.")}}},
+			"What is the secret word in notes.txt? Reply with the word only.", "pineapple"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Model = "haiku"
+			tt.opts.MaxTurns = 1
+			session, _ := NewSession(SessionConfig{LaunchOptions: tt.opts})
+			result, err := session.RunAndCollect(ctx, tt.prompt)
+			if err != nil {
+				t.Fatalf("RunAndCollect() error: %v", err)
+			}
+			if !strings.Contains(strings.ToLower(result.Text), tt.want) {
+				t.Errorf("text = %q, want %q", result.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntegrationShutdown(t *testing.T) {
+	skipIfNoCLI(t)
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	session, _ := NewSession(SessionConfig{
+		LaunchOptions: LaunchOptions{
+			Model:        "haiku",
+			MaxTurns:     3,
+			AllowedTools: []string{"Bash"},
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := session.Run(ctx, "Run `sleep 120` with the Bash tool, then reply with done."); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	// Shut down once the CLI is blocked in the tool call.
+	for msg := range session.Messages {
+		if name, _ := GetToolCall(&msg); name == "Bash" {
+			break
+		}
+	}
+	go func() {
+		for range session.Messages {
+		}
+	}()
+
+	start := time.Now()
+	if err := session.Shutdown(ctx, 10*time.Second); err != nil {
+		t.Fatalf("Shutdown() error: %v", err)
+	}
+	<-session.Done()
+	if elapsed := time.Since(start); elapsed > 15*time.Second {
+		t.Errorf("Shutdown took %v", elapsed)
+	}
+	t.Logf("stopped in %v, err %v", time.Since(start), session.Err())
+}
+
 // ---------------------------------------------------------------------------
 // Test helpers
 // ---------------------------------------------------------------------------
@@ -1594,3 +5935,104 @@ func indexOfArg(args []string, flag string) int {
 	}
 	return -1
 }
+
+func containsString(list []string, want string) bool {
+	for _, s := range list {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptStdin in scriptSpawner.lines makes the fake process block until it
+// reads one line from stdin, mimicking a stream-json turn boundary.
+const scriptStdin = "<stdin>"
+
+// scriptSpawner is an in-memory Spawner that replays canned stdout lines.
+type scriptSpawner struct {
+	lines    []string
+	stderr   string
+	exitCode int
+
+	got  SpawnConfig
+	proc *scriptProcess
+}
+
+func (sp *scriptSpawner) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	sp.got = cfg
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	p := &scriptProcess{
+		stdinR:   stdinR,
+		stdinW:   stdinW,
+		stdoutR:  stdoutR,
+		stdoutW:  stdoutW,
+		stderr:   strings.NewReader(sp.stderr),
+		exitCode: sp.exitCode,
+		done:     make(chan struct{}),
+	}
+	sp.proc = p
+
+	go func() {
+		defer close(p.done)
+		defer stdoutW.Close()
+		in := bufio.NewReader(stdinR)
+		for _, line := range sp.lines {
+			if line == scriptStdin {
+				// A final line without a newline counts: the CLI reads a
+				// text prompt up to EOF.
+				text, err := in.ReadString('\n')
+				if text == "" && err != nil {
+					return
+				}
+				p.input = append(p.input, text)
+				continue
+			}
+			if _, err := io.WriteString(stdoutW, line+"\n"); err != nil {
+				return
+			}
+		}
+		// Like the CLI, wait for stdin EOF before exiting.
+		io.Copy(io.Discard, stdinR)
+	}()
+
+	return p, nil
+}
+
+type scriptProcess struct {
+	stdinR   *io.PipeReader
+	stdinW   *io.PipeWriter
+	stdoutR  *io.PipeReader
+	stdoutW  *io.PipeWriter
+	stderr   io.Reader
+	exitCode int
+	done     chan struct{}
+	input    []string
+}
+
+func (p *scriptProcess) Stdin() io.WriteCloser      { return p.stdinW }
+func (p *scriptProcess) Stdout() io.Reader          { return p.stdoutR }
+func (p *scriptProcess) Stderr() io.Reader          { return p.stderr }
+func (p *scriptProcess) Pid() int                   { return 4242 }
+func (p *scriptProcess) Signal(sig os.Signal) error { return p.Kill() }
+
+// Kill ends the script: readers see EOF on stdout, like a killed process.
+func (p *scriptProcess) Kill() error {
+	p.stdinR.Close()
+	p.stdoutW.Close()
+	return nil
+}
+
+func (p *scriptProcess) Wait() error {
+	<-p.done
+	if p.exitCode != 0 {
+		return scriptExitError(p.exitCode)
+	}
+	return nil
+}
+
+type scriptExitError int
+
+func (e scriptExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e scriptExitError) ExitCode() int { return int(e) }
@@ -0,0 +1,362 @@
+package claudetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+)
+
+func helloScenario() *Scenario {
+	return &Scenario{
+		ExpectFlags: map[string]string{"--model": "sonnet", "--verbose": ""},
+		Steps: []Step{
+			Init("sess-1", "claude-sonnet-4-20250514"),
+			ToolUse("tu-1", "Read", map[string]any{"file_path": "main.go"}),
+			ToolResult("tu-1", "package main"),
+			AssistantText("Hello"),
+			Result("Hello", 0.01),
+		},
+	}
+}
+
+func TestSessionHappyPath(t *testing.T) {
+	sp := NewSpawner(helloScenario())
+
+	s, _ := claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{Model: "sonnet", Spawner: sp},
+	})
+	result, err := s.RunAndCollect(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	if result.Text != "Hello" {
+		t.Errorf("Text = %q, want Hello", result.Text)
+	}
+	if result.SessionID != "sess-1" || result.TotalCost != 0.01 {
+		t.Errorf("session/cost = %q/%f", result.SessionID, result.TotalCost)
+	}
+	if len(result.Messages) != 5 {
+		t.Errorf("len(Messages) = %d, want 5", len(result.Messages))
+	}
+
+	calls := sp.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("len(Calls()) = %d, want 1", len(calls))
+	}
+	if args := calls[0].Config.Args; args[len(args)-1] != "hi" {
+		t.Errorf("prompt arg = %q", args[len(args)-1])
+	}
+	if code := calls[0].ExitCode(); code != 0 {
+		t.Errorf("ExitCode() = %d", code)
+	}
+}
+
+func TestExpectFlagsMismatch(t *testing.T) {
+	sp := NewSpawner(helloScenario())
+
+	s, _ := claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{Model: "opus", Spawner: sp},
+	})
+	_, err := s.RunAndCollect(context.Background(), "hi")
+
+	var exitErr *claude.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("error = %v, want *ExitError", err)
+	}
+	if exitErr.Code != ExitUsage {
+		t.Errorf("Code = %d, want %d", exitErr.Code, ExitUsage)
+	}
+	if !strings.Contains(exitErr.Stderr, `expected --model "sonnet"`) {
+		t.Errorf("Stderr = %q", exitErr.Stderr)
+	}
+}
+
+func TestRejectFlags(t *testing.T) {
+	sc := &Scenario{RejectFlags: []string{"--dangerously-skip-permissions"}}
+	if err := sc.CheckArgs([]string{"-p", "hi"}); err != nil {
+		t.Errorf("CheckArgs() error: %v", err)
+	}
+	if err := sc.CheckArgs([]string{"--dangerously-skip-permissions"}); err == nil {
+		t.Error("CheckArgs() should reject flag")
+	}
+}
+
+func TestCrash(t *testing.T) {
+	sp := NewSpawner(&Scenario{
+		Steps: []Step{
+			Init("sess-1", "m"),
+			Raw("{not json"),
+			Crash(),
+			AssistantText("never sent"),
+		},
+		Stderr:   "fatal: out of memory",
+		ExitCode: 137,
+	})
+
+	s, _ := claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{Spawner: sp},
+	})
+	if err := s.Run(context.Background(), "hi"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	var types []string
+	for msg := range s.Messages {
+		types = append(types, msg.Type)
+	}
+	var parseErrs int
+	for err := range s.Errors {
+		if _, ok := err.(*claude.ParseError); ok {
+			parseErrs++
+		}
+	}
+
+	if strings.Join(types, ",") != "system" {
+		t.Errorf("message types = %v", types)
+	}
+	if parseErrs != 1 {
+		t.Errorf("parse errors = %d, want 1", parseErrs)
+	}
+
+	var exitErr *claude.ExitError
+	if !errors.As(s.Err(), &exitErr) {
+		t.Fatalf("Err() = %v, want *ExitError", s.Err())
+	}
+	if exitErr.Code != 137 || exitErr.Stderr != "fatal: out of memory" {
+		t.Errorf("ExitError = %+v", exitErr)
+	}
+}
+
+func TestConversationInput(t *testing.T) {
+	sp := NewSpawner(&Scenario{
+		ExpectFlags: map[string]string{"--input-format": "stream-json"},
+		Steps: []Step{
+			ExpectInput(`"text":"first"`),
+			Init("sess-1", "m"),
+			AssistantText("one"),
+			Result("one", 0.01),
+			ExpectInput(`"text":"second"`),
+			Init("sess-1", "m"),
+			AssistantText("two"),
+			Result("two", 0.02),
+		},
+	})
+
+	conv, _ := claude.NewConversation(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{Spawner: sp},
+	})
+	ctx := context.Background()
+	if err := conv.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	r1, err := conv.Send(ctx, "first")
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	r2, err := conv.Send(ctx, "second")
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if err := conv.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if r1.Text != "one" || r2.Text != "two" {
+		t.Errorf("texts = %q, %q", r1.Text, r2.Text)
+	}
+	if in := sp.LastCall().Input(); len(in) != 2 {
+		t.Errorf("Input() = %v, want 2 lines", in)
+	}
+}
+
+func TestDelayAndCancel(t *testing.T) {
+	sp := NewSpawner(&Scenario{
+		Steps: []Step{
+			Init("sess-1", "m"),
+			Delay(10 * time.Second),
+			Result("late", 0),
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	s, _ := claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{Spawner: sp},
+	})
+
+	start := time.Now()
+	result, err := s.RunAndCollect(ctx, "hi")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("error = %v, want DeadlineExceeded", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("cancel did not interrupt the delay")
+	}
+	if result.Text != "" {
+		t.Errorf("Text = %q, want empty", result.Text)
+	}
+	if code := sp.LastCall().ExitCode(); code != -1 {
+		t.Errorf("ExitCode() = %d, want -1", code)
+	}
+}
+
+func TestSpawnerSequence(t *testing.T) {
+	first := &Scenario{Steps: []Step{Result("first", 0)}}
+	second := &Scenario{Steps: []Step{Result("second", 0)}}
+	sp := NewSpawner(first, second)
+
+	for _, want := range []string{"first", "second", "second"} {
+		s, _ := claude.NewSession(claude.SessionConfig{
+			LaunchOptions: claude.LaunchOptions{Spawner: sp},
+		})
+		msgs, err := s.CollectMessages(context.Background(), "hi")
+		if err != nil {
+			t.Fatalf("CollectMessages() error: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].Result != want {
+			t.Errorf("result = %+v, want %q", msgs, want)
+		}
+	}
+	if len(sp.Calls()) != 3 {
+		t.Errorf("len(Calls()) = %d, want 3", len(sp.Calls()))
+	}
+}
+
+func TestNoScenario(t *testing.T) {
+	l := claude.NewLauncher()
+	err := l.Start(context.Background(), "hi", claude.LaunchOptions{Spawner: NewSpawner()})
+
+	var startErr *claude.StartError
+	if !errors.As(err, &startErr) {
+		t.Errorf("Start() error = %v, want *StartError", err)
+	}
+}
+
+func TestScenarioFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hello.json")
+	if err := helloScenario().WriteFile(path); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	sc, err := LoadScenario(path)
+	if err != nil {
+		t.Fatalf("LoadScenario() error: %v", err)
+	}
+	if len(sc.Steps) != 5 || sc.ExpectFlags["--model"] != "sonnet" {
+		t.Errorf("loaded scenario = %+v", sc)
+	}
+
+	var out strings.Builder
+	code := sc.Play(context.Background(), []string{"--model", "sonnet", "--verbose"},
+		strings.NewReader(""), &out, &strings.Builder{})
+	if code != 0 {
+		t.Fatalf("Play() = %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("emitted %d lines, want 5:\n%s", len(lines), out.String())
+	}
+	if !strings.HasPrefix(lines[0], `{"type":"system","subtype":"init"`) {
+		t.Errorf("line 0 = %s", lines[0])
+	}
+}
+
+func TestPlayCancelWhileReading(t *testing.T) {
+	for _, steps := range [][]Step{{ReadInput()}, {Init("s", "m")}} {
+		stdin, _ := io.Pipe() // never written or closed
+		ctx, cancel := context.WithCancel(context.Background())
+		code := make(chan int, 1)
+		go func() {
+			code <- (&Scenario{Steps: steps}).Play(ctx, nil, stdin, io.Discard, io.Discard)
+		}()
+		cancel()
+		select {
+		case c := <-code:
+			if c != -1 {
+				t.Errorf("Play() = %d, want -1", c)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Play() blocked on stdin after cancel")
+		}
+	}
+}
+
+func TestLoadScenarioErrors(t *testing.T) {
+	if _, err := LoadScenario(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadScenario() should fail for missing file")
+	}
+
+	path := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := LoadScenario(path); err == nil {
+		t.Error("LoadScenario() should fail for invalid JSON")
+	}
+}
+
+// TestFakeClaudeBinary builds cmd/fakeclaude and drives it through the
+// default exec transport.
+func TestFakeClaudeBinary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping binary build in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "fakeclaude")
+	build := exec.Command(goBin, "build", "-o", bin, "../cmd/fakeclaude")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build fakeclaude: %v\n%s", err, out)
+	}
+
+	scenario := filepath.Join(dir, "hello.json")
+	if err := helloScenario().WriteFile(scenario); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{
+			Model:      "sonnet",
+			BinaryPath: bin,
+			Env:        map[string]string{ScenarioEnv: scenario},
+		},
+	})
+	result, err := s.RunAndCollect(context.Background(), "hi")
+	if err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+	if result.Text != "Hello" || result.SessionID != "sess-1" {
+		t.Errorf("result text/session = %q/%q", result.Text, result.SessionID)
+	}
+
+	// Exit codes and stderr propagate from the real process.
+	crash := &Scenario{Steps: []Step{Crash()}, Stderr: "boom", ExitCode: 3}
+	if err := crash.WriteFile(scenario); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{
+			BinaryPath: bin,
+			Env:        map[string]string{ScenarioEnv: scenario},
+		},
+	})
+	_, err = s.RunAndCollect(context.Background(), "hi")
+
+	var exitErr *claude.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 || exitErr.Stderr != "boom" {
+		t.Errorf("error = %#v, want exit 3 with stderr", err)
+	}
+}
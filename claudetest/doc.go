@@ -0,0 +1,29 @@
+// Package claudetest provides a scriptable fake of the Claude CLI for
+// deterministic, offline tests of code built on package claude.
+//
+// A Scenario describes what the fake prints and how it exits. Plug it in
+// through LaunchOptions.Spawner to run it in-process:
+//
+//	sp := claudetest.NewSpawner(&claudetest.Scenario{
+//		ExpectFlags: map[string]string{"--model": "sonnet"},
+//		Steps: []claudetest.Step{
+//			claudetest.Init("sess-1", "claude-sonnet-4-20250514"),
+//			claudetest.AssistantText("Hello"),
+//			claudetest.Result("Hello", 0.01),
+//		},
+//	})
+//
+//	session, _ := claude.NewSession(claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{Model: "sonnet", Spawner: sp},
+//	})
+//	result, err := session.RunAndCollect(ctx, "hi")
+//
+// The same scenario, saved with Scenario.WriteFile, drives the
+// cmd/fakeclaude binary for tests that need a real process: point
+// LaunchOptions.BinaryPath at the binary and set ScenarioEnv in
+// LaunchOptions.Env to the scenario path.
+//
+// Scenarios can assert received flags (ExpectFlags, RejectFlags), wait for
+// and check stream-json input (ReadInput, ExpectInput), pause (Delay),
+// emit malformed lines (Raw), and crash or exit non-zero (Crash, ExitCode).
+package claudetest
@@ -0,0 +1,366 @@
+package claudetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+)
+
+// Scenario scripts one run of the fake Claude CLI.
+//
+// Scenarios are plain JSON so the same file can drive both the in-process
+// Spawner and the cmd/fakeclaude binary:
+//
+//	{
+//	  "expect_flags": {"--model": "sonnet", "--verbose": ""},
+//	  "steps": [
+//	    {"emit": {"type": "system", "subtype": "init", "session_id": "s1"}},
+//	    {"delay_ms": 50, "emit": {"type": "result", "subtype": "success"}}
+//	  ],
+//	  "exit_code": 0
+//	}
+type Scenario struct {
+	// Name is an optional label used in diagnostics.
+	Name string `json:"name,omitempty"`
+
+	// ExpectFlags lists flags that must be present in the CLI arguments.
+	// A non-empty value must immediately follow the flag; an empty value
+	// only requires the flag itself.
+	ExpectFlags map[string]string `json:"expect_flags,omitempty"`
+
+	// RejectFlags lists flags that must not be present.
+	RejectFlags []string `json:"reject_flags,omitempty"`
+
+	// Steps are executed in order.
+	Steps []Step `json:"steps"`
+
+	// Stderr is written to standard error before the process exits.
+	Stderr string `json:"stderr,omitempty"`
+
+	// ExitCode is the process exit code after the last step (or on Crash).
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+// Step is a single scripted action. Fields combine: the delay runs first,
+// then input is read, then output is written.
+type Step struct {
+	// DelayMS pauses before the step.
+	DelayMS int `json:"delay_ms,omitempty"`
+
+	// ReadInput blocks until one line is read from stdin, like the CLI
+	// waiting for the next stream-json user message.
+	ReadInput bool `json:"read_input,omitempty"`
+
+	// ExpectInput reads one stdin line (implies ReadInput) and fails the
+	// scenario if it does not contain this substring.
+	ExpectInput string `json:"expect_input,omitempty"`
+
+	// Emit is written to stdout as one stream-json line.
+	Emit json.RawMessage `json:"emit,omitempty"`
+
+	// Raw is written to stdout verbatim, e.g. to simulate malformed output.
+	Raw string `json:"raw,omitempty"`
+
+	// Stderr is written to standard error.
+	Stderr string `json:"stderr,omitempty"`
+
+	// Crash exits immediately with the scenario ExitCode (1 if unset),
+	// without writing remaining steps or waiting for stdin to close.
+	Crash bool `json:"crash,omitempty"`
+}
+
+// ExitUsage is the exit code used when the fake rejects its arguments or
+// input, mirroring the CLI's usage-error exit status.
+const ExitUsage = 2
+
+// LoadScenario reads a JSON scenario file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+// WriteFile writes the scenario as JSON to path.
+func (sc *Scenario) WriteFile(path string) error {
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal scenario: %w", err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Play runs the scenario against the given streams and returns the exit code.
+//
+// After the last step Play writes Stderr and waits for stdin to reach EOF
+// before returning ExitCode, matching how the CLI behaves when launched by
+// claude.Launcher. If ctx is cancelled Play returns -1 promptly, even
+// while waiting for input.
+func (sc *Scenario) Play(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if err := sc.CheckArgs(args); err != nil {
+		fmt.Fprintf(stderr, "fakeclaude: %v\n", err)
+		return ExitUsage
+	}
+
+	lines := readLines(ctx, stdin)
+	for i, step := range sc.Steps {
+		if step.DelayMS > 0 {
+			timer := time.NewTimer(time.Duration(step.DelayMS) * time.Millisecond)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return -1
+			}
+		}
+
+		if step.ReadInput || step.ExpectInput != "" {
+			var line string
+			var ok bool
+			select {
+			case line, ok = <-lines:
+			case <-ctx.Done():
+				return -1
+			}
+			if !ok {
+				fmt.Fprintf(stderr, "fakeclaude: step %d: unexpected end of input\n", i)
+				return 1
+			}
+			if step.ExpectInput != "" && !strings.Contains(line, step.ExpectInput) {
+				fmt.Fprintf(stderr, "fakeclaude: step %d: input %q does not contain %q\n",
+					i, strings.TrimSpace(line), step.ExpectInput)
+				return ExitUsage
+			}
+		}
+
+		if len(step.Emit) > 0 {
+			if err := writeLine(stdout, compactJSON(step.Emit)); err != nil {
+				return 1
+			}
+		}
+		if step.Raw != "" {
+			if err := writeLine(stdout, step.Raw); err != nil {
+				return 1
+			}
+		}
+		if step.Stderr != "" {
+			io.WriteString(stderr, step.Stderr)
+		}
+
+		if step.Crash {
+			io.WriteString(stderr, sc.Stderr)
+			if sc.ExitCode == 0 {
+				return 1
+			}
+			return sc.ExitCode
+		}
+	}
+
+	io.WriteString(stderr, sc.Stderr)
+
+	// Like the CLI, stay alive until stdin is closed.
+	for {
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				return sc.ExitCode
+			}
+		case <-ctx.Done():
+			return -1
+		}
+	}
+}
+
+// readLines reads r line by line in the background, so that Play can stop
+// waiting for input when ctx is cancelled. The channel is closed at EOF.
+func readLines(ctx context.Context, r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		in := bufio.NewReader(r)
+		for {
+			line, err := in.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// CheckArgs verifies args against ExpectFlags and RejectFlags.
+func (sc *Scenario) CheckArgs(args []string) error {
+	for flag, want := range sc.ExpectFlags {
+		i := indexOf(args, flag)
+		if i < 0 {
+			return fmt.Errorf("expected flag %s", flag)
+		}
+		if want != "" && (i+1 >= len(args) || args[i+1] != want) {
+			return fmt.Errorf("expected %s %q", flag, want)
+		}
+	}
+	for _, flag := range sc.RejectFlags {
+		if indexOf(args, flag) >= 0 {
+			return fmt.Errorf("unexpected flag %s", flag)
+		}
+	}
+	return nil
+}
+
+func indexOf(args []string, flag string) int {
+	for i, a := range args {
+		if a == flag {
+			return i
+		}
+	}
+	return -1
+}
+
+func writeLine(w io.Writer, line string) error {
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
+
+// compactJSON strips insignificant whitespace so pretty-printed scenario
+// files still emit one message per line.
+func compactJSON(raw json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// ---------------------------------------------------------------------------
+// Step builders
+// ---------------------------------------------------------------------------
+
+// Emit returns a step that writes msg as a stream-json line.
+func Emit(msg claude.StreamMessage) Step {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(fmt.Sprintf("claudetest: marshal message: %v", err))
+	}
+	return Step{Emit: data}
+}
+
+// Init returns a system init message step.
+func Init(sessionID, model string) Step {
+	return Emit(claude.StreamMessage{
+		Type:      "system",
+		Subtype:   "init",
+		SessionID: sessionID,
+		Model:     model,
+	})
+}
+
+// AssistantText returns an assistant message step with a single text block.
+func AssistantText(text string) Step {
+	return Emit(claude.StreamMessage{
+		Type: "assistant",
+		Message: &claude.MessageContent{
+			Role:    "assistant",
+			Content: []claude.ContentBlock{{Type: "text", Text: text}},
+		},
+	})
+}
+
+// ToolUse returns an assistant message step invoking a tool.
+func ToolUse(id, name string, input map[string]any) Step {
+	return Emit(claude.StreamMessage{
+		Type: "assistant",
+		Message: &claude.MessageContent{
+			Role: "assistant",
+			Content: []claude.ContentBlock{{
+				Type:  "tool_use",
+				ID:    id,
+				Name:  name,
+				Input: input,
+			}},
+		},
+	})
+}
+
+// ToolResult returns a user message step carrying a tool result.
+func ToolResult(toolUseID, content string) Step {
+	return Emit(claude.StreamMessage{
+		Type: "user",
+		Message: &claude.MessageContent{
+			Role: "user",
+			Content: []claude.ContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: toolUseID,
+				Content:   claude.Blocks{{Type: "text", Text: content}},
+			}},
+		},
+	})
+}
+
+// Result returns a successful result message step.
+func Result(text string, totalCostUSD float64) Step {
+	return Emit(claude.StreamMessage{
+		Type:      "result",
+		Subtype:   "success",
+		Result:    text,
+		TotalCost: totalCostUSD,
+		NumTurns:  1,
+	})
+}
+
+// ErrorResult returns an error result message step, e.g. with subtype
+// "error_max_turns" or "error_during_execution".
+func ErrorResult(subtype, text string) Step {
+	return Emit(claude.StreamMessage{
+		Type:          "result",
+		Subtype:       subtype,
+		Result:        text,
+		IsErrorResult: true,
+	})
+}
+
+// Raw returns a step that writes line to stdout verbatim.
+func Raw(line string) Step {
+	return Step{Raw: line}
+}
+
+// Delay returns a step that pauses for d.
+func Delay(d time.Duration) Step {
+	return Step{DelayMS: int(d / time.Millisecond)}
+}
+
+// ReadInput returns a step that waits for one stdin line.
+func ReadInput() Step {
+	return Step{ReadInput: true}
+}
+
+// ExpectInput returns a step that reads one stdin line and fails the
+// scenario unless it contains substr.
+func ExpectInput(substr string) Step {
+	return Step{ExpectInput: substr}
+}
+
+// Crash returns a step that exits immediately with the scenario ExitCode.
+func Crash() Step {
+	return Step{Crash: true}
+}
@@ -0,0 +1,213 @@
+package claudetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+)
+
+// ScenarioEnv is the environment variable cmd/fakeclaude reads the scenario
+// file path from.
+const ScenarioEnv = "FAKECLAUDE_SCENARIO"
+
+// Spawner is an in-process claude.Spawner that plays scenarios instead of
+// starting the CLI.
+//
+// Each Spawn consumes the next scenario; once exhausted, the last scenario
+// is reused. Spawner is safe for concurrent use.
+type Spawner struct {
+	mu        sync.Mutex
+	scenarios []*Scenario
+	calls     []*Call
+}
+
+// NewSpawner creates a Spawner that plays scenarios in order.
+func NewSpawner(scenarios ...*Scenario) *Spawner {
+	return &Spawner{scenarios: scenarios}
+}
+
+// Call records one Spawn invocation.
+type Call struct {
+	// Config is the spawn configuration built by claude.Launcher.
+	Config claude.SpawnConfig
+
+	// Scenario is the scenario played for this call.
+	Scenario *Scenario
+
+	mu       sync.Mutex
+	input    bytes.Buffer
+	exitCode int
+	done     chan struct{}
+}
+
+// Input returns the stdin lines the fake has consumed so far.
+func (c *Call) Input() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	text := strings.TrimSuffix(c.input.String(), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// ExitCode blocks until the fake process exits and returns its exit code.
+// Killed processes report -1.
+func (c *Call) ExitCode() int {
+	<-c.done
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exitCode
+}
+
+// inputRecorder appends stdin bytes read by the scenario to a Call.
+type inputRecorder struct{ call *Call }
+
+func (r inputRecorder) Write(p []byte) (int, error) {
+	r.call.mu.Lock()
+	defer r.call.mu.Unlock()
+	return r.call.input.Write(p)
+}
+
+// Calls returns every Spawn invocation so far, oldest first.
+func (s *Spawner) Calls() []*Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Call(nil), s.calls...)
+}
+
+// LastCall returns the most recent Spawn invocation, or nil.
+func (s *Spawner) LastCall() *Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.calls) == 0 {
+		return nil
+	}
+	return s.calls[len(s.calls)-1]
+}
+
+// Spawn implements claude.Spawner.
+func (s *Spawner) Spawn(ctx context.Context, cfg claude.SpawnConfig) (claude.Process, error) {
+	s.mu.Lock()
+	if len(s.scenarios) == 0 {
+		s.mu.Unlock()
+		return nil, errors.New("claudetest: no scenario configured")
+	}
+	idx := len(s.calls)
+	if idx >= len(s.scenarios) {
+		idx = len(s.scenarios) - 1
+	}
+	call := &Call{Config: cfg, Scenario: s.scenarios[idx], done: make(chan struct{})}
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+
+	return startProcess(ctx, call), nil
+}
+
+// process is the claude.Process handed to Launcher.
+type process struct {
+	call    *Call
+	cancel  context.CancelFunc
+	stdinR  *io.PipeReader
+	stdinW  *io.PipeWriter
+	stdoutR *io.PipeReader
+	stdoutW *io.PipeWriter
+	stderrR *io.PipeReader
+	stderrW *io.PipeWriter
+
+	mu     sync.Mutex
+	killed bool
+}
+
+func startProcess(ctx context.Context, call *Call) *process {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &process{call: call, cancel: cancel}
+	p.stdinR, p.stdinW = io.Pipe()
+	p.stdoutR, p.stdoutW = io.Pipe()
+	p.stderrR, p.stderrW = io.Pipe()
+
+	go func() {
+		code := call.Scenario.Play(ctx, call.Config.Args, io.TeeReader(p.stdinR, inputRecorder{call}), p.stdoutW, p.stderrW)
+
+		// An exited process no longer accepts input or produces output.
+		p.stdinR.CloseWithError(io.ErrClosedPipe)
+		p.stdoutW.Close()
+		p.stderrW.Close()
+
+		p.mu.Lock()
+		if p.killed {
+			code = -1
+		}
+		p.mu.Unlock()
+
+		call.mu.Lock()
+		call.exitCode = code
+		call.mu.Unlock()
+		close(call.done)
+		cancel()
+	}()
+
+	// Like exec.CommandContext, cancelling the spawn context kills the process.
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Kill()
+		case <-call.done:
+		}
+	}()
+
+	return p
+}
+
+func (p *process) Stdin() io.WriteCloser { return p.stdinW }
+func (p *process) Stdout() io.Reader     { return p.stdoutR }
+func (p *process) Stderr() io.Reader     { return p.stderrR }
+func (p *process) Pid() int              { return 0 }
+
+// Signal terminates the fake; scenarios do not model graceful shutdown.
+func (p *process) Signal(sig os.Signal) error {
+	return p.Kill()
+}
+
+// Kill stops the scenario and closes its streams.
+func (p *process) Kill() error {
+	p.mu.Lock()
+	p.killed = true
+	p.mu.Unlock()
+
+	p.cancel()
+	p.stdinR.CloseWithError(io.ErrClosedPipe)
+	p.stdoutW.Close()
+	p.stderrW.Close()
+	return nil
+}
+
+// Wait blocks until the scenario finishes.
+func (p *process) Wait() error {
+	code := p.call.ExitCode()
+	if code != 0 {
+		return exitError(code)
+	}
+	return nil
+}
+
+// exitError reports a non-zero exit. It implements ExitCode() so Launcher
+// surfaces it as *claude.ExitError.
+type exitError int
+
+func (e exitError) Error() string {
+	if e < 0 {
+		return "signal: killed"
+	}
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (e exitError) ExitCode() int { return int(e) }
@@ -0,0 +1,18 @@
+// Command claude-hook-shim forwards Claude CLI hook events to Go callbacks.
+//
+// The SDK writes it into the CLI's hook settings when LaunchOptions has
+// HookHandlers; it is not meant to be run by hand. Install it on PATH, or
+// point LaunchOptions.HookShimPath at the built binary:
+//
+//	go install github.com/MateoSegura/claudesdk-go/cmd/claude-hook-shim@latest
+package main
+
+import (
+	"os"
+
+	"github.com/MateoSegura/claudesdk-go/internal/hookshim"
+)
+
+func main() {
+	os.Exit(hookshim.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
@@ -0,0 +1,43 @@
+// Command fakeclaude is a scriptable stand-in for the Claude CLI.
+//
+// It plays the claudetest.Scenario whose JSON file path is given in the
+// FAKECLAUDE_SCENARIO environment variable, ignoring its arguments except
+// for the scenario's flag assertions. Point LaunchOptions.BinaryPath at the
+// built binary to exercise the real process path without network access:
+//
+//	go build -o /tmp/fakeclaude ./cmd/fakeclaude
+//	FAKECLAUDE_SCENARIO=testdata/hello.json /tmp/fakeclaude -p --output-format stream-json hi
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/MateoSegura/claudesdk-go/claudetest"
+)
+
+func main() {
+	path := os.Getenv(claudetest.ScenarioEnv)
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "fakeclaude: %s is not set\n", claudetest.ScenarioEnv)
+		os.Exit(claudetest.ExitUsage)
+	}
+
+	sc, err := claudetest.LoadScenario(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fakeclaude: %v\n", err)
+		os.Exit(claudetest.ExitUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	code := sc.Play(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if code < 0 {
+		code = 130 // interrupted, as a shell would report SIGINT
+	}
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,314 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conversation keeps a single Claude CLI process alive across multiple turns.
+//
+// Conversation launches the CLI with --input-format stream-json and keeps
+// stdin open, so follow-up prompts reuse the same process and loaded context
+// instead of paying a spawn and context reload per turn. Turns are serialized:
+// Send blocks until Claude emits the result message for that turn.
+//
+// Example:
+//
+//	conv, err := claude.NewConversation(claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{SkipPermissions: true},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := conv.Start(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer conv.Close()
+//
+//	r1, _ := conv.Send(ctx, "Pick a number between 1 and 10.")
+//	r2, _ := conv.Send(ctx, "Now double it.")
+//	fmt.Println(r1.Text, r2.Text)
+type Conversation struct {
+	// ID is the conversation identifier (from config or auto-generated).
+	ID string
+
+	launcher *Launcher
+	config   SessionConfig
+	incoming chan StreamMessage
+
+	// turnMu serializes Send and Close so each turn owns the incoming stream.
+	turnMu sync.Mutex
+
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	done    chan struct{}
+	err     error
+	metrics SessionMetrics
+}
+
+// NewConversation creates a new Conversation with the given configuration.
+//
+// InputFormat is forced to stream-json. The process is not started until
+// Start is called.
+func NewConversation(cfg SessionConfig) (*Conversation, error) {
+	bufSize := cfg.ChannelBuffer
+	if bufSize <= 0 {
+		bufSize = 100
+	}
+
+	id := cfg.ID
+	if id == "" {
+		id = fmt.Sprintf("conversation-%d", time.Now().UnixNano())
+	}
+
+	cfg.InputFormat = InputFormatStreamJSON
+
+	return &Conversation{
+		ID:       id,
+		config:   cfg,
+		incoming: make(chan StreamMessage, bufSize),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start launches the Claude CLI process.
+//
+// The context controls the lifetime of the whole conversation: if it is
+// cancelled the process is killed. Use the per-call context on Send to
+// bound individual turns.
+func (c *Conversation) Start(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrSessionClosed
+	}
+	if c.started {
+		return ErrAlreadyStarted
+	}
+
+	c.launcher = NewLauncher()
+	opts := c.config.LaunchOptions
+	opts.Attachments = nil // sent per turn by Send
+	if opts.Logger != nil {
+		opts.Logger = opts.Logger.With(logKeySession, c.ID)
+	}
+	if err := c.launcher.Start(ctx, "", opts); err != nil {
+		c.closed = true
+		close(c.incoming)
+		close(c.done)
+		return err
+	}
+	c.started = true
+
+	go c.readLoop()
+
+	return nil
+}
+
+// readLoop forwards launcher messages to the current turn and reaps the
+// process at EOF.
+func (c *Conversation) readLoop() {
+	for {
+		msg, err := c.launcher.ReadMessage()
+		if err != nil {
+			// Bad lines are already reported via the OnError hook.
+			if skippable(err) {
+				continue
+			}
+			break // stdout is unreadable
+		}
+		if msg == nil {
+			break // EOF
+		}
+		c.incoming <- *msg
+	}
+
+	err := c.launcher.Wait()
+
+	c.mu.Lock()
+	c.err = err
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.incoming)
+	close(c.done)
+}
+
+// Send submits a user message and blocks until Claude finishes the turn.
+//
+// The returned Result covers only this turn: its Messages, Text, and
+// Duration are per-turn, while cost and usage fields mirror the CLI's
+// cumulative values from the turn's result message.
+//
+// Attachments are sent with the text as image and document blocks.
+//
+// If ctx is cancelled mid-turn the process is killed and the conversation
+// cannot be used further; the partial Result is returned with ctx.Err().
+func (c *Conversation) Send(ctx context.Context, text string, attachments ...Attachment) (*Result, error) {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+
+	c.mu.Lock()
+	started, closed := c.started, c.closed
+	c.mu.Unlock()
+
+	if !started {
+		return nil, ErrNotStarted
+	}
+	if closed {
+		return nil, ErrSessionClosed
+	}
+
+	blocks, err := attachmentBlocks(attachments)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.launcher.SendMessage(NewUserMessage(text, blocks...)); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	var textBuilder strings.Builder
+	startTime := time.Now()
+
+	finish := func() {
+		result.Duration = time.Since(startTime)
+		result.Text = textBuilder.String()
+		result.Metrics = c.CurrentMetrics()
+	}
+
+	for {
+		select {
+		case msg, ok := <-c.incoming:
+			if !ok {
+				finish()
+				if err := c.Err(); err != nil {
+					return result, err
+				}
+				return result, ErrSessionClosed
+			}
+
+			result.collect(msg, &textBuilder)
+			c.updateMetrics(&msg)
+
+			if msg.Type == "result" {
+				finish()
+				return result, result.Err()
+			}
+
+		case <-ctx.Done():
+			c.launcher.Kill()
+			// Keep the reader unblocked until it observes EOF.
+			go func() {
+				for range c.incoming {
+				}
+			}()
+			finish()
+			return result, ctx.Err()
+		}
+	}
+}
+
+// updateMetrics merges session info from init messages and totals from
+// result messages into the conversation metrics.
+func (c *Conversation) updateMetrics(msg *StreamMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case msg.Type == "system" && msg.Subtype == "init":
+		if msg.SessionID != "" {
+			c.metrics.SessionID = msg.SessionID
+		}
+		if msg.Model != "" {
+			c.metrics.Model = msg.Model
+		}
+
+	case msg.Type == "result":
+		m := metricsFromMessage(msg)
+		if m.SessionID == "" {
+			m.SessionID = c.metrics.SessionID
+		}
+		if m.Model == "" {
+			m.Model = c.metrics.Model
+		}
+		c.metrics = m
+	}
+}
+
+// Close ends the conversation by closing stdin and waiting for Claude to exit.
+//
+// Close waits for any in-flight Send to finish first. Returns the process
+// exit error, if any.
+func (c *Conversation) Close() error {
+	c.turnMu.Lock()
+	defer c.turnMu.Unlock()
+
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+
+	if !started {
+		return ErrNotStarted
+	}
+
+	if err := c.launcher.CloseInput(); err != nil {
+		return err
+	}
+
+	// Discard anything Claude emits while shutting down.
+	for range c.incoming {
+	}
+	<-c.done
+
+	return c.Err()
+}
+
+// Done returns a channel that's closed when the Claude process has exited.
+func (c *Conversation) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the process exit error, if any.
+//
+// Call after Done() is closed to get the final error.
+func (c *Conversation) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// SessionID returns the CLI session identifier reported by the init message.
+//
+// Returns empty string until the first turn has started.
+func (c *Conversation) SessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.metrics.SessionID
+}
+
+// CurrentMetrics returns the latest accumulated conversation metrics.
+//
+// Safe to call from any goroutine at any time.
+func (c *Conversation) CurrentMetrics() SessionMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.metrics
+}
+
+// Kill forcefully terminates Claude. The conversation cannot be used afterwards.
+func (c *Conversation) Kill() error {
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+
+	if !started {
+		return ErrNotStarted
+	}
+	return c.launcher.Kill()
+}
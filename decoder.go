@@ -0,0 +1,110 @@
+package claude
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+const (
+	// maxRetainedLine is the largest line buffer a Decoder keeps between
+	// lines; larger buffers are released once the line has been decoded.
+	maxRetainedLine = 1 << 20
+
+	// maxLinePrefix is how much of a skipped line LineTooLongError keeps.
+	maxLinePrefix = 100
+)
+
+// Decoder reads stream-json messages, one JSON object per line, from an
+// io.Reader such as the CLI's stdout or a saved --output-format stream-json
+// log.
+//
+// Lines have no length limit unless one is set with NewDecoder. Both
+// *ParseError and *LineTooLongError are recoverable: the offending line is
+// skipped and the next Decode continues with the line after it.
+type Decoder struct {
+	r            *bufio.Reader
+	maxLineBytes int
+	keepRaw      bool
+	buf          []byte
+}
+
+// NewDecoder returns a Decoder reading from r. Lines longer than
+// maxLineBytes, excluding the newline, are skipped and reported as
+// *LineTooLongError; zero or less means no limit.
+func NewDecoder(r io.Reader, maxLineBytes int) *Decoder {
+	return &Decoder{r: bufio.NewReaderSize(r, 64*1024), maxLineBytes: maxLineBytes}
+}
+
+// KeepRaw makes Decode keep a copy of each line in StreamMessage.Raw.
+func (d *Decoder) KeepRaw() {
+	d.keepRaw = true
+}
+
+// Decode reads the next message. Blank lines are skipped.
+//
+// Returns nil, nil at EOF, *ParseError if a line is not valid JSON,
+// *LineTooLongError if a line exceeds the limit, or the reader's error.
+func (d *Decoder) Decode() (*StreamMessage, error) {
+	line, err := d.readLine()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var msg StreamMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, &ParseError{Line: string(line), Err: err}
+	}
+	if d.keepRaw {
+		msg.Raw = bytes.Clone(line)
+	}
+	return &msg, nil
+}
+
+// readLine returns the next non-blank line without its line ending. The
+// slice is only valid until the next call.
+func (d *Decoder) readLine() ([]byte, error) {
+	for {
+		if cap(d.buf) > maxRetainedLine {
+			d.buf = nil
+		}
+		d.buf = d.buf[:0]
+
+		var (
+			size    int    // bytes in the line, excluding the newline
+			tooLong bool   // d.buf was discarded
+			prefix  string // start of a line that is too long
+		)
+		for {
+			chunk, err := d.r.ReadSlice('\n')
+			size += len(bytes.TrimSuffix(chunk, []byte("\n")))
+			if !tooLong {
+				d.buf = append(d.buf, chunk...)
+				if d.maxLineBytes > 0 && len(bytes.TrimRight(d.buf, "\r\n")) > d.maxLineBytes {
+					tooLong = true
+					prefix = string(d.buf[:min(len(d.buf), maxLinePrefix)])
+					d.buf = d.buf[:0]
+				}
+			}
+
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil && (err != io.EOF || size == 0) {
+				return nil, err
+			}
+			break
+		}
+
+		if tooLong {
+			return nil, &LineTooLongError{Size: size, Limit: d.maxLineBytes, Prefix: prefix}
+		}
+		if line := bytes.TrimSpace(d.buf); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package claude
+
+import "sync"
+
+// DeliveryPolicy controls what a Session does when the Messages channel
+// is full because the consumer has fallen behind.
+type DeliveryPolicy string
+
+const (
+	// DeliveryDrop discards the new message and reports it on Errors.
+	// This is the default.
+	DeliveryDrop DeliveryPolicy = "drop"
+
+	// DeliveryBlock waits for the consumer. The session stops reading
+	// stdout, so the CLI itself is paused once the pipe fills. No message
+	// is lost, but Messages must be drained (or the session killed or its
+	// context cancelled) for the session to finish.
+	DeliveryBlock DeliveryPolicy = "block"
+
+	// DeliveryDropOldest discards the oldest buffered message to make room,
+	// so the consumer always sees the most recent output.
+	DeliveryDropOldest DeliveryPolicy = "drop-oldest"
+
+	// DeliveryUnbounded queues messages in memory without limit. Nothing
+	// is lost and the CLI is never paused, at the cost of memory when the
+	// consumer is slow.
+	DeliveryUnbounded DeliveryPolicy = "unbounded"
+)
+
+func (p DeliveryPolicy) valid() bool {
+	switch p {
+	case "", DeliveryDrop, DeliveryBlock, DeliveryDropOldest, DeliveryUnbounded:
+		return true
+	}
+	return false
+}
+
+// queue feeds out from an unbounded buffer so the producer never blocks.
+// The pump goroutine closes out once the queue is closed and drained, or
+// immediately when stop is closed.
+type queue[T any] struct {
+	out  chan T
+	stop <-chan struct{}
+	wake chan struct{}
+
+	mu     sync.Mutex
+	items  []T
+	closed bool
+}
+
+func newQueue[T any](out chan T, stop <-chan struct{}) *queue[T] {
+	q := &queue[T]{out: out, stop: stop, wake: make(chan struct{}, 1)}
+	go q.pump()
+	return q
+}
+
+// push appends v. Returns false if the queue no longer accepts values.
+func (q *queue[T]) push(v T) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	q.items = append(q.items, v)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// close stops accepting values; buffered values are still delivered.
+func (q *queue[T]) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue[T]) pump() {
+	defer close(q.out)
+
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 {
+			if q.closed {
+				q.mu.Unlock()
+				return
+			}
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+			case <-q.stop:
+				q.discard()
+				return
+			}
+			q.mu.Lock()
+		}
+		v := q.items[0]
+		var zero T
+		q.items[0] = zero
+		q.items = q.items[1:]
+		q.mu.Unlock()
+
+		select {
+		case q.out <- v:
+		case <-q.stop:
+			q.discard()
+			return
+		}
+	}
+}
+
+func (q *queue[T]) discard() {
+	q.mu.Lock()
+	q.closed = true
+	q.items = nil
+	q.mu.Unlock()
+}
@@ -32,6 +32,11 @@
 //	session.Run(ctx, "Write a haiku")
 //	for msg := range session.Messages { ... }
 //
+// When the Messages buffer is full, SessionConfig.Delivery chooses between
+// dropping ([DeliveryDrop], the default), backpressure ([DeliveryBlock]),
+// evicting the oldest message ([DeliveryDropOldest]), or an in-memory queue
+// ([DeliveryUnbounded]). Drops are counted in [SessionMetrics].
+//
 // Launcher (low-level) provides synchronous message reading and direct
 // process control for custom read loops:
 //
@@ -47,6 +52,35 @@
 //		fmt.Print(claude.ExtractText(msg))
 //	}
 //
+// On Unix the CLI runs in its own process group. Kill stops the whole
+// group, including tools and MCP servers the CLI started, and Shutdown sends
+// SIGINT first, killing the group only if the CLI has not exited within a
+// grace period:
+//
+//	session.Shutdown(ctx, 5*time.Second)
+//
+// # Multi-Turn Conversations
+//
+// [Conversation] keeps one CLI process alive across turns using stream-json
+// input, avoiding a process spawn and context reload per prompt:
+//
+//	conv, _ := claude.NewConversation(claude.SessionConfig{})
+//	conv.Start(ctx)
+//	defer conv.Close()
+//
+//	r1, _ := conv.Send(ctx, "Read main.go")
+//	r2, _ := conv.Send(ctx, "Now summarize it")
+//
+// # Concurrent Workloads
+//
+// [Pool] runs one-shot prompts on a bounded number of concurrent sessions,
+// queuing the rest by priority and enforcing an aggregate budget:
+//
+//	pool, _ := claude.NewPool(claude.PoolConfig{MaxConcurrent: 8, MaxBudgetUSD: 25})
+//	result, err := pool.Do(ctx, claude.PoolTask{Prompt: "...", Priority: 1})
+//
+// [Pool.Stats] reports queue depth, utilization, and spend.
+//
 // # Configuration
 //
 // [LaunchOptions] provides 30+ fields mapping directly to CLI flags, organized
@@ -56,6 +90,13 @@
 // [SessionConfig] embeds LaunchOptions and adds session-specific fields (ID,
 // channel buffer size).
 //
+// Prompts longer than MaxPromptArgBytes are written to the CLI's stdin
+// rather than its argument list. Attachments sends images, PDFs, and text
+// files with the prompt as content blocks over stream-json input:
+//
+//	img, err := claude.AttachFile("screenshot.png")
+//	opts.Attachments = []claude.Attachment{img}
+//
 // # Permission Modes
 //
 // Four permission modes control tool approval behavior:
@@ -68,6 +109,21 @@
 // Granular control is available via AllowedTools and DisallowedTools with
 // glob-pattern support (e.g., "Bash(git log *)").
 //
+// For decisions in Go code, set CanUseTool. The SDK serves the callback to
+// the CLI over a loopback MCP server and wires --permission-prompt-tool
+// automatically; return [Allow], [Deny], or [AllowWithInput].
+//
+// # Errors and Retries
+//
+// CLI failures are classified into sentinel classes ([ErrOverloaded],
+// [ErrRateLimited], [ErrAuth], [ErrNetwork], [ErrMaxTurns], [ErrMaxBudget])
+// that match with errors.Is; [Classify] returns the class of any error.
+// A run that ends with an error result message returns a [ResultError]
+// carrying the subtype, turns, cost, and partial text; [Result.Err]
+// exposes it from the Result.
+// SessionConfig.Retry sets a [RetryPolicy] that makes RunAndCollect retry
+// transient classes with backoff, jitter, and optional fallback models.
+//
 // # Real-Time Metrics
 //
 // Session metrics (cost, tokens, turns, model, duration) are available via:
@@ -86,6 +142,27 @@
 //		},
 //	}
 //
+// A [Ledger] aggregates spend across sessions by label, model, and day,
+// pricing token usage with a [Pricing] table, persisting records to a
+// JSONL file, and enforcing soft and hard spend limits:
+//
+//	ledger, _ := claude.NewLedger(claude.LedgerConfig{Path: "spend.jsonl", HardLimitUSD: 50})
+//	opts, err := ledger.Attach("triage", claude.LaunchOptions{})
+//
+// The metrics subpackage serves the same data to Prometheus: its Collector
+// is fed by Hooks and exposes counters and histograms as an http.Handler.
+//
+// # Tracing
+//
+// Set SessionConfig.Tracer to trace each run: a root span, a child span
+// per assistant turn, and a span per tool call, carrying model, token
+// usage, and cost attributes. [Tracer] is a small interface to adapt to
+// OpenTelemetry; [NewTracer] implements it with [InMemoryExporter] and
+// [JSONExporter]:
+//
+//	spans := &claude.InMemoryExporter{}
+//	session, _ := claude.NewSession(claude.SessionConfig{Tracer: claude.NewTracer(spans)})
+//
 // # Message Extraction
 //
 // Typed helper functions extract structured data from stream messages:
@@ -103,6 +180,49 @@
 // Type predicates ([IsResult], [IsAssistant], [IsInit], etc.) simplify
 // message filtering in stream processing loops.
 //
+// [ToolTracker] matches tool calls to their results and reports each as a
+// [ToolInvocation] with its result, error flag, and duration; Pending lists
+// calls still unanswered when a session ends.
+//
+// Tool result content arrives as a string or as an array of text and image
+// blocks; both decode into [Blocks], and [ContentBlock.ContentText]
+// flattens it to text. Server tool blocks (web search) and redacted
+// thinking decode into ContentBlock as well.
+//
+// Each decoded [StreamMessage] keeps keys without a typed field in Extra,
+// so newer CLI fields are not lost; LaunchOptions.KeepRawMessages also
+// keeps the original line in Raw.
+// [ExtractCompactBoundary], [ExtractHookResponse], and [ExtractAPIRetry]
+// decode system messages the CLI emits for compaction, hooks, and API
+// retries.
+//
+// Stream lines have no length limit unless LaunchOptions.MaxLineBytes is
+// set; longer lines are then skipped with a [*LineTooLongError], like a
+// [*ParseError], and reading continues. [NewDecoder] reads saved
+// stream-json output the same way.
+//
+// # Typed Structured Output
+//
+// [RunTyped] derives a JSON Schema from a Go struct, runs the prompt with
+// --json-schema, validates the result, and decodes it into the struct:
+//
+//	type Answer struct {
+//		Answer     string  `json:"answer"`
+//		Confidence float64 `json:"confidence"`
+//	}
+//	answer, result, err := claude.RunTyped[Answer](ctx, cfg, "What is the speed of light?")
+//
+// The schema subpackage performs the reflection, so description and
+// jsonschema struct tags (enum, minimum, maximum, ...) refine the schema.
+// Mismatched output is reported as [ErrInvalidOutput].
+//
+// # Partial Messages
+//
+// With IncludePartialMessages the CLI streams "stream_event" messages whose
+// [StreamEvent] carries token-level deltas. [Session] forwards text deltas
+// to its TextDeltas channel; [PartialAccumulator] rebuilds complete
+// [ContentBlock] values (text, thinking, tool_use input) from the events.
+//
 // # Hooks
 //
 // Optional [Hooks] callbacks provide observability without coupling to a logging
@@ -120,6 +240,24 @@
 //
 // All hooks and the Hooks pointer itself are nil-safe.
 //
+// Set LaunchOptions.Logger to a *slog.Logger for structured records of
+// process start and exit, parse errors, tool calls, results, and stderr
+// lines, without writing hooks. Secrets in logged arguments are redacted.
+//
+// # Lifecycle Hooks
+//
+// [Hooks] only observe. LaunchOptions.HookHandlers registers [HookFunc]
+// callbacks for the CLI's own hook events, which can block a tool call,
+// add context, or keep Claude working:
+//
+//	opts.HookHandlers = map[claude.HookEvent][]claude.HookMatcher{
+//		claude.HookPreToolUse: {{Matcher: "Bash", Func: checkCommand}},
+//	}
+//
+// The CLI runs the claude-hook-shim command (see cmd/claude-hook-shim),
+// which forwards each event to the callback over a unix socket. Install it
+// on PATH or set LaunchOptions.HookShimPath.
+//
 // # MCP Servers
 //
 // External tool providers are configured via [MCPServer] and passed to Claude
@@ -130,6 +268,21 @@
 //		"my-api":   {Type: "http", URL: "https://api.example.com/mcp/"},
 //	}
 //
+// Go functions can be exposed as MCP tools with the mcp subpackage. Servers
+// in LocalMCPServers are served on loopback for the lifetime of the CLI
+// process and wired into --mcp-config automatically:
+//
+//	srv := mcp.NewServer("inventory", "1.0.0")
+//	srv.AddTool(mcp.Tool{Name: "stock_level", InputSchema: schema, Handler: stockLevel})
+//
+//	LocalMCPServers: map[string]*mcp.Server{"inventory": srv},
+//
+// # Session History
+//
+// The history subpackage lists and loads the session transcripts the CLI
+// saves on disk, for resume pickers (LaunchOptions.Resume) and offline
+// analysis. Loaded transcripts convert to []StreamMessage.
+//
 // # Custom Agents
 //
 // Define specialized subagents via [AgentDefinition] that Claude can invoke
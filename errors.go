@@ -3,6 +3,8 @@ package claude
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 )
 
 // Sentinel errors for common failure modes.
@@ -21,8 +23,134 @@ var (
 
 	// ErrNotStarted indicates an operation requiring a started launcher.
 	ErrNotStarted = errors.New("claude: launcher not started")
+
+	// ErrInputClosed indicates a write to stdin that is closed or was never
+	// opened (stdin is only kept open for stream-json input).
+	ErrInputClosed = errors.New("claude: input stream is closed")
+
+	// ErrCassetteExhausted indicates a CassettePlayer has replayed every
+	// recorded run.
+	ErrCassetteExhausted = errors.New("claude: no cassette runs left to replay")
+
+	// ErrPoolClosed indicates a task submitted to, or queued on, a closed
+	// Pool.
+	ErrPoolClosed = errors.New("claude: pool is closed")
+
+	// ErrBudgetExceeded indicates a Pool's MaxBudgetUSD was spent before
+	// the task could start.
+	ErrBudgetExceeded = errors.New("claude: pool budget exceeded")
+
+	// ErrSpendLimit indicates a Ledger's HardLimitUSD has been reached.
+	ErrSpendLimit = errors.New("claude: ledger spend limit reached")
+
+	// ErrHookShimNotFound indicates HookHandlers were set but the
+	// claude-hook-shim command is not in PATH and HookShimPath is empty.
+	ErrHookShimNotFound = errors.New("claude: claude-hook-shim not found in PATH")
+
+	// ErrUnsupportedAttachment indicates an Attachment that is not a JPEG,
+	// PNG, GIF, or WebP image, a PDF, or UTF-8 text.
+	ErrUnsupportedAttachment = errors.New("claude: unsupported attachment type")
+
+	// ErrNoStructuredOutput indicates a run that requested structured
+	// output finished without producing any.
+	ErrNoStructuredOutput = errors.New("claude: no structured output in result")
+
+	// ErrInvalidOutput indicates structured output that does not match
+	// the requested schema or Go type.
+	ErrInvalidOutput = errors.New("claude: structured output does not match schema")
+)
+
+// Failure classes. A classified error matches one of these with errors.Is;
+// use Classify to get the class of any error returned by the SDK.
+var (
+	// ErrOverloaded indicates the API was overloaded (HTTP 529).
+	ErrOverloaded = errors.New("claude: API overloaded")
+
+	// ErrRateLimited indicates a rate or usage limit was hit (HTTP 429).
+	ErrRateLimited = errors.New("claude: rate limited")
+
+	// ErrAuth indicates missing or invalid credentials.
+	ErrAuth = errors.New("claude: authentication failed")
+
+	// ErrNetwork indicates the CLI could not reach the API.
+	ErrNetwork = errors.New("claude: network error")
+
+	// ErrMaxTurns indicates the run stopped at LaunchOptions.MaxTurns.
+	ErrMaxTurns = errors.New("claude: max turns reached")
+
+	// ErrMaxBudget indicates the run stopped at LaunchOptions.MaxBudgetUSD.
+	ErrMaxBudget = errors.New("claude: max budget reached")
+
+	// ErrStructuredOutputRetries indicates Claude repeatedly failed to
+	// produce output matching LaunchOptions.JSONSchema.
+	ErrStructuredOutputRetries = errors.New("claude: structured output retries exhausted")
+
+	// ErrExecution indicates the run failed with an error_during_execution
+	// result. Check Classify for a more specific cause.
+	ErrExecution = errors.New("claude: error during execution")
 )
 
+// failureClasses lists the classes in the order Classify checks them, most
+// specific first.
+var failureClasses = []error{
+	ErrAuth, ErrRateLimited, ErrOverloaded, ErrNetwork,
+	ErrMaxTurns, ErrMaxBudget, ErrStructuredOutputRetries, ErrExecution,
+}
+
+// failurePatterns match CLI stderr and error result text, lowercased.
+// Status codes and auth phrases are anchored to the CLI's own error forms
+// ("API Error: 401 ...", "Invalid API key · Please run /login"), since
+// tools and logs echoed to stderr often mention them in passing.
+var failurePatterns = []struct {
+	class error
+	re    *regexp.Regexp
+}{
+	{ErrAuth, regexp.MustCompile(`invalid api key|api error: 401\b|authentication_error|oauth token has (expired|been revoked)|not logged in|please run /login`)},
+	{ErrRateLimited, regexp.MustCompile(`rate.?limit|too many requests|usage limit|api error: 429\b`)},
+	{ErrOverloaded, regexp.MustCompile(`overloaded|api error: 529\b`)},
+	{ErrNetwork, regexp.MustCompile(`econnrefused|econnreset|enotfound|etimedout|eai_again|socket hang up|fetch failed|connection error|network error`)},
+	{ErrMaxTurns, regexp.MustCompile(`max(imum)?.?turns`)},
+	{ErrMaxBudget, regexp.MustCompile(`max(imum)?.?budget`)},
+}
+
+// resultSubtypeClasses maps result subtypes to failure classes.
+var resultSubtypeClasses = map[string]error{
+	"error_max_turns":                     ErrMaxTurns,
+	"error_max_budget_usd":                ErrMaxBudget,
+	"error_max_structured_output_retries": ErrStructuredOutputRetries,
+	"error_during_execution":              ErrExecution,
+}
+
+// classifyText returns the failure class described by CLI output, or nil.
+func classifyText(text string) error {
+	text = strings.ToLower(text)
+	for _, p := range failurePatterns {
+		if p.re.MatchString(text) {
+			return p.class
+		}
+	}
+	return nil
+}
+
+// Classify returns the failure class of err (ErrOverloaded, ErrRateLimited,
+// ErrAuth, ErrNetwork, ErrMaxTurns, ErrMaxBudget, ErrStructuredOutputRetries,
+// or ErrExecution), or nil if err is nil or unrecognized.
+//
+// Classes are usually tested with errors.Is directly:
+//
+//	if errors.Is(err, claude.ErrRateLimited) { ... }
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, class := range failureClasses {
+		if errors.Is(err, class) {
+			return class
+		}
+	}
+	return nil
+}
+
 // ParseError wraps JSON parsing failures with context.
 type ParseError struct {
 	Line string
@@ -41,6 +169,19 @@ func (e *ParseError) Unwrap() error {
 	return e.Err
 }
 
+// LineTooLongError reports a stream-json line longer than
+// LaunchOptions.MaxLineBytes. The line is skipped; reading continues with
+// the next one.
+type LineTooLongError struct {
+	Size   int    // length of the line in bytes
+	Limit  int    // the configured maximum
+	Prefix string // the first bytes of the line
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("claude: line of %d bytes exceeds %d-byte limit (line: %s...)", e.Size, e.Limit, e.Prefix)
+}
+
 // ExitError wraps non-zero exit codes from the CLI.
 type ExitError struct {
 	Code   int
@@ -54,6 +195,63 @@ func (e *ExitError) Error() string {
 	return fmt.Sprintf("claude: exit code %d", e.Code)
 }
 
+// Is reports whether target is the failure class described by Stderr, so
+// errors.Is(err, ErrOverloaded) works on exit errors.
+func (e *ExitError) Is(target error) bool {
+	class := classifyText(e.Stderr)
+	return class != nil && class == target
+}
+
+// ResultError reports a run that ended with an error result message: a
+// subtype other than "success" (such as "error_max_turns"), or is_error
+// set by the CLI.
+//
+// It matches the sentinel for its subtype with errors.Is (ErrMaxTurns,
+// ErrMaxBudget, ErrStructuredOutputRetries, ErrExecution), as well as any
+// failure class described by its Message.
+type ResultError struct {
+	// Subtype is the result subtype, e.g. "error_max_turns".
+	Subtype string
+
+	// Message is the result message's text, often an API error.
+	Message string
+
+	// NumTurns is the number of turns completed before the failure.
+	NumTurns int
+
+	// TotalCostUSD is the cost of the run.
+	TotalCostUSD float64
+
+	// Text is the assistant text collected before the failure.
+	Text string
+
+	// Err is the process error that accompanied the result, typically an
+	// *ExitError. Nil if the CLI exited cleanly.
+	Err error
+}
+
+func (e *ResultError) Error() string {
+	msg := fmt.Sprintf("claude: %s result after %d turns", e.Subtype, e.NumTurns)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *ResultError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel for e's subtype or the failure
+// class described by e.Message.
+func (e *ResultError) Is(target error) bool {
+	if class, ok := resultSubtypeClasses[e.Subtype]; ok && class == target {
+		return true
+	}
+	class := classifyText(e.Message)
+	return class != nil && class == target
+}
+
 // StartError wraps failures during CLI startup.
 type StartError struct {
 	Err error
@@ -321,6 +321,21 @@ func ExtractInitPermissionMode(msg *StreamMessage) string {
 	return msg.PermissionMode
 }
 
+// ExtractTextDelta extracts the text increment from a partial stream event.
+//
+// Returns empty string unless the message is a content_block_delta
+// stream_event carrying a text_delta.
+func ExtractTextDelta(msg *StreamMessage) string {
+	if msg == nil || msg.Type != "stream_event" || msg.Event == nil {
+		return ""
+	}
+	ev := msg.Event
+	if ev.Type != "content_block_delta" || ev.Delta == nil || ev.Delta.Type != "text_delta" {
+		return ""
+	}
+	return ev.Delta.Text
+}
+
 // --- Message type predicates ---
 
 // IsResult returns true if this is a final result message with metrics.
@@ -353,6 +368,12 @@ func IsUser(msg *StreamMessage) bool {
 	return msg != nil && msg.Type == "user"
 }
 
+// IsStreamEvent returns true if this is a partial streaming event
+// (only sent with IncludePartialMessages).
+func IsStreamEvent(msg *StreamMessage) bool {
+	return msg != nil && msg.Type == "stream_event" && msg.Event != nil
+}
+
 // getString safely extracts a string from a map.
 func getString(m map[string]any, key string) string {
 	if v, ok := m[key].(string); ok {
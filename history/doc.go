@@ -0,0 +1,32 @@
+// Package history reads the session transcripts the Claude CLI keeps on
+// disk, so tools can offer a picker for claude.LaunchOptions.Resume or
+// analyze past runs offline.
+//
+// The CLI writes one JSONL transcript per session under
+// $CLAUDE_CONFIG_DIR/projects (default ~/.claude/projects), in a directory
+// named after the working directory with every non-alphanumeric character
+// replaced by "-":
+//
+//	~/.claude/projects/-home-me-src-app/0b3c...e1.jsonl
+//
+// List summarizes the sessions of one working directory, newest first:
+//
+//	sessions, err := history.List("/home/me/src/app")
+//	for _, s := range sessions {
+//		fmt.Printf("%s  %s  $%.2f  %s\n", s.Updated.Format(time.DateTime), s.Model, s.CostUSD, s.Title())
+//	}
+//
+//	opts := claude.LaunchOptions{Resume: sessions[0].ID}
+//
+// Load reads a whole transcript. Its Messages method returns the
+// conversation as claude.StreamMessage values, so the root package's
+// extraction helpers work on history as they do on live output:
+//
+//	t, err := history.Load(sessions[0].Path)
+//	for _, msg := range t.Messages() {
+//		fmt.Println(claude.ExtractText(&msg))
+//	}
+//
+// The transcript format is internal to the CLI and changes between
+// versions. Unknown entry types are kept as raw JSON and otherwise ignored.
+package history
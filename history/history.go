@@ -0,0 +1,494 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+)
+
+// ConfigDirEnv overrides the CLI's configuration directory, as it does for
+// the CLI itself.
+const ConfigDirEnv = "CLAUDE_CONFIG_DIR"
+
+// ErrNotFound indicates no transcript exists for a session ID.
+var ErrNotFound = errors.New("history: session not found")
+
+// maxProjectKey is the longest project directory name the CLI writes
+// verbatim; longer names are shortened with a suffix.
+const maxProjectKey = 200
+
+// Session summarizes one transcript.
+type Session struct {
+	// ID is the CLI session ID, usable as claude.LaunchOptions.Resume.
+	ID string
+
+	// Path is the transcript file.
+	Path string
+
+	// CWD is the working directory the session ran in.
+	CWD string
+
+	// Created and Updated are the first and last entry timestamps. Both
+	// fall back to the file's modification time.
+	Created time.Time
+	Updated time.Time
+
+	// FirstPrompt is the first prompt the user typed.
+	FirstPrompt string
+
+	// Summary is the CLI's title for the session: a user-set title,
+	// else a generated one, else the last compaction summary. May be
+	// empty.
+	Summary string
+
+	// Model is the model of the first assistant message.
+	Model string
+
+	// CostUSD is the session's total cost as last recorded by the CLI.
+	// Zero for CLI versions that don't record it.
+	CostUSD float64
+
+	// NumMessages counts user and assistant messages, excluding
+	// subagent (sidechain) messages.
+	NumMessages int
+
+	// GitBranch is the branch checked out when the session started.
+	GitBranch string
+
+	// Version is the CLI version that started the session.
+	Version string
+}
+
+// Title returns Summary, or FirstPrompt when there is no summary.
+func (s Session) Title() string {
+	if s.Summary != "" {
+		return s.Summary
+	}
+	return s.FirstPrompt
+}
+
+// Entry is one line of a transcript.
+type Entry struct {
+	// Type is the entry kind: "user", "assistant", "system", "summary",
+	// "attachment", "cost-state", and others.
+	Type string `json:"type"`
+
+	// UUID identifies the entry; ParentUUID links it to the previous one.
+	UUID       string `json:"uuid,omitempty"`
+	ParentUUID string `json:"parentUuid,omitempty"`
+
+	// SessionID is the CLI session the entry belongs to.
+	SessionID string `json:"sessionId,omitempty"`
+
+	// Timestamp is when the entry was written. Zero for metadata entries.
+	Timestamp time.Time `json:"timestamp"`
+
+	// CWD, GitBranch, and Version describe the CLI's environment.
+	CWD       string `json:"cwd,omitempty"`
+	GitBranch string `json:"gitBranch,omitempty"`
+	Version   string `json:"version,omitempty"`
+
+	// IsSidechain marks messages from subagents.
+	IsSidechain bool `json:"isSidechain,omitempty"`
+
+	// IsMeta marks user entries the CLI injected rather than the user typed.
+	IsMeta bool `json:"isMeta,omitempty"`
+
+	// Message is the API message of "user" and "assistant" entries.
+	Message *claude.MessageContent `json:"-"`
+
+	// Raw is the complete entry.
+	Raw json.RawMessage `json:"-"`
+}
+
+// entryMeta holds the fields of metadata entries.
+type entryMeta struct {
+	Message      json.RawMessage `json:"message"`
+	Summary      string          `json:"summary"`
+	CustomTitle  string          `json:"customTitle"`
+	AITitle      string          `json:"aiTitle"`
+	TotalCostUSD *float64        `json:"totalCostUSD"`
+}
+
+// Transcript is a fully loaded session.
+type Transcript struct {
+	Session
+
+	// Entries holds every line of the transcript in file order.
+	Entries []Entry
+}
+
+// Messages returns the main conversation's user and assistant entries as
+// StreamMessages. Subagent messages are omitted.
+func (t *Transcript) Messages() []claude.StreamMessage {
+	var out []claude.StreamMessage
+	for _, e := range t.Entries {
+		if e.Message == nil || e.IsSidechain {
+			continue
+		}
+		out = append(out, claude.StreamMessage{
+			Type:      e.Type,
+			SessionID: e.SessionID,
+			UUID:      e.UUID,
+			Model:     e.Message.Model,
+			Message:   e.Message,
+		})
+	}
+	return out
+}
+
+// Dir returns the directory holding the CLI's per-project transcript
+// directories.
+func Dir() (string, error) {
+	if dir := os.Getenv(ConfigDirEnv); dir != "" {
+		return filepath.Join(dir, "projects"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("history: %w", err)
+	}
+	return filepath.Join(home, ".claude", "projects"), nil
+}
+
+// ProjectKey returns the directory name the CLI uses for transcripts of
+// sessions run in cwd.
+func ProjectKey(cwd string) string {
+	var b strings.Builder
+	for _, r := range cwd {
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		case utf16.RuneLen(r) == 2:
+			// The CLI replaces each UTF-16 code unit.
+			b.WriteString("--")
+		default:
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+// ProjectDir returns the transcript directory for cwd. The directory may
+// not exist if no session has run there.
+func ProjectDir(cwd string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(cwd)
+	if err != nil {
+		return "", fmt.Errorf("history: %w", err)
+	}
+	key := ProjectKey(abs)
+	if len(key) > maxProjectKey {
+		// Long keys are truncated and suffixed, so directories sharing the
+		// prefix may belong to other paths. Pick the one whose transcripts
+		// ran in abs.
+		matches, _ := filepath.Glob(filepath.Join(dir, key[:maxProjectKey]+"*"))
+		for _, m := range matches {
+			if projectCWD(m) == abs {
+				return m, nil
+			}
+		}
+	}
+	return filepath.Join(dir, key), nil
+}
+
+// projectCWD returns the working directory recorded in the first of dir's
+// transcripts that has one, or "".
+func projectCWD(dir string) string {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	for _, path := range paths {
+		if cwd := transcriptCWD(path); cwd != "" {
+			return cwd
+		}
+	}
+	return ""
+}
+
+// transcriptCWD returns the first working directory recorded in a
+// transcript, or "".
+func transcriptCWD(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		var e struct {
+			CWD string `json:"cwd"`
+		}
+		if json.Unmarshal(line, &e) == nil && e.CWD != "" {
+			return e.CWD
+		}
+		if err != nil {
+			return ""
+		}
+	}
+}
+
+// List returns the sessions run in cwd, most recently updated first.
+// Returns an empty list if there are none. Transcripts that cannot be read
+// are skipped; Load reports why.
+func List(cwd string) ([]Session, error) {
+	dir, err := ProjectDir(cwd)
+	if err != nil {
+		return nil, err
+	}
+	return ListDir(dir)
+}
+
+// ListAll returns the sessions of every project, most recently updated
+// first. Transcripts that cannot be read are skipped.
+func ListAll() ([]Session, error) {
+	root, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	projects, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+
+	var all []Session
+	for _, p := range projects {
+		if !p.IsDir() {
+			continue
+		}
+		sessions, err := listDir(filepath.Join(root, p.Name()))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, sessions...)
+	}
+	sortSessions(all)
+	return all, nil
+}
+
+// ListDir returns the sessions whose transcripts are in dir, most recently
+// updated first. Transcripts that cannot be read are skipped.
+func ListDir(dir string) ([]Session, error) {
+	sessions, err := listDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+// listDir summarizes the transcripts in dir, skipping any that fail to
+// load so that one corrupt file does not hide the rest.
+func listDir(dir string) ([]Session, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(paths))
+	for _, path := range paths {
+		t, err := load(path, false)
+		if err != nil {
+			continue
+		}
+		sessions = append(sessions, t.Session)
+	}
+	return sessions, nil
+}
+
+func sortSessions(sessions []Session) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+}
+
+// Find returns the transcript path for a session ID, searching every
+// project. Returns ErrNotFound if there is none.
+func Find(id string) (string, error) {
+	root, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return "", ErrNotFound
+	}
+	matches, err := filepath.Glob(filepath.Join(root, "*", id+".jsonl"))
+	if err != nil {
+		return "", fmt.Errorf("history: %w", err)
+	}
+	if len(matches) == 0 {
+		return "", ErrNotFound
+	}
+	return matches[0], nil
+}
+
+// Load reads a transcript file.
+func Load(path string) (*Transcript, error) {
+	return load(path, true)
+}
+
+// load reads path into a Transcript, keeping Entries only if full is set.
+func load(path string, full bool) (*Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+
+	t := &Transcript{Session: Session{
+		ID:   strings.TrimSuffix(filepath.Base(path), ".jsonl"),
+		Path: path,
+	}}
+	var customTitle, aiTitle, summary string
+
+	// Lines can be far longer than bufio.Scanner's default limit.
+	r := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("history: read %s: %w", path, err)
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			entry, meta, perr := parseEntry(line)
+			if perr != nil {
+				// A session still being written can end mid-line.
+				if err == io.EOF {
+					break
+				}
+				return nil, fmt.Errorf("history: %s:%d: %w", path, lineNum, perr)
+			}
+
+			t.observe(&entry)
+			switch {
+			case meta.CustomTitle != "":
+				customTitle = meta.CustomTitle
+			case meta.AITitle != "":
+				aiTitle = meta.AITitle
+			case entry.Type == "summary" && meta.Summary != "":
+				summary = meta.Summary
+			case entry.Type == "cost-state" && meta.TotalCostUSD != nil:
+				t.CostUSD = *meta.TotalCostUSD
+			}
+			if full {
+				t.Entries = append(t.Entries, entry)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	t.Summary = firstNonEmpty(customTitle, aiTitle, summary)
+	if t.Created.IsZero() {
+		t.Created = info.ModTime()
+	}
+	if t.Updated.IsZero() {
+		t.Updated = info.ModTime()
+	}
+	return t, nil
+}
+
+// observe folds an entry into the session summary.
+func (t *Transcript) observe(e *Entry) {
+	if !e.Timestamp.IsZero() {
+		if t.Created.IsZero() || e.Timestamp.Before(t.Created) {
+			t.Created = e.Timestamp
+		}
+		if e.Timestamp.After(t.Updated) {
+			t.Updated = e.Timestamp
+		}
+	}
+	if t.CWD == "" {
+		t.CWD = e.CWD
+	}
+	if t.GitBranch == "" {
+		t.GitBranch = e.GitBranch
+	}
+	if t.Version == "" {
+		t.Version = e.Version
+	}
+
+	if e.Message == nil || e.IsSidechain {
+		return
+	}
+	t.NumMessages++
+
+	switch e.Type {
+	case "user":
+		if t.FirstPrompt == "" && !e.IsMeta {
+			t.FirstPrompt = promptText(e.Message)
+		}
+	case "assistant":
+		// Synthetic messages (e.g. API errors) carry a placeholder model.
+		if t.Model == "" && !strings.HasPrefix(e.Message.Model, "<") {
+			t.Model = e.Message.Model
+		}
+	}
+}
+
+// promptText returns the typed text of a user message, or "" for tool
+// results.
+func promptText(m *claude.MessageContent) string {
+	var parts []string
+	for _, block := range m.Content {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// parseEntry decodes one transcript line.
+func parseEntry(line []byte) (Entry, entryMeta, error) {
+	var e Entry
+	var meta entryMeta
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, meta, err
+	}
+	if err := json.Unmarshal(line, &meta); err != nil {
+		return e, meta, err
+	}
+	e.Raw = append(json.RawMessage(nil), line...)
+
+	if (e.Type == "user" || e.Type == "assistant") && len(meta.Message) > 0 {
+		var msg claude.MessageContent
+		if err := json.Unmarshal(meta.Message, &msg); err != nil {
+			return e, meta, fmt.Errorf("%s message: %w", e.Type, err)
+		}
+		e.Message = &msg
+	}
+	return e, meta, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+)
+
+const transcriptA = `{"type":"queue-operation","operation":"enqueue","timestamp":"2026-03-01T10:00:00.000Z","sessionId":"aaa"}
+{"type":"user","uuid":"u1","parentUuid":null,"isMeta":true,"message":{"role":"user","content":"<command-name>/init</command-name>"},"timestamp":"2026-03-01T10:00:00.500Z","sessionId":"aaa","cwd":"/work/app","gitBranch":"main","version":"2.1.0"}
+{"type":"user","uuid":"u2","parentUuid":"u1","message":{"role":"user","content":"Fix the flaky test"},"timestamp":"2026-03-01T10:00:01.000Z","sessionId":"aaa","cwd":"/work/app","gitBranch":"main","version":"2.1.0"}
+{"type":"assistant","uuid":"a0","parentUuid":"u2","message":{"role":"assistant","model":"<synthetic>","content":[{"type":"text","text":"API Error"}]},"timestamp":"2026-03-01T10:00:01.500Z","sessionId":"aaa"}
+{"type":"assistant","uuid":"a1","parentUuid":"a0","message":{"id":"msg_1","role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"Looking."},{"type":"tool_use","id":"t1","name":"Read","input":{"file_path":"a_test.go"}}],"usage":{"input_tokens":10,"output_tokens":5}},"timestamp":"2026-03-01T10:00:02.000Z","sessionId":"aaa"}
+{"type":"user","uuid":"u3","parentUuid":"a1","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"package a"},{"type":"text","text":"func TestA(t *testing.T) {}"}]}]},"timestamp":"2026-03-01T10:00:03.000Z","sessionId":"aaa"}
+{"type":"assistant","uuid":"s1","parentUuid":"u3","isSidechain":true,"message":{"role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"subagent"}]},"timestamp":"2026-03-01T10:00:04.000Z","sessionId":"aaa"}
+{"type":"assistant","uuid":"a2","parentUuid":"u3","message":{"role":"assistant","model":"claude-sonnet-4-5","content":[{"type":"text","text":"Fixed."}]},"timestamp":"2026-03-01T10:00:05.000Z","sessionId":"aaa"}
+{"type":"summary","summary":"Old summary","leafUuid":"a2"}
+{"type":"ai-title","aiTitle":"Fix flaky test in package a","sessionId":"aaa"}
+{"type":"cost-state","sessionId":"aaa","totalCostUSD":0.12}
+{"type":"cost-state","sessionId":"aaa","totalCostUSD":0.34}
+`
+
+const transcriptB = `{"type":"user","uuid":"u1","message":{"role":"user","content":[{"type":"text","text":"Second session"}]},"timestamp":"2026-03-02T09:00:00.000Z","sessionId":"bbb","cwd":"/work/app"}
+{"type":"summary","summary":"Compacted work"}
+{"type":"assistant","uuid":"a1","message":{"role":"assistant","model":"claude-opus-4-1","content":[{"type":"text","text":"Hi"}]},"timestamp":"2026-03-02T09:00:01.000Z","sessionId":"bbb"}
+{"type":"user","uuid":"u2","message":{"role":"us`
+
+// setupHistory creates a config dir with transcripts for /work/app and
+// /work/other and points ConfigDirEnv at it.
+func setupHistory(t *testing.T) string {
+	t.Helper()
+	config := t.TempDir()
+	t.Setenv(ConfigDirEnv, config)
+
+	write := func(project, name, content string) {
+		dir := filepath.Join(config, "projects", project)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("-work-app", "aaa.jsonl", transcriptA)
+	write("-work-app", "bbb.jsonl", transcriptB)
+	write("-work-app", "notes.txt", "ignored")
+	write("-work-other", "ccc.jsonl", `{"type":"user","message":{"role":"user","content":"Other"},"timestamp":"2026-01-01T00:00:00Z","sessionId":"ccc"}`+"\n")
+	return config
+}
+
+func TestProjectKey(t *testing.T) {
+	tests := map[string]string{
+		"/root/module":        "-root-module",
+		"/home/me/my.app_v2":  "-home-me-my-app-v2",
+		`C:\Users\me\src`:     "C--Users-me-src",
+		"/tmp/with space/ünï": "-tmp-with-space--n-",
+		"/tmp/🚀":              "-tmp---",
+	}
+	for in, want := range tests {
+		if got := ProjectKey(in); got != want {
+			t.Errorf("ProjectKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	config := setupHistory(t)
+
+	sessions, err := List("/work/app")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "bbb" || sessions[1].ID != "aaa" {
+		t.Fatalf("sessions = %+v, want bbb then aaa", sessions)
+	}
+
+	a := sessions[1]
+	if a.Path != filepath.Join(config, "projects", "-work-app", "aaa.jsonl") {
+		t.Errorf("Path = %s", a.Path)
+	}
+	if a.FirstPrompt != "Fix the flaky test" {
+		t.Errorf("FirstPrompt = %q, want meta prompt skipped", a.FirstPrompt)
+	}
+	if a.Model != "claude-sonnet-4-5" {
+		t.Errorf("Model = %q, want synthetic model skipped", a.Model)
+	}
+	if a.CostUSD != 0.34 {
+		t.Errorf("CostUSD = %v, want last cost-state", a.CostUSD)
+	}
+	if a.Summary != "Fix flaky test in package a" || a.Title() != a.Summary {
+		t.Errorf("Summary = %q, want ai-title over summary", a.Summary)
+	}
+	if a.NumMessages != 6 {
+		t.Errorf("NumMessages = %d, want 6 (sidechain excluded)", a.NumMessages)
+	}
+	if a.CWD != "/work/app" || a.GitBranch != "main" || a.Version != "2.1.0" {
+		t.Errorf("environment = %q %q %q", a.CWD, a.GitBranch, a.Version)
+	}
+	wantCreated := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	wantUpdated := time.Date(2026, 3, 1, 10, 0, 5, 0, time.UTC)
+	if !a.Created.Equal(wantCreated) || !a.Updated.Equal(wantUpdated) {
+		t.Errorf("Created, Updated = %v, %v", a.Created, a.Updated)
+	}
+
+	// A transcript cut off mid-line is read up to the last full entry.
+	b := sessions[0]
+	if b.FirstPrompt != "Second session" || b.Model != "claude-opus-4-1" || b.Summary != "Compacted work" {
+		t.Errorf("truncated session = %+v", b)
+	}
+
+	none, err := List("/never/used")
+	if err != nil || len(none) != 0 {
+		t.Errorf("List(unused) = %v, %v", none, err)
+	}
+}
+
+func TestListSkipsUnreadable(t *testing.T) {
+	config := setupHistory(t)
+	bad := filepath.Join(config, "projects", "-work-app", "bad.jsonl")
+	os.WriteFile(bad, []byte("not json\n{\"type\":\"user\"}\n"), 0o600)
+
+	sessions, err := List("/work/app")
+	if err != nil || len(sessions) != 2 {
+		t.Errorf("List() = %d sessions, %v; want 2 with bad.jsonl skipped", len(sessions), err)
+	}
+	all, err := ListAll()
+	if err != nil || len(all) != 3 {
+		t.Errorf("ListAll() = %d sessions, %v; want 3 with bad.jsonl skipped", len(all), err)
+	}
+}
+
+func TestProjectDirLongPath(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv(ConfigDirEnv, config)
+
+	// Both paths truncate to the same key prefix.
+	base := "/" + strings.Repeat("a", maxProjectKey)
+	one, two := base+"/one", base+"/two"
+	prefix := ProjectKey(one)[:maxProjectKey]
+	for suffix, cwd := range map[string]string{"-1abc": two, "-2def": one} {
+		dir := filepath.Join(config, "projects", prefix+suffix)
+		os.MkdirAll(dir, 0o755)
+		line := `{"type":"user","message":{"role":"user","content":"hi"},"sessionId":"s","cwd":"` + cwd + `"}` + "\n"
+		os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(line), 0o600)
+	}
+
+	for cwd, suffix := range map[string]string{one: "-2def", two: "-1abc"} {
+		dir, err := ProjectDir(cwd)
+		if err != nil || filepath.Base(dir) != prefix+suffix {
+			t.Errorf("ProjectDir(%s) = %s, %v; want suffix %s", cwd[len(base):], filepath.Base(dir)[maxProjectKey:], err, suffix)
+		}
+	}
+
+	// A path without transcripts does not borrow another's directory.
+	if dir, _ := ProjectDir(base + "/three"); filepath.Base(dir) != ProjectKey(base+"/three") {
+		t.Errorf("ProjectDir(unused) = %s", dir)
+	}
+}
+
+func TestListAllAndFind(t *testing.T) {
+	setupHistory(t)
+
+	all, err := ListAll()
+	if err != nil {
+		t.Fatalf("ListAll() error: %v", err)
+	}
+	var ids []string
+	for _, s := range all {
+		ids = append(ids, s.ID)
+	}
+	if got := strings.Join(ids, ","); got != "bbb,aaa,ccc" {
+		t.Errorf("ListAll() ids = %s", got)
+	}
+
+	path, err := Find("ccc")
+	if err != nil || !strings.HasSuffix(path, filepath.Join("-work-other", "ccc.jsonl")) {
+		t.Errorf("Find(ccc) = %q, %v", path, err)
+	}
+	for _, id := range []string{"zzz", "", "../-work-app/aaa"} {
+		if _, err := Find(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Find(%q) error = %v, want ErrNotFound", id, err)
+		}
+	}
+
+	t.Setenv(ConfigDirEnv, t.TempDir())
+	if all, err := ListAll(); err != nil || len(all) != 0 {
+		t.Errorf("ListAll() without projects = %v, %v", all, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	setupHistory(t)
+	path, _ := Find("aaa")
+
+	tr, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(tr.Entries) != 12 || tr.ID != "aaa" || tr.CostUSD != 0.34 {
+		t.Errorf("transcript = %d entries, %+v", len(tr.Entries), tr.Session)
+	}
+	if string(tr.Entries[0].Raw) == "" || tr.Entries[0].Type != "queue-operation" {
+		t.Errorf("first entry = %+v", tr.Entries[0])
+	}
+
+	msgs := tr.Messages()
+	if len(msgs) != 6 {
+		t.Fatalf("Messages() = %d, want 6", len(msgs))
+	}
+	if msgs[1].Type != "user" || claude.ExtractText(&msgs[1]) != "Fix the flaky test" {
+		t.Errorf("string content = %+v", msgs[1].Message)
+	}
+	if name, input := claude.GetToolCall(&msgs[3]); name != "Read" || input["file_path"] != "a_test.go" {
+		t.Errorf("tool call = %s %v", name, input)
+	}
+	if msgs[3].SessionID != "aaa" || msgs[3].UUID != "a1" || msgs[3].Message.Usage.OutputTokens != 5 {
+		t.Errorf("assistant message = %+v", msgs[3])
+	}
+	result := msgs[4].Message.Content[0]
+	if result.Type != "tool_result" || result.ToolUseID != "t1" || result.ContentText() != "package a\nfunc TestA(t *testing.T) {}" {
+		t.Errorf("tool result = %+v", result)
+	}
+	if got := claude.ExtractText(&msgs[5]); got != "Fixed." {
+		t.Errorf("last message = %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Error("Load(missing) should fail")
+	}
+
+	bad := filepath.Join(dir, "bad.jsonl")
+	os.WriteFile(bad, []byte("{\"type\":\"user\"}\nnot json\n{\"type\":\"user\"}\n"), 0o600)
+	if _, err := Load(bad); err == nil || !strings.Contains(err.Error(), "bad.jsonl:2") {
+		t.Errorf("Load(bad) error = %v, want line 2", err)
+	}
+
+	// Sessions without timestamps fall back to the file time.
+	empty := filepath.Join(dir, "empty.jsonl")
+	os.WriteFile(empty, nil, 0o600)
+	tr, err := Load(empty)
+	if err != nil || tr.Created.IsZero() || tr.Updated.IsZero() {
+		t.Errorf("Load(empty) = %+v, %v", tr, err)
+	}
+}
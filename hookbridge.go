@@ -0,0 +1,474 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MateoSegura/claudesdk-go/internal/hookshim"
+)
+
+// HookEvent names a CLI lifecycle hook event.
+type HookEvent string
+
+const (
+	// HookPreToolUse runs before a tool call and can approve or block it.
+	HookPreToolUse HookEvent = "PreToolUse"
+
+	// HookPostToolUse runs after a tool call and can give Claude feedback.
+	HookPostToolUse HookEvent = "PostToolUse"
+
+	// HookUserPromptSubmit runs when a prompt is submitted and can block
+	// it or add context.
+	HookUserPromptSubmit HookEvent = "UserPromptSubmit"
+
+	// HookStop runs when Claude finishes responding; blocking makes it
+	// continue with the reason as its next instruction.
+	HookStop HookEvent = "Stop"
+
+	// HookSubagentStop is HookStop for subagents (Task tool).
+	HookSubagentStop HookEvent = "SubagentStop"
+)
+
+// DefaultHookShim is the shim command looked up in PATH when
+// LaunchOptions.HookShimPath is empty.
+const DefaultHookShim = "claude-hook-shim"
+
+// HookInput is the event data the CLI passes to a hook.
+type HookInput struct {
+	// Event is the hook event name.
+	Event HookEvent `json:"hook_event_name"`
+
+	// SessionID is the CLI session identifier.
+	SessionID string `json:"session_id"`
+
+	// TranscriptPath is the session transcript file.
+	TranscriptPath string `json:"transcript_path,omitempty"`
+
+	// CWD is the CLI's working directory.
+	CWD string `json:"cwd,omitempty"`
+
+	// PermissionMode is the active permission mode.
+	PermissionMode string `json:"permission_mode,omitempty"`
+
+	// ToolName is the tool being called (PreToolUse, PostToolUse).
+	ToolName string `json:"tool_name,omitempty"`
+
+	// ToolInput is the tool arguments (PreToolUse, PostToolUse).
+	ToolInput map[string]any `json:"tool_input,omitempty"`
+
+	// ToolResponse is the tool's result (PostToolUse).
+	ToolResponse any `json:"tool_response,omitempty"`
+
+	// ToolUseID identifies the tool call (PreToolUse, PostToolUse).
+	ToolUseID string `json:"tool_use_id,omitempty"`
+
+	// Prompt is the submitted prompt (UserPromptSubmit).
+	Prompt string `json:"prompt,omitempty"`
+
+	// StopHookActive is true when Claude is already continuing because of
+	// a Stop hook; check it to avoid blocking forever (Stop, SubagentStop).
+	StopHookActive bool `json:"stop_hook_active,omitempty"`
+
+	// Raw is the complete input, including fields not mapped above.
+	Raw json.RawMessage `json:"-"`
+}
+
+// HookResult is a hook's response. The zero value expresses no opinion and
+// lets the CLI proceed normally.
+//
+// Build one with HookApprove, HookBlock, or HookContext.
+type HookResult struct {
+	// Decision is "approve", "block", or empty.
+	Decision string
+
+	// Reason explains the decision. For blocks it is shown to Claude.
+	Reason string
+
+	// AdditionalContext is added to Claude's context (PreToolUse,
+	// PostToolUse, UserPromptSubmit). Ignored for Stop events.
+	AdditionalContext string
+}
+
+// HookApprove allows the action. For PreToolUse it skips the permission
+// prompt; other events proceed as they would anyway.
+func HookApprove(reason string) HookResult {
+	return HookResult{Decision: "approve", Reason: reason}
+}
+
+// HookBlock blocks the action: the tool call is denied (PreToolUse),
+// Claude is told about the problem (PostToolUse), the prompt is rejected
+// (UserPromptSubmit), or Claude keeps working (Stop, SubagentStop). The
+// reason is shown to Claude.
+func HookBlock(reason string) HookResult {
+	return HookResult{Decision: "block", Reason: reason}
+}
+
+// HookContext adds text to Claude's context without changing the outcome.
+func HookContext(text string) HookResult {
+	return HookResult{AdditionalContext: text}
+}
+
+// HookFunc handles a hook event. Returning an error reports a non-blocking
+// hook failure to the CLI (and to Hooks.OnError); use HookBlock to block.
+type HookFunc func(ctx context.Context, in HookInput) (HookResult, error)
+
+// HookMatcher registers a HookFunc for a subset of events.
+type HookMatcher struct {
+	// Matcher selects tools by name for PreToolUse and PostToolUse, e.g.
+	// "Bash", "Edit|Write", or "mcp__.*". Empty matches all tools.
+	// Ignored for other events.
+	Matcher string
+
+	// Func handles the event.
+	Func HookFunc
+
+	// Timeout bounds Func. Zero uses the CLI's default (60s).
+	Timeout time.Duration
+}
+
+// output renders r as the hook JSON the CLI expects for event.
+func (r HookResult) output(event HookEvent) map[string]any {
+	out := map[string]any{}
+	specific := map[string]any{}
+
+	switch {
+	case event == HookPreToolUse && r.Decision == "approve":
+		specific["permissionDecision"] = "allow"
+		if r.Reason != "" {
+			specific["permissionDecisionReason"] = r.Reason
+		}
+	case event == HookPreToolUse && r.Decision == "block":
+		specific["permissionDecision"] = "deny"
+		specific["permissionDecisionReason"] = r.Reason
+	case r.Decision == "block":
+		out["decision"] = "block"
+		out["reason"] = r.Reason
+	}
+
+	if r.AdditionalContext != "" && event != HookStop && event != HookSubagentStop {
+		specific["additionalContext"] = r.AdditionalContext
+	}
+	if len(specific) > 0 {
+		specific["hookEventName"] = string(event)
+		out["hookSpecificOutput"] = specific
+	}
+	return out
+}
+
+// hookBridge serves HookHandlers to the shim over a unix socket.
+type hookBridge struct {
+	dir      string
+	socket   string
+	settings string // path of the generated settings file
+
+	handlers map[string]registeredHook
+	onError  func(error)
+
+	ln     net.Listener
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{} // open shim connections
+	closed bool
+}
+
+type registeredHook struct {
+	event   HookEvent
+	matcher HookMatcher
+}
+
+// startHookBridge serves opts.HookHandlers and returns opts with Settings
+// pointing at generated hook settings (merged with any existing Settings).
+// The bridge must be closed once the CLI has exited.
+// Returns a nil bridge if opts has no hook handlers.
+func startHookBridge(opts LaunchOptions) (LaunchOptions, *hookBridge, error) {
+	if len(opts.HookHandlers) == 0 {
+		return opts, nil, nil
+	}
+
+	shim := opts.HookShimPath
+	if shim == "" {
+		path, err := exec.LookPath(DefaultHookShim)
+		if err != nil {
+			return opts, nil, ErrHookShimNotFound
+		}
+		shim = path
+	}
+
+	settings, err := loadSettings(opts.Settings)
+	if err != nil {
+		return opts, nil, err
+	}
+
+	dir, err := hookDir()
+	if err != nil {
+		return opts, nil, fmt.Errorf("create hook dir: %w", err)
+	}
+	b := &hookBridge{
+		dir:      dir,
+		socket:   filepath.Join(dir, "hooks.sock"),
+		settings: filepath.Join(dir, "settings.json"),
+		handlers: map[string]registeredHook{},
+	}
+	if opts.Hooks != nil {
+		b.onError = opts.Hooks.OnError
+	}
+
+	// Register handlers in a stable order.
+	events := make([]string, 0, len(opts.HookHandlers))
+	for event := range opts.HookHandlers {
+		events = append(events, string(event))
+	}
+	sort.Strings(events)
+
+	hooks, _ := settings["hooks"].(map[string]any)
+	if hooks == nil {
+		hooks = map[string]any{}
+	}
+	for _, name := range events {
+		event := HookEvent(name)
+		entries, _ := hooks[name].([]any)
+		for i, m := range opts.HookHandlers[event] {
+			if m.Func == nil {
+				os.RemoveAll(dir)
+				return opts, nil, fmt.Errorf("hook %s[%d] has no Func", event, i)
+			}
+			id := name + "/" + strconv.Itoa(i)
+			b.handlers[id] = registeredHook{event: event, matcher: m}
+
+			command := map[string]any{
+				"type":    "command",
+				"command": shellQuote(shim) + " " + shellQuote(b.socket) + " " + shellQuote(id),
+			}
+			if m.Timeout > 0 {
+				command["timeout"] = int(math.Ceil(m.Timeout.Seconds()))
+			}
+			entry := map[string]any{"hooks": []any{command}}
+			if m.Matcher != "" {
+				entry["matcher"] = m.Matcher
+			}
+			entries = append(entries, entry)
+		}
+		hooks[name] = entries
+	}
+	settings["hooks"] = hooks
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		os.RemoveAll(dir)
+		return opts, nil, fmt.Errorf("marshal hook settings: %w", err)
+	}
+	if err := os.WriteFile(b.settings, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		return opts, nil, fmt.Errorf("write hook settings: %w", err)
+	}
+
+	ln, err := net.Listen("unix", b.socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return opts, nil, fmt.Errorf("listen for hooks: %w", err)
+	}
+	b.ln = ln
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+
+	b.wg.Add(1)
+	go b.serve()
+
+	opts.Settings = b.settings
+	return opts, b, nil
+}
+
+// maxSocketPath is the longest unix socket path every platform accepts:
+// sun_path is 104 bytes on macOS and the BSDs, including the NUL.
+const maxSocketPath = 103
+
+// hookDir creates the bridge's directory. On macOS the default temp dir
+// (/var/folders/...) can leave too little room in sun_path for the socket,
+// so fall back to /tmp when the path would not fit.
+func hookDir() (string, error) {
+	dir, err := os.MkdirTemp("", "claude-hooks-")
+	if err != nil || len(filepath.Join(dir, "hooks.sock")) <= maxSocketPath || runtime.GOOS == "windows" {
+		return dir, err
+	}
+	os.Remove(dir)
+	return os.MkdirTemp("/tmp", "claude-hooks-")
+}
+
+// loadSettings parses a Settings value (inline JSON or a file path).
+func loadSettings(settings string) (map[string]any, error) {
+	out := map[string]any{}
+	if settings == "" {
+		return out, nil
+	}
+
+	data := []byte(settings)
+	if !strings.HasPrefix(strings.TrimSpace(settings), "{") {
+		var err error
+		if data, err = os.ReadFile(settings); err != nil {
+			return nil, fmt.Errorf("read settings: %w", err)
+		}
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("parse settings: %w", err)
+	}
+	if out == nil {
+		out = map[string]any{}
+	}
+	return out, nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (b *hookBridge) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		if !b.track(conn) {
+			conn.Close()
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			defer b.untrack(conn)
+			b.handle(conn)
+		}()
+	}
+}
+
+// track records an open connection so Close can unblock it. It returns
+// false once the bridge is closed.
+func (b *hookBridge) track(conn net.Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	if b.conns == nil {
+		b.conns = make(map[net.Conn]struct{})
+	}
+	b.conns[conn] = struct{}{}
+	return true
+}
+
+func (b *hookBridge) untrack(conn net.Conn) {
+	conn.Close()
+	b.mu.Lock()
+	delete(b.conns, conn)
+	b.mu.Unlock()
+}
+
+func (b *hookBridge) handle(conn net.Conn) {
+	var req hookshim.Request
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		return
+	}
+	resp := b.dispatch(req)
+	data, _ := json.Marshal(resp)
+	conn.Write(append(data, '\n'))
+}
+
+// dispatch runs the handler for req and renders the shim response.
+func (b *hookBridge) dispatch(req hookshim.Request) hookshim.Response {
+	h, ok := b.handlers[req.Handler]
+	if !ok {
+		return hookshim.Response{Stderr: fmt.Sprintf("unknown hook handler %q", req.Handler), ExitCode: hookshim.ExitError}
+	}
+
+	var in HookInput
+	if err := json.Unmarshal(req.Input, &in); err != nil {
+		return b.fail(h, fmt.Errorf("invalid hook input: %w", err))
+	}
+	in.Raw = req.Input
+	if in.Event == "" {
+		in.Event = h.event
+	}
+
+	ctx := b.ctx
+	if h.matcher.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.matcher.Timeout)
+		defer cancel()
+	}
+
+	result, err := h.matcher.Func(ctx, in)
+	if err != nil {
+		return b.fail(h, err)
+	}
+
+	out := result.output(in.Event)
+	if len(out) == 0 {
+		return hookshim.Response{}
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return b.fail(h, err)
+	}
+	return hookshim.Response{Stdout: string(data)}
+}
+
+func (b *hookBridge) fail(h registeredHook, err error) hookshim.Response {
+	err = fmt.Errorf("claude: %s hook: %w", h.event, err)
+	if b.onError != nil {
+		b.onError(err)
+	}
+	return hookshim.Response{Stderr: err.Error(), ExitCode: hookshim.ExitError}
+}
+
+// hookCloseTimeout bounds how long Close waits for handlers after
+// cancelling them.
+var hookCloseTimeout = 5 * time.Second
+
+// Close stops the listener, cancels running handlers, closes their
+// connections, and removes the socket and settings file. It waits up to
+// hookCloseTimeout for handlers to return; a handler that ignores its
+// context is abandoned and reported through onError, so a stuck HookFunc
+// or a silent peer cannot hang Launcher.Wait.
+func (b *hookBridge) Close() error {
+	b.ln.Close()
+	b.cancel()
+
+	b.mu.Lock()
+	b.closed = true
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(hookCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		if b.onError != nil {
+			b.onError(fmt.Errorf("claude: hook handler still running %v after close", hookCloseTimeout))
+		}
+	}
+	return os.RemoveAll(b.dir)
+}
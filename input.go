@@ -0,0 +1,184 @@
+package claude
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxPromptArgBytes is the longest prompt passed to the CLI as a
+// command-line argument when LaunchOptions.MaxPromptArgBytes is zero.
+// Longer prompts are written to stdin.
+const DefaultMaxPromptArgBytes = 16 * 1024
+
+// promptOnStdin reports whether a text-input prompt is written to stdin
+// rather than passed as the last argument.
+func promptOnStdin(prompt string, opts LaunchOptions) bool {
+	limit := opts.MaxPromptArgBytes
+	switch {
+	case limit == 0:
+		limit = DefaultMaxPromptArgBytes
+	case limit < 0:
+		return prompt != ""
+	}
+	return len(prompt) > limit
+}
+
+// writeInput writes data to the CLI's stdin and closes it. It runs in its
+// own goroutine: a large prompt fills the pipe before the CLI reads it.
+// A failed write is kept for Wait to report.
+func (l *Launcher) writeInput(stdin io.WriteCloser, data []byte) {
+	defer close(l.inputDone)
+	_, err := stdin.Write(data)
+	if cerr := stdin.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		l.mu.Lock()
+		l.inputErr = fmt.Errorf("write prompt: %w", err)
+		l.mu.Unlock()
+	}
+}
+
+// Attachment is a file sent to Claude with a prompt: an image, a PDF, or a
+// text file. Images and PDFs are sent base64-encoded.
+//
+// Set LaunchOptions.Attachments to attach files to a Session or Launcher
+// prompt, or pass them to Conversation.Send.
+type Attachment struct {
+	// Name is the file name. It titles document attachments and, when
+	// MediaType is empty, its extension selects the media type.
+	Name string
+
+	// MediaType is the MIME type, such as "image/png", "application/pdf",
+	// or "text/plain". Detected from Name and Data if empty.
+	MediaType string
+
+	// Data is the file content.
+	Data []byte
+}
+
+// AttachFile reads the file at path into an Attachment.
+//
+// Returns an error wrapping ErrUnsupportedAttachment if the file is not a
+// supported image, a PDF, or UTF-8 text.
+func AttachFile(path string) (Attachment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("claude: attach: %w", err)
+	}
+	a := Attachment{Name: filepath.Base(path), Data: data}
+	if _, err := a.ContentBlock(); err != nil {
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+// imageTypes are the image media types the API accepts.
+var imageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ContentBlock converts the attachment to an "image" or "document" block
+// for a user message (see NewUserMessage).
+//
+// Returns an error wrapping ErrUnsupportedAttachment for media types other
+// than JPEG, PNG, GIF, and WebP images, PDFs, and UTF-8 text.
+//
+// Text is a text/* type, JSON, or XML. Without an explicit MediaType, a
+// file whose extension names another non-image type (".ts" is often
+// video/mp2t) is still sent as text if its content sniffs as text.
+func (a Attachment) ContentBlock() (ContentBlock, error) {
+	mediaType := a.mediaType()
+	switch {
+	case imageTypes[mediaType]:
+		return ContentBlock{Type: "image", Source: a.base64Source(mediaType)}, nil
+
+	case mediaType == "application/pdf":
+		return ContentBlock{Type: "document", Source: a.base64Source(mediaType), Title: a.Name}, nil
+
+	case textType(mediaType) || a.MediaType == "" && !strings.HasPrefix(mediaType, "image/") && textType(sniffType(a.Data)):
+		if !utf8.Valid(a.Data) {
+			// Text documents are sent as JSON strings, which must be UTF-8.
+			return ContentBlock{}, fmt.Errorf("%w: %q is %s but not valid UTF-8", ErrUnsupportedAttachment, a.Name, mediaType)
+		}
+		return ContentBlock{
+			Type:   "document",
+			Source: &BlockSource{Type: "text", MediaType: "text/plain", Data: string(a.Data)},
+			Title:  a.Name,
+		}, nil
+	}
+	return ContentBlock{}, fmt.Errorf("%w: %q is %s", ErrUnsupportedAttachment, a.Name, mediaType)
+}
+
+// mediaType returns MediaType, or the type implied by Name or Data,
+// without parameters. Empty data of unknown type is
+// application/octet-stream.
+func (a Attachment) mediaType() string {
+	mediaType := a.MediaType
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(filepath.Ext(a.Name))
+	}
+	if mediaType == "" {
+		mediaType = sniffType(a.Data)
+	}
+	if mediaType == "" {
+		return "application/octet-stream"
+	}
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		return mt
+	}
+	return mediaType
+}
+
+// sniffType returns the media type detected from data, without
+// parameters, or "" if data is empty.
+func sniffType(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	mt, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mt
+}
+
+// textType reports whether mediaType is sent as a text document.
+func textType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasPrefix(mediaType, "image/"):
+		return false // SVG is XML, but not an image the API accepts
+	}
+	return mediaType == "application/json" || mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+func (a Attachment) base64Source(mediaType string) *BlockSource {
+	return &BlockSource{
+		Type:      "base64",
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(a.Data),
+	}
+}
+
+// attachmentBlocks converts attachments to content blocks.
+func attachmentBlocks(attachments []Attachment) ([]ContentBlock, error) {
+	blocks := make([]ContentBlock, 0, len(attachments))
+	for _, a := range attachments {
+		b, err := a.ContentBlock()
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
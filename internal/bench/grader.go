@@ -2,7 +2,6 @@ package bench
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -31,38 +30,18 @@ func NewGrader(model string) *Grader {
 func (g *Grader) Grade(ctx context.Context, entry corpus.Entry, withSkill, withoutSkill *EntryResult) (*GradeResult, error) {
 	prompt := g.buildGradingPrompt(entry, withSkill, withoutSkill)
 
-	session, err := claude.NewSession(claude.SessionConfig{
+	grade, _, err := claude.RunTyped[GradeResult](ctx, claude.SessionConfig{
 		LaunchOptions: claude.LaunchOptions{
 			Model:           g.model,
 			MaxTurns:        3,
 			SkipPermissions: true,
 			JSONSchema:      gradeJSONSchema(),
 		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("creating grader session: %w", err)
-	}
-
-	result, err := session.RunAndCollect(ctx, prompt)
+	}, prompt)
 	if err != nil {
 		return nil, fmt.Errorf("running grader: %w", err)
 	}
 
-	if result.StructuredOutput == nil {
-		return nil, fmt.Errorf("grader returned no structured output")
-	}
-
-	// Marshal then unmarshal to convert from any to GradeResult
-	data, err := json.Marshal(result.StructuredOutput)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling structured output: %w", err)
-	}
-
-	var grade GradeResult
-	if err := json.Unmarshal(data, &grade); err != nil {
-		return nil, fmt.Errorf("unmarshaling grade result: %w", err)
-	}
-
 	return &grade, nil
 }
 
@@ -181,7 +160,7 @@ func summarizeForGrading(result *EntryResult) string {
 				sb.WriteString(fmt.Sprintf("[Tool: %s] %s\n", block.Name, args))
 
 			case block.IsToolResult():
-				content := block.Content
+				content := block.ContentText()
 				if len(content) > maxToolResult {
 					content = content[:maxToolResult] + "...(truncated)"
 				}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/MateoSegura/claudesdk-go/schema"
 )
 
 // RichMetrics holds full metric data extracted from Claude's stream-json output.
@@ -28,11 +30,11 @@ type RichMetrics struct {
 
 // VariantGrade holds per-variant grading scores.
 type VariantGrade struct {
-	Correctness int `json:"correctness"`
-	CodeQuality int `json:"code_quality"`
-	Diagnosis   int `json:"diagnosis"`
-	Minimality  int `json:"minimality"`
-	Efficiency  int `json:"efficiency"`
+	Correctness int `json:"correctness" jsonschema:"minimum=1,maximum=10"`
+	CodeQuality int `json:"code_quality" jsonschema:"minimum=1,maximum=10"`
+	Diagnosis   int `json:"diagnosis" jsonschema:"minimum=1,maximum=10"`
+	Minimality  int `json:"minimality" jsonschema:"minimum=1,maximum=10"`
+	Efficiency  int `json:"efficiency" jsonschema:"minimum=1,maximum=10"`
 }
 
 // Total returns the sum of all dimension scores.
@@ -44,7 +46,7 @@ func (vg *VariantGrade) Total() int {
 type GradeResult struct {
 	WithSkillGrade    VariantGrade `json:"with_skill_grade"`
 	WithoutSkillGrade VariantGrade `json:"without_skill_grade"`
-	Verdict           string       `json:"verdict"`
+	Verdict           string       `json:"verdict" jsonschema:"enum=skill_better|no_skill_better|tie|inconclusive"`
 	Reasoning         string       `json:"reasoning"`
 }
 
@@ -141,33 +143,8 @@ func (w *OutputWriter) WritePrompt(entryID string, variant Variant, prompt strin
 	return os.WriteFile(filepath.Join(dir, "prompt.txt"), []byte(prompt), 0644)
 }
 
-// gradeJSONSchema returns the JSON schema for structured grading output.
+// gradeJSONSchema returns the JSON schema for structured grading output,
+// derived from GradeResult's struct tags.
 func gradeJSONSchema() map[string]any {
-	variantGradeSchema := map[string]any{
-		"type": "object",
-		"properties": map[string]any{
-			"correctness":  map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
-			"code_quality": map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
-			"diagnosis":    map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
-			"minimality":   map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
-			"efficiency":   map[string]any{"type": "integer", "minimum": 1, "maximum": 10},
-		},
-		"required":             []string{"correctness", "code_quality", "diagnosis", "minimality", "efficiency"},
-		"additionalProperties": false,
-	}
-
-	return map[string]any{
-		"type": "object",
-		"properties": map[string]any{
-			"with_skill_grade":    variantGradeSchema,
-			"without_skill_grade": variantGradeSchema,
-			"verdict": map[string]any{
-				"type": "string",
-				"enum": []string{"skill_better", "no_skill_better", "tie", "inconclusive"},
-			},
-			"reasoning": map[string]any{"type": "string"},
-		},
-		"required":             []string{"with_skill_grade", "without_skill_grade", "verdict", "reasoning"},
-		"additionalProperties": false,
-	}
+	return schema.MustFor[GradeResult]()
 }
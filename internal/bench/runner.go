@@ -348,10 +348,12 @@ func (r *Runner) runEntry(ctx context.Context, entry corpus.Entry, variant Varia
 	}
 
 	// 7. Run Claude (--print mode skips workspace trust, no permission bypass needed)
-	claudeCmd := r.buildClaudeCommand(prompt)
+	claudeCmd := r.buildClaudeCommand()
 	r.log.Printf("  running claude (max %d turns)...", r.config.MaxTurns)
 
-	claudeOutput, _, err := r.docker.ExecCommand(containerID, []string{"bash", "-c", claudeCmd})
+	// The prompt goes over stdin: it can exceed ARG_MAX and must not pass
+	// through shell quoting.
+	claudeOutput, _, err := r.docker.ExecCommandInput(containerID, []string{"bash", "-c", claudeCmd}, strings.NewReader(prompt))
 	if err != nil {
 		er.Error = fmt.Sprintf("claude exec: %v", err)
 		er.WallClock = time.Since(start)
@@ -410,7 +412,9 @@ func (r *Runner) writeVariantArtifacts(er *EntryResult) {
 	}
 }
 
-func (r *Runner) buildClaudeCommand(prompt string) string {
+// buildClaudeCommand returns the shell command that runs claude in the
+// container, reading the prompt from stdin.
+func (r *Runner) buildClaudeCommand() string {
 	var parts []string
 
 	// Add host Node.js to PATH so claude CLI is available
@@ -431,11 +435,8 @@ func (r *Runner) buildClaudeCommand(prompt string) string {
 		claudeArgs = append(claudeArgs, "--model", r.config.Model)
 	}
 
-	// Pipe prompt via stdin (--allowed-tools consumes positional args)
-	escaped := strings.ReplaceAll(prompt, "'", "'\\''")
-	cmd := fmt.Sprintf("echo '%s' | %s", escaped, strings.Join(claudeArgs, " "))
-
-	parts = append(parts, cmd)
+	// The prompt is read from stdin (--allowed-tools consumes positional args)
+	parts = append(parts, strings.Join(claudeArgs, " "))
 	return strings.Join(parts, " && ")
 }
 
@@ -4,6 +4,7 @@ package docker
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 )
@@ -113,8 +114,20 @@ func (m *Manager) StartContainer(id string) error {
 // ExecCommand runs a command inside a running container and returns
 // the combined stdout/stderr output and exit code.
 func (m *Manager) ExecCommand(containerID string, command []string) (string, int, error) {
-	args := append([]string{"exec", containerID}, command...)
+	return m.ExecCommandInput(containerID, command, nil)
+}
+
+// ExecCommandInput is like ExecCommand, but feeds stdin to the command.
+// A nil stdin leaves the command's standard input closed.
+func (m *Manager) ExecCommandInput(containerID string, command []string, stdin io.Reader) (string, int, error) {
+	args := []string{"exec"}
+	if stdin != nil {
+		args = append(args, "-i")
+	}
+	args = append(args, containerID)
+	args = append(args, command...)
 	cmd := exec.Command(m.DockerBin, args...)
+	cmd.Stdin = stdin
 
 	var output bytes.Buffer
 	cmd.Stdout = &output
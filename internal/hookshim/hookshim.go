@@ -0,0 +1,86 @@
+// Package hookshim carries CLI hook invocations from the claude-hook-shim
+// command to the SDK process over a unix socket.
+//
+// The CLI runs the shim as a settings-based command hook:
+//
+//	claude-hook-shim <socket> <handler>
+//
+// The shim sends one Request line with the hook input it read from stdin,
+// then relays the Response: Stdout and Stderr are written verbatim and
+// ExitCode becomes the shim's exit code, so the CLI sees exactly what a
+// hand-written hook script would produce.
+package hookshim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ExitError is the exit code for a non-blocking hook failure. The CLI
+// shows stderr to the user and carries on.
+const ExitError = 1
+
+// Request is one hook invocation sent by the shim.
+type Request struct {
+	// Handler identifies the registered Go callback.
+	Handler string `json:"handler"`
+
+	// Input is the hook input JSON the CLI wrote to the shim's stdin.
+	Input json.RawMessage `json:"input"`
+}
+
+// Response is the hook output the shim relays to the CLI.
+type Response struct {
+	Stdout   string `json:"stdout,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// dialTimeout bounds how long the shim waits for the SDK to accept.
+const dialTimeout = 5 * time.Second
+
+// Run is the shim's main function. It returns the process exit code.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprintln(stderr, "usage: claude-hook-shim <socket> <handler>")
+		return ExitError
+	}
+	socket, handler := args[0], args[1]
+
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "claude-hook-shim: read input: %v\n", err)
+		return ExitError
+	}
+	if !json.Valid(input) {
+		fmt.Fprintln(stderr, "claude-hook-shim: hook input is not valid JSON")
+		return ExitError
+	}
+
+	conn, err := net.DialTimeout("unix", socket, dialTimeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "claude-hook-shim: connect: %v\n", err)
+		return ExitError
+	}
+	defer conn.Close()
+
+	req, _ := json.Marshal(Request{Handler: handler, Input: input})
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		fmt.Fprintf(stderr, "claude-hook-shim: send: %v\n", err)
+		return ExitError
+	}
+
+	var resp Response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		fmt.Fprintf(stderr, "claude-hook-shim: receive: %v\n", err)
+		return ExitError
+	}
+
+	io.WriteString(stdout, resp.Stdout)
+	io.WriteString(stderr, resp.Stderr)
+	return resp.ExitCode
+}
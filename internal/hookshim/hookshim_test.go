@@ -0,0 +1,72 @@
+package hookshim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRelaysResponse(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "s.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := make(chan Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req Request
+		json.NewDecoder(bufio.NewReader(conn)).Decode(&req)
+		got <- req
+		data, _ := json.Marshal(Response{Stdout: `{"decision":"block"}`, Stderr: "warn", ExitCode: 2})
+		conn.Write(append(data, '\n'))
+	}()
+
+	var stdout, stderr bytes.Buffer
+	code := Run([]string{socket, "Stop/0"}, strings.NewReader(`{"hook_event_name":"Stop"}`), &stdout, &stderr)
+
+	if code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	if stdout.String() != `{"decision":"block"}` || stderr.String() != "warn" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+	req := <-got
+	if req.Handler != "Stop/0" || string(req.Input) != `{"hook_event_name":"Stop"}` {
+		t.Errorf("request = %+v", req)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	tests := []struct {
+		name  string
+		args  []string
+		input string
+	}{
+		{"usage", []string{"only-one"}, `{}`},
+		{"invalid input", []string{missing, "x"}, `not json`},
+		{"no server", []string{missing, "x"}, `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := Run(tt.args, strings.NewReader(tt.input), &stdout, &stderr); code != ExitError {
+				t.Errorf("exit code = %d, want %d", code, ExitError)
+			}
+			if stderr.Len() == 0 || stdout.Len() != 0 {
+				t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+			}
+		})
+	}
+}
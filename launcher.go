@@ -4,10 +4,10 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -44,17 +44,28 @@ import (
 //		fmt.Println(claude.ExtractText(msg))
 //	}
 type Launcher struct {
-	cmd       *exec.Cmd
-	stdout    *bufio.Scanner
-	stderr    io.ReadCloser
+	proc      Process
+	stdin     io.WriteCloser // nil unless InputFormat is stream-json
+	stdout    *Decoder
+	stderr    io.Reader
 	stderrBuf []byte
+	stderrEOF chan struct{} // closed once stderr is fully read
 	startTime time.Time
 	hooks     *Hooks
-	tempFiles []string // temp files cleaned up on Wait
+	log       *launchLogger
+	model     string         // from the init message, for OnMetrics
+	tempFiles []string       // temp files cleaned up on Wait
+	cleanups  []func() error // SDK servers stopped on Wait
 
-	mu      sync.Mutex
-	started bool
-	done    chan struct{}
+	inputDone chan struct{} // closed once a prompt sent on stdin is written
+
+	mu       sync.Mutex
+	started  bool
+	done     chan struct{}
+	inputErr error // failed write of a prompt sent on stdin
+
+	waitOnce sync.Once
+	waitErr  error
 }
 
 // NewLauncher creates a new Launcher.
@@ -62,13 +73,15 @@ type Launcher struct {
 // The launcher is not started until Start is called.
 func NewLauncher() *Launcher {
 	return &Launcher{
-		done: make(chan struct{}),
+		done:      make(chan struct{}),
+		stderrEOF: make(chan struct{}),
 	}
 }
 
 // buildArgs constructs CLI arguments from LaunchOptions.
 // mcpConfigFile is the path to a temporary MCP config file (empty if none).
-// The prompt is appended at the end.
+// The prompt is appended at the end, except in stream-json input mode or
+// when it is longer than MaxPromptArgBytes: then it is delivered over stdin.
 func buildArgs(prompt string, opts LaunchOptions, mcpConfigFile string) ([]string, error) {
 	// Required flags for SDK mode
 	args := []string{
@@ -245,8 +258,12 @@ func buildArgs(prompt string, opts LaunchOptions, mcpConfigFile string) ([]strin
 
 	args = append(args, opts.AdditionalArgs...)
 
-	// Prompt must be last
-	args = append(args, prompt)
+	// Prompt must be last. "--" stops variadic flags such as --mcp-config
+	// and --allowedTools from consuming it, and keeps a prompt starting
+	// with "-" from being parsed as a flag.
+	if opts.InputFormat != InputFormatStreamJSON && !promptOnStdin(prompt, opts) {
+		args = append(args, "--", prompt)
+	}
 
 	return args, nil
 }
@@ -258,24 +275,66 @@ func buildArgs(prompt string, opts LaunchOptions, mcpConfigFile string) ([]strin
 //
 // The context controls the lifetime of the process. If the context is
 // cancelled, the process is killed.
-func (l *Launcher) Start(ctx context.Context, prompt string, opts LaunchOptions) error {
+//
+// When opts.InputFormat is InputFormatStreamJSON, stdin is kept open and
+// the prompt (if non-empty) is sent as the first user message. Use
+// SendMessage to push follow-up messages and CloseInput to signal that
+// no more input will arrive.
+//
+// Otherwise the prompt is passed as an argument, or written to stdin if
+// it is longer than opts.MaxPromptArgBytes or opts.Attachments is set.
+func (l *Launcher) Start(ctx context.Context, prompt string, opts LaunchOptions) (err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.started {
+	if l.started || l.exited() {
 		return ErrAlreadyStarted
 	}
 
-	// Verify CLI exists
-	binaryPath, err := exec.LookPath(DefaultBinary)
+	log := newLaunchLogger(opts.Logger)
+	binary := DefaultBinary
+	if opts.BinaryPath != "" {
+		binary = opts.BinaryPath
+	}
+	defer func() {
+		if err != nil {
+			log.startFailed(binary, err)
+		}
+	}()
+
+	// Release temp files and SDK servers if the process never starts, and
+	// close Done so waiters are not left hanging.
+	defer func() {
+		if !l.started {
+			l.cleanup()
+			close(l.done)
+		}
+	}()
+
+	// Serve Go MCP servers and in-process callbacks (CanUseTool) on loopback.
+	opts, closeLocal, err := startLocalServers(opts)
+	if err != nil {
+		return &StartError{Err: err}
+	}
+	if closeLocal != nil {
+		l.cleanups = append(l.cleanups, closeLocal)
+	}
+
+	// Bridge CLI lifecycle hooks to Go callbacks via the hook shim.
+	opts, bridge, err := startHookBridge(opts)
 	if err != nil {
-		return ErrCLINotFound
+		return &StartError{Err: err}
+	}
+	if bridge != nil {
+		l.cleanups = append(l.cleanups, bridge.Close)
 	}
 
 	// Handle MCP server configuration (requires temp file)
 	var mcpConfigFile string
 	if len(opts.MCPServers) > 0 {
-		mcpJSON, err := json.Marshal(opts.MCPServers)
+		// --mcp-config takes the .mcp.json layout; the CLI rejects a bare
+		// map of servers.
+		mcpJSON, err := json.Marshal(map[string]any{"mcpServers": opts.MCPServers})
 		if err != nil {
 			return &StartError{Err: fmt.Errorf("marshal mcp config: %w", err)}
 		}
@@ -289,6 +348,25 @@ func (l *Launcher) Start(ctx context.Context, prompt string, opts LaunchOptions)
 		l.tempFiles = append(l.tempFiles, mcpFile)
 	}
 
+	// Attachments need stream-json input. Unless the caller drives stdin,
+	// send the prompt as a single message and close it, as text input does.
+	blocks, err := attachmentBlocks(opts.Attachments)
+	if err != nil {
+		return &StartError{Err: err}
+	}
+	var input []byte // written to stdin in the background, then closed
+	switch {
+	case len(blocks) > 0 && opts.InputFormat != InputFormatStreamJSON:
+		opts.InputFormat = InputFormatStreamJSON
+		data, err := json.Marshal(NewUserMessage(prompt, blocks...))
+		if err != nil {
+			return &StartError{Err: fmt.Errorf("marshal prompt: %w", err)}
+		}
+		input = append(data, '\n')
+	case opts.InputFormat != InputFormatStreamJSON && promptOnStdin(prompt, opts):
+		input = []byte(prompt)
+	}
+
 	// Build arguments
 	args, err := buildArgs(prompt, opts, mcpConfigFile)
 	if err != nil {
@@ -302,57 +380,77 @@ func (l *Launcher) Start(ctx context.Context, prompt string, opts LaunchOptions)
 		_ = cancel // cleaned up when process exits
 	}
 
-	l.cmd = exec.CommandContext(ctx, binaryPath, args...)
-
-	// Build environment
-	l.cmd.Env = os.Environ()
+	spawn := SpawnConfig{
+		Path: binary,
+		Args: args,
+		Env:  os.Environ(),
+		Dir:  opts.WorkDir,
+	}
 
 	// Set API key
 	if opts.APIKey != "" {
-		l.cmd.Env = withEnvVar(l.cmd.Env, "ANTHROPIC_API_KEY", opts.APIKey)
+		spawn.Env = withEnvVar(spawn.Env, "ANTHROPIC_API_KEY", opts.APIKey)
 	}
 
 	// Set max thinking tokens
 	if opts.MaxThinkingTokens > 0 {
-		l.cmd.Env = withEnvVar(l.cmd.Env, "MAX_THINKING_TOKENS", strconv.Itoa(opts.MaxThinkingTokens))
+		spawn.Env = withEnvVar(spawn.Env, "MAX_THINKING_TOKENS", strconv.Itoa(opts.MaxThinkingTokens))
 	}
 
 	// Merge additional environment variables
 	for k, v := range opts.Env {
-		l.cmd.Env = withEnvVar(l.cmd.Env, k, v)
-	}
-
-	if opts.WorkDir != "" {
-		l.cmd.Dir = opts.WorkDir
+		spawn.Env = withEnvVar(spawn.Env, k, v)
 	}
 
 	l.hooks = opts.Hooks
 
-	// Set up stdout pipe
-	stdout, err := l.cmd.StdoutPipe()
-	if err != nil {
-		return &StartError{Err: fmt.Errorf("stdout pipe: %w", err)}
+	var spawner Spawner = ExecSpawner{}
+	if opts.Spawner != nil {
+		spawner = opts.Spawner
 	}
 
-	// Set up stderr pipe
-	l.stderr, err = l.cmd.StderrPipe()
+	// Start the process
+	l.startTime = time.Now()
+	proc, err := spawner.Spawn(ctx, spawn)
 	if err != nil {
-		return &StartError{Err: fmt.Errorf("stderr pipe: %w", err)}
+		if errors.Is(err, ErrCLINotFound) {
+			return err
+		}
+		return &StartError{Err: err}
+	}
+	l.proc = proc
+	l.stderr = proc.Stderr()
+
+	// Keep stdin open for stream-json input; otherwise Claude sees EOF
+	// once any prompt written there has been sent.
+	switch stdin := proc.Stdin(); {
+	case stdin == nil:
+	case input != nil:
+		l.inputDone = make(chan struct{})
+		go l.writeInput(stdin, input)
+	case opts.InputFormat == InputFormatStreamJSON:
+		l.stdin = stdin
+	default:
+		stdin.Close()
 	}
 
-	// Configure scanner with large buffer for long JSON lines
-	l.stdout = bufio.NewScanner(stdout)
-	buf := make([]byte, 0, 256*1024)
-	l.stdout.Buffer(buf, 1024*1024) // 1MB max line
+	l.stdout = NewDecoder(proc.Stdout(), opts.MaxLineBytes)
+	if opts.KeepRawMessages {
+		l.stdout.KeepRaw()
+	}
 
-	// Start the process
-	l.startTime = time.Now()
-	if err := l.cmd.Start(); err != nil {
-		return &StartError{Err: err}
+	if l.stdin != nil && (prompt != "" || len(blocks) > 0) {
+		if err := l.writeMessage(NewUserMessage(prompt, blocks...)); err != nil {
+			// A failed Start must not leave the process running.
+			proc.Kill()
+			proc.Wait()
+			return &StartError{Err: fmt.Errorf("write prompt: %w", err)}
+		}
 	}
 
 	l.started = true
-	l.hooks.invokeStart(l.cmd.Process.Pid)
+	l.log = log.started(proc.Pid(), spawn.Path, spawn.Args)
+	l.hooks.invokeStart(proc.Pid())
 
 	// Collect stderr in background
 	go l.collectStderr()
@@ -360,9 +458,85 @@ func (l *Launcher) Start(ctx context.Context, prompt string, opts LaunchOptions)
 	return nil
 }
 
-// collectStderr reads stderr into buffer for error reporting.
+// SendMessage writes a message to Claude's stdin as a stream-json line.
+//
+// Only available when the launcher was started with InputFormat set to
+// InputFormatStreamJSON. Use NewUserMessage to build a user turn.
+func (l *Launcher) SendMessage(msg StreamMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.started {
+		return ErrNotStarted
+	}
+	return l.writeMessage(msg)
+}
+
+// writeMessage encodes msg onto stdin. Caller must hold l.mu.
+func (l *Launcher) writeMessage(msg StreamMessage) error {
+	if l.stdin == nil {
+		return ErrInputClosed
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("claude: marshal input message: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := l.stdin.Write(data); err != nil {
+		return fmt.Errorf("claude: write input message: %w", err)
+	}
+	return nil
+}
+
+// CloseInput closes Claude's stdin, signalling that no more messages will
+// be sent. Claude finishes any in-flight turn and then exits.
+//
+// Safe to call more than once; returns nil if stdin is already closed.
+func (l *Launcher) CloseInput() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.started {
+		return ErrNotStarted
+	}
+	if l.stdin == nil {
+		return nil
+	}
+
+	err := l.stdin.Close()
+	l.stdin = nil
+	return err
+}
+
+// cleanup removes temp files and stops SDK servers.
+func (l *Launcher) cleanup() {
+	for _, f := range l.tempFiles {
+		os.Remove(f)
+	}
+	for _, fn := range l.cleanups {
+		fn()
+	}
+	l.tempFiles, l.cleanups = nil, nil
+}
+
+// collectStderr reads stderr into buffer for error reporting, logging
+// each line.
 func (l *Launcher) collectStderr() {
-	data, _ := io.ReadAll(l.stderr)
+	defer close(l.stderrEOF)
+	var data []byte
+	r := bufio.NewReader(l.stderr)
+	for {
+		line, err := r.ReadString('\n')
+		data = append(data, line...)
+		if line != "" {
+			l.log.stderr(line)
+		}
+		if err != nil {
+			break
+		}
+	}
 	l.mu.Lock()
 	l.stderrBuf = data
 	l.mu.Unlock()
@@ -371,49 +545,64 @@ func (l *Launcher) collectStderr() {
 // ReadMessage reads the next message from Claude's output.
 //
 // Returns nil, nil at EOF (Claude has finished).
-// Returns nil, error on parse or I/O errors.
+// Returns nil, error on parse or I/O errors. After a *ParseError or
+// *LineTooLongError the bad line has been skipped and reading can
+// continue.
 //
 // This is a blocking call. Use a separate goroutine if you need
 // concurrent processing.
 func (l *Launcher) ReadMessage() (*StreamMessage, error) {
-	if !l.stdout.Scan() {
-		if err := l.stdout.Err(); err != nil {
-			return nil, err
+	msg, err := l.stdout.Decode()
+	if err != nil {
+		switch err := err.(type) {
+		case *ParseError:
+			l.log.parseError(err)
+			l.hooks.invokeError(err)
+		case *LineTooLongError:
+			l.log.lineTooLong(err)
+			l.hooks.invokeError(err)
 		}
-		return nil, nil // EOF
+		return nil, err
 	}
-
-	line := l.stdout.Text()
-	if line == "" {
-		// Empty line, try next
-		return l.ReadMessage()
-	}
-
-	var msg StreamMessage
-	if err := json.Unmarshal([]byte(line), &msg); err != nil {
-		parseErr := &ParseError{Line: line, Err: err}
-		l.hooks.invokeError(parseErr)
-		return nil, parseErr
+	if msg == nil {
+		return nil, nil // EOF
 	}
 
 	// Invoke hooks
-	l.hooks.invokeMessage(msg)
+	l.hooks.invokeMessage(*msg)
 
-	if text := ExtractText(&msg); text != "" {
+	if text := ExtractText(msg); text != "" {
 		l.hooks.invokeText(text)
 	}
 
-	if name, input := GetToolCall(&msg); name != "" {
+	if name, input := GetToolCall(msg); name != "" {
 		l.hooks.invokeToolCall(name, input)
 	}
 
 	// Invoke metrics hook for result messages
+	if msg.Type == "system" && msg.Subtype == "init" {
+		l.model = msg.Model
+	}
 	if msg.Type == "result" {
-		m := metricsFromMessage(&msg)
+		m := metricsFromMessage(msg)
+		if m.Model == "" {
+			m.Model = l.model
+		}
 		l.hooks.invokeMetrics(m)
 	}
+	l.log.message(msg, l.model)
 
-	return &msg, nil
+	return msg, nil
+}
+
+// skippable reports whether ReadMessage can be called again after err:
+// the offending line was skipped and the stream is intact.
+func skippable(err error) bool {
+	switch err.(type) {
+	case *ParseError, *LineTooLongError:
+		return true
+	}
+	return false
 }
 
 // metricsFromMessage extracts SessionMetrics from a result StreamMessage.
@@ -425,6 +614,7 @@ func metricsFromMessage(msg *StreamMessage) SessionMetrics {
 		DurationMS:    msg.DurationMS,
 		DurationAPIMS: msg.DurationAPIMS,
 		Model:         msg.Model,
+		ModelUsage:    msg.ModelUsage,
 		SessionID:     msg.SessionID,
 	}
 	if msg.Usage != nil {
@@ -439,7 +629,11 @@ func metricsFromMessage(msg *StreamMessage) SessionMetrics {
 // Wait blocks until Claude exits and returns any error.
 //
 // Always call Wait to ensure resources are cleaned up, even if you
-// call Kill or Interrupt.
+// call Kill, Interrupt, or Shutdown. Wait may be called more than once;
+// later calls block until the first returns and report the same error.
+//
+// If a prompt written to stdin (see LaunchOptions.MaxPromptArgBytes)
+// could not be written in full, the error includes the write error.
 func (l *Launcher) Wait() error {
 	l.mu.Lock()
 	if !l.started {
@@ -448,29 +642,33 @@ func (l *Launcher) Wait() error {
 	}
 	l.mu.Unlock()
 
-	err := l.cmd.Wait()
+	l.waitOnce.Do(func() { l.waitErr = l.wait() })
+	return l.waitErr
+}
 
-	// Clean up temp files
-	for _, f := range l.tempFiles {
-		os.Remove(f)
+// wait reaps the process and closes Done.
+func (l *Launcher) wait() error {
+	// Drain stderr before reaping so ExitError.Stderr is complete; the exec
+	// transport closes its pipes once Wait returns.
+	<-l.stderrEOF
+	err := l.proc.Wait()
+	if l.inputDone != nil {
+		<-l.inputDone
 	}
 
-	// Close done channel
+	l.cleanup()
+
 	l.mu.Lock()
-	select {
-	case <-l.done:
-	default:
-		close(l.done)
-	}
+	close(l.done)
 	duration := time.Since(l.startTime)
+	inputErr := l.inputErr
 	l.mu.Unlock()
 
 	// Determine exit code
 	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		}
+	var coder exitCoder
+	if errors.As(err, &coder) {
+		exitCode = coder.ExitCode()
 	}
 
 	l.hooks.invokeExit(exitCode, duration)
@@ -480,13 +678,16 @@ func (l *Launcher) Wait() error {
 		stderr := string(l.stderrBuf)
 		l.mu.Unlock()
 
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return &ExitError{Code: exitErr.ExitCode(), Stderr: stderr}
+		if coder != nil {
+			err = &ExitError{Code: exitCode, Stderr: stderr}
 		}
-		return err
 	}
-
-	return nil
+	if inputErr != nil {
+		// Claude ran without its full prompt.
+		err = errors.Join(err, inputErr)
+	}
+	l.log.exited(exitCode, duration, err)
+	return err
 }
 
 // Interrupt sends SIGINT to Claude for graceful shutdown.
@@ -497,29 +698,86 @@ func (l *Launcher) Interrupt() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if !l.started || l.cmd.Process == nil {
+	if !l.started {
 		return ErrNotStarted
 	}
 
-	return l.cmd.Process.Signal(syscall.SIGINT)
+	return l.proc.Signal(syscall.SIGINT)
 }
 
-// Kill forcefully terminates Claude.
+// Kill forcefully terminates Claude and, with ExecSpawner on Unix, every
+// process in its process group.
 //
-// Use Interrupt for graceful shutdown when possible.
+// Use Interrupt or Shutdown for graceful shutdown when possible.
 // Follow with Wait() to ensure the process has exited.
 func (l *Launcher) Kill() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if !l.started || l.cmd.Process == nil {
+	if !l.started {
 		return ErrNotStarted
 	}
 
-	return l.cmd.Process.Kill()
+	return l.proc.Kill()
+}
+
+// Shutdown stops Claude gracefully: it sends SIGINT, gives Claude up to
+// grace to write its result message and exit, and then kills the process
+// group. A grace of zero or less kills straight away.
+//
+// It returns nil once Claude has exited, or ctx.Err() if ctx ends first,
+// in which case the process group is killed before returning. After a
+// kill Shutdown reaps the process itself, so Done is closed even if
+// nothing calls Wait.
+func (l *Launcher) Shutdown(ctx context.Context, grace time.Duration) error {
+	return l.shutdown(ctx, grace, nil)
+}
+
+// shutdown implements Shutdown, calling beforeKill (if non-nil) before
+// escalating to Kill.
+func (l *Launcher) shutdown(ctx context.Context, grace time.Duration, beforeKill func()) error {
+	if l.exited() {
+		return nil
+	}
+	if err := l.Interrupt(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	timer := time.NewTimer(max(grace, 0))
+	defer timer.Stop()
+
+	var ctxErr error
+	select {
+	case <-l.done:
+		return nil
+	case <-timer.C:
+	case <-ctx.Done():
+		ctxErr = ctx.Err()
+	}
+
+	if beforeKill != nil {
+		beforeKill()
+	}
+	if err := l.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	// Reap the killed process so Done closes without a caller in Wait;
+	// Wait is idempotent, so a reader's own Wait gets the same result.
+	go l.Wait()
+	if ctxErr != nil {
+		return ctxErr
+	}
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// Done returns a channel that's closed when Claude exits.
+// Done returns a channel that's closed when Claude exits, or when Start
+// fails.
 //
 // Use this for select-based waiting:
 //
@@ -540,8 +798,8 @@ func (l *Launcher) PID() int {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.cmd != nil && l.cmd.Process != nil {
-		return l.cmd.Process.Pid
+	if l.proc != nil {
+		return l.proc.Pid()
 	}
 	return 0
 }
@@ -551,19 +809,25 @@ func (l *Launcher) Running() bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if !l.started || l.cmd == nil || l.cmd.Process == nil {
-		return false
-	}
+	return l.started && l.proc != nil && !l.exited()
+}
 
-	// Check if done channel is closed
+// exited reports whether Done is closed.
+func (l *Launcher) exited() bool {
 	select {
 	case <-l.done:
-		return false
-	default:
 		return true
+	default:
+		return false
 	}
 }
 
+// exitCoder is implemented by process wait errors that carry an exit code,
+// such as *exec.ExitError.
+type exitCoder interface {
+	ExitCode() int
+}
+
 // withEnvVar returns a copy of env with key=value set.
 // If key already exists, it's replaced.
 func withEnvVar(env []string, key, value string) []string {
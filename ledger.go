@@ -0,0 +1,479 @@
+package claude
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ModelPrice is the price of a model in USD per million tokens.
+type ModelPrice struct {
+	// InputPerMTok is the price of uncached input tokens.
+	InputPerMTok float64 `json:"input_per_mtok"`
+
+	// OutputPerMTok is the price of output tokens.
+	OutputPerMTok float64 `json:"output_per_mtok"`
+
+	// CacheWritePerMTok is the price of tokens written to the prompt cache.
+	CacheWritePerMTok float64 `json:"cache_write_per_mtok"`
+
+	// CacheReadPerMTok is the price of tokens read from the prompt cache.
+	CacheReadPerMTok float64 `json:"cache_read_per_mtok"`
+}
+
+// Cost returns the price of the given token counts.
+func (p ModelPrice) Cost(input, output, cacheWrite, cacheRead int) float64 {
+	return (float64(input)*p.InputPerMTok +
+		float64(output)*p.OutputPerMTok +
+		float64(cacheWrite)*p.CacheWritePerMTok +
+		float64(cacheRead)*p.CacheReadPerMTok) / 1e6
+}
+
+// Pricing maps model names, such as "claude-sonnet-4-5", to prices.
+type Pricing map[string]ModelPrice
+
+// modelSuffix matches what Lookup strips from a model name: a release
+// date ("-20250929") and a context window tag ("[1m]").
+var modelSuffix = regexp.MustCompile(`(-\d{8})?(\[\w+\])?$`)
+
+// Lookup returns the price of model. Names match exactly, or after
+// stripping a release date and context window tag, so
+// "claude-sonnet-4-5-20250929" and "claude-sonnet-4-5[1m]" both use the
+// "claude-sonnet-4-5" entry.
+func (p Pricing) Lookup(model string) (ModelPrice, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+	price, ok := p[modelSuffix.ReplaceAllString(model, "")]
+	return price, ok
+}
+
+// DefaultPricing returns the list prices of current and recent Claude
+// models. Cache writes use the 5-minute cache rate; batch discounts and
+// long-context premiums are not modeled. Add or override entries for
+// models it does not know or negotiated rates:
+//
+//	pricing := claude.DefaultPricing()
+//	pricing["claude-sonnet-4-5"] = claude.ModelPrice{InputPerMTok: 2.4, ...}
+func DefaultPricing() Pricing {
+	opus4 := ModelPrice{InputPerMTok: 15, OutputPerMTok: 75, CacheWritePerMTok: 18.75, CacheReadPerMTok: 1.5}
+	sonnet := ModelPrice{InputPerMTok: 3, OutputPerMTok: 15, CacheWritePerMTok: 3.75, CacheReadPerMTok: 0.3}
+	return Pricing{
+		"claude-opus-4-5":   {InputPerMTok: 5, OutputPerMTok: 25, CacheWritePerMTok: 6.25, CacheReadPerMTok: 0.5},
+		"claude-opus-4-1":   opus4,
+		"claude-opus-4":     opus4,
+		"claude-3-opus":     opus4,
+		"claude-sonnet-4-5": sonnet,
+		"claude-sonnet-4":   sonnet,
+		"claude-3-7-sonnet": sonnet,
+		"claude-3-5-sonnet": sonnet,
+		"claude-haiku-4-5":  {InputPerMTok: 1, OutputPerMTok: 5, CacheWritePerMTok: 1.25, CacheReadPerMTok: 0.1},
+		"claude-3-5-haiku":  {InputPerMTok: 0.8, OutputPerMTok: 4, CacheWritePerMTok: 1, CacheReadPerMTok: 0.08},
+		"claude-3-haiku":    {InputPerMTok: 0.25, OutputPerMTok: 1.25, CacheWritePerMTok: 0.3, CacheReadPerMTok: 0.03},
+	}
+}
+
+// LedgerConfig configures a Ledger.
+type LedgerConfig struct {
+	// Pricing prices token usage. Defaults to DefaultPricing() if nil.
+	Pricing Pricing
+
+	// Path is a JSONL file that records are appended to. Records already
+	// in the file are loaded by NewLedger, so totals and limits carry
+	// over between processes. Empty keeps the ledger in memory.
+	Path string
+
+	// SoftLimitUSD calls OnSoftLimit once the total reaches it.
+	// Zero means no soft limit.
+	SoftLimitUSD float64
+
+	// OnSoftLimit is called, once per Ledger, with the total when it
+	// first reaches SoftLimitUSD. It runs in the goroutine that recorded
+	// the spend, typically a hook.
+	OnSoftLimit func(totalUSD float64)
+
+	// HardLimitUSD stops new work once the total reaches it: Check and
+	// Attach return ErrSpendLimit. Zero means no hard limit.
+	HardLimitUSD float64
+}
+
+// LedgerRecord is one model's spend from one result message.
+type LedgerRecord struct {
+	// Time is when the spend was recorded.
+	Time time.Time `json:"time"`
+
+	// Label is the label passed to Hooks, Attach, or Record.
+	Label string `json:"label,omitempty"`
+
+	// SessionID is the CLI session identifier.
+	SessionID string `json:"session_id,omitempty"`
+
+	// Model is the model that was billed.
+	Model string `json:"model,omitempty"`
+
+	// InputTokens is the uncached input tokens consumed.
+	InputTokens int `json:"input_tokens,omitempty"`
+
+	// OutputTokens is the output tokens generated.
+	OutputTokens int `json:"output_tokens,omitempty"`
+
+	// CacheCreationInputTokens is the tokens written to the prompt cache.
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+
+	// CacheReadInputTokens is the tokens read from the prompt cache.
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"`
+
+	// CostUSD is the cost of the tokens.
+	CostUSD float64 `json:"cost_usd"`
+
+	// Priced is true if CostUSD was computed from the pricing table, and
+	// false if the model was not in it and the CLI's cost was used.
+	Priced bool `json:"priced"`
+}
+
+// LedgerSummary is a snapshot of a Ledger's totals.
+type LedgerSummary struct {
+	// TotalUSD is the spend across all records.
+	TotalUSD float64
+
+	// ByLabel is the spend per label.
+	ByLabel map[string]float64
+
+	// ByModel is the spend per model.
+	ByModel map[string]float64
+
+	// ByDay is the spend per UTC day, keyed "2006-01-02".
+	ByDay map[string]float64
+
+	// Records is the number of records.
+	Records int
+
+	// RemainingUSD is HardLimitUSD minus TotalUSD, floored at zero.
+	// Zero when the ledger has no hard limit.
+	RemainingUSD float64
+}
+
+// Ledger aggregates spend across sessions by label, model, and day.
+//
+// A Ledger subscribes to sessions through Hooks.OnMetrics and prices
+// each result's token usage, including cache reads and writes, with its
+// Pricing table. It does not rely on the CLI's reported cost, which may be
+// missing under subscription auth, except for models it cannot price.
+// One Ledger is meant to be shared by every session in a process.
+//
+// Example:
+//
+//	ledger, _ := claude.NewLedger(claude.LedgerConfig{
+//		Path:         "spend.jsonl",
+//		SoftLimitUSD: 40,
+//		OnSoftLimit:  func(total float64) { log.Printf("spent $%.2f", total) },
+//		HardLimitUSD: 50,
+//	})
+//	defer ledger.Close()
+//
+//	opts, err := ledger.Attach("triage", claude.LaunchOptions{Model: "sonnet"})
+//	if err != nil {
+//		return err // ErrSpendLimit
+//	}
+//	session, _ := claude.NewSession(claude.SessionConfig{LaunchOptions: opts})
+type Ledger struct {
+	cfg LedgerConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	file      *os.File
+	summary   LedgerSummary
+	sessions  map[string]map[string]ModelUsage // last cumulative usage per session and model
+	totals    map[string]float64               // last cumulative TotalCostUSD per session
+	softFired bool
+	err       error // first error recording from Hooks
+}
+
+// NewLedger creates a Ledger, loading any records already in cfg.Path.
+func NewLedger(cfg LedgerConfig) (*Ledger, error) {
+	if cfg.SoftLimitUSD < 0 {
+		return nil, fmt.Errorf("claude: invalid ledger SoftLimitUSD %v", cfg.SoftLimitUSD)
+	}
+	if cfg.HardLimitUSD < 0 {
+		return nil, fmt.Errorf("claude: invalid ledger HardLimitUSD %v", cfg.HardLimitUSD)
+	}
+	if cfg.Pricing == nil {
+		cfg.Pricing = DefaultPricing()
+	}
+
+	l := &Ledger{
+		cfg: cfg,
+		now: time.Now,
+		summary: LedgerSummary{
+			ByLabel: make(map[string]float64),
+			ByModel: make(map[string]float64),
+			ByDay:   make(map[string]float64),
+		},
+		sessions: make(map[string]map[string]ModelUsage),
+		totals:   make(map[string]float64),
+	}
+	if cfg.Path != "" {
+		if err := l.open(cfg.Path); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// open loads the records in path and opens it for appending.
+func (l *Ledger) open(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("claude: open ledger: %w", err)
+	}
+
+	r := bufio.NewReader(f)
+	var size int64 // bytes of complete lines
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] != '\n' {
+			// A record cut off by a crash; drop it so the next write
+			// starts a clean line.
+			if err := f.Truncate(size); err != nil {
+				f.Close()
+				return fmt.Errorf("claude: repair ledger: %w", err)
+			}
+			break
+		}
+		size += int64(len(line))
+		if len(line) > 1 {
+			var rec LedgerRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				f.Close()
+				return fmt.Errorf("claude: ledger %s:%d: %w", path, n, err)
+			}
+			l.add(rec)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("claude: read ledger: %w", err)
+		}
+	}
+	l.file = f
+	return nil
+}
+
+// Hooks returns Hooks that record every session's metrics under label.
+// Combine them with your own hooks with ChainHooks, or use Attach.
+//
+// Errors writing the ledger file are returned by Close.
+func (l *Ledger) Hooks(label string) *Hooks {
+	return &Hooks{
+		OnMetrics: func(m SessionMetrics) {
+			if err := l.Record(label, m); err != nil {
+				l.mu.Lock()
+				if l.err == nil {
+					l.err = err
+				}
+				l.mu.Unlock()
+			}
+		},
+	}
+}
+
+// Attach returns opts set up to record into the ledger under label: its
+// Hooks are chained with the ledger's, and with a hard limit, its
+// MaxBudgetUSD is lowered to the remaining budget so the CLI stops
+// before overshooting it.
+//
+// Returns ErrSpendLimit if the hard limit has been reached.
+func (l *Ledger) Attach(label string, opts LaunchOptions) (LaunchOptions, error) {
+	if err := l.Check(); err != nil {
+		return opts, err
+	}
+	opts.Hooks = ChainHooks(opts.Hooks, l.Hooks(label))
+	if l.cfg.HardLimitUSD > 0 {
+		opts.MaxBudgetUSD = capBudget(opts.MaxBudgetUSD, l.Summary().RemainingUSD)
+	}
+	return opts, nil
+}
+
+// Check returns ErrSpendLimit if the hard limit has been reached.
+func (l *Ledger) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.HardLimitUSD > 0 && l.summary.TotalUSD >= l.cfg.HardLimitUSD {
+		return ErrSpendLimit
+	}
+	return nil
+}
+
+// Record adds the spend in m, a result's metrics, under label.
+//
+// The CLI reports ModelUsage cumulatively for a session, so for sessions
+// with several results, such as a Conversation, only the increase since
+// the session's previous result is recorded. Without ModelUsage, the
+// latest turn's usage is billed to m.Model, and for models without a
+// price, the increase in TotalCostUSD.
+func (l *Ledger) Record(label string, m SessionMetrics) error {
+	l.mu.Lock()
+	recs := l.records(label, m)
+	var err error
+	for _, rec := range recs {
+		if err = l.write(rec); err != nil {
+			break
+		}
+		l.add(rec)
+	}
+	total := l.summary.TotalUSD
+	fire := l.cfg.SoftLimitUSD > 0 && total >= l.cfg.SoftLimitUSD && !l.softFired
+	if fire {
+		l.softFired = true
+	}
+	l.mu.Unlock()
+
+	if fire && l.cfg.OnSoftLimit != nil {
+		l.cfg.OnSoftLimit(total)
+	}
+	return err
+}
+
+// records converts m to records. Must hold l.mu.
+func (l *Ledger) records(label string, m SessionMetrics) []LedgerRecord {
+	now := l.now().UTC()
+	rec := func(model string, u ModelUsage) LedgerRecord {
+		r := LedgerRecord{
+			Time:                     now,
+			Label:                    label,
+			SessionID:                m.SessionID,
+			Model:                    model,
+			InputTokens:              u.InputTokens,
+			OutputTokens:             u.OutputTokens,
+			CacheCreationInputTokens: u.CacheCreationInputTokens,
+			CacheReadInputTokens:     u.CacheReadInputTokens,
+			CostUSD:                  u.CostUSD,
+		}
+		if price, ok := l.cfg.Pricing.Lookup(model); ok {
+			r.CostUSD = price.Cost(u.InputTokens, u.OutputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens)
+			r.Priced = true
+		}
+		return r
+	}
+
+	if len(m.ModelUsage) == 0 {
+		// TotalCostUSD is cumulative too; bill only its increase.
+		total := m.TotalCostUSD
+		if m.SessionID != "" {
+			if prev := l.totals[m.SessionID]; prev <= total {
+				total -= prev
+			}
+			l.totals[m.SessionID] = m.TotalCostUSD
+		}
+		cost := m.CostUSD
+		if cost == 0 {
+			cost = total
+		}
+		u := ModelUsage{
+			InputTokens:              m.InputTokens,
+			OutputTokens:             m.OutputTokens,
+			CacheCreationInputTokens: m.CacheCreationInputTokens,
+			CacheReadInputTokens:     m.CacheReadInputTokens,
+			CostUSD:                  cost,
+		}
+		if u == (ModelUsage{}) {
+			return nil
+		}
+		return []LedgerRecord{rec(m.Model, u)}
+	}
+
+	prev := l.sessions[m.SessionID]
+	if m.SessionID != "" {
+		l.sessions[m.SessionID] = m.ModelUsage
+	}
+	var recs []LedgerRecord
+	for _, model := range slices.Sorted(maps.Keys(m.ModelUsage)) {
+		u, p := m.ModelUsage[model], prev[model]
+		u.InputTokens -= p.InputTokens
+		u.OutputTokens -= p.OutputTokens
+		u.CacheCreationInputTokens -= p.CacheCreationInputTokens
+		u.CacheReadInputTokens -= p.CacheReadInputTokens
+		u.WebSearchRequests -= p.WebSearchRequests
+		u.CostUSD -= p.CostUSD
+		if u != (ModelUsage{}) {
+			recs = append(recs, rec(model, u))
+		}
+	}
+	return recs
+}
+
+// write appends rec to the ledger file, if any. Must hold l.mu.
+func (l *Ledger) write(rec LedgerRecord) error {
+	if l.cfg.Path == "" {
+		return nil
+	}
+	if l.file == nil {
+		return errors.New("claude: ledger is closed")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("claude: write ledger: %w", err)
+	}
+	return nil
+}
+
+// add adds rec to the totals. Must hold l.mu.
+func (l *Ledger) add(rec LedgerRecord) {
+	s := &l.summary
+	s.TotalUSD += rec.CostUSD
+	s.ByLabel[rec.Label] += rec.CostUSD
+	s.ByModel[rec.Model] += rec.CostUSD
+	s.ByDay[rec.Time.UTC().Format(time.DateOnly)] += rec.CostUSD
+	s.Records++
+}
+
+// Summary returns a snapshot of the ledger's totals.
+func (l *Ledger) Summary() LedgerSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.summary
+	s.ByLabel = maps.Clone(s.ByLabel)
+	s.ByModel = maps.Clone(s.ByModel)
+	s.ByDay = maps.Clone(s.ByDay)
+	if l.cfg.HardLimitUSD > 0 {
+		s.RemainingUSD = max(l.cfg.HardLimitUSD-s.TotalUSD, 0)
+	}
+	return s
+}
+
+// Total returns the spend across all records.
+func (l *Ledger) Total() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.summary.TotalUSD
+}
+
+// Close closes the ledger file. It returns the first error recording
+// from Hooks, or the error closing the file. Totals remain readable.
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.err
+	if l.file != nil {
+		if cerr := l.file.Close(); err == nil {
+			err = cerr
+		}
+		l.file = nil
+	}
+	return err
+}
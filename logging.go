@@ -0,0 +1,151 @@
+package claude
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Log records emitted through LaunchOptions.Logger. Every record after
+// the process starts carries "pid"; records from a Session also carry
+// "session".
+//
+//	Message                         Level        Attributes
+//	claude: process started         Info         binary, args
+//	claude: process start failed    Error        binary, error
+//	claude: process exited          Info, Warn   exit_code, duration, error
+//	claude: parse error             Warn         error, line
+//	claude: line too long           Warn         error, line
+//	claude: tool call               Debug        tool, tool_use_id
+//	claude: result                  Info, Warn   session_id, model, subtype, num_turns,
+//	                                             cost_usd, input_tokens, output_tokens, duration, error
+//	claude: stderr                  Debug        line
+const (
+	logKeyPID       = "pid"
+	logKeySession   = "session"
+	logKeyBinary    = "binary"
+	logKeyArgs      = "args"
+	logKeyExitCode  = "exit_code"
+	logKeyDuration  = "duration"
+	logKeyError     = "error"
+	logKeyLine      = "line"
+	logKeyTool      = "tool"
+	logKeyToolUseID = "tool_use_id"
+	logKeySessionID = "session_id"
+	logKeyModel     = "model"
+	logKeySubtype   = "subtype"
+	logKeyNumTurns  = "num_turns"
+	logKeyCostUSD   = "cost_usd"
+	logKeyInput     = "input_tokens"
+	logKeyOutput    = "output_tokens"
+)
+
+// maxLoggedLine caps the length of stdout and stderr lines in log records.
+const maxLoggedLine = 1024
+
+// truncateLine shortens s to maxLoggedLine bytes for logging.
+func truncateLine(s string) string {
+	s = strings.TrimRight(s, "\r\n")
+	if len(s) <= maxLoggedLine {
+		return s
+	}
+	return s[:maxLoggedLine] + "..."
+}
+
+// launchLogger wraps LaunchOptions.Logger. A nil *launchLogger logs
+// nothing, like Hooks.
+type launchLogger struct {
+	log *slog.Logger
+}
+
+func newLaunchLogger(l *slog.Logger) *launchLogger {
+	if l == nil {
+		return nil
+	}
+	return &launchLogger{log: l}
+}
+
+func (l *launchLogger) logAttrs(level slog.Level, msg string, attrs ...slog.Attr) {
+	if l == nil {
+		return
+	}
+	l.log.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// started logs a process start and returns a logger carrying its pid.
+func (l *launchLogger) started(pid int, binary string, args []string) *launchLogger {
+	if l == nil {
+		return nil
+	}
+	l = &launchLogger{log: l.log.With(logKeyPID, pid)}
+	l.logAttrs(slog.LevelInfo, "claude: process started",
+		slog.String(logKeyBinary, binary),
+		slog.Any(logKeyArgs, redactArgs(args)))
+	return l
+}
+
+func (l *launchLogger) startFailed(binary string, err error) {
+	l.logAttrs(slog.LevelError, "claude: process start failed",
+		slog.String(logKeyBinary, binary),
+		slog.Any(logKeyError, err))
+}
+
+func (l *launchLogger) exited(code int, duration time.Duration, err error) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{slog.Int(logKeyExitCode, code), slog.Duration(logKeyDuration, duration)}
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any(logKeyError, err))
+	}
+	l.logAttrs(level, "claude: process exited", attrs...)
+}
+
+func (l *launchLogger) parseError(err *ParseError) {
+	l.logAttrs(slog.LevelWarn, "claude: parse error",
+		slog.Any(logKeyError, err.Err),
+		slog.String(logKeyLine, truncateLine(err.Line)))
+}
+
+func (l *launchLogger) lineTooLong(err *LineTooLongError) {
+	l.logAttrs(slog.LevelWarn, "claude: line too long",
+		slog.Any(logKeyError, err),
+		slog.String(logKeyLine, err.Prefix))
+}
+
+func (l *launchLogger) stderr(line string) {
+	l.logAttrs(slog.LevelDebug, "claude: stderr", slog.String(logKeyLine, truncateLine(line)))
+}
+
+// message logs the tool calls and results in msg.
+func (l *launchLogger) message(msg *StreamMessage, model string) {
+	if l == nil {
+		return
+	}
+	for _, c := range GetAllToolCalls(msg) {
+		l.logAttrs(slog.LevelDebug, "claude: tool call",
+			slog.String(logKeyTool, c.Name),
+			slog.String(logKeyToolUseID, c.ID))
+	}
+	if msg.Type != "result" {
+		return
+	}
+
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String(logKeySessionID, msg.SessionID),
+		slog.String(logKeyModel, model),
+		slog.String(logKeySubtype, msg.Subtype),
+		slog.Int(logKeyNumTurns, msg.NumTurns),
+		slog.Float64(logKeyCostUSD, msg.TotalCost),
+	}
+	if u := msg.Usage; u != nil {
+		attrs = append(attrs, slog.Int(logKeyInput, u.InputTokens), slog.Int(logKeyOutput, u.OutputTokens))
+	}
+	attrs = append(attrs, slog.Duration(logKeyDuration, time.Duration(msg.DurationMS)*time.Millisecond))
+	if re := (&Result{Messages: []StreamMessage{*msg}}).resultError(); re != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any(logKeyError, re))
+	}
+	l.logAttrs(level, "claude: result", attrs...)
+}
@@ -0,0 +1,82 @@
+package claude
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/MateoSegura/claudesdk-go/mcp"
+)
+
+// startLocalServers serves LocalMCPServers and the SDK's in-process tools
+// (CanUseTool) on loopback and returns opts rewired to reach them through
+// MCPServers. The returned close function must be called once the CLI has
+// exited. Returns a nil close function if opts needs no local server.
+func startLocalServers(opts LaunchOptions) (LaunchOptions, func() error, error) {
+	local := make(map[string]*mcp.Server, len(opts.LocalMCPServers)+1)
+	for name, srv := range opts.LocalMCPServers {
+		if srv == nil {
+			return opts, nil, fmt.Errorf("local mcp server %q is nil", name)
+		}
+		if _, ok := opts.MCPServers[name]; ok {
+			return opts, nil, fmt.Errorf("mcp server %q is in both MCPServers and LocalMCPServers", name)
+		}
+		local[name] = srv
+	}
+
+	if opts.CanUseTool != nil {
+		if opts.PermissionPromptTool != "" {
+			return opts, nil, fmt.Errorf("CanUseTool and PermissionPromptTool are mutually exclusive")
+		}
+		if _, ok := local[sdkMCPServerName]; ok {
+			return opts, nil, fmt.Errorf("mcp server name %q is reserved when CanUseTool is set", sdkMCPServerName)
+		}
+		if _, ok := opts.MCPServers[sdkMCPServerName]; ok {
+			return opts, nil, fmt.Errorf("mcp server name %q is reserved when CanUseTool is set", sdkMCPServerName)
+		}
+		srv := mcp.NewServer(sdkMCPServerName, Version)
+		srv.AddTool(permissionTool(opts.CanUseTool))
+		local[sdkMCPServerName] = srv
+		opts.PermissionPromptTool = "mcp__" + sdkMCPServerName + "__" + permissionToolName
+	}
+
+	if len(local) == 0 {
+		return opts, nil, nil
+	}
+
+	// Copy so the caller's map is not modified.
+	servers := make(map[string]MCPServer, len(opts.MCPServers)+len(local))
+	for name, s := range opts.MCPServers {
+		servers[name] = s
+	}
+
+	// Start in a stable order so failures are reproducible.
+	names := make([]string, 0, len(local))
+	for name := range local {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var endpoints []*mcp.Local
+	closeAll := func() error {
+		var first error
+		for _, ep := range endpoints {
+			if err := ep.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	for _, name := range names {
+		ep, err := local[name].ServeLocal()
+		if err != nil {
+			closeAll()
+			return opts, nil, fmt.Errorf("start mcp server %q: %w", name, err)
+		}
+		endpoints = append(endpoints, ep)
+		servers[name] = MCPServer{Type: "http", URL: ep.URL, Headers: ep.Headers()}
+	}
+
+	opts.MCPServers = servers
+	return opts, closeAll, nil
+}
@@ -0,0 +1,45 @@
+// Package mcp lets Go programs expose functions to Claude as Model Context
+// Protocol (MCP) tools, without a separate Node or Python server.
+//
+// Register tools on a Server, then make it reachable in one of three ways:
+//
+//   - In-process: put it in claude.LaunchOptions.LocalMCPServers. The SDK
+//     serves it on a loopback endpoint for the lifetime of the CLI process
+//     and adds it to --mcp-config automatically.
+//   - Local HTTP: ServeLocal starts a token-protected loopback endpoint.
+//     Server also implements http.Handler for mounting in your own service.
+//   - Stdio: ServeStdio serves it from a standalone binary that the CLI
+//     launches via claude.MCPServer{Command: ...}.
+//
+// Example:
+//
+//	srv := mcp.NewServer("inventory", "1.0.0")
+//	srv.AddTool(mcp.Tool{
+//		Name:        "stock_level",
+//		Description: "Returns units in stock for a SKU",
+//		InputSchema: map[string]any{
+//			"type":       "object",
+//			"properties": map[string]any{"sku": map[string]any{"type": "string"}},
+//			"required":   []string{"sku"},
+//		},
+//		Handler: func(ctx context.Context, args json.RawMessage) (*mcp.Result, error) {
+//			var in struct{ SKU string `json:"sku"` }
+//			if err := json.Unmarshal(args, &in); err != nil {
+//				return nil, err
+//			}
+//			return mcp.TextResult(fmt.Sprint(inventory.Stock(in.SKU))), nil
+//		},
+//	})
+//
+//	session, _ := claude.NewSession(claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{
+//			LocalMCPServers: map[string]*mcp.Server{"inventory": srv},
+//			AllowedTools:    []string{"mcp__inventory__stock_level"},
+//		},
+//	})
+//
+// TypedTool derives the input schema from a Go struct using the schema
+// package and decodes validated arguments before calling the handler.
+//
+// Claude sees each tool as mcp__<server>__<tool>.
+package mcp
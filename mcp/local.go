@@ -0,0 +1,73 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Local is a Server listening on a loopback HTTP endpoint.
+type Local struct {
+	// URL is the endpoint, e.g. "http://127.0.0.1:49152/mcp".
+	URL string
+
+	// Token must be sent as "Authorization: Bearer <Token>".
+	Token string
+
+	srv *http.Server
+}
+
+// Headers returns the HTTP headers a client needs to reach the endpoint.
+func (l *Local) Headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + l.Token}
+}
+
+// ServeLocal serves s on 127.0.0.1 using an ephemeral port.
+//
+// Requests must carry a random bearer token so other local processes
+// cannot call the tools. Call Close when done.
+func (s *Server) ServeLocal() (*Local, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	token := newToken()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.ServeHTTP(w, r)
+	})
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+
+	return &Local{
+		URL:   "http://" + ln.Addr().String() + "/mcp",
+		Token: token,
+		srv:   srv,
+	}, nil
+}
+
+// Close stops the endpoint, waiting briefly for in-flight calls.
+func (l *Local) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.srv.Shutdown(ctx); err != nil {
+		return l.srv.Close()
+	}
+	return nil
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,320 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer() *Server {
+	s := NewServer("test", "1.0.0")
+	s.AddTool(Tool{
+		Name:        "echo",
+		Description: "Echoes its input",
+		InputSchema: map[string]any{"type": "object"},
+		Handler: func(ctx context.Context, args json.RawMessage) (*Result, error) {
+			var in struct {
+				Text string `json:"text"`
+			}
+			json.Unmarshal(args, &in)
+			return TextResult(in.Text), nil
+		},
+	})
+	s.AddTool(Tool{
+		Name: "fail",
+		Handler: func(ctx context.Context, args json.RawMessage) (*Result, error) {
+			return nil, errors.New("boom")
+		},
+	})
+	return s
+}
+
+func call(t *testing.T, s *Server, msg string) map[string]any {
+	t.Helper()
+	resp := s.Handle(context.Background(), []byte(msg))
+	if resp == nil {
+		t.Fatalf("Handle(%s) returned no response", msg)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(resp, &out); err != nil {
+		t.Fatalf("invalid response %s: %v", resp, err)
+	}
+	return out
+}
+
+func TestInitialize(t *testing.T) {
+	s := newTestServer()
+
+	out := call(t, s, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+	result := out["result"].(map[string]any)
+	if result["protocolVersion"] != "2025-03-26" {
+		t.Errorf("protocolVersion = %v, want echo of client version", result["protocolVersion"])
+	}
+	if info := result["serverInfo"].(map[string]any); info["name"] != "test" {
+		t.Errorf("serverInfo = %v", info)
+	}
+
+	out = call(t, s, `{"jsonrpc":"2.0","id":2,"method":"initialize","params":{}}`)
+	if v := out["result"].(map[string]any)["protocolVersion"]; v != DefaultProtocolVersion {
+		t.Errorf("default protocolVersion = %v", v)
+	}
+}
+
+func TestNotificationHasNoResponse(t *testing.T) {
+	s := newTestServer()
+	if resp := s.Handle(context.Background(), []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); resp != nil {
+		t.Errorf("notification response = %s, want nil", resp)
+	}
+}
+
+func TestToolsList(t *testing.T) {
+	out := call(t, newTestServer(), `{"jsonrpc":"2.0","id":"a","method":"tools/list"}`)
+	if out["id"] != "a" {
+		t.Errorf("id = %v, want a", out["id"])
+	}
+
+	tools := out["result"].(map[string]any)["tools"].([]any)
+	if len(tools) != 2 {
+		t.Fatalf("len(tools) = %d, want 2", len(tools))
+	}
+	echo := tools[0].(map[string]any)
+	if echo["name"] != "echo" || echo["description"] != "Echoes its input" {
+		t.Errorf("tools[0] = %v", echo)
+	}
+	// Tools registered without a schema get an empty object schema.
+	if schema := tools[1].(map[string]any)["inputSchema"].(map[string]any); schema["type"] != "object" {
+		t.Errorf("default inputSchema = %v", schema)
+	}
+}
+
+func TestToolsCall(t *testing.T) {
+	s := newTestServer()
+
+	out := call(t, s, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`)
+	content := out["result"].(map[string]any)["content"].([]any)
+	if text := content[0].(map[string]any)["text"]; text != "hi" {
+		t.Errorf("echo text = %v", text)
+	}
+
+	out = call(t, s, `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"fail"}}`)
+	result := out["result"].(map[string]any)
+	if result["isError"] != true {
+		t.Errorf("handler error should produce isError result: %v", result)
+	}
+
+	out = call(t, s, `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"missing"}}`)
+	if code := out["error"].(map[string]any)["code"]; code != float64(CodeInvalidParams) {
+		t.Errorf("unknown tool code = %v", code)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	s := newTestServer()
+
+	out := call(t, s, `{not json`)
+	if code := out["error"].(map[string]any)["code"]; code != float64(CodeParseError) {
+		t.Errorf("parse error code = %v", code)
+	}
+
+	out = call(t, s, `{"jsonrpc":"2.0","id":6,"method":"resources/list"}`)
+	if code := out["error"].(map[string]any)["code"]; code != float64(CodeMethodNotFound) {
+		t.Errorf("unknown method code = %v", code)
+	}
+}
+
+func TestServeLocal(t *testing.T) {
+	s := newTestServer()
+
+	local, err := s.ServeLocal()
+	if err != nil {
+		t.Fatalf("ServeLocal() error: %v", err)
+	}
+	defer local.Close()
+
+	if !strings.HasPrefix(local.URL, "http://127.0.0.1:") || !strings.HasSuffix(local.URL, "/mcp") {
+		t.Errorf("URL = %q", local.URL)
+	}
+	if local.Headers()["Authorization"] != "Bearer "+local.Token || local.Token == "" {
+		t.Errorf("Headers() = %v", local.Headers())
+	}
+
+	post := func(token, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, local.URL, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post("", `{"jsonrpc":"2.0","id":1,"method":"ping"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want 401", resp.StatusCode)
+	}
+	if resp := post(local.Token, `{"jsonrpc":"2.0","id":1,"method":"ping"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("ping status = %d, want 200", resp.StatusCode)
+	}
+	if resp := post(local.Token, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("notification status = %d, want 202", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, local.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+local.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", resp.StatusCode)
+	}
+
+	local.Close()
+	if _, err := http.Post(local.URL, "application/json", strings.NewReader("{}")); err == nil {
+		t.Error("server should be unreachable after Close")
+	}
+}
+
+func TestServeHTTPNoAuth(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	newTestServer().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `"echo"`) {
+		t.Errorf("body = %s", rec.Body.String())
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		``,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo","arguments":{"text":"over stdio"}}}`,
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	if err := newTestServer().ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatalf("ServeStdio() error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d responses, want 2:\n%s", len(lines), out.String())
+	}
+	byID := make(map[float64]map[string]any)
+	for _, line := range lines {
+		var resp map[string]any
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", line, err)
+		}
+		byID[resp["id"].(float64)] = resp
+	}
+	content := byID[2]["result"].(map[string]any)["content"].([]any)
+	if text := content[0].(map[string]any)["text"]; text != "over stdio" {
+		t.Errorf("echo text = %v", text)
+	}
+}
+
+func TestServeStdioCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- newTestServer().ServeStdio(ctx, pr, io.Discard) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ServeStdio() = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeStdio did not return after cancel")
+	}
+}
+
+func TestAddFuncAndJSONResult(t *testing.T) {
+	s := NewServer("test", "1.0.0")
+	s.AddFunc("greet", "Greets someone", nil, func(ctx context.Context, args map[string]any) (string, error) {
+		return "hello " + args["name"].(string), nil
+	})
+	s.AddTool(Tool{
+		Name: "stats",
+		Handler: func(ctx context.Context, args json.RawMessage) (*Result, error) {
+			return JSONResult(map[string]int{"count": 3})
+		},
+	})
+
+	out := call(t, s, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"greet","arguments":{"name":"go"}}}`)
+	content := out["result"].(map[string]any)["content"].([]any)
+	if text := content[0].(map[string]any)["text"]; text != "hello go" {
+		t.Errorf("greet text = %v", text)
+	}
+
+	out = call(t, s, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"stats"}}`)
+	result := out["result"].(map[string]any)
+	if sc := result["structuredContent"].(map[string]any); sc["count"] != float64(3) {
+		t.Errorf("structuredContent = %v", sc)
+	}
+	if text := result["content"].([]any)[0].(map[string]any)["text"]; text != `{"count":3}` {
+		t.Errorf("text = %v", text)
+	}
+}
+
+func TestTypedTool(t *testing.T) {
+	type input struct {
+		SKU   string `json:"sku" description:"Stock keeping unit"`
+		Count int    `json:"count" jsonschema:"minimum=1"`
+	}
+
+	tool, err := TypedTool("reserve", "Reserves stock", func(ctx context.Context, in input) (*Result, error) {
+		return TextResult(fmt.Sprintf("%s x%d", in.SKU, in.Count)), nil
+	})
+	if err != nil {
+		t.Fatalf("TypedTool() error: %v", err)
+	}
+	props := tool.InputSchema["properties"].(map[string]any)
+	if props["sku"].(map[string]any)["description"] != "Stock keeping unit" {
+		t.Errorf("inputSchema = %v", tool.InputSchema)
+	}
+
+	s := NewServer("test", "1.0.0")
+	s.AddTool(tool)
+
+	out := call(t, s, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"reserve","arguments":{"sku":"A1","count":2}}}`)
+	content := out["result"].(map[string]any)["content"].([]any)
+	if text := content[0].(map[string]any)["text"]; text != "A1 x2" {
+		t.Errorf("text = %v", text)
+	}
+
+	out = call(t, s, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"reserve","arguments":{"sku":"A1","count":0}}}`)
+	result := out["result"].(map[string]any)
+	if result["isError"] != true || !strings.Contains(fmt.Sprint(result["content"]), "minimum") {
+		t.Errorf("invalid arguments result = %v", result)
+	}
+
+	if _, err := TypedTool("bad", "", func(ctx context.Context, in string) (*Result, error) { return nil, nil }); err == nil {
+		t.Error("non-object input type should be rejected")
+	}
+}
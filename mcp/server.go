@@ -0,0 +1,363 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/MateoSegura/claudesdk-go/schema"
+)
+
+// DefaultProtocolVersion is advertised when the client does not request one.
+const DefaultProtocolVersion = "2025-06-18"
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Handler executes a tool call with the raw JSON arguments.
+//
+// Returning an error reports a failed call to Claude (isError result) rather
+// than a protocol error, so the model can see what went wrong and retry.
+type Handler func(ctx context.Context, args json.RawMessage) (*Result, error)
+
+// Tool is a callable tool exposed to Claude.
+type Tool struct {
+	// Name is the tool name. Claude sees it as mcp__<server>__<name>.
+	Name string `json:"name"`
+
+	// Description tells Claude what the tool does and when to use it.
+	Description string `json:"description,omitempty"`
+
+	// InputSchema is the JSON Schema for the tool arguments.
+	// Nil means an object with no declared properties.
+	InputSchema map[string]any `json:"inputSchema"`
+
+	// Handler runs the tool.
+	Handler Handler `json:"-"`
+}
+
+// Content is a single item of tool output.
+type Content struct {
+	// Type is "text" or "image".
+	Type string `json:"type"`
+
+	// Text is the content for "text" items.
+	Text string `json:"text,omitempty"`
+
+	// Data is base64-encoded data for "image" items.
+	Data string `json:"data,omitempty"`
+
+	// MimeType is the media type for "image" items.
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// Result is the output of a tool call.
+type Result struct {
+	// Content is shown to Claude.
+	Content []Content `json:"content"`
+
+	// StructuredContent optionally carries the result as a JSON value.
+	StructuredContent any `json:"structuredContent,omitempty"`
+
+	// IsError marks the call as failed.
+	IsError bool `json:"isError,omitempty"`
+}
+
+// TextResult returns a result with a single text item.
+func TextResult(text string) *Result {
+	return &Result{Content: []Content{{Type: "text", Text: text}}}
+}
+
+// ErrorResult returns a failed result with a single text item.
+func ErrorResult(text string) *Result {
+	return &Result{Content: []Content{{Type: "text", Text: text}}, IsError: true}
+}
+
+// JSONResult returns a result carrying v both as JSON text and as
+// structured content.
+func JSONResult(v any) (*Result, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal result: %w", err)
+	}
+	return &Result{
+		Content:           []Content{{Type: "text", Text: string(data)}},
+		StructuredContent: v,
+	}, nil
+}
+
+// Server dispatches MCP requests to registered tools.
+//
+// Server implements http.Handler for the streamable HTTP transport; use
+// ServeStdio for the stdio transport or ServeLocal for a loopback endpoint.
+// Tools may be added at any time and Server is safe for concurrent use.
+type Server struct {
+	name    string
+	version string
+
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// NewServer creates a server that identifies itself with name and version.
+func NewServer(name, version string) *Server {
+	return &Server{name: name, version: version, tools: make(map[string]Tool)}
+}
+
+// Name returns the server name given to NewServer.
+func (s *Server) Name() string {
+	return s.name
+}
+
+// AddTool registers a tool, replacing any tool with the same name.
+func (s *Server) AddTool(t Tool) {
+	if t.InputSchema == nil {
+		t.InputSchema = map[string]any{"type": "object"}
+	}
+	s.mu.Lock()
+	s.tools[t.Name] = t
+	s.mu.Unlock()
+}
+
+// AddFunc registers fn as a tool taking arguments as a generic map.
+//
+// The returned string is sent to Claude as text.
+func (s *Server) AddFunc(name, description string, schema map[string]any, fn func(ctx context.Context, args map[string]any) (string, error)) {
+	s.AddTool(Tool{
+		Name:        name,
+		Description: description,
+		InputSchema: schema,
+		Handler: func(ctx context.Context, raw json.RawMessage) (*Result, error) {
+			var args map[string]any
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			text, err := fn(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			return TextResult(text), nil
+		},
+	})
+}
+
+// Tools returns the registered tools sorted by name.
+func (s *Server) Tools() []Tool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tools := make([]Tool, 0, len(s.tools))
+	for _, t := range s.tools {
+		tools = append(tools, t)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Handle processes one JSON-RPC message and returns the encoded response,
+// or nil for notifications. Transports call Handle once per message.
+func (s *Server) Handle(ctx context.Context, data []byte) []byte {
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return encode(response{
+			JSONRPC: "2.0",
+			ID:      json.RawMessage("null"),
+			Error:   &rpcError{Code: CodeParseError, Message: err.Error()},
+		})
+	}
+
+	// Notifications (no id) never get a response.
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	result, rerr := s.dispatch(ctx, req)
+	resp := response{JSONRPC: "2.0", ID: req.ID}
+	if rerr != nil {
+		resp.Error = rerr
+	} else {
+		resp.Result = result
+	}
+	return encode(resp)
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := params.ProtocolVersion
+		if version == "" {
+			version = DefaultProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": s.name, "version": s.version},
+		}, nil
+
+	case "ping":
+		return map[string]any{}, nil
+
+	case "tools/list":
+		return map[string]any{"tools": s.Tools()}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: CodeInvalidParams, Message: err.Error()}
+		}
+
+		s.mu.RLock()
+		tool, ok := s.tools[params.Name]
+		s.mu.RUnlock()
+		if !ok {
+			return nil, &rpcError{Code: CodeInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+		}
+
+		args := params.Arguments
+		if len(args) == 0 || string(args) == "null" {
+			args = json.RawMessage("{}")
+		}
+		result, err := tool.Handler(ctx, args)
+		if err != nil {
+			return ErrorResult(err.Error()), nil
+		}
+		if result == nil {
+			result = &Result{}
+		}
+		if result.Content == nil {
+			result.Content = []Content{}
+		}
+		return result, nil
+
+	default:
+		return nil, &rpcError{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+	}
+}
+
+func encode(resp response) []byte {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(response{
+			JSONRPC: "2.0",
+			ID:      resp.ID,
+			Error:   &rpcError{Code: CodeInternalError, Message: err.Error()},
+		})
+	}
+	return data
+}
+
+// ServeHTTP implements the MCP streamable HTTP transport for clients that
+// send one JSON-RPC message per POST and accept a JSON response.
+//
+// ServeHTTP performs no authentication; mount it behind your own
+// middleware, or use ServeLocal which requires a bearer token.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		// Session termination; the server is stateless.
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		// No server-initiated stream (GET) is offered.
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := s.Handle(r.Context(), data)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// TypedTool builds a Tool whose input schema is derived from In with
+// schema.For. Arguments are validated against the schema and decoded into
+// In before fn runs; invalid arguments are reported to Claude as a failed
+// call.
+//
+// Example:
+//
+//	type stockInput struct {
+//		SKU string `json:"sku" description:"Stock keeping unit"`
+//	}
+//
+//	tool, err := mcp.TypedTool("stock_level", "Returns units in stock",
+//		func(ctx context.Context, in stockInput) (*mcp.Result, error) {
+//			return mcp.TextResult(fmt.Sprint(inventory.Stock(in.SKU))), nil
+//		})
+func TypedTool[In any](name, description string, fn func(ctx context.Context, in In) (*Result, error)) (Tool, error) {
+	s, err := schema.For[In]()
+	if err != nil {
+		return Tool{}, fmt.Errorf("tool %s: %w", name, err)
+	}
+	if s["type"] != "object" {
+		return Tool{}, fmt.Errorf("tool %s: input type must be a struct or map, got %v", name, s["type"])
+	}
+
+	return Tool{
+		Name:        name,
+		Description: description,
+		InputSchema: s,
+		Handler: func(ctx context.Context, args json.RawMessage) (*Result, error) {
+			var raw any
+			if err := json.Unmarshal(args, &raw); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			if err := schema.Validate(s, raw); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			var in In
+			if err := json.Unmarshal(args, &in); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+			return fn(ctx, in)
+		},
+	}, nil
+}
@@ -0,0 +1,77 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+)
+
+// ServeStdio serves s over the MCP stdio transport: newline-delimited
+// JSON-RPC messages on r, responses on w.
+//
+// Use it from a standalone binary that Claude launches as a stdio server
+// (claude.MCPServer{Command: "/path/to/binary"}):
+//
+//	func main() {
+//		srv := mcp.NewServer("inventory", "1.0.0")
+//		srv.AddTool(...)
+//		if err := srv.ServeStdio(context.Background(), os.Stdin, os.Stdout); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+//
+// Requests are handled concurrently. ServeStdio returns nil when r reaches
+// EOF, or ctx.Err() if ctx is cancelled first.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+	)
+	defer wg.Wait()
+
+	lines := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		errc <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-errc:
+			return err
+
+		case line := <-lines:
+			if len(line) == 0 {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp := s.Handle(ctx, line)
+				if resp == nil {
+					return
+				}
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				w.Write(append(resp, '\n'))
+			}()
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package claude
 
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
 // Usage tracks token consumption for a session.
 type Usage struct {
 	// InputTokens is the total input tokens consumed.
@@ -23,6 +31,29 @@ func (u *Usage) TotalTokens() int {
 	return u.InputTokens + u.OutputTokens
 }
 
+// ModelUsage is the token consumption and cost of one model, as reported
+// per model in result messages. Unlike Usage, which covers the latest
+// turn, it accumulates over the whole session.
+type ModelUsage struct {
+	// InputTokens is the total input tokens consumed.
+	InputTokens int `json:"inputTokens"`
+
+	// OutputTokens is the total output tokens generated.
+	OutputTokens int `json:"outputTokens"`
+
+	// CacheReadInputTokens is tokens read from prompt cache.
+	CacheReadInputTokens int `json:"cacheReadInputTokens"`
+
+	// CacheCreationInputTokens is tokens used to create prompt cache entries.
+	CacheCreationInputTokens int `json:"cacheCreationInputTokens"`
+
+	// WebSearchRequests is the number of server-side web searches.
+	WebSearchRequests int `json:"webSearchRequests"`
+
+	// CostUSD is the cost the CLI computed for this model.
+	CostUSD float64 `json:"costUSD"`
+}
+
 // StreamMessage represents a message from Claude's JSON stream output.
 //
 // Claude CLI with --output-format stream-json produces newline-delimited JSON
@@ -34,9 +65,15 @@ func (u *Usage) TotalTokens() int {
 //   - "assistant": Claude's response with content blocks (text, tool_use, thinking)
 //   - "user": User/tool result messages
 //   - "result": Final result with cost/duration/usage metrics
+//   - "stream_event": Partial API streaming event (IncludePartialMessages)
 //   - "error": Error information
+//
+// Fields the SDK does not model yet are kept: Extra holds unknown
+// top-level keys, and Raw the original line if LaunchOptions.KeepRawMessages
+// is set.
 type StreamMessage struct {
-	// Type identifies the message kind: "system", "assistant", "user", "result", "error"
+	// Type identifies the message kind: "system", "assistant", "user",
+	// "result", "stream_event", "error"
 	Type string `json:"type"`
 
 	// Subtype provides additional classification.
@@ -73,6 +110,13 @@ type StreamMessage struct {
 	// Text contains direct text content for some message types.
 	Text string `json:"text,omitempty"`
 
+	// --- Partial message fields (type="stream_event") ---
+
+	// Event is the raw API streaming event. Only sent when
+	// IncludePartialMessages is set. Use PartialAccumulator to rebuild
+	// complete content blocks from a sequence of events.
+	Event *StreamEvent `json:"event,omitempty"`
+
 	// --- Result fields (type="result") ---
 
 	// Result contains the final text output.
@@ -99,9 +143,142 @@ type StreamMessage struct {
 	// Usage contains token consumption data.
 	Usage *Usage `json:"usage,omitempty"`
 
+	// ModelUsage breaks the session's cumulative usage down by model
+	// name, including models used by subagents.
+	ModelUsage map[string]ModelUsage `json:"modelUsage,omitempty"`
+
 	// StructuredOutput contains validated JSON when --json-schema was used.
 	// Type depends on the schema; typically map[string]any after JSON unmarshal.
 	StructuredOutput any `json:"structured_output,omitempty"`
+
+	// --- Forward compatibility ---
+
+	// Raw is the line the message was decoded from, if
+	// LaunchOptions.KeepRawMessages (or Decoder.KeepRaw) is set. Empty
+	// otherwise, and for messages built in Go.
+	Raw json.RawMessage `json:"-"`
+
+	// Extra holds top-level keys that have no field above, such as those
+	// of newer message types or subtypes. Marshaling writes them back.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// streamMessageKeys returns the JSON keys of StreamMessage's fields.
+var streamMessageKeys = sync.OnceValue(func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeFor[StreamMessage]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+})
+
+// UnmarshalJSON decodes a message, keeping unknown keys in Extra.
+func (m *StreamMessage) UnmarshalJSON(data []byte) error {
+	type plain StreamMessage
+	var msg plain
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	*m = StreamMessage(msg)
+	m.Extra = unknownMembers(data, streamMessageKeys())
+	return nil
+}
+
+// unknownMembers returns copies of the members of the JSON object data
+// whose keys are not in known, or nil if there are none. data must be
+// valid JSON; unlike a second json.Unmarshal, the scan only allocates for
+// the members it returns.
+func unknownMembers(data []byte, known map[string]bool) map[string]json.RawMessage {
+	i := skipSpace(data, 0)
+	if i == len(data) || data[i] != '{' {
+		return nil
+	}
+	var extra map[string]json.RawMessage
+	for i = skipSpace(data, i+1); i < len(data) && data[i] == '"'; i = skipSpace(data, i) {
+		keyEnd := skipValue(data, i)
+		key := string(data[i+1 : keyEnd-1])
+		if bytes.IndexByte(data[i:keyEnd], '\\') >= 0 {
+			json.Unmarshal(data[i:keyEnd], &key)
+		}
+		start := skipSpace(data, skipSpace(data, keyEnd)+1) // past ':'
+		i = skipValue(data, start)
+		if !known[key] {
+			if extra == nil {
+				extra = make(map[string]json.RawMessage)
+			}
+			extra[key] = bytes.Clone(data[start:i])
+		}
+		if i = skipSpace(data, i); i < len(data) && data[i] == ',' {
+			i = skipSpace(data, i+1)
+		}
+	}
+	return extra
+}
+
+// skipSpace returns the index of the first non-whitespace byte at or after i.
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// skipValue returns the index just past the JSON value starting at i.
+func skipValue(data []byte, i int) int {
+	depth := 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+		case '{', '[':
+			depth++
+			continue
+		case '}', ']':
+			if depth == 0 {
+				return i // end of a scalar member
+			}
+			depth--
+		case ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				return i
+			}
+			continue
+		default:
+			continue
+		}
+		if depth == 0 {
+			return i + 1
+		}
+	}
+	return i
+}
+
+// MarshalJSON encodes a message, including the keys in Extra. Raw is not
+// used.
+func (m StreamMessage) MarshalJSON() ([]byte, error) {
+	type plain StreamMessage
+	data, err := json.Marshal(plain(m))
+	if err != nil || len(m.Extra) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for key, value := range m.Extra {
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return json.Marshal(fields)
 }
 
 // MessageContent represents the content of an assistant or user message.
@@ -109,8 +286,22 @@ type MessageContent struct {
 	// Role is typically "assistant" for Claude's responses or "user" for tool results.
 	Role string `json:"role,omitempty"`
 
-	// Content is the list of content blocks in this message.
-	Content []ContentBlock `json:"content,omitempty"`
+	// Content is the list of content blocks in this message. A plain
+	// string content, as in typed user prompts, decodes as one text block.
+	Content Blocks `json:"content,omitempty"`
+
+	// ID is the API message identifier (assistant messages).
+	ID string `json:"id,omitempty"`
+
+	// Model is the model that produced this message (assistant messages).
+	Model string `json:"model,omitempty"`
+
+	// StopReason is why generation stopped: "end_turn", "tool_use",
+	// "max_tokens", etc. Empty while the message is still streaming.
+	StopReason string `json:"stop_reason,omitempty"`
+
+	// Usage is the per-message token usage (assistant messages).
+	Usage *Usage `json:"usage,omitempty"`
 }
 
 // ContentBlock represents a single content block in a message.
@@ -118,10 +309,16 @@ type MessageContent struct {
 // Content blocks can be:
 //   - Text blocks (Type="text"): Contains Text field
 //   - Thinking blocks (Type="thinking"): Contains Thinking field
-//   - Tool use blocks (Type="tool_use"): Contains Name, ID, and Input fields
-//   - Tool result blocks (Type="tool_result"): Contains ToolUseID and Content fields
+//   - Redacted thinking blocks (Type="redacted_thinking"): Contains Data field
+//   - Tool use blocks (Type="tool_use" or "server_tool_use"): Contains Name, ID, and Input fields
+//   - Tool result blocks (Type="tool_result" or "web_search_tool_result"): Contains ToolUseID and Content fields
+//   - Image and document blocks (Type="image" or "document"): Contains Source field
+//   - Web search results (Type="web_search_result"): Contains URL, Title, and PageAge fields
 type ContentBlock struct {
-	// Type identifies the block kind: "text", "thinking", "tool_use", "tool_result"
+	// Type identifies the block kind: "text", "thinking",
+	// "redacted_thinking", "tool_use", "server_tool_use", "tool_result",
+	// "web_search_tool_result", "web_search_result",
+	// "web_search_tool_result_error", "image", "document"
 	Type string `json:"type"`
 
 	// --- Text blocks ---
@@ -134,15 +331,23 @@ type ContentBlock struct {
 	// Thinking contains Claude's internal reasoning for "thinking" type blocks.
 	Thinking string `json:"thinking,omitempty"`
 
+	// Signature verifies thinking content for "thinking" type blocks.
+	Signature string `json:"signature,omitempty"`
+
+	// Data is the encrypted reasoning of "redacted_thinking" type blocks.
+	Data string `json:"data,omitempty"`
+
 	// --- Tool use blocks ---
 
-	// Name is the tool name for "tool_use" type blocks.
+	// Name is the tool name for "tool_use" and "server_tool_use" type
+	// blocks, e.g. "web_search" for server tools.
 	Name string `json:"name,omitempty"`
 
 	// ID is the unique identifier for tool use blocks, used to match with tool results.
 	ID string `json:"id,omitempty"`
 
-	// Input contains the tool arguments for "tool_use" type blocks.
+	// Input contains the tool arguments for "tool_use" and
+	// "server_tool_use" type blocks.
 	Input map[string]any `json:"input,omitempty"`
 
 	// --- Tool result blocks ---
@@ -150,9 +355,110 @@ type ContentBlock struct {
 	// ToolUseID references the tool_use block this result corresponds to.
 	ToolUseID string `json:"tool_use_id,omitempty"`
 
-	// Content contains the tool result content for "tool_result" type blocks.
-	// Uses the JSON key "content" which is distinct from the "text" key.
-	Content string `json:"content,omitempty"`
+	// Content contains the result of "tool_result" type blocks: text,
+	// image, and document blocks. For "web_search_tool_result" blocks it
+	// holds "web_search_result" blocks, or one
+	// "web_search_tool_result_error" block. Use ContentText to flatten it.
+	Content Blocks `json:"content,omitempty"`
+
+	// IsError is true if the tool failed, for "tool_result" type blocks.
+	IsError bool `json:"is_error,omitempty"`
+
+	// ErrorCode is the failure reason of "web_search_tool_result_error"
+	// type blocks, e.g. "max_uses_exceeded".
+	ErrorCode string `json:"error_code,omitempty"`
+
+	// --- Image and document blocks ---
+
+	// Source holds the data of "image" and "document" type blocks.
+	Source *BlockSource `json:"source,omitempty"`
+
+	// Title names the document for "document" type blocks and the page
+	// for "web_search_result" type blocks.
+	Title string `json:"title,omitempty"`
+
+	// --- Web search results ---
+
+	// URL is the page address of "web_search_result" type blocks.
+	URL string `json:"url,omitempty"`
+
+	// PageAge is how long ago the page was updated, e.g. "2 days ago",
+	// for "web_search_result" type blocks.
+	PageAge string `json:"page_age,omitempty"`
+
+	// EncryptedContent is the page content of "web_search_result" type
+	// blocks, opaque to clients.
+	EncryptedContent string `json:"encrypted_content,omitempty"`
+}
+
+// ContentText returns the text of a tool result: its text blocks joined
+// by newlines. Image and document blocks are skipped.
+func (c *ContentBlock) ContentText() string {
+	return c.Content.Text()
+}
+
+// Blocks is a list of content blocks.
+//
+// It decodes from the polymorphic "content" field of messages and tool
+// results: a JSON string becomes one text block, a single object one
+// block, and an array a block each. It always encodes as an array.
+type Blocks []ContentBlock
+
+// UnmarshalJSON decodes a string, object, or array of content blocks.
+func (b *Blocks) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0 || bytes.Equal(data, []byte("null")):
+		*b = nil
+
+	case data[0] == '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*b = nil
+		if text != "" {
+			*b = Blocks{{Type: "text", Text: text}}
+		}
+
+	case data[0] == '{':
+		var block ContentBlock
+		if err := json.Unmarshal(data, &block); err != nil {
+			return err
+		}
+		*b = Blocks{block}
+
+	default:
+		var blocks []ContentBlock
+		if err := json.Unmarshal(data, &blocks); err != nil {
+			return err
+		}
+		*b = blocks
+	}
+	return nil
+}
+
+// Text returns the text blocks joined by newlines.
+func (b Blocks) Text() string {
+	var parts []string
+	for _, block := range b {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// BlockSource is the data of an image or document content block.
+type BlockSource struct {
+	// Type is "base64" for images and PDFs, or "text" for plain text.
+	Type string `json:"type"`
+
+	// MediaType is the MIME type, e.g. "image/png" or "application/pdf".
+	MediaType string `json:"media_type"`
+
+	// Data is the base64-encoded or plain text content.
+	Data string `json:"data"`
 }
 
 // IsToolUse returns true if this block represents a tool invocation.
@@ -175,6 +481,107 @@ func (c *ContentBlock) IsToolResult() bool {
 	return c.Type == "tool_result"
 }
 
+// IsServerToolUse returns true if this block is a tool invocation run by
+// the API rather than the CLI, such as web search.
+func (c *ContentBlock) IsServerToolUse() bool {
+	return c.Type == "server_tool_use"
+}
+
+// IsRedactedThinking returns true if this block contains encrypted
+// reasoning.
+func (c *ContentBlock) IsRedactedThinking() bool {
+	return c.Type == "redacted_thinking"
+}
+
+// IsImage returns true if this block contains an image.
+func (c *ContentBlock) IsImage() bool {
+	return c.Type == "image"
+}
+
+// IsDocument returns true if this block contains a PDF or text document.
+func (c *ContentBlock) IsDocument() bool {
+	return c.Type == "document"
+}
+
+// StreamEvent is a partial API streaming event carried by a "stream_event"
+// message when IncludePartialMessages is set.
+//
+// A message streams as:
+//
+//	message_start                  Message carries id, model, and input usage
+//	content_block_start            ContentBlock is the (empty) block at Index
+//	content_block_delta ...        Delta carries text, thinking, JSON, or signature
+//	content_block_stop             the block at Index is complete
+//	message_delta                  Delta.StopReason and final Usage
+//	message_stop
+type StreamEvent struct {
+	// Type is the event kind: "message_start", "content_block_start",
+	// "content_block_delta", "content_block_stop", "message_delta",
+	// "message_stop".
+	Type string `json:"type"`
+
+	// Index is the content block position for content_block_* events.
+	Index int `json:"index"`
+
+	// Message is the message skeleton for message_start.
+	Message *MessageContent `json:"message,omitempty"`
+
+	// ContentBlock is the initial block for content_block_start.
+	ContentBlock *ContentBlock `json:"content_block,omitempty"`
+
+	// Delta is the increment for content_block_delta and message_delta.
+	Delta *EventDelta `json:"delta,omitempty"`
+
+	// Usage is the cumulative token usage for message_delta.
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// EventDelta is the payload of a content_block_delta or message_delta event.
+//
+// For content_block_delta, Type is one of "text_delta" (Text),
+// "thinking_delta" (Thinking), "input_json_delta" (PartialJSON), or
+// "signature_delta" (Signature). For message_delta, Type is empty and
+// StopReason is set.
+type EventDelta struct {
+	// Type identifies the delta kind for content_block_delta events.
+	Type string `json:"type,omitempty"`
+
+	// Text is appended to a text block.
+	Text string `json:"text,omitempty"`
+
+	// Thinking is appended to a thinking block.
+	Thinking string `json:"thinking,omitempty"`
+
+	// PartialJSON is a fragment of a tool_use block's JSON input.
+	PartialJSON string `json:"partial_json,omitempty"`
+
+	// Signature is a thinking block's signature.
+	Signature string `json:"signature,omitempty"`
+
+	// StopReason is set on message_delta events.
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+// NewUserMessage builds a user turn for stream-json input.
+//
+// Used with Launcher.SendMessage and Conversation when InputFormat is
+// InputFormatStreamJSON. Blocks, such as attachments converted with
+// Attachment.ContentBlock, precede the text; empty text is omitted when
+// blocks are given.
+func NewUserMessage(text string, blocks ...ContentBlock) StreamMessage {
+	content := append([]ContentBlock(nil), blocks...)
+	if text != "" || len(content) == 0 {
+		content = append(content, ContentBlock{Type: "text", Text: text})
+	}
+	return StreamMessage{
+		Type: "user",
+		Message: &MessageContent{
+			Role:    "user",
+			Content: content,
+		},
+	}
+}
+
 // TodoItem represents a todo item from Claude's TodoWrite tool.
 type TodoItem struct {
 	// ID is the unique identifier for this todo.
@@ -0,0 +1,36 @@
+// Package metrics exports session metrics in the Prometheus text
+// exposition format, without depending on a Prometheus client library.
+//
+// A Collector is fed by claude.Hooks and served as an http.Handler:
+//
+//	collector := metrics.New(metrics.Config{})
+//	http.Handle("/metrics", collector)
+//
+//	session, _ := claude.NewSession(claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{Hooks: collector.Hooks()},
+//	})
+//
+// Call Hooks once per session: the hooks keep per-process state. A Pool
+// does this when given the method as its hook factory:
+//
+//	pool, _ := claude.NewPool(claude.PoolConfig{NewHooks: collector.Hooks})
+//
+// Use claude.ChainHooks to combine them with other hooks.
+//
+// # Metrics
+//
+// With the default namespace:
+//
+//	claude_sessions_started_total                  counter
+//	claude_sessions_finished_total{outcome}        counter    success, killed, error, or a result subtype such as error_max_turns
+//	claude_sessions_active                         gauge
+//	claude_tokens_total{type,model}                counter    type is input, output, cache_read, or cache_creation
+//	claude_cost_usd_total{model}                   counter    cost reported by the CLI
+//	claude_turns                                   histogram  agentic turns per result
+//	claude_tool_invocations_total{tool}            counter
+//	claude_time_to_first_message_seconds           histogram  process start to first stream message
+//	claude_session_duration_seconds                histogram  process start to exit
+//
+// Tokens and cost are attributed per model from the result's model usage
+// breakdown, which includes subagents, and fall back to the session model.
+package metrics
@@ -0,0 +1,354 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+)
+
+// DefaultNamespace prefixes metric names when Config.Namespace is empty.
+const DefaultNamespace = "claude"
+
+// Default histogram buckets.
+var (
+	// TimeToFirstMessageBuckets are in seconds.
+	TimeToFirstMessageBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+	// DurationBuckets are in seconds.
+	DurationBuckets = []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+	// TurnBuckets count agentic turns.
+	TurnBuckets = []float64{1, 2, 3, 5, 8, 13, 21, 34, 55, 89}
+)
+
+// Config configures a Collector.
+type Config struct {
+	// Namespace prefixes every metric name, as in "<namespace>_tokens_total".
+	// Defaults to DefaultNamespace if empty.
+	Namespace string
+}
+
+// Collector aggregates session metrics and serves them in the Prometheus
+// text exposition format. It is safe for concurrent use, and one
+// Collector is meant to be shared by every session in a process.
+type Collector struct {
+	mu       sync.Mutex
+	families []*family
+
+	started  *family
+	finished *family
+	active   *family
+	tokens   *family
+	cost     *family
+	turns    *family
+	tools    *family
+	ttfm     *family
+	duration *family
+}
+
+// New creates a Collector.
+func New(cfg Config) *Collector {
+	ns := cfg.Namespace
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	c := &Collector{}
+	def := func(name, typ, help string, buckets []float64, labels ...string) *family {
+		f := &family{
+			name:    ns + "_" + name,
+			typ:     typ,
+			help:    help,
+			labels:  labels,
+			buckets: buckets,
+			series:  make(map[string]*series),
+		}
+		c.families = append(c.families, f)
+		return f
+	}
+	c.started = def("sessions_started_total", "counter", "CLI processes started.", nil)
+	c.finished = def("sessions_finished_total", "counter", "CLI processes exited, by outcome: success, killed, error, or an error result subtype.", nil, "outcome")
+	c.active = def("sessions_active", "gauge", "CLI processes running.", nil)
+	c.tokens = def("tokens_total", "counter", "Tokens consumed, by type (input, output, cache_read, cache_creation) and model.", nil, "type", "model")
+	c.cost = def("cost_usd_total", "counter", "Cost reported by the CLI in USD, by model.", nil, "model")
+	c.turns = def("turns", "histogram", "Agentic turns per result.", TurnBuckets)
+	c.tools = def("tool_invocations_total", "counter", "Tool calls, by tool name.", nil, "tool")
+	c.ttfm = def("time_to_first_message_seconds", "histogram", "Time from process start to the first stream message.", TimeToFirstMessageBuckets)
+	c.duration = def("session_duration_seconds", "histogram", "Time from process start to exit.", DurationBuckets)
+	return c
+}
+
+// Hooks returns claude.Hooks that feed the collector from one session.
+//
+// The hooks track per-process state (start time, cumulative usage), so
+// call Hooks for each session rather than sharing one value between
+// sessions that run concurrently. Reusing it for sessions that run one
+// after another, as RetryPolicy attempts do, is fine. For a claude.Pool,
+// set PoolConfig.NewHooks to c.Hooks so each task gets its own. Combine
+// it with other hooks using claude.ChainHooks.
+func (c *Collector) Hooks() *claude.Hooks {
+	s := &sessionState{c: c}
+	return &claude.Hooks{
+		OnStart:    s.onStart,
+		OnMessage:  s.onMessage,
+		OnToolCall: func(name string, _ map[string]any) { c.add(c.tools, 1, name) },
+		OnMetrics:  s.onMetrics,
+		OnExit:     s.onExit,
+	}
+}
+
+// sessionState is the per-process state behind Hooks.
+type sessionState struct {
+	c *Collector
+
+	mu        sync.Mutex
+	start     time.Time
+	seenFirst bool
+	subtype   string
+	isError   bool
+	usage     map[string]claude.ModelUsage // last cumulative usage per model
+	totalCost float64                      // last cumulative cost
+}
+
+func (s *sessionState) onStart(int) {
+	s.mu.Lock()
+	s.start = time.Now()
+	s.seenFirst = false
+	s.subtype, s.isError = "", false
+	s.usage, s.totalCost = nil, 0
+	s.mu.Unlock()
+
+	s.c.add(s.c.started, 1)
+	s.c.add(s.c.active, 1)
+}
+
+func (s *sessionState) onMessage(msg claude.StreamMessage) {
+	s.mu.Lock()
+	first := !s.seenFirst && !s.start.IsZero()
+	s.seenFirst = true
+	elapsed := time.Since(s.start)
+	if msg.Type == "result" {
+		s.subtype, s.isError = msg.Subtype, msg.IsErrorResult
+	}
+	s.mu.Unlock()
+
+	if first {
+		s.c.observe(s.c.ttfm, elapsed.Seconds())
+	}
+}
+
+func (s *sessionState) onMetrics(m claude.SessionMetrics) {
+	c := s.c
+	c.observe(c.turns, float64(m.NumTurns))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(m.ModelUsage) == 0 {
+		// Usage covers the latest turn; the cost is cumulative.
+		c.addTokens(m.Model, claude.ModelUsage{
+			InputTokens:              m.InputTokens,
+			OutputTokens:             m.OutputTokens,
+			CacheReadInputTokens:     m.CacheReadInputTokens,
+			CacheCreationInputTokens: m.CacheCreationInputTokens,
+		})
+		if d := m.TotalCostUSD - s.totalCost; d > 0 {
+			c.add(c.cost, d, m.Model)
+		}
+		s.totalCost = m.TotalCostUSD
+		return
+	}
+
+	// ModelUsage is cumulative for the session: count the increase.
+	for model, u := range m.ModelUsage {
+		p := s.usage[model]
+		c.addTokens(model, claude.ModelUsage{
+			InputTokens:              u.InputTokens - p.InputTokens,
+			OutputTokens:             u.OutputTokens - p.OutputTokens,
+			CacheReadInputTokens:     u.CacheReadInputTokens - p.CacheReadInputTokens,
+			CacheCreationInputTokens: u.CacheCreationInputTokens - p.CacheCreationInputTokens,
+		})
+		if d := u.CostUSD - p.CostUSD; d > 0 {
+			c.add(c.cost, d, model)
+		}
+	}
+	s.usage = m.ModelUsage
+	s.totalCost = m.TotalCostUSD
+}
+
+func (s *sessionState) onExit(code int, d time.Duration) {
+	s.mu.Lock()
+	outcome := "success"
+	switch {
+	case s.subtype != "" && (s.isError || s.subtype != "success"):
+		outcome = s.subtype
+	case code < 0:
+		outcome = "killed"
+	case code != 0:
+		outcome = "error"
+	}
+	s.mu.Unlock()
+
+	c := s.c
+	c.add(c.finished, 1, outcome)
+	c.add(c.active, -1)
+	c.observe(c.duration, d.Seconds())
+}
+
+// addTokens adds positive token counts to the tokens family.
+func (c *Collector) addTokens(model string, u claude.ModelUsage) {
+	for _, t := range []struct {
+		typ string
+		n   int
+	}{
+		{"input", u.InputTokens},
+		{"output", u.OutputTokens},
+		{"cache_read", u.CacheReadInputTokens},
+		{"cache_creation", u.CacheCreationInputTokens},
+	} {
+		if t.n > 0 {
+			c.add(c.tokens, float64(t.n), t.typ, model)
+		}
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition
+// format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	c.mu.Lock()
+	for _, f := range c.families {
+		f.write(&buf)
+	}
+	c.mu.Unlock()
+	return buf.WriteTo(w)
+}
+
+func (c *Collector) add(f *family, v float64, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.get(labels).value += v
+}
+
+func (c *Collector) observe(f *family, v float64, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := f.get(labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	for i, b := range f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// family is one metric with its series, keyed by label values.
+type family struct {
+	name, typ, help string
+	labels          []string
+	buckets         []float64 // histograms only
+	series          map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64  // counters and gauges
+	counts []uint64 // cumulative count per bucket
+	sum    float64
+	count  uint64
+}
+
+func (f *family) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: values}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+
+	// Unlabeled metrics are always present, so scrapes see zeros.
+	if len(f.labels) == 0 {
+		f.get(nil)
+	}
+	for _, key := range slices.Sorted(maps.Keys(f.series)) {
+		s := f.series[key]
+		pairs := labelPairs(f.labels, s.labels)
+		if f.typ != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, formatLabels(pairs), formatFloat(s.value))
+			continue
+		}
+		for i, b := range f.buckets {
+			var n uint64
+			if s.counts != nil {
+				n = s.counts[i]
+			}
+			le := append(pairs, [2]string{"le", formatFloat(b)})
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(le), n)
+		}
+		inf := append(pairs, [2]string{"le", "+Inf"})
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(inf), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, formatLabels(pairs), formatFloat(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, formatLabels(pairs), s.count)
+	}
+}
+
+func labelPairs(names, values []string) [][2]string {
+	pairs := make([][2]string, len(names))
+	for i, n := range names {
+		pairs[i] = [2]string{n, values[i]}
+	}
+	return pairs
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(pairs [][2]string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, p := range pairs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, p[0], labelEscaper.Replace(p[1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	claude "github.com/MateoSegura/claudesdk-go"
+	"github.com/MateoSegura/claudesdk-go/claudetest"
+)
+
+// scrape returns the collector's exposition output.
+func scrape(t *testing.T, c *Collector) string {
+	t.Helper()
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// wantLines fails unless every line appears in out.
+func wantLines(t *testing.T, out string, lines ...string) {
+	t.Helper()
+	have := make(map[string]bool)
+	for _, l := range strings.Split(out, "\n") {
+		have[l] = true
+	}
+	for _, l := range lines {
+		if !have[l] {
+			t.Errorf("missing line %q in:\n%s", l, out)
+		}
+	}
+}
+
+func TestCollectorSession(t *testing.T) {
+	c := New(Config{})
+	sp := claudetest.NewSpawner(&claudetest.Scenario{Steps: []claudetest.Step{
+		claudetest.Init("sess-1", "claude-sonnet-4-5"),
+		claudetest.ToolUse("t1", "Read", map[string]any{"file_path": "a.go"}),
+		claudetest.ToolResult("t1", "package a"),
+		claudetest.ToolUse("t2", "Read", map[string]any{"file_path": "b.go"}),
+		claudetest.ToolResult("t2", "package b"),
+		claudetest.Emit(claude.StreamMessage{
+			Type: "result", Subtype: "success", SessionID: "sess-1", NumTurns: 3, TotalCost: 0.5,
+			ModelUsage: map[string]claude.ModelUsage{
+				"claude-sonnet-4-5": {InputTokens: 100, OutputTokens: 20, CacheReadInputTokens: 1000, CostUSD: 0.4},
+				"claude-haiku-4-5":  {InputTokens: 50, CostUSD: 0.1},
+			},
+		}),
+	}})
+	session, _ := claude.NewSession(claude.SessionConfig{
+		LaunchOptions: claude.LaunchOptions{Spawner: sp, Hooks: c.Hooks()},
+	})
+	if _, err := session.RunAndCollect(context.Background(), "hi"); err != nil {
+		t.Fatalf("RunAndCollect() error: %v", err)
+	}
+
+	out := scrape(t, c)
+	wantLines(t, out,
+		"# TYPE claude_sessions_started_total counter",
+		"claude_sessions_started_total 1",
+		`claude_sessions_finished_total{outcome="success"} 1`,
+		"claude_sessions_active 0",
+		`claude_tokens_total{type="input",model="claude-sonnet-4-5"} 100`,
+		`claude_tokens_total{type="output",model="claude-sonnet-4-5"} 20`,
+		`claude_tokens_total{type="cache_read",model="claude-sonnet-4-5"} 1000`,
+		`claude_tokens_total{type="input",model="claude-haiku-4-5"} 50`,
+		`claude_cost_usd_total{model="claude-sonnet-4-5"} 0.4`,
+		`claude_tool_invocations_total{tool="Read"} 2`,
+		`claude_turns_bucket{le="2"} 0`,
+		`claude_turns_bucket{le="3"} 1`,
+		`claude_turns_bucket{le="+Inf"} 1`,
+		"claude_turns_sum 3",
+		"claude_time_to_first_message_seconds_count 1",
+		"claude_session_duration_seconds_count 1",
+	)
+	if strings.Contains(out, `type="cache_creation"`) {
+		t.Error("zero token counts should not create series")
+	}
+}
+
+func TestCollectorHooks(t *testing.T) {
+	c := New(Config{Namespace: "agents"})
+	h := c.Hooks()
+
+	// A conversation: usage accumulates across results.
+	h.OnStart(1)
+	h.OnMessage(claude.StreamMessage{Type: "system"})
+	for _, n := range []int{10, 25} {
+		h.OnMetrics(claude.SessionMetrics{NumTurns: 1, TotalCostUSD: float64(n) / 100, ModelUsage: map[string]claude.ModelUsage{
+			"opus": {InputTokens: n, CostUSD: float64(n) / 100},
+		}})
+	}
+	h.OnMessage(claude.StreamMessage{Type: "result", Subtype: "error_max_turns", IsErrorResult: true})
+	h.OnExit(1, 2*time.Second)
+
+	// Reused for the next process, without ModelUsage.
+	h.OnStart(2)
+	h.OnMetrics(claude.SessionMetrics{Model: "sonnet", InputTokens: 7, TotalCostUSD: 0.05})
+	h.OnExit(-1, time.Second)
+
+	h.OnStart(3)
+	h.OnExit(2, time.Second)
+
+	out := scrape(t, c)
+	wantLines(t, out,
+		"agents_sessions_started_total 3",
+		`agents_sessions_finished_total{outcome="error_max_turns"} 1`,
+		`agents_sessions_finished_total{outcome="killed"} 1`,
+		`agents_sessions_finished_total{outcome="error"} 1`,
+		`agents_tokens_total{type="input",model="opus"} 25`,
+		`agents_cost_usd_total{model="opus"} 0.25`,
+		`agents_tokens_total{type="input",model="sonnet"} 7`,
+		`agents_cost_usd_total{model="sonnet"} 0.05`,
+		"agents_time_to_first_message_seconds_count 1",
+		`agents_session_duration_seconds_bucket{le="1"} 2`,
+		`agents_session_duration_seconds_bucket{le="2.5"} 3`,
+		"agents_session_duration_seconds_sum 4",
+	)
+}
+
+func TestCollectorPool(t *testing.T) {
+	c := New(Config{})
+	sp := claudetest.NewSpawner(&claudetest.Scenario{Steps: []claudetest.Step{
+		claudetest.Init("sess-1", "claude-sonnet-4-5"),
+		claudetest.Delay(50 * time.Millisecond), // keep the sessions overlapping
+		claudetest.Result("done", 0.5),
+	}})
+	pool, _ := claude.NewPool(claude.PoolConfig{
+		MaxConcurrent: 4,
+		Session:       claude.SessionConfig{LaunchOptions: claude.LaunchOptions{Spawner: sp}},
+		NewHooks:      c.Hooks,
+	})
+	defer pool.Close()
+
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := pool.Run(context.Background(), "hi")
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+	}
+
+	wantLines(t, scrape(t, c),
+		"claude_sessions_started_total 4",
+		`claude_sessions_finished_total{outcome="success"} 4`,
+		"claude_sessions_active 0",
+		`claude_cost_usd_total{model="claude-sonnet-4-5"} 2`,
+		"claude_time_to_first_message_seconds_count 4",
+	)
+}
+
+func TestExposition(t *testing.T) {
+	c := New(Config{})
+	out := scrape(t, c)
+
+	// Unlabeled metrics are exported at zero before any session runs.
+	wantLines(t, out,
+		"# HELP claude_sessions_active CLI processes running.",
+		"claude_sessions_active 0",
+		`claude_turns_bucket{le="+Inf"} 0`,
+		"claude_turns_count 0",
+	)
+	if strings.Contains(out, "claude_tokens_total{") {
+		t.Error("labeled metrics should have no series yet")
+	}
+
+	c.Hooks().OnToolCall("mcp__x__\"odd\"\\tool\n", nil)
+	wantLines(t, scrape(t, c), `claude_tool_invocations_total{tool="mcp__x__\"odd\"\\tool\n"} 1`)
+}
@@ -1,6 +1,11 @@
 package claude
 
-import "time"
+import (
+	"log/slog"
+	"time"
+
+	"github.com/MateoSegura/claudesdk-go/mcp"
+)
 
 // PermissionMode controls how Claude handles tool permission requests.
 type PermissionMode string
@@ -23,6 +28,15 @@ const (
 	PermissionBypass PermissionMode = "bypassPermissions"
 )
 
+// Input formats accepted by LaunchOptions.InputFormat.
+const (
+	// InputFormatText passes the prompt as a command-line argument.
+	InputFormatText = "text"
+
+	// InputFormatStreamJSON reads newline-delimited user messages from stdin.
+	InputFormatStreamJSON = "stream-json"
+)
+
 // AgentDefinition defines a custom subagent that Claude can invoke via the Task tool.
 //
 // Example:
@@ -107,6 +121,22 @@ type LaunchOptions struct {
 	// prompts in non-interactive (print) mode.
 	PermissionPromptTool string
 
+	// CanUseTool decides tool permissions in-process. The SDK serves it
+	// to the CLI as a loopback MCP tool and sets --permission-prompt-tool
+	// automatically; no MCP server needs to be hosted. Called only for
+	// tool calls the permission mode and AllowedTools do not already
+	// decide. Mutually exclusive with PermissionPromptTool.
+	//
+	// Example:
+	//
+	//	CanUseTool: func(ctx context.Context, tool string, input map[string]any) (claude.Decision, error) {
+	//		if tool == "Bash" {
+	//			return claude.Deny("shell access is disabled"), nil
+	//		}
+	//		return claude.Allow(), nil
+	//	},
+	CanUseTool CanUseToolFunc
+
 	// --- Model & Budget ---
 
 	// Model specifies which Claude model to use.
@@ -217,8 +247,22 @@ type LaunchOptions struct {
 	IncludePartialMessages bool
 
 	// InputFormat specifies the input format: "text" (default) or "stream-json".
+	// With InputFormatStreamJSON the prompt is sent over stdin and stdin is
+	// kept open for follow-up messages (see Conversation).
 	InputFormat string
 
+	// MaxPromptArgBytes is the longest prompt passed as a command-line
+	// argument. Longer prompts are written to stdin instead, avoiding
+	// ARG_MAX limits and keeping them out of ps output. Zero uses
+	// DefaultMaxPromptArgBytes; negative sends every prompt over stdin.
+	MaxPromptArgBytes int
+
+	// Attachments are images, PDFs, or text files sent with the prompt as
+	// content blocks. They require stream-json input, so with text input
+	// the prompt and attachments are written to stdin as one message.
+	// Conversation ignores them; pass attachments to Send instead.
+	Attachments []Attachment
+
 	// --- Configuration ---
 
 	// SettingSources specifies which settings to load.
@@ -246,6 +290,11 @@ type LaunchOptions struct {
 	// Keys that already exist are overwritten.
 	Env map[string]string
 
+	// BinaryPath overrides the CLI executable, e.g. a pinned install or a
+	// wrapper script. Bare names are resolved against PATH.
+	// Defaults to DefaultBinary.
+	BinaryPath string
+
 	// --- Limits ---
 
 	// MaxTurns limits the number of agentic turns.
@@ -256,6 +305,15 @@ type LaunchOptions struct {
 	// Zero means no timeout.
 	Timeout time.Duration
 
+	// MaxLineBytes caps the length of a stream-json line read from the CLI.
+	// Longer lines are skipped and reported as *LineTooLongError, and
+	// reading continues. Zero means no limit.
+	MaxLineBytes int
+
+	// KeepRawMessages keeps a copy of every stream-json line in
+	// StreamMessage.Raw. Unknown keys are kept in Extra either way.
+	KeepRawMessages bool
+
 	// --- MCP ---
 
 	// MCPServers configures MCP servers for this session.
@@ -269,6 +327,19 @@ type LaunchOptions struct {
 	//	}
 	MCPServers map[string]MCPServer
 
+	// LocalMCPServers exposes Go-native MCP servers to Claude. Each server
+	// is served on a token-protected loopback endpoint for the lifetime of
+	// the CLI process and added to MCPServers under its map key, so its
+	// tools appear as mcp__<name>__<tool>. Names must not collide with
+	// MCPServers.
+	//
+	// Example:
+	//
+	//	srv := mcp.NewServer("inventory", "1.0.0")
+	//	srv.AddTool(mcp.Tool{Name: "stock_level", Handler: stockLevel})
+	//	LocalMCPServers: map[string]*mcp.Server{"inventory": srv}
+	LocalMCPServers map[string]*mcp.Server
+
 	// StrictMCP when true, only uses MCP servers from MCPServers,
 	// ignoring all other configured MCP servers.
 	StrictMCP bool
@@ -289,9 +360,47 @@ type LaunchOptions struct {
 	// as struct fields. Use sparingly; prefer structured options.
 	AdditionalArgs []string
 
+	// Spawner replaces the process layer used to run the CLI.
+	// Nil uses ExecSpawner. Useful for in-memory fakes in tests or for
+	// running the CLI somewhere other than a local subprocess.
+	Spawner Spawner
+
+	// HookHandlers registers Go callbacks for the CLI's lifecycle hooks.
+	// Unlike Hooks, which observe the stream after the fact, these run
+	// inside the CLI's hook points and can approve or block tool calls,
+	// reject prompts, keep Claude working, or add context.
+	//
+	// The SDK writes the hook settings (merged with Settings) and serves
+	// the callbacks on a unix socket that the claude-hook-shim command
+	// connects to. Requires the shim in PATH or HookShimPath.
+	//
+	// Example:
+	//
+	//	HookHandlers: map[claude.HookEvent][]claude.HookMatcher{
+	//		claude.HookPreToolUse: {{
+	//			Matcher: "Bash",
+	//			Func: func(ctx context.Context, in claude.HookInput) (claude.HookResult, error) {
+	//				if strings.Contains(fmt.Sprint(in.ToolInput["command"]), "rm -rf") {
+	//					return claude.HookBlock("destructive commands are not allowed"), nil
+	//				}
+	//				return claude.HookResult{}, nil
+	//			},
+	//		}},
+	//	}
+	HookHandlers map[HookEvent][]HookMatcher
+
+	// HookShimPath is the path to the claude-hook-shim binary used by
+	// HookHandlers. Empty looks up DefaultHookShim in PATH.
+	HookShimPath string
+
 	// Hooks provides optional callbacks for observability.
 	// Nil is safe — all hooks are nil-checked before invocation.
 	Hooks *Hooks
+
+	// Logger, if set, receives structured records for process start and
+	// exit, parse errors, tool calls, results, and stderr lines. Secrets
+	// in logged arguments are redacted. Nil disables logging.
+	Logger *slog.Logger
 }
 
 // SessionConfig configures a high-level Session.
@@ -322,6 +431,23 @@ type SessionConfig struct {
 	// ChannelBuffer sets the buffer size for message channels.
 	// Defaults to 100 if zero.
 	ChannelBuffer int
+
+	// Delivery controls what happens when Messages is full because the
+	// consumer has fallen behind. Defaults to DeliveryDrop.
+	//
+	// The policy applies to Messages only. Text, TextDeltas, and Errors
+	// are derived, best-effort channels that drop when full; all drops are
+	// counted in SessionMetrics.
+	Delivery DeliveryPolicy
+
+	// Retry makes RunAndCollect retry transient failures such as API
+	// overload or rate limits. Run, CollectAll, and CollectMessages do not
+	// retry: their output has already been delivered. Nil disables retries.
+	Retry *RetryPolicy
+
+	// Tracer, if set, traces each run: a span for the run with a child
+	// span per assistant turn and per tool call. See NewTracer.
+	Tracer Tracer
 }
 
 // MCPServer configures an MCP server for a Claude session.
@@ -442,6 +568,55 @@ func (h *Hooks) invokeMetrics(m SessionMetrics) {
 	}
 }
 
+// ChainHooks returns Hooks that call each of hooks in order. Nil entries
+// and nil callbacks are skipped. Use it to combine your own hooks with
+// ones provided by the SDK, such as Ledger.Hooks.
+func ChainHooks(hooks ...*Hooks) *Hooks {
+	var hs []*Hooks
+	for _, h := range hooks {
+		if h != nil {
+			hs = append(hs, h)
+		}
+	}
+	return &Hooks{
+		OnMessage: func(msg StreamMessage) {
+			for _, h := range hs {
+				h.invokeMessage(msg)
+			}
+		},
+		OnText: func(text string) {
+			for _, h := range hs {
+				h.invokeText(text)
+			}
+		},
+		OnToolCall: func(name string, input map[string]any) {
+			for _, h := range hs {
+				h.invokeToolCall(name, input)
+			}
+		},
+		OnError: func(err error) {
+			for _, h := range hs {
+				h.invokeError(err)
+			}
+		},
+		OnStart: func(pid int) {
+			for _, h := range hs {
+				h.invokeStart(pid)
+			}
+		},
+		OnExit: func(code int, duration time.Duration) {
+			for _, h := range hs {
+				h.invokeExit(code, duration)
+			}
+		},
+		OnMetrics: func(m SessionMetrics) {
+			for _, h := range hs {
+				h.invokeMetrics(m)
+			}
+		},
+	}
+}
+
 // SessionMetrics contains accumulated session metrics.
 //
 // Available via Session.CurrentMetrics() (sync) or the OnMetrics hook (async).
@@ -477,8 +652,24 @@ type SessionMetrics struct {
 	// Model is the model used for the session.
 	Model string
 
+	// ModelUsage is the session's cumulative usage per model, if the CLI
+	// reported it. See StreamMessage.ModelUsage.
+	ModelUsage map[string]ModelUsage
+
 	// SessionID is the CLI session identifier.
 	SessionID string
+
+	// DroppedMessages is the number of messages a Session discarded
+	// because Messages was full (see SessionConfig.Delivery).
+	DroppedMessages int
+
+	// DroppedText is the number of Text and TextDeltas values a Session
+	// discarded because the channel was full.
+	DroppedText int
+
+	// DroppedErrors is the number of non-fatal errors a Session discarded
+	// because Errors was full.
+	DroppedErrors int
 }
 
 // BoolPtr returns a pointer to a bool value.
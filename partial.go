@@ -0,0 +1,184 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PartialAccumulator rebuilds complete content blocks from the
+// "stream_event" messages produced with IncludePartialMessages.
+//
+// Feed every message to Add in order; non-event messages are ignored. The
+// accumulator tracks one API message at a time and resets on each
+// message_start, so a multi-step agentic turn yields one message per step.
+//
+// Example:
+//
+//	acc := claude.NewPartialAccumulator()
+//	for msg := range session.Messages {
+//		if delta := acc.Add(&msg); delta != nil && delta.Type == "text_delta" {
+//			fmt.Print(delta.Text)
+//		}
+//		if acc.Done() {
+//			fmt.Println("\nstop:", acc.Message().StopReason)
+//		}
+//	}
+//
+// PartialAccumulator is not safe for concurrent use.
+type PartialAccumulator struct {
+	message MessageContent
+	blocks  []ContentBlock
+	json    map[int]*strings.Builder
+	started bool
+	done    bool
+}
+
+// NewPartialAccumulator creates an empty accumulator.
+func NewPartialAccumulator() *PartialAccumulator {
+	return &PartialAccumulator{}
+}
+
+// Add folds a stream_event message into the accumulator.
+//
+// Returns the event's content delta for content_block_delta events so
+// callers can render output incrementally, or nil otherwise.
+func (a *PartialAccumulator) Add(msg *StreamMessage) *EventDelta {
+	if msg == nil || msg.Type != "stream_event" || msg.Event == nil {
+		return nil
+	}
+	ev := msg.Event
+
+	switch ev.Type {
+	case "message_start":
+		a.Reset()
+		a.started = true
+		if ev.Message != nil {
+			a.message = *ev.Message
+			a.message.Content = nil
+		}
+
+	case "content_block_start":
+		block := ev.ContentBlock
+		if block == nil {
+			block = &ContentBlock{}
+		}
+		b := a.block(ev.Index)
+		*b = *block
+		if b.Type == "tool_use" || b.Type == "server_tool_use" {
+			// Input arrives as input_json_delta fragments.
+			b.Input = nil
+		}
+
+	case "content_block_delta":
+		if ev.Delta == nil {
+			return nil
+		}
+		b := a.block(ev.Index)
+		switch ev.Delta.Type {
+		case "text_delta":
+			b.Text += ev.Delta.Text
+		case "thinking_delta":
+			b.Thinking += ev.Delta.Thinking
+		case "signature_delta":
+			b.Signature += ev.Delta.Signature
+		case "input_json_delta":
+			if a.json == nil {
+				a.json = make(map[int]*strings.Builder)
+			}
+			sb, ok := a.json[ev.Index]
+			if !ok {
+				sb = &strings.Builder{}
+				a.json[ev.Index] = sb
+			}
+			sb.WriteString(ev.Delta.PartialJSON)
+		}
+		return ev.Delta
+
+	case "content_block_stop":
+		a.finishInput(ev.Index)
+
+	case "message_delta":
+		if ev.Delta != nil && ev.Delta.StopReason != "" {
+			a.message.StopReason = ev.Delta.StopReason
+		}
+		if ev.Usage != nil {
+			a.message.Usage = ev.Usage
+		}
+
+	case "message_stop":
+		for i := range a.blocks {
+			a.finishInput(i)
+		}
+		a.done = true
+	}
+
+	return nil
+}
+
+// block returns the block at index, growing the slice as needed.
+func (a *PartialAccumulator) block(index int) *ContentBlock {
+	if index < 0 {
+		index = 0
+	}
+	for len(a.blocks) <= index {
+		a.blocks = append(a.blocks, ContentBlock{})
+	}
+	return &a.blocks[index]
+}
+
+// finishInput decodes the accumulated tool input for the block at index.
+func (a *PartialAccumulator) finishInput(index int) {
+	sb, ok := a.json[index]
+	if !ok || index >= len(a.blocks) {
+		return
+	}
+	delete(a.json, index)
+
+	var input map[string]any
+	if err := json.Unmarshal([]byte(sb.String()), &input); err == nil {
+		a.blocks[index].Input = input
+	}
+}
+
+// Blocks returns a copy of the content blocks accumulated so far. Blocks
+// still streaming hold partial text; tool_use inputs are filled in once
+// their block stops.
+func (a *PartialAccumulator) Blocks() []ContentBlock {
+	if len(a.blocks) == 0 {
+		return nil
+	}
+	return append([]ContentBlock(nil), a.blocks...)
+}
+
+// Text returns the concatenated text of all text blocks so far.
+func (a *PartialAccumulator) Text() string {
+	var sb strings.Builder
+	for _, b := range a.blocks {
+		if b.Type == "text" {
+			sb.WriteString(b.Text)
+		}
+	}
+	return sb.String()
+}
+
+// Message returns the message being accumulated, with ID, model, stop
+// reason, usage, and the blocks so far. Returns nil before message_start.
+func (a *PartialAccumulator) Message() *MessageContent {
+	if !a.started {
+		return nil
+	}
+	m := a.message
+	m.Content = a.Blocks()
+	return &m
+}
+
+// Done reports whether message_stop has been received for the current
+// message.
+func (a *PartialAccumulator) Done() bool {
+	return a.done
+}
+
+// Reset discards all accumulated state.
+func (a *PartialAccumulator) Reset() {
+	*a = PartialAccumulator{}
+}
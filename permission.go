@@ -0,0 +1,114 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/MateoSegura/claudesdk-go/mcp"
+)
+
+// Decision is the outcome of a CanUseTool permission check.
+//
+// Build one with Allow, Deny, or AllowWithInput.
+type Decision struct {
+	// Behavior is "allow" or "deny".
+	Behavior string `json:"behavior"`
+
+	// UpdatedInput replaces the tool input when allowing. Nil keeps the
+	// original input.
+	UpdatedInput map[string]any `json:"updatedInput,omitempty"`
+
+	// Message explains a denial to Claude.
+	Message string `json:"message,omitempty"`
+}
+
+// Allow permits the tool call with its original input.
+func Allow() Decision {
+	return Decision{Behavior: "allow"}
+}
+
+// AllowWithInput permits the tool call with modified input, e.g. to
+// rewrite a path or strip a dangerous flag.
+func AllowWithInput(input map[string]any) Decision {
+	return Decision{Behavior: "allow", UpdatedInput: input}
+}
+
+// Deny rejects the tool call. The message is shown to Claude so it can
+// adjust its approach.
+func Deny(message string) Decision {
+	return Decision{Behavior: "deny", Message: message}
+}
+
+// CanUseToolFunc decides whether Claude may run a tool.
+//
+// toolName is the CLI tool name (e.g. "Bash", "Edit", "mcp__github__create_issue")
+// and input its arguments. Returning an error denies the call with the
+// error text as the message.
+type CanUseToolFunc func(ctx context.Context, toolName string, input map[string]any) (Decision, error)
+
+const (
+	// sdkMCPServerName is the MCP server name the SDK registers for its
+	// in-process tools.
+	sdkMCPServerName = "claudesdk"
+
+	// permissionToolName is the tool the CLI calls for permission prompts.
+	permissionToolName = "can_use_tool"
+)
+
+// permissionRequest is the input the CLI passes to --permission-prompt-tool.
+type permissionRequest struct {
+	ToolName  string         `json:"tool_name"`
+	Input     map[string]any `json:"input"`
+	ToolUseID string         `json:"tool_use_id,omitempty"`
+}
+
+// permissionTool adapts fn to the CLI's permission prompt tool protocol.
+func permissionTool(fn CanUseToolFunc) mcp.Tool {
+	return mcp.Tool{
+		Name:        permissionToolName,
+		Description: "Decides whether a tool call is permitted.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"tool_name":   map[string]any{"type": "string"},
+				"input":       map[string]any{"type": "object"},
+				"tool_use_id": map[string]any{"type": "string"},
+			},
+			"required": []string{"tool_name", "input"},
+		},
+		Handler: func(ctx context.Context, args json.RawMessage) (*mcp.Result, error) {
+			var req permissionRequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return nil, fmt.Errorf("invalid permission request: %w", err)
+			}
+
+			d, err := fn(ctx, req.ToolName, req.Input)
+			if err != nil {
+				d = Deny(err.Error())
+			}
+			switch d.Behavior {
+			case "allow":
+				// The CLI requires the input to run with, even if unchanged.
+				if d.UpdatedInput == nil {
+					d.UpdatedInput = req.Input
+				}
+				if d.UpdatedInput == nil {
+					d.UpdatedInput = map[string]any{}
+				}
+			case "deny":
+				if d.Message == "" {
+					d.Message = fmt.Sprintf("Permission to use %s was denied.", req.ToolName)
+				}
+			default:
+				d = Deny(fmt.Sprintf("invalid permission decision %q", d.Behavior))
+			}
+
+			data, err := json.Marshal(d)
+			if err != nil {
+				return nil, err
+			}
+			return mcp.TextResult(string(data)), nil
+		},
+	}
+}
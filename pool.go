@@ -0,0 +1,352 @@
+package claude
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultPoolConcurrency is the MaxConcurrent used when PoolConfig leaves
+// it at zero.
+const DefaultPoolConcurrency = 4
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Session is the configuration for every session the pool starts.
+	// PoolTask.Config overrides it per task.
+	Session SessionConfig
+
+	// MaxConcurrent caps the number of CLI processes running at once.
+	// Defaults to DefaultPoolConcurrency if zero.
+	MaxConcurrent int
+
+	// MaxBudgetUSD caps the total cost of all sessions run by the pool,
+	// summed from each session's SessionMetrics.TotalCostUSD. Zero means
+	// no limit.
+	//
+	// The budget is checked whenever a task is about to start, and each
+	// session's own MaxBudgetUSD is lowered to what remains. Sessions
+	// already running when the budget runs out are allowed to finish, so
+	// the total can overshoot by what they spend.
+	MaxBudgetUSD float64
+
+	// NewHooks, if set, is called for every task to create hooks for its
+	// session, chained after the session's own Hooks. Use it for hooks
+	// that keep per-session state and so must not be shared by sessions
+	// running at once, such as metrics.Collector.Hooks.
+	NewHooks func() *Hooks
+}
+
+// PoolTask is one prompt submitted to a Pool.
+type PoolTask struct {
+	// Prompt is the prompt to run.
+	Prompt string
+
+	// Priority orders queued tasks: higher runs first. Tasks with equal
+	// priority run in submission order.
+	Priority int
+
+	// Config replaces PoolConfig.Session for this task when non-nil.
+	Config *SessionConfig
+}
+
+// PoolStats is a snapshot of a Pool's state.
+type PoolStats struct {
+	// Running is the number of sessions currently running.
+	Running int
+
+	// Queued is the number of tasks waiting for a free slot.
+	Queued int
+
+	// MaxConcurrent is the pool's concurrency limit.
+	MaxConcurrent int
+
+	// Utilization is Running / MaxConcurrent, from 0 to 1.
+	Utilization float64
+
+	// Completed is the number of tasks that ran and succeeded.
+	Completed int
+
+	// Failed is the number of tasks that ran and returned an error.
+	// Tasks rejected before starting (budget, closed pool, cancelled
+	// while queued) are not counted.
+	Failed int
+
+	// SpentUSD is the total cost of all finished sessions.
+	SpentUSD float64
+
+	// RemainingUSD is MaxBudgetUSD minus SpentUSD, floored at zero.
+	// Zero when the pool has no budget.
+	RemainingUSD float64
+}
+
+// Pool runs prompts on a bounded number of concurrent sessions.
+//
+// Do and Run block until their task has run; call them from as many
+// goroutines as needed and the pool queues the excess by priority.
+//
+// Example:
+//
+//	pool, _ := claude.NewPool(claude.PoolConfig{
+//		MaxConcurrent: 8,
+//		MaxBudgetUSD:  25,
+//	})
+//	defer pool.Close()
+//
+//	for _, p := range prompts {
+//		go func() {
+//			result, err := pool.Run(ctx, p)
+//			...
+//		}()
+//	}
+type Pool struct {
+	cfg PoolConfig
+
+	mu        sync.Mutex
+	closed    bool
+	running   int
+	waiting   waiterHeap
+	seq       uint64
+	completed int
+	failed    int
+	spent     float64
+}
+
+// NewPool creates a Pool. No process is started until a task is submitted.
+func NewPool(cfg PoolConfig) (*Pool, error) {
+	if cfg.MaxConcurrent < 0 {
+		return nil, fmt.Errorf("claude: invalid pool MaxConcurrent %d", cfg.MaxConcurrent)
+	}
+	if cfg.MaxConcurrent == 0 {
+		cfg.MaxConcurrent = DefaultPoolConcurrency
+	}
+	if cfg.MaxBudgetUSD < 0 {
+		return nil, fmt.Errorf("claude: invalid pool MaxBudgetUSD %v", cfg.MaxBudgetUSD)
+	}
+	if !cfg.Session.Delivery.valid() {
+		return nil, fmt.Errorf("claude: unknown delivery policy %q", cfg.Session.Delivery)
+	}
+	return &Pool{cfg: cfg}, nil
+}
+
+// Run runs prompt with priority zero and the pool's session configuration.
+func (p *Pool) Run(ctx context.Context, prompt string) (*Result, error) {
+	return p.Do(ctx, PoolTask{Prompt: prompt})
+}
+
+// Do waits for a free slot, runs task on a new Session, and returns its
+// Result as Session.RunAndCollect would.
+//
+// Returns ErrBudgetExceeded if the pool's budget is spent before the task
+// starts, ErrPoolClosed if the pool is closed, or ctx.Err() if ctx is
+// done while the task is queued.
+func (p *Pool) Do(ctx context.Context, task PoolTask) (*Result, error) {
+	if err := p.acquire(ctx, task.Priority); err != nil {
+		return nil, err
+	}
+
+	cfg := p.cfg.Session
+	if task.Config != nil {
+		cfg = *task.Config
+	}
+
+	remaining, ok := p.remaining()
+	if !ok {
+		p.release(0, nil, false)
+		return nil, ErrBudgetExceeded
+	}
+	if p.cfg.MaxBudgetUSD > 0 {
+		cfg.MaxBudgetUSD = capBudget(cfg.MaxBudgetUSD, remaining)
+	}
+
+	if p.cfg.NewHooks != nil {
+		cfg.Hooks = ChainHooks(cfg.Hooks, p.cfg.NewHooks())
+	}
+
+	session, err := NewSession(cfg)
+	if err != nil {
+		p.release(0, err, false)
+		return nil, err
+	}
+
+	result, err := session.RunAndCollect(ctx, task.Prompt)
+	p.release(session.CurrentMetrics().TotalCostUSD, err, true)
+	return result, err
+}
+
+// Stats returns a snapshot of the pool's queue depth, utilization, and
+// spend.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := PoolStats{
+		Running:       p.running,
+		Queued:        len(p.waiting),
+		MaxConcurrent: p.cfg.MaxConcurrent,
+		Utilization:   float64(p.running) / float64(p.cfg.MaxConcurrent),
+		Completed:     p.completed,
+		Failed:        p.failed,
+		SpentUSD:      p.spent,
+	}
+	if p.cfg.MaxBudgetUSD > 0 {
+		stats.RemainingUSD = max(p.cfg.MaxBudgetUSD-p.spent, 0)
+	}
+	return stats
+}
+
+// Close stops the pool from accepting tasks. Queued tasks fail with
+// ErrPoolClosed; running sessions are left to finish.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for len(p.waiting) > 0 {
+		w := heap.Pop(&p.waiting).(*waiter)
+		w.err = ErrPoolClosed
+		close(w.ready)
+	}
+	return nil
+}
+
+// acquire blocks until the caller holds a slot.
+func (p *Pool) acquire(ctx context.Context, priority int) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	if p.exhausted() {
+		p.mu.Unlock()
+		return ErrBudgetExceeded
+	}
+	if p.running < p.cfg.MaxConcurrent && len(p.waiting) == 0 {
+		p.running++
+		p.mu.Unlock()
+		return nil
+	}
+
+	w := &waiter{priority: priority, seq: p.seq, ready: make(chan struct{})}
+	p.seq++
+	heap.Push(&p.waiting, w)
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&p.waiting, w.index)
+			p.mu.Unlock()
+			return ctx.Err()
+		}
+		p.mu.Unlock()
+
+		// Granted concurrently with cancellation: hand the slot on.
+		if w.err == nil {
+			p.release(0, nil, false)
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, records the finished task, and wakes the next
+// waiter.
+func (p *Pool) release(cost float64, err error, ran bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running--
+	p.spent += cost
+	if ran {
+		if err != nil {
+			p.failed++
+		} else {
+			p.completed++
+		}
+	}
+
+	if p.exhausted() {
+		for len(p.waiting) > 0 {
+			w := heap.Pop(&p.waiting).(*waiter)
+			w.err = ErrBudgetExceeded
+			close(w.ready)
+		}
+		return
+	}
+	if len(p.waiting) > 0 && p.running < p.cfg.MaxConcurrent {
+		w := heap.Pop(&p.waiting).(*waiter)
+		p.running++
+		close(w.ready)
+	}
+}
+
+// remaining reports the unspent budget, and false if it is used up.
+func (p *Pool) remaining() (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cfg.MaxBudgetUSD - p.spent, !p.exhausted()
+}
+
+// exhausted reports whether the budget is spent. Callers hold p.mu.
+func (p *Pool) exhausted() bool {
+	return p.cfg.MaxBudgetUSD > 0 && p.spent >= p.cfg.MaxBudgetUSD
+}
+
+// capBudget returns the MaxBudgetUSD to launch with when remaining is left
+// of a shared budget: cur if it is set and fits, otherwise remaining. The
+// CLI flag has cent precision, so the cap is never rounded down to zero.
+func capBudget(cur, remaining float64) float64 {
+	if cur > 0 && cur <= remaining {
+		return cur
+	}
+	return max(remaining, 0.01)
+}
+
+// waiter is a task queued for a slot.
+type waiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	err      error // set before ready is closed if the task is rejected
+	index    int   // position in the heap; -1 once removed
+}
+
+// waiterHeap orders waiters by priority, then submission order.
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
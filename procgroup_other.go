@@ -0,0 +1,16 @@
+//go:build !unix
+
+package claude
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op: process groups are only used on Unix.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killGroup kills p alone; its children are not tracked on this platform.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
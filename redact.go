@@ -0,0 +1,54 @@
+package claude
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redacted replaces secret values in logged and recorded arguments.
+const redacted = "[REDACTED]"
+
+var (
+	// secretToken matches API keys and bearer tokens anywhere in an argument.
+	secretToken = regexp.MustCompile(`(?i)sk-ant-[\w-]+|bearer\s+[^\s"',]+`)
+
+	// secretAssignment matches key=value pairs whose key names a secret.
+	secretAssignment = regexp.MustCompile(`(?i)\b([\w.-]*(?:key|token|secret|password|auth)[\w.-]*)=([^\s"',]+)`)
+
+	// secretJSONField matches JSON string fields whose key names a secret,
+	// as in inline --settings or --mcp-config JSON.
+	secretJSONField = regexp.MustCompile(`(?i)("[\w.-]*(?:key|token|secret|password|auth)[\w.-]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+	// tempPath matches the per-run files Launcher creates: the MCP config
+	// (claude-mcp-<nanos>.json) and the hook bridge directory
+	// (claude-hooks-<random>).
+	tempPath = regexp.MustCompile(`[^\s"',=]*[/\\](claude-(?:mcp|hooks))-\d+`)
+)
+
+// redactArgs returns a copy of args safe to log: secret values are
+// replaced with "[REDACTED]" and the prompt with its length.
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && args[i-1] == "--" {
+			out[i] = fmt.Sprintf("[%d-byte prompt]", len(arg))
+			continue
+		}
+		arg = secretJSONField.ReplaceAllString(arg, `$1"`+redacted+`"`)
+		arg = secretAssignment.ReplaceAllString(arg, "$1="+redacted)
+		out[i] = secretToken.ReplaceAllString(arg, redacted)
+	}
+	return out
+}
+
+// cassetteArgs returns args as a cassette records them: redacted like
+// redactArgs, with per-run temp paths replaced by "<tmp>/claude-mcp.json"
+// and "<tmp>/claude-hooks/...", so runs with the same options compare
+// equal.
+func cassetteArgs(args []string) []string {
+	out := redactArgs(args)
+	for i, arg := range out {
+		out[i] = tempPath.ReplaceAllString(arg, "<tmp>/$1")
+	}
+	return out
+}
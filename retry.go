@@ -0,0 +1,183 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy makes Session.RunAndCollect retry transient failures.
+//
+// Each attempt runs a fresh CLI process. A failure is retryable if it
+// matches one of RetryOn with errors.Is; see Classify for the classes.
+//
+// Example:
+//
+//	cfg := claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{Model: "opus"},
+//		Retry: &claude.RetryPolicy{
+//			MaxAttempts:    4,
+//			Jitter:         0.2,
+//			FallbackModels: []string{"sonnet"},
+//		},
+//	}
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Defaults to 3 if zero.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	// Defaults to 1s if zero.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts.
+	// Defaults to 30s if zero.
+	MaxBackoff time.Duration
+
+	// Multiplier grows the delay after each retry.
+	// Defaults to 2 if zero.
+	Multiplier float64
+
+	// Jitter randomizes each delay by up to ±Jitter of its length, from
+	// 0 to 1. Zero disables jitter.
+	Jitter float64
+
+	// RetryOn lists the failure classes to retry.
+	// Defaults to ErrOverloaded, ErrRateLimited, and ErrNetwork.
+	RetryOn []error
+
+	// FallbackModels switches models on retry: the first retry uses
+	// FallbackModels[0], the second FallbackModels[1], and the last is
+	// kept once they run out. Empty keeps LaunchOptions.Model.
+	FallbackModels []string
+
+	// OnRetry, if set, is called before each retry with the number of the
+	// attempt that failed (starting at 1), its error, and the delay.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// withDefaults returns p with zero fields set to their defaults.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = time.Second
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = 2
+	}
+	if p.RetryOn == nil {
+		p.RetryOn = []error{ErrOverloaded, ErrRateLimited, ErrNetwork}
+	}
+	return p
+}
+
+// retryable reports whether err matches one of p.RetryOn.
+func (p RetryPolicy) retryable(err error) bool {
+	for _, class := range p.RetryOn {
+		if errors.Is(err, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait after the given failed attempt (starting at 1).
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt && d < float64(p.MaxBackoff); i++ {
+		d *= p.Multiplier
+	}
+	d = min(d, float64(p.MaxBackoff))
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// model returns the model for the given attempt, or "" to keep the
+// configured one.
+func (p RetryPolicy) model(attempt int) string {
+	if attempt == 1 || len(p.FallbackModels) == 0 {
+		return ""
+	}
+	return p.FallbackModels[min(attempt-2, len(p.FallbackModels)-1)]
+}
+
+// runWithRetry implements RunAndCollect under a RetryPolicy. Each attempt
+// runs on its own Session; s reflects the last one.
+func (s *Session) runWithRetry(ctx context.Context, prompt string) (*Result, error) {
+	s.mu.Lock()
+	if s.closed || s.attempt != nil {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s.mu.Unlock()
+	defer s.close()
+
+	policy := s.config.Retry.withDefaults()
+	var spent float64
+	var result *Result
+
+	for attempt := 1; ; attempt++ {
+		cfg := s.config
+		cfg.Retry = nil
+		cfg.ID = s.ID
+		if model := policy.model(attempt); model != "" {
+			cfg.Model = model
+		}
+
+		child, err := NewSession(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.attempt = child
+		s.mu.Unlock()
+		// Kill and Shutdown stop s before reading the attempt, so past this
+		// check they reach child.
+		if s.stopped() {
+			return result, ErrSessionClosed
+		}
+
+		result, err = child.RunAndCollect(ctx, prompt)
+
+		// Failed attempts are billed too.
+		metrics := child.CurrentMetrics()
+		spent += metrics.TotalCostUSD
+		metrics.TotalCostUSD = spent
+		s.mu.Lock()
+		s.metrics = metrics
+		s.dropped = droppedCounts{metrics.DroppedMessages, metrics.DroppedText, metrics.DroppedErrors}
+		s.err = child.Err()
+		s.mu.Unlock()
+		if result != nil {
+			result.Attempts = attempt
+			result.Metrics = metrics
+		}
+
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || s.stopped() || !policy.retryable(err) {
+			return result, err
+		}
+
+		delay := policy.delay(attempt)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, ctx.Err()
+		case <-s.stop:
+			timer.Stop()
+			return result, ErrSessionClosed
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Package schema reflects Go types into JSON Schema and validates values
+// against the result.
+//
+// The generated schemas are plain map[string]any values, usable directly as
+// claude.LaunchOptions.JSONSchema and as mcp.Tool input schemas:
+//
+//	type Ticket struct {
+//		Title    string   `json:"title" description:"One-line summary"`
+//		Priority string   `json:"priority" jsonschema:"enum=low|medium|high"`
+//		Estimate int      `json:"estimate" jsonschema:"minimum=1,maximum=13"`
+//		Labels   []string `json:"labels,omitempty" jsonschema:"maxItems=5"`
+//		Parent   *Ticket  `json:"parent,omitempty"`
+//	}
+//
+//	s, err := schema.For[Ticket]()
+//
+// # Mapping
+//
+// Structs become objects with additionalProperties false. Properties are
+// named by json tags and json:"-" fields are skipped; embedded structs are
+// flattened as encoding/json does, so shallower and tagged fields win and
+// ambiguous names are dropped. A field is required unless it is a
+// pointer, tagged omitempty, or promoted through an embedded pointer.
+// Slices and arrays become arrays, maps become objects with
+// additionalProperties, time.Time becomes a date-time string, and
+// interfaces accept any value.
+//
+// Struct types that refer to themselves are emitted once under $defs and
+// referenced with $ref ("#" for the root type); all other types are inlined.
+//
+// # Tags
+//
+// The description tag sets a property's description. The jsonschema tag
+// holds comma-separated rules; values cannot contain commas:
+//
+//   - enum=a|b|c: allowed values, parsed according to the field type
+//   - minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//   - minLength, maxLength, pattern, format
+//   - minItems, maxItems, uniqueItems, minProperties, maxProperties
+//   - title, default
+//   - required, optional: override the pointer/omitempty rule
+//
+// On slices, value rules (enum, bounds, pattern, format) apply to the
+// elements and item-count rules apply to the slice itself.
+package schema
@@ -0,0 +1,492 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// For returns the JSON Schema for T.
+func For[T any]() (map[string]any, error) {
+	return Reflect(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// MustFor is like For but panics on error. Use it for package-level
+// schemas of types known to be supported.
+func MustFor[T any]() map[string]any {
+	s, err := For[T]()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Of returns the JSON Schema for the dynamic type of v.
+func Of(v any) (map[string]any, error) {
+	if v == nil {
+		return nil, fmt.Errorf("schema: nil value")
+	}
+	return Reflect(reflect.TypeOf(v))
+}
+
+// Reflect returns the JSON Schema for t.
+//
+// It fails for types encoding/json cannot represent (channels, functions,
+// complex numbers) and for invalid jsonschema tags.
+func Reflect(t reflect.Type) (map[string]any, error) {
+	g := &generator{
+		root:      deref(t),
+		recursive: findRecursive(t),
+		names:     map[reflect.Type]string{},
+		defs:      map[string]any{},
+	}
+	s, err := g.schema(t, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.defs) > 0 {
+		s["$defs"] = g.defs
+	}
+	return s, nil
+}
+
+type generator struct {
+	root      reflect.Type
+	recursive map[reflect.Type]bool
+	names     map[reflect.Type]string
+	defs      map[string]any
+}
+
+func deref(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// findRecursive returns the struct types reachable from themselves.
+// Only these are emitted as $defs; everything else is inlined.
+func findRecursive(t reflect.Type) map[reflect.Type]bool {
+	recursive := map[reflect.Type]bool{}
+	visiting := map[reflect.Type]bool{}
+	done := map[reflect.Type]bool{}
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		t = deref(t)
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			walk(t.Elem())
+		case reflect.Struct:
+			if visiting[t] {
+				recursive[t] = true
+				return
+			}
+			if done[t] {
+				return
+			}
+			visiting[t] = true
+			for i := 0; i < t.NumField(); i++ {
+				walk(t.Field(i).Type)
+			}
+			delete(visiting, t)
+			done[t] = true
+		}
+	}
+	walk(t)
+	return recursive
+}
+
+// defName picks a unique $defs key for t.
+func (g *generator) defName(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	base := t.Name()
+	if base == "" {
+		base = "Type"
+	}
+	name := base
+	for i := 2; ; i++ {
+		if _, taken := g.defs[name]; !taken {
+			break
+		}
+		name = base + strconv.Itoa(i)
+	}
+	g.names[t] = name
+	g.defs[name] = nil // reserve while generating
+	return name
+}
+
+func (g *generator) schema(t reflect.Type, top bool) (map[string]any, error) {
+	t = deref(t)
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	case t == rawMessageType:
+		return map[string]any{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json writes []byte as base64 but [N]byte as numbers.
+			return map[string]any{"type": "string", "contentEncoding": "base64"}, nil
+		}
+		items, err := g.schema(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		s := map[string]any{"type": "array", "items": items}
+		if t.Kind() == reflect.Array {
+			s["minItems"] = t.Len()
+			s["maxItems"] = t.Len()
+		}
+		return s, nil
+
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("schema: unsupported map key type %s", t.Key())
+		}
+		values, err := g.schema(t.Elem(), false)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+
+	case reflect.Struct:
+		if g.recursive[t] && !top {
+			if t == g.root {
+				return map[string]any{"$ref": "#"}, nil
+			}
+			if name, ok := g.names[t]; ok {
+				return map[string]any{"$ref": "#/$defs/" + name}, nil
+			}
+			name := g.defName(t)
+			def, err := g.object(t)
+			if err != nil {
+				return nil, err
+			}
+			g.defs[name] = def
+			return map[string]any{"$ref": "#/$defs/" + name}, nil
+		}
+		return g.object(t)
+
+	default:
+		return nil, fmt.Errorf("schema: unsupported type %s", t)
+	}
+}
+
+// object builds the schema for a struct type.
+func (g *generator) object(t reflect.Type) (map[string]any, error) {
+	properties := map[string]any{}
+	required := []string{}
+	if err := g.addFields(t, properties, &required); err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// addFields adds t's fields to properties, resolving embedded structs the
+// way encoding/json does.
+func (g *generator) addFields(t reflect.Type, properties map[string]any, required *[]string) error {
+	for _, f := range jsonFields(t) {
+		s, err := g.schema(f.Type, false)
+		if err != nil {
+			return fmt.Errorf("field %s.%s: %w", f.owner.Name(), f.Name, err)
+		}
+		if desc := f.Tag.Get("description"); desc != "" {
+			s = withKey(s, "description", desc)
+		}
+
+		optional := f.Type.Kind() == reflect.Pointer || hasOption(f.opts, "omitempty")
+		if rules, ok := f.Tag.Lookup("jsonschema"); ok {
+			s, optional, err = applyTag(s, deref(f.Type), rules, optional)
+			if err != nil {
+				return fmt.Errorf("field %s.%s: %w", f.owner.Name(), f.Name, err)
+			}
+		}
+
+		properties[f.name] = s
+		// encoding/json omits fields promoted through a nil pointer.
+		if !optional && !f.viaPointer {
+			*required = append(*required, f.name)
+		}
+	}
+	return nil
+}
+
+// jsonField is a struct field as encoding/json sees it, possibly promoted
+// from an embedded struct.
+type jsonField struct {
+	reflect.StructField
+	name       string
+	opts       string
+	tagged     bool
+	index      []int        // path from the root struct
+	owner      reflect.Type // struct that declares the field
+	viaPointer bool         // promoted through an embedded pointer
+}
+
+// jsonFields returns the fields encoding/json encodes for struct t, in
+// encoding order. Embedded structs are walked breadth first; for each
+// name the shallowest field wins, then the tagged one, and names that
+// remain ambiguous are dropped.
+func jsonFields(t reflect.Type) []jsonField {
+	type embedded struct {
+		typ        reflect.Type
+		index      []int
+		viaPointer bool
+	}
+
+	var fields []jsonField
+	next := []embedded{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	var count, nextCount map[reflect.Type]int
+
+	for len(next) > 0 {
+		current := next
+		next = nil
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				if sf.Anonymous {
+					if deref(sf.Type).Kind() != reflect.Struct && !sf.IsExported() {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), e.index...), i)
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Pointer {
+					ft = ft.Elem()
+				}
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					f := jsonField{
+						StructField: sf,
+						name:        name,
+						opts:        opts,
+						tagged:      name != "",
+						index:       index,
+						owner:       e.typ,
+						viaPointer:  e.viaPointer,
+					}
+					if f.name == "" {
+						f.name = sf.Name
+					}
+					fields = append(fields, f)
+					if count[e.typ] > 1 {
+						// The struct is embedded twice at this depth, so
+						// its fields conflict with themselves.
+						fields = append(fields, f)
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, embedded{
+						typ:        ft,
+						index:      index,
+						viaPointer: e.viaPointer || sf.Type.Kind() == reflect.Pointer,
+					})
+				}
+			}
+		}
+	}
+
+	// Group by name, dominant field first.
+	slices.SortStableFunc(fields, func(a, b jsonField) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		if c := len(a.index) - len(b.index); c != 0 {
+			return c
+		}
+		if a.tagged != b.tagged {
+			if a.tagged {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.index, b.index)
+	})
+
+	out := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		group := fields[i:j]
+		if len(group) == 1 || len(group[0].index) < len(group[1].index) || group[0].tagged != group[1].tagged {
+			out = append(out, group[0])
+		}
+		i = j
+	}
+
+	slices.SortFunc(out, func(a, b jsonField) int { return slices.Compare(a.index, b.index) })
+	return out
+}
+
+// withKey returns s with key set, copying $ref schemas so the annotation
+// does not leak into the shared definition.
+func withKey(s map[string]any, key string, v any) map[string]any {
+	if _, ok := s["$ref"]; ok {
+		return map[string]any{"allOf": []any{s}, key: v}
+	}
+	s[key] = v
+	return s
+}
+
+func hasOption(opts, opt string) bool {
+	return strings.Contains(","+opts+",", ","+opt+",")
+}
+
+// applyTag applies a jsonschema struct tag such as
+// `jsonschema:"enum=a|b|c,minimum=1,maximum=10"` to s.
+func applyTag(s map[string]any, t reflect.Type, rules string, optional bool) (map[string]any, bool, error) {
+	// Constraints on a slice's elements go on the items schema unless
+	// they describe the array itself.
+	target := s
+	if items, ok := s["items"].(map[string]any); ok {
+		target = items
+	}
+	elem := t
+	if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		elem = deref(t.Elem())
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(rule, "=")
+
+		switch key {
+		case "required":
+			optional = false
+		case "optional":
+			optional = true
+
+		case "enum":
+			if !hasValue {
+				return nil, false, fmt.Errorf("enum needs values")
+			}
+			var values []any
+			for _, v := range strings.Split(value, "|") {
+				parsed, err := parseValue(elem, v)
+				if err != nil {
+					return nil, false, fmt.Errorf("enum: %w", err)
+				}
+				values = append(values, parsed)
+			}
+			target["enum"] = values
+
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", key, err)
+			}
+			target[key] = n
+
+		case "minLength", "maxLength":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", key, err)
+			}
+			target[key] = n
+
+		case "minItems", "maxItems", "minProperties", "maxProperties":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", key, err)
+			}
+			s[key] = n
+
+		case "uniqueItems":
+			s[key] = true
+
+		case "pattern", "format":
+			if !hasValue {
+				return nil, false, fmt.Errorf("%s needs a value", key)
+			}
+			target[key] = value
+
+		case "title":
+			s = withKey(s, key, value)
+
+		case "default":
+			parsed, err := parseValue(t, value)
+			if err != nil {
+				return nil, false, fmt.Errorf("default: %w", err)
+			}
+			s = withKey(s, key, parsed)
+
+		default:
+			return nil, false, fmt.Errorf("unknown jsonschema rule %q", key)
+		}
+	}
+	return s, optional, nil
+}
+
+// parseValue converts a tag literal to the JSON value for kind t.
+func parseValue(t reflect.Type, v string) (any, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseInt(v, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(v, 64)
+	case reflect.Bool:
+		return strconv.ParseBool(v)
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,427 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type address struct {
+	Street string `json:"street"`
+	City   string `json:"city"`
+}
+
+type meta struct {
+	Created time.Time `json:"created"`
+}
+
+type ticket struct {
+	meta
+	Title    string            `json:"title" description:"One-line summary"`
+	Priority string            `json:"priority" jsonschema:"enum=low|medium|high"`
+	Estimate int               `json:"estimate" jsonschema:"minimum=1,maximum=13"`
+	Ratio    float64           `json:"ratio,omitempty" jsonschema:"exclusiveMaximum=1"`
+	Labels   []string          `json:"labels,omitempty" jsonschema:"maxItems=3,pattern=^[a-z]+$"`
+	Points   []int             `json:"points,omitempty" jsonschema:"enum=1|2|3"`
+	Owner    *address          `json:"owner"`
+	Addrs    []address         `json:"addrs,omitempty"`
+	Attrs    map[string]int    `json:"attrs,omitempty"`
+	Extra    any               `json:"extra,omitempty"`
+	Raw      json.RawMessage   `json:"raw,omitempty"`
+	Blob     []byte            `json:"blob,omitempty"`
+	Pair     [2]string         `json:"pair,omitempty"`
+	Count    uint              `json:"count" jsonschema:"optional"`
+	Ptr      *string           `json:"ptr" jsonschema:"required"`
+	Notes    map[string]string `json:"-"`
+	Done     bool
+	hidden   int
+}
+
+func props(t *testing.T, s map[string]any) map[string]map[string]any {
+	t.Helper()
+	raw, ok := s["properties"].(map[string]any)
+	if !ok {
+		t.Fatalf("no properties in %v", s)
+	}
+	out := make(map[string]map[string]any, len(raw))
+	for k, v := range raw {
+		out[k] = v.(map[string]any)
+	}
+	return out
+}
+
+func TestReflectStruct(t *testing.T) {
+	s, err := For[ticket]()
+	if err != nil {
+		t.Fatalf("For() error: %v", err)
+	}
+	if s["type"] != "object" || s["additionalProperties"] != false {
+		t.Errorf("root = %v", s)
+	}
+	if _, ok := s["$defs"]; ok {
+		t.Error("non-recursive types should not produce $defs")
+	}
+
+	p := props(t, s)
+	tests := []struct {
+		name, key string
+		want      any
+	}{
+		{"created", "format", "date-time"},
+		{"title", "description", "One-line summary"},
+		{"priority", "type", "string"},
+		{"estimate", "type", "integer"},
+		{"estimate", "minimum", 1.0},
+		{"estimate", "maximum", 13.0},
+		{"ratio", "type", "number"},
+		{"ratio", "exclusiveMaximum", 1.0},
+		{"labels", "maxItems", 3},
+		{"owner", "type", "object"},
+		{"addrs", "type", "array"},
+		{"attrs", "type", "object"},
+		{"blob", "type", "string"},
+		{"blob", "contentEncoding", "base64"},
+		{"pair", "minItems", 2},
+		{"pair", "maxItems", 2},
+		{"count", "minimum", 0},
+		{"ptr", "type", "string"},
+		{"Done", "type", "boolean"},
+	}
+	for _, tt := range tests {
+		if got := p[tt.name][tt.key]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.%s = %#v, want %#v", tt.name, tt.key, got, tt.want)
+		}
+	}
+
+	if got := p["priority"]["enum"]; !reflect.DeepEqual(got, []any{"low", "medium", "high"}) {
+		t.Errorf("priority enum = %#v", got)
+	}
+	if items := p["labels"]["items"].(map[string]any); items["pattern"] != "^[a-z]+$" {
+		t.Errorf("labels items = %v, want pattern on elements", items)
+	}
+	if items := p["points"]["items"].(map[string]any); !reflect.DeepEqual(items["enum"], []any{int64(1), int64(2), int64(3)}) {
+		t.Errorf("points enum = %#v", items["enum"])
+	}
+	if len(p["extra"]) != 0 || len(p["raw"]) != 0 {
+		t.Errorf("interface and RawMessage should be unconstrained: %v %v", p["extra"], p["raw"])
+	}
+	if vals := p["attrs"]["additionalProperties"].(map[string]any); vals["type"] != "integer" {
+		t.Errorf("attrs values = %v", vals)
+	}
+	for _, name := range []string{"Notes", "hidden", "meta"} {
+		if _, ok := p[name]; ok {
+			t.Errorf("property %q should be skipped", name)
+		}
+	}
+
+	required := s["required"].([]string)
+	want := []string{"created", "title", "priority", "estimate", "ptr", "Done"}
+	if strings.Join(required, ",") != strings.Join(want, ",") {
+		t.Errorf("required = %v, want %v", required, want)
+	}
+}
+
+type treeNode struct {
+	Value    int         `json:"value"`
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+type person struct {
+	Name    string  `json:"name"`
+	Manager *person `json:"manager,omitempty"`
+}
+
+type org struct {
+	CEO   person   `json:"ceo"`
+	Staff []person `json:"staff"`
+	Tree  treeNode `json:"tree" description:"Reporting tree"`
+}
+
+func TestReflectRecursive(t *testing.T) {
+	s, err := For[treeNode]()
+	if err != nil {
+		t.Fatalf("For() error: %v", err)
+	}
+	children := props(t, s)["children"]
+	if items := children["items"].(map[string]any); items["$ref"] != "#" {
+		t.Errorf("self reference = %v, want $ref #", items)
+	}
+
+	s, err = For[org]()
+	if err != nil {
+		t.Fatalf("For() error: %v", err)
+	}
+	defs := s["$defs"].(map[string]any)
+	if len(defs) != 2 {
+		t.Errorf("$defs = %v, want person and treeNode", defs)
+	}
+	p := props(t, s)
+	if p["ceo"]["$ref"] != "#/$defs/person" {
+		t.Errorf("ceo = %v", p["ceo"])
+	}
+	if items := p["staff"]["items"].(map[string]any); items["$ref"] != "#/$defs/person" {
+		t.Errorf("staff items = %v", items)
+	}
+	// Annotations on a $ref are kept out of the shared definition.
+	if p["tree"]["description"] != "Reporting tree" || p["tree"]["allOf"] == nil {
+		t.Errorf("tree = %v", p["tree"])
+	}
+	if _, ok := defs["treeNode"].(map[string]any)["description"]; ok {
+		t.Error("description leaked into $defs")
+	}
+
+	if _, err := json.Marshal(s); err != nil {
+		t.Errorf("schema not serializable: %v", err)
+	}
+
+	valid := map[string]any{
+		"ceo":   map[string]any{"name": "a", "manager": map[string]any{"name": "b"}},
+		"staff": []any{},
+		"tree":  map[string]any{"value": 1, "children": []any{map[string]any{"value": 2}}},
+	}
+	if err := Validate(s, valid); err != nil {
+		t.Errorf("Validate(valid) = %v", err)
+	}
+	valid["tree"].(map[string]any)["children"] = []any{map[string]any{"value": "x"}}
+	if err := Validate(s, valid); err == nil {
+		t.Error("Validate should follow $ref into nested recursive values")
+	}
+}
+
+type digest struct {
+	Sum  [4]byte `json:"sum"`
+	Data []byte  `json:"data"`
+}
+
+func TestReflectByteArray(t *testing.T) {
+	s := MustFor[digest]()
+	p := props(t, s)
+	if p["sum"]["type"] != "array" || p["sum"]["minItems"] != 4 || p["sum"]["maxItems"] != 4 {
+		t.Errorf("sum = %v, want array of 4 items", p["sum"])
+	}
+	if items := p["sum"]["items"].(map[string]any); items["type"] != "integer" {
+		t.Errorf("sum items = %v", items)
+	}
+	if p["data"]["type"] != "string" || p["data"]["contentEncoding"] != "base64" {
+		t.Errorf("data = %v, want base64 string", p["data"])
+	}
+	if err := Validate(s, digest{Sum: [4]byte{1, 2, 3, 4}, Data: []byte("x")}); err != nil {
+		t.Errorf("Validate(marshaled value) = %v", err)
+	}
+}
+
+type base struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+type audit struct {
+	Kind  string `json:"kind"`
+	Actor string `json:"Actor"`
+}
+
+type labels struct {
+	Actor int
+}
+
+type record struct {
+	*base
+	audit
+	labels
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type clash struct {
+	Left  string
+	Right string `json:"right"`
+}
+
+type clash2 struct {
+	Left string
+}
+
+type ambiguous struct {
+	clash
+	clash2
+	Right int `json:"right"`
+}
+
+func TestReflectEmbedded(t *testing.T) {
+	s := MustFor[record]()
+	p := props(t, s)
+	for _, name := range []string{"id", "kind", "Actor", "name"} {
+		if _, ok := p[name]; !ok {
+			t.Errorf("missing property %q", name)
+		}
+	}
+	if len(p) != 4 {
+		t.Errorf("properties = %v, want id, kind, Actor, and name", p)
+	}
+	// The tagged Actor beats the untagged one at the same depth.
+	if p["Actor"]["type"] != "string" {
+		t.Errorf("Actor = %v, want the tagged string field", p["Actor"])
+	}
+	// The outer kind dominates the promoted ones; id is promoted through
+	// a pointer, so json.Marshal omits it when the pointer is nil.
+	required := s["required"].([]string)
+	if strings.Join(required, ",") != "Actor,name,kind" {
+		t.Errorf("required = %v, want Actor,name,kind", required)
+	}
+
+	if err := Validate(s, record{Name: "n", Kind: "k"}); err != nil {
+		t.Errorf("Validate(nil embedded pointer) = %v", err)
+	}
+	if err := Validate(s, record{base: &base{ID: "1"}, Name: "n"}); err != nil {
+		t.Errorf("Validate(set embedded pointer) = %v", err)
+	}
+
+	// Left is ambiguous at the same depth and dropped, as encoding/json
+	// does; the shallower right wins.
+	p = props(t, MustFor[ambiguous]())
+	if _, ok := p["Left"]; ok {
+		t.Errorf("ambiguous field Left = %v, want dropped", p["Left"])
+	}
+	if p["right"]["type"] != "integer" || len(p) != 1 {
+		t.Errorf("properties = %v, want only integer right", p)
+	}
+	data, _ := json.Marshal(ambiguous{clash: clash{Left: "a"}, clash2: clash2{Left: "b"}})
+	if string(data) != `{"right":0}` {
+		t.Errorf("json.Marshal = %s, schema assumptions no longer hold", data)
+	}
+}
+
+func TestReflectErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"chan", struct {
+			C chan int `json:"c"`
+		}{}},
+		{"func", struct {
+			F func() `json:"f"`
+		}{}},
+		{"unknown rule", struct {
+			A string `json:"a" jsonschema:"bogus=1"`
+		}{}},
+		{"bad minimum", struct {
+			A int `json:"a" jsonschema:"minimum=x"`
+		}{}},
+		{"bad enum", struct {
+			A int `json:"a" jsonschema:"enum=1|two"`
+		}{}},
+		{"map key", map[[2]int]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Of(tt.v); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := Of(nil); err == nil {
+		t.Error("Of(nil) should fail")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("MustFor should panic on unsupported types")
+		}
+	}()
+	MustFor[chan int]()
+}
+
+func TestValidate(t *testing.T) {
+	s := MustFor[ticket]()
+	base := func() map[string]any {
+		return map[string]any{
+			"created":  "2025-01-01T00:00:00Z",
+			"title":    "Fix it",
+			"priority": "high",
+			"estimate": 3,
+			"owner":    nil,
+			"ptr":      "x",
+			"Done":     false,
+		}
+	}
+	if err := Validate(s, base()); err != nil {
+		t.Fatalf("Validate(valid) = %v", err)
+	}
+
+	// Go values are normalized through encoding/json.
+	ptr := "x"
+	if err := Validate(s, ticket{Title: "t", Priority: "low", Estimate: 1, Ptr: &ptr}); err != nil {
+		t.Errorf("Validate(struct) = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(map[string]any)
+		path   string
+	}{
+		{"missing required", func(m map[string]any) { delete(m, "title") }, "$"},
+		{"enum", func(m map[string]any) { m["priority"] = "urgent" }, "$.priority"},
+		{"maximum", func(m map[string]any) { m["estimate"] = 20 }, "$.estimate"},
+		{"integer", func(m map[string]any) { m["estimate"] = 2.5 }, "$.estimate"},
+		{"exclusiveMaximum", func(m map[string]any) { m["ratio"] = 1 }, "$.ratio"},
+		{"type", func(m map[string]any) { m["title"] = 5 }, "$.title"},
+		{"maxItems", func(m map[string]any) { m["labels"] = []string{"a", "b", "c", "d"} }, "$.labels"},
+		{"pattern", func(m map[string]any) { m["labels"] = []string{"ok", "NOT"} }, "$.labels[1]"},
+		{"nested", func(m map[string]any) { m["owner"] = map[string]any{"street": "x"} }, "$.owner"},
+		{"additional", func(m map[string]any) { m["unknown"] = true }, "$"},
+		{"map values", func(m map[string]any) { m["attrs"] = map[string]any{"a": "x"} }, "$.attrs.a"},
+		{"uint minimum", func(m map[string]any) { m["count"] = -1 }, "$.count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := base()
+			tt.mutate(v)
+			err := Validate(s, v)
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() = %v, want *ValidationError", err)
+			}
+			if verr.Path != tt.path {
+				t.Errorf("Path = %q, want %q (%v)", verr.Path, tt.path, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeywords(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema map[string]any
+		value  any
+		ok     bool
+	}{
+		{"type list", map[string]any{"type": []string{"string", "null"}}, nil, true},
+		{"type list mismatch", map[string]any{"type": []string{"string", "null"}}, 1, false},
+		{"minLength", map[string]any{"type": "string", "minLength": 3}, "ab", false},
+		{"maxLength runes", map[string]any{"type": "string", "maxLength": 2}, "éé", true},
+		{"exclusiveMinimum", map[string]any{"exclusiveMinimum": 0}, 0, false},
+		{"multipleOf", map[string]any{"multipleOf": 0.5}, 1.5, true},
+		{"multipleOf mismatch", map[string]any{"multipleOf": 2}, 3, false},
+		{"uniqueItems", map[string]any{"uniqueItems": true}, []int{1, 1}, false},
+		{"minItems", map[string]any{"minItems": 1}, []int{}, false},
+		{"minProperties", map[string]any{"minProperties": 1}, map[string]any{}, false},
+		{"unresolvable ref", map[string]any{"$ref": "#/$defs/missing"}, 1, false},
+		{"empty schema", map[string]any{}, []any{1, "x"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.schema, tt.value)
+			if (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+
+	if err := Validate(map[string]any{"x": make(chan int)}, 1); err == nil {
+		t.Error("unmarshalable schema should fail")
+	}
+}
@@ -0,0 +1,306 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError reports where a value does not match a schema.
+type ValidationError struct {
+	// Path locates the value, e.g. "$.items[2].name".
+	Path string
+
+	// Message describes the mismatch.
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks v against schema.
+//
+// Both arguments may be any JSON-marshalable value: Go structs and typed
+// slices are normalized through encoding/json first. Validate supports the
+// keywords Reflect emits (type, properties, required, additionalProperties,
+// items, enum, numeric and length bounds, pattern, $ref to "#" and
+// "#/$defs/...", allOf) and ignores the rest. It returns a
+// *ValidationError for the first mismatch found.
+func Validate(schema, v any) error {
+	var s map[string]any
+	if err := normalize(schema, &s); err != nil {
+		return fmt.Errorf("schema: %w", err)
+	}
+	var value any
+	if err := normalize(v, &value); err != nil {
+		return fmt.Errorf("schema: value: %w", err)
+	}
+	return (&validator{root: s}).validate(s, value, "$")
+}
+
+func normalize(in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+type validator struct {
+	root map[string]any
+}
+
+func (vd *validator) fail(path, format string, args ...any) error {
+	return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+func (vd *validator) resolve(ref string) (map[string]any, bool) {
+	if ref == "#" {
+		return vd.root, true
+	}
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, false
+	}
+	defs, _ := vd.root["$defs"].(map[string]any)
+	s, ok := defs[name].(map[string]any)
+	return s, ok
+}
+
+func (vd *validator) validate(s map[string]any, v any, path string) error {
+	if len(s) == 0 {
+		return nil
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		target, ok := vd.resolve(ref)
+		if !ok {
+			return vd.fail(path, "unresolvable $ref %q", ref)
+		}
+		if err := vd.validate(target, v, path); err != nil {
+			return err
+		}
+	}
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			subSchema, _ := sub.(map[string]any)
+			if err := vd.validate(subSchema, v, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return vd.fail(path, "%v is not one of %v", v, enum)
+		}
+	}
+
+	switch typ := s["type"].(type) {
+	case string:
+		if !matchesType(typ, v) {
+			return vd.fail(path, "expected %s, got %s", typ, kindOf(v))
+		}
+	case []any:
+		ok := false
+		for _, t := range typ {
+			if name, _ := t.(string); matchesType(name, v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return vd.fail(path, "expected one of %v, got %s", typ, kindOf(v))
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		return vd.validateObject(s, val, path)
+	case []any:
+		return vd.validateArray(s, val, path)
+	case string:
+		return vd.validateString(s, val, path)
+	case float64:
+		return vd.validateNumber(s, val, path)
+	}
+	return nil
+}
+
+func (vd *validator) validateObject(s map[string]any, obj map[string]any, path string) error {
+	required := map[string]bool{}
+	if req, ok := s["required"].([]any); ok {
+		for _, r := range req {
+			name, _ := r.(string)
+			if _, ok := obj[name]; !ok {
+				return vd.fail(path, "missing required property %q", name)
+			}
+			required[name] = true
+		}
+	}
+	if n, ok := s["minProperties"].(float64); ok && float64(len(obj)) < n {
+		return vd.fail(path, "has %d properties, fewer than minProperties %v", len(obj), n)
+	}
+	if n, ok := s["maxProperties"].(float64); ok && float64(len(obj)) > n {
+		return vd.fail(path, "has %d properties, more than maxProperties %v", len(obj), n)
+	}
+
+	props, _ := s["properties"].(map[string]any)
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := path + "." + k
+		if ps, ok := props[k].(map[string]any); ok {
+			if obj[k] == nil && !required[k] {
+				// Optional properties may be sent as null.
+				continue
+			}
+			if err := vd.validate(ps, obj[k], child); err != nil {
+				return err
+			}
+			continue
+		}
+		switch extra := s["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return vd.fail(path, "unexpected property %q", k)
+			}
+		case map[string]any:
+			if err := vd.validate(extra, obj[k], child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (vd *validator) validateArray(s map[string]any, arr []any, path string) error {
+	if n, ok := s["minItems"].(float64); ok && float64(len(arr)) < n {
+		return vd.fail(path, "has %d items, fewer than minItems %v", len(arr), n)
+	}
+	if n, ok := s["maxItems"].(float64); ok && float64(len(arr)) > n {
+		return vd.fail(path, "has %d items, more than maxItems %v", len(arr), n)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					return vd.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	if items, ok := s["items"].(map[string]any); ok {
+		for i, item := range arr {
+			if err := vd.validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (vd *validator) validateString(s map[string]any, str, path string) error {
+	n := float64(utf8.RuneCountInString(str))
+	if min, ok := s["minLength"].(float64); ok && n < min {
+		return vd.fail(path, "length %v is less than minLength %v", n, min)
+	}
+	if max, ok := s["maxLength"].(float64); ok && n > max {
+		return vd.fail(path, "length %v is greater than maxLength %v", n, max)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return vd.fail(path, "invalid pattern %q: %v", pattern, err)
+		}
+		if !re.MatchString(str) {
+			return vd.fail(path, "%q does not match pattern %q", str, pattern)
+		}
+	}
+	return nil
+}
+
+func (vd *validator) validateNumber(s map[string]any, n float64, path string) error {
+	if min, ok := s["minimum"].(float64); ok && n < min {
+		return vd.fail(path, "%v is less than minimum %v", n, min)
+	}
+	if max, ok := s["maximum"].(float64); ok && n > max {
+		return vd.fail(path, "%v is greater than maximum %v", n, max)
+	}
+	if min, ok := s["exclusiveMinimum"].(float64); ok && n <= min {
+		return vd.fail(path, "%v is not greater than exclusiveMinimum %v", n, min)
+	}
+	if max, ok := s["exclusiveMaximum"].(float64); ok && n >= max {
+		return vd.fail(path, "%v is not less than exclusiveMaximum %v", n, max)
+	}
+	if m, ok := s["multipleOf"].(float64); ok && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			return vd.fail(path, "%v is not a multiple of %v", n, m)
+		}
+	}
+	return nil
+}
+
+func matchesType(typ string, v any) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "null":
+		return v == nil
+	default:
+		return true
+	}
+}
+
+// kindOf names the JSON type of a generic decoded value.
+func kindOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
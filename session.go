@@ -43,8 +43,19 @@ type Session struct {
 
 	// Text receives extracted text content.
 	// Closed when the session ends.
+	//
+	// Text is best-effort: SessionConfig.Delivery applies to Messages
+	// only, and text that does not fit in the buffer is dropped and
+	// counted in SessionMetrics.DroppedText. For lossless text, read
+	// Messages and use ExtractText. Unused by the Collect methods.
 	Text chan string
 
+	// TextDeltas receives incremental text as it is generated, for
+	// token-by-token rendering. Only populated when IncludePartialMessages
+	// is set; Text still receives each complete text block.
+	// Best-effort like Text. Closed when the session ends.
+	TextDeltas chan string
+
 	// Errors receives non-fatal errors (parse errors, etc.).
 	// Closed when the session ends.
 	Errors chan error
@@ -52,11 +63,35 @@ type Session struct {
 	launcher *Launcher
 	config   SessionConfig
 
+	// attempt is the Session running the current try under a RetryPolicy.
+	attempt *Session
+
+	// trace records spans when SessionConfig.Tracer is set.
+	trace *sessionTrace
+
+	// queue feeds Messages under DeliveryUnbounded.
+	queue *queue[StreamMessage]
+
+	// collecting is set by the Collect methods, which read Messages only,
+	// so Text and TextDeltas are not fed.
+	collecting bool
+
+	// stop is closed on Kill or context cancellation to release a reader
+	// blocked on delivery.
+	stop     chan struct{}
+	stopOnce sync.Once
+
 	mu      sync.Mutex
 	closed  bool
 	done    chan struct{}
 	err     error
 	metrics SessionMetrics
+	dropped droppedCounts
+}
+
+// droppedCounts tracks values discarded because a channel was full.
+type droppedCounts struct {
+	messages, text, errors int
 }
 
 // NewSession creates a new Session with the given configuration.
@@ -68,18 +103,27 @@ func NewSession(cfg SessionConfig) (*Session, error) {
 		bufSize = 100
 	}
 
+	if !cfg.Delivery.valid() {
+		return nil, fmt.Errorf("claude: unknown delivery policy %q", cfg.Delivery)
+	}
+	if r := cfg.Retry; r != nil && (r.MaxAttempts < 0 || r.Jitter < 0 || r.Jitter > 1) {
+		return nil, fmt.Errorf("claude: invalid retry policy: MaxAttempts %d, Jitter %v", r.MaxAttempts, r.Jitter)
+	}
+
 	id := cfg.ID
 	if id == "" {
 		id = fmt.Sprintf("session-%d", time.Now().UnixNano())
 	}
 
 	return &Session{
-		ID:       id,
-		Messages: make(chan StreamMessage, bufSize),
-		Text:     make(chan string, bufSize),
-		Errors:   make(chan error, 10),
-		config:   cfg,
-		done:     make(chan struct{}),
+		ID:         id,
+		Messages:   make(chan StreamMessage, bufSize),
+		Text:       make(chan string, bufSize),
+		TextDeltas: make(chan string, bufSize),
+		Errors:     make(chan error, 10),
+		config:     cfg,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
 	}, nil
 }
 
@@ -100,42 +144,82 @@ func (s *Session) Run(ctx context.Context, prompt string) error {
 	s.mu.Unlock()
 
 	s.launcher = NewLauncher()
+	if s.config.Tracer != nil {
+		s.trace = startTrace(ctx, s.config.Tracer, s.ID, s.config.LaunchOptions)
+	}
 
-	if err := s.launcher.Start(ctx, prompt, s.config.LaunchOptions); err != nil {
+	opts := s.config.LaunchOptions
+	if opts.Logger != nil {
+		opts.Logger = opts.Logger.With(logKeySession, s.ID)
+	}
+	if err := s.launcher.Start(ctx, prompt, opts); err != nil {
+		s.trace.finish(err)
 		s.close()
 		return err
 	}
 
-	// Start reading goroutine
-	go s.readLoop()
+	// Run is single-shot: with stream-json input the prompt has already been
+	// written, so close stdin to let Claude exit when the turn completes.
+	if s.config.InputFormat == InputFormatStreamJSON {
+		if err := s.launcher.CloseInput(); err != nil {
+			s.sendError(err)
+		}
+	}
 
-	// Start waiter goroutine
-	go s.waitLoop()
+	if s.config.Delivery == DeliveryUnbounded {
+		s.mu.Lock()
+		s.queue = newQueue(s.Messages, s.stop)
+		s.mu.Unlock()
+	}
+
+	// Release a reader blocked on delivery if the caller gives up.
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.abort()
+		case <-s.done:
+		}
+	}()
+
+	// Start reading goroutine; it reaps the process once stdout hits EOF.
+	go s.readLoop()
 
 	return nil
 }
 
 // readLoop reads messages from the launcher and dispatches to channels.
+// At EOF it waits for the process so Err is set before channels close.
 func (s *Session) readLoop() {
 	defer s.close()
+	defer s.wait()
 
 	for {
 		msg, err := s.launcher.ReadMessage()
 		if err != nil {
 			s.sendError(err)
-			continue
+			if skippable(err) {
+				continue
+			}
+			break // stdout is unreadable
 		}
 		if msg == nil {
 			break // EOF
 		}
 
+		s.trace.observe(msg)
 		s.sendMessage(*msg)
 
-		// Only send text from assistant messages to avoid duplicates.
-		// Result messages repeat the same text content.
-		if msg.Type == "assistant" {
-			if text := ExtractText(msg); text != "" {
-				s.sendText(text)
+		if !s.collecting {
+			// Only send text from assistant messages to avoid duplicates.
+			// Result messages repeat the same text content.
+			if msg.Type == "assistant" {
+				if text := ExtractText(msg); text != "" {
+					s.sendText(text)
+				}
+			}
+
+			if delta := ExtractTextDelta(msg); delta != "" {
+				s.sendTextDelta(delta)
 			}
 		}
 
@@ -143,6 +227,12 @@ func (s *Session) readLoop() {
 		if msg.Type == "result" {
 			m := metricsFromMessage(msg)
 			s.mu.Lock()
+			if m.SessionID == "" {
+				m.SessionID = s.metrics.SessionID
+			}
+			if m.Model == "" {
+				m.Model = s.metrics.Model
+			}
 			s.metrics = m
 			s.mu.Unlock()
 		}
@@ -157,22 +247,58 @@ func (s *Session) readLoop() {
 	}
 }
 
-// waitLoop waits for the launcher to exit and captures the error.
-func (s *Session) waitLoop() {
+// wait waits for the launcher to exit and captures the error.
+//
+// Must run after stdout is drained: the exec transport closes its pipes
+// once the process is reaped.
+func (s *Session) wait() {
 	err := s.launcher.Wait()
 
 	s.mu.Lock()
 	s.err = err
 	s.mu.Unlock()
+	s.trace.finish(err)
 }
 
-// sendMessage sends a message to the Messages channel without blocking.
+// sendMessage delivers a message to the Messages channel according to
+// the session's DeliveryPolicy.
 func (s *Session) sendMessage(msg StreamMessage) {
-	select {
-	case s.Messages <- msg:
+	switch s.config.Delivery {
+	case DeliveryBlock:
+		select {
+		case s.Messages <- msg:
+		case <-s.stop:
+			s.countDrop(&s.dropped.messages)
+		}
+
+	case DeliveryDropOldest:
+		for {
+			select {
+			case s.Messages <- msg:
+				return
+			default:
+			}
+			// Make room; the consumer may have taken one meanwhile.
+			select {
+			case <-s.Messages:
+				s.countDrop(&s.dropped.messages)
+			default:
+			}
+		}
+
+	case DeliveryUnbounded:
+		if !s.queue.push(msg) {
+			s.countDrop(&s.dropped.messages)
+		}
+
 	default:
-		// Buffer full, drop message
-		s.sendError(fmt.Errorf("message channel buffer full, dropping message"))
+		select {
+		case s.Messages <- msg:
+		default:
+			// Buffer full, drop message
+			s.countDrop(&s.dropped.messages)
+			s.sendError(fmt.Errorf("message channel buffer full, dropping message"))
+		}
 	}
 }
 
@@ -182,6 +308,17 @@ func (s *Session) sendText(text string) {
 	case s.Text <- text:
 	default:
 		// Buffer full, drop
+		s.countDrop(&s.dropped.text)
+	}
+}
+
+// sendTextDelta sends a partial text increment without blocking.
+func (s *Session) sendTextDelta(delta string) {
+	select {
+	case s.TextDeltas <- delta:
+	default:
+		// Buffer full, drop
+		s.countDrop(&s.dropped.text)
 	}
 }
 
@@ -191,6 +328,30 @@ func (s *Session) sendError(err error) {
 	case s.Errors <- err:
 	default:
 		// Buffer full, drop
+		s.countDrop(&s.dropped.errors)
+	}
+}
+
+// countDrop increments a dropped-value counter.
+func (s *Session) countDrop(n *int) {
+	s.mu.Lock()
+	*n++
+	s.mu.Unlock()
+}
+
+// abort releases a reader blocked on delivery. Undelivered messages are
+// counted as dropped.
+func (s *Session) abort() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// stopped reports whether abort has been called.
+func (s *Session) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -205,8 +366,14 @@ func (s *Session) close() {
 	s.closed = true
 
 	close(s.done)
-	close(s.Messages)
+	if s.queue != nil {
+		// The queue closes Messages once buffered messages are consumed.
+		s.queue.close()
+	} else {
+		close(s.Messages)
+	}
 	close(s.Text)
+	close(s.TextDeltas)
 	close(s.Errors)
 }
 
@@ -239,11 +406,38 @@ func (s *Session) Wait() error {
 func (s *Session) CurrentMetrics() SessionMetrics {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.metrics
+	m := s.metrics
+	m.DroppedMessages = s.dropped.messages
+	m.DroppedText = s.dropped.text
+	m.DroppedErrors = s.dropped.errors
+	return m
+}
+
+// currentAttempt returns the Session running the current retry attempt,
+// or nil when no RetryPolicy is in use.
+func (s *Session) currentAttempt() *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempt
+}
+
+// stopRetrying stops a RetryPolicy run from starting another attempt and
+// returns the current one, or nil when no RetryPolicy run has started.
+func (s *Session) stopRetrying() *Session {
+	if s.currentAttempt() == nil {
+		return nil
+	}
+	s.abort()
+	// Read the attempt again: runWithRetry checks for the stop after
+	// publishing each attempt, so one started since is returned here.
+	return s.currentAttempt()
 }
 
 // Interrupt sends SIGINT to Claude for graceful shutdown.
 func (s *Session) Interrupt() error {
+	if a := s.currentAttempt(); a != nil {
+		return a.Interrupt()
+	}
 	if s.launcher == nil {
 		return ErrNotStarted
 	}
@@ -251,13 +445,38 @@ func (s *Session) Interrupt() error {
 }
 
 // Kill forcefully terminates Claude.
+//
+// Messages not yet delivered under DeliveryBlock or DeliveryUnbounded
+// are discarded. Under a RetryPolicy no further attempts are made, and
+// RunAndCollect returns ErrSessionClosed if it was waiting to retry.
 func (s *Session) Kill() error {
+	if a := s.stopRetrying(); a != nil {
+		return a.Kill()
+	}
 	if s.launcher == nil {
 		return ErrNotStarted
 	}
+	s.abort()
 	return s.launcher.Kill()
 }
 
+// Shutdown stops Claude gracefully: it sends SIGINT, gives Claude up to
+// grace to write its result message and exit, and then kills the process
+// group, as Launcher.Shutdown does. Messages emitted during the grace
+// period are delivered as usual.
+//
+// Returns nil once Claude has exited, or ctx.Err() if ctx ends first.
+// Under a RetryPolicy no further attempts are made, as with Kill.
+func (s *Session) Shutdown(ctx context.Context, grace time.Duration) error {
+	if a := s.stopRetrying(); a != nil {
+		return a.Shutdown(ctx, grace)
+	}
+	if s.launcher == nil {
+		return ErrNotStarted
+	}
+	return s.launcher.shutdown(ctx, grace, s.abort)
+}
+
 // CollectAll runs a prompt and returns all text output.
 //
 // This is a convenience method for simple request-response patterns.
@@ -272,27 +491,35 @@ func (s *Session) Kill() error {
 //	}
 //	fmt.Println(text) // "2+2 = 4"
 func (s *Session) CollectAll(ctx context.Context, prompt string) (string, error) {
+	s.collecting = true
 	if err := s.Run(ctx, prompt); err != nil {
 		return "", err
 	}
 
+	// Text is built from Messages, which unlike Text honors the
+	// DeliveryPolicy.
 	var builder strings.Builder
+	collectText := func(msg StreamMessage) {
+		if msg.Type == "assistant" {
+			builder.WriteString(ExtractText(&msg))
+		}
+	}
 
 	for {
 		select {
-		case text, ok := <-s.Text:
+		case msg, ok := <-s.Messages:
 			if !ok {
 				return builder.String(), s.Err()
 			}
-			builder.WriteString(text)
+			collectText(msg)
 
 		case <-s.Errors:
 			// Non-fatal errors are ignored in collect mode
 
 		case <-s.Done():
-			// Drain remaining text
-			for text := range s.Text {
-				builder.WriteString(text)
+			// Drain remaining messages
+			for msg := range s.Messages {
+				collectText(msg)
 			}
 			return builder.String(), s.Err()
 
@@ -308,6 +535,7 @@ func (s *Session) CollectAll(ctx context.Context, prompt string) (string, error)
 // Similar to CollectAll but returns the full StreamMessage slice
 // for access to metadata, tool calls, and other structured data.
 func (s *Session) CollectMessages(ctx context.Context, prompt string) ([]StreamMessage, error) {
+	s.collecting = true
 	if err := s.Run(ctx, prompt); err != nil {
 		return nil, err
 	}
@@ -376,18 +604,33 @@ type Result struct {
 
 	// Metrics is the full session metrics snapshot.
 	Metrics SessionMetrics
+
+	// Attempts is the number of CLI runs it took to produce this result:
+	// 1, or more when a RetryPolicy retried.
+	Attempts int
 }
 
 // RunAndCollect runs a prompt and returns a comprehensive Result.
 //
 // This is the most complete collection method, returning text, messages,
 // and all available metadata including cost, tokens, and structured output.
+//
+// If the run ends with an error result message (e.g. subtype
+// "error_max_turns"), the error is a *ResultError wrapping any process
+// error, and the Result holds everything collected up to that point.
+//
+// With SessionConfig.Retry set, failed attempts are retried according to
+// the policy and the Result is from the last attempt.
 func (s *Session) RunAndCollect(ctx context.Context, prompt string) (*Result, error) {
+	if s.config.Retry != nil {
+		return s.runWithRetry(ctx, prompt)
+	}
+	s.collecting = true
 	if err := s.Run(ctx, prompt); err != nil {
 		return nil, err
 	}
 
-	result := &Result{}
+	result := &Result{Attempts: 1}
 	var textBuilder strings.Builder
 	startTime := time.Now()
 
@@ -398,45 +641,9 @@ func (s *Session) RunAndCollect(ctx context.Context, prompt string) (*Result, er
 				result.Duration = time.Since(startTime)
 				result.Text = textBuilder.String()
 				result.Metrics = s.CurrentMetrics()
-				return result, s.Err()
-			}
-
-			result.Messages = append(result.Messages, msg)
-
-			// Only collect text from assistant messages to avoid duplicates.
-			if msg.Type == "assistant" {
-				if text := ExtractText(&msg); text != "" {
-					textBuilder.WriteString(text)
-				}
-			}
-
-			// Capture metadata from result message
-			if msg.Type == "result" {
-				result.TotalCost = msg.TotalCost
-				result.CostUSD = msg.CostUSD
-				if msg.SessionID != "" {
-					result.SessionID = msg.SessionID
-				}
-				if msg.Model != "" {
-					result.Model = msg.Model
-				}
-				result.NumTurns = msg.NumTurns
-				result.Usage = msg.Usage
-				result.StructuredOutput = msg.StructuredOutput
-				if msg.DurationAPIMS > 0 {
-					result.DurationAPI = time.Duration(msg.DurationAPIMS) * time.Millisecond
-				}
-			}
-
-			// Capture session info from system init message
-			if msg.Type == "system" && msg.Subtype == "init" {
-				if msg.SessionID != "" {
-					result.SessionID = msg.SessionID
-				}
-				if msg.Model != "" {
-					result.Model = msg.Model
-				}
+				return result, result.withErr(s.Err())
 			}
+			result.collect(msg, &textBuilder)
 
 		case <-s.Errors:
 			// Ignore non-fatal errors
@@ -444,27 +651,12 @@ func (s *Session) RunAndCollect(ctx context.Context, prompt string) (*Result, er
 		case <-s.Done():
 			// Drain remaining
 			for msg := range s.Messages {
-				result.Messages = append(result.Messages, msg)
-				if msg.Type == "assistant" {
-					if text := ExtractText(&msg); text != "" {
-						textBuilder.WriteString(text)
-					}
-				}
-				if msg.Type == "result" {
-					result.TotalCost = msg.TotalCost
-					result.CostUSD = msg.CostUSD
-					result.NumTurns = msg.NumTurns
-					result.Usage = msg.Usage
-					result.StructuredOutput = msg.StructuredOutput
-					if msg.DurationAPIMS > 0 {
-						result.DurationAPI = time.Duration(msg.DurationAPIMS) * time.Millisecond
-					}
-				}
+				result.collect(msg, &textBuilder)
 			}
 			result.Duration = time.Since(startTime)
 			result.Text = textBuilder.String()
 			result.Metrics = s.CurrentMetrics()
-			return result, s.Err()
+			return result, result.withErr(s.Err())
 
 		case <-ctx.Done():
 			s.Kill()
@@ -475,3 +667,89 @@ func (s *Session) RunAndCollect(ctx context.Context, prompt string) (*Result, er
 		}
 	}
 }
+
+// Err returns a *ResultError if the run ended with an error result
+// message, or nil if it succeeded or produced no result message.
+//
+// Process and context errors are not included; use the error returned
+// alongside the Result for those.
+func (r *Result) Err() error {
+	if re := r.resultError(); re != nil {
+		return re
+	}
+	return nil
+}
+
+// resultError builds the ResultError for the last result message, if it
+// reports a failure.
+func (r *Result) resultError() *ResultError {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		msg := &r.Messages[i]
+		if msg.Type != "result" {
+			continue
+		}
+		if !msg.IsErrorResult && (msg.Subtype == "" || msg.Subtype == "success") {
+			return nil
+		}
+		return &ResultError{
+			Subtype:      msg.Subtype,
+			Message:      msg.Result,
+			NumTurns:     msg.NumTurns,
+			TotalCostUSD: msg.TotalCost,
+			Text:         r.Text,
+		}
+	}
+	return nil
+}
+
+// withErr returns the error for a finished run: the result's
+// *ResultError wrapping err, or err itself when the result succeeded.
+func (r *Result) withErr(err error) error {
+	re := r.resultError()
+	if re == nil {
+		return err
+	}
+	re.Err = err
+	return re
+}
+
+// collect folds a message into the result. Text is accumulated in text
+// rather than on the Result so callers can finalize it once.
+func (r *Result) collect(msg StreamMessage, text *strings.Builder) {
+	r.Messages = append(r.Messages, msg)
+
+	// Only collect text from assistant messages to avoid duplicates.
+	if msg.Type == "assistant" {
+		if t := ExtractText(&msg); t != "" {
+			text.WriteString(t)
+		}
+	}
+
+	// Capture metadata from result message
+	if msg.Type == "result" {
+		r.TotalCost = msg.TotalCost
+		r.CostUSD = msg.CostUSD
+		if msg.SessionID != "" {
+			r.SessionID = msg.SessionID
+		}
+		if msg.Model != "" {
+			r.Model = msg.Model
+		}
+		r.NumTurns = msg.NumTurns
+		r.Usage = msg.Usage
+		r.StructuredOutput = msg.StructuredOutput
+		if msg.DurationAPIMS > 0 {
+			r.DurationAPI = time.Duration(msg.DurationAPIMS) * time.Millisecond
+		}
+	}
+
+	// Capture session info from system init message
+	if msg.Type == "system" && msg.Subtype == "init" {
+		if msg.SessionID != "" {
+			r.SessionID = msg.SessionID
+		}
+		if msg.Model != "" {
+			r.Model = msg.Model
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package claude
+
+import "encoding/json"
+
+// CompactBoundary is the payload of a "system" message with subtype
+// "compact_boundary", emitted where the CLI compacted the conversation.
+// Messages before it were replaced by a summary.
+type CompactBoundary struct {
+	// Trigger is "manual" for /compact or "auto" when the context filled up.
+	Trigger string `json:"trigger"`
+
+	// PreTokens is the context size before compaction.
+	PreTokens int `json:"pre_tokens"`
+
+	// PostTokens is the context size after compaction, if reported.
+	PostTokens int `json:"post_tokens,omitempty"`
+
+	// DurationMS is how long compaction took, if reported.
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+// HookResponse is the payload of a "system" message with subtype
+// "hook_response", emitted when a configured hook command finishes.
+type HookResponse struct {
+	// HookID identifies this hook run.
+	HookID string `json:"hook_id"`
+
+	// HookName is the hook and its matcher, e.g. "SessionStart:startup".
+	HookName string `json:"hook_name"`
+
+	// HookEvent is the event that ran the hook.
+	HookEvent HookEvent `json:"hook_event"`
+
+	// Output is the hook's combined output.
+	Output string `json:"output"`
+
+	// Stdout and Stderr are the hook command's output streams.
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+
+	// ExitCode is the hook command's exit code, if reported.
+	ExitCode int `json:"exit_code,omitempty"`
+
+	// Outcome is "success", "error", or "cancelled".
+	Outcome string `json:"outcome"`
+}
+
+// APIRetry is the payload of a "system" message with subtype "api_retry",
+// emitted when an API request failed with a retryable error and the CLI
+// will retry it after a delay.
+type APIRetry struct {
+	// Attempt is the retry number, starting at 1.
+	Attempt int `json:"attempt"`
+
+	// MaxRetries is how many retries the CLI makes before giving up.
+	MaxRetries int `json:"max_retries"`
+
+	// RetryDelayMS is the delay before the retry.
+	RetryDelayMS int64 `json:"retry_delay_ms"`
+
+	// ErrorStatus is the HTTP status of the failed request, e.g. 529 when
+	// overloaded. Zero for connection errors without a response.
+	ErrorStatus int `json:"error_status"`
+
+	// Error classifies the failure, e.g. "overloaded" or "rate_limit".
+	Error string `json:"error"`
+}
+
+// ExtractCompactBoundary returns the payload of a compact_boundary system
+// message, or nil for any other message.
+func ExtractCompactBoundary(msg *StreamMessage) *CompactBoundary {
+	var fields struct {
+		Metadata *CompactBoundary `json:"compact_metadata"`
+	}
+	if !decodeSystem(msg, "compact_boundary", &fields) || fields.Metadata == nil {
+		return nil
+	}
+	return fields.Metadata
+}
+
+// ExtractHookResponse returns the payload of a hook_response system
+// message, or nil for any other message.
+func ExtractHookResponse(msg *StreamMessage) *HookResponse {
+	var r HookResponse
+	if !decodeSystem(msg, "hook_response", &r) {
+		return nil
+	}
+	return &r
+}
+
+// ExtractAPIRetry returns the payload of an api_retry system message, or
+// nil for any other message.
+func ExtractAPIRetry(msg *StreamMessage) *APIRetry {
+	var r APIRetry
+	if !decodeSystem(msg, "api_retry", &r) {
+		return nil
+	}
+	return &r
+}
+
+// decodeSystem decodes a system message with the given subtype into v,
+// from Raw or, for messages built in Go, from the fields and Extra.
+func decodeSystem(msg *StreamMessage, subtype string, v any) bool {
+	if msg == nil || msg.Type != "system" || msg.Subtype != subtype {
+		return false
+	}
+	data := msg.Raw
+	if len(data) == 0 {
+		var err error
+		if data, err = json.Marshal(msg); err != nil {
+			return false
+		}
+	}
+	return json.Unmarshal(data, v) == nil
+}
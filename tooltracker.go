@@ -0,0 +1,163 @@
+package claude
+
+import (
+	"slices"
+	"time"
+)
+
+// ToolInvocation is a tool call matched with its result.
+type ToolInvocation struct {
+	// ID is the tool_use block ID.
+	ID string
+
+	// Name is the tool name, e.g. "Bash" or "mcp__github__get_issue".
+	Name string
+
+	// Input is the tool arguments.
+	Input map[string]any
+
+	// ParentToolUseID is the Task tool call that ran this call in a
+	// subagent, or empty for top-level calls.
+	ParentToolUseID string
+
+	// Result is the content of the tool_result block. Empty while pending.
+	Result Blocks
+
+	// IsError is true if the tool failed.
+	IsError bool
+
+	// Start is when the tool_use block was seen; End when its result was
+	// seen. End is zero while pending.
+	Start time.Time
+	End   time.Time
+
+	// Duration is End minus Start, or zero while pending.
+	Duration time.Duration
+}
+
+// ResultText returns the text of the tool result.
+func (inv *ToolInvocation) ResultText() string {
+	return inv.Result.Text()
+}
+
+// ToolTracker matches tool_use blocks to their tool_result blocks by
+// ID, producing a ToolInvocation per completed call.
+//
+// Feed every message to Add in order. Calls run by the API rather than
+// the CLI (server_tool_use, such as web search) are matched with their
+// web_search_tool_result blocks the same way.
+//
+// Example:
+//
+//	tracker := claude.NewToolTracker()
+//	for msg := range session.Messages {
+//		for _, inv := range tracker.Add(&msg) {
+//			fmt.Printf("%s took %s (error=%v)\n", inv.Name, inv.Duration, inv.IsError)
+//		}
+//	}
+//	for _, inv := range tracker.Pending() {
+//		fmt.Println("no result:", inv.Name)
+//	}
+//
+// ToolTracker is not safe for concurrent use.
+type ToolTracker struct {
+	now       func() time.Time
+	pending   map[string]*pendingCall
+	started   int
+	completed []ToolInvocation
+}
+
+// pendingCall is a call waiting for its result.
+type pendingCall struct {
+	ToolInvocation
+	seq int // start order
+}
+
+// NewToolTracker creates an empty tracker.
+func NewToolTracker() *ToolTracker {
+	return &ToolTracker{
+		now:     time.Now,
+		pending: make(map[string]*pendingCall),
+	}
+}
+
+// Add records the tool calls in msg and returns the calls its results
+// complete, in result order.
+//
+// Results for calls the tracker has not seen are ignored.
+func (t *ToolTracker) Add(msg *StreamMessage) []ToolInvocation {
+	if msg == nil || msg.Message == nil {
+		return nil
+	}
+	now := t.now()
+
+	var parent string
+	if msg.ParentToolUseID != nil {
+		parent = *msg.ParentToolUseID
+	}
+
+	var done []ToolInvocation
+	for _, c := range msg.Message.Content {
+		switch {
+		case c.IsToolUse() || c.IsServerToolUse():
+			if _, ok := t.pending[c.ID]; ok || c.ID == "" {
+				continue
+			}
+			t.pending[c.ID] = &pendingCall{
+				ToolInvocation: ToolInvocation{
+					ID:              c.ID,
+					Name:            c.Name,
+					Input:           c.Input,
+					ParentToolUseID: parent,
+					Start:           now,
+				},
+				seq: t.started,
+			}
+			t.started++
+
+		case c.IsToolResult() || c.Type == "web_search_tool_result":
+			call, ok := t.pending[c.ToolUseID]
+			if !ok {
+				continue
+			}
+			delete(t.pending, c.ToolUseID)
+			inv := call.ToolInvocation
+			inv.Result = c.Content
+			inv.IsError = c.IsError || isWebSearchError(c.Content)
+			inv.End = now
+			inv.Duration = now.Sub(inv.Start)
+			done = append(done, inv)
+		}
+	}
+
+	t.completed = append(t.completed, done...)
+	return done
+}
+
+// isWebSearchError reports whether web search result content is an error.
+func isWebSearchError(content Blocks) bool {
+	return len(content) == 1 && content[0].Type == "web_search_tool_result_error"
+}
+
+// Completed returns every completed call, in result order.
+func (t *ToolTracker) Completed() []ToolInvocation {
+	return append([]ToolInvocation(nil), t.completed...)
+}
+
+// Pending returns the calls still waiting for a result, in start order.
+//
+// After the session has ended these are the calls that never completed,
+// e.g. because the process was killed or hit its turn limit mid-call.
+func (t *ToolTracker) Pending() []ToolInvocation {
+	calls := make([]*pendingCall, 0, len(t.pending))
+	for _, call := range t.pending {
+		calls = append(calls, call)
+	}
+	slices.SortFunc(calls, func(a, b *pendingCall) int { return a.seq - b.seq })
+
+	var pending []ToolInvocation
+	for _, call := range calls {
+		pending = append(pending, call.ToolInvocation)
+	}
+	return pending
+}
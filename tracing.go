@@ -0,0 +1,450 @@
+package claude
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"sync"
+	"time"
+)
+
+// Tracer starts spans. Set SessionConfig.Tracer to trace sessions.
+//
+// The interface is small enough to adapt to OpenTelemetry in a few lines,
+// keeping the SDK free of tracing dependencies:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string, start time.Time, attrs ...claude.Attribute) (context.Context, claude.Span) {
+//		ctx, span := o.t.Start(ctx, name, trace.WithTimestamp(start))
+//		s := otelSpan{span}
+//		s.SetAttributes(attrs...)
+//		return ctx, s
+//	}
+//
+// NewTracer returns a Tracer that hands finished spans to SpanExporters
+// instead.
+type Tracer interface {
+	// Start starts a span at start, as a child of the span in ctx if
+	// any, and returns a context carrying the new span.
+	Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer.
+type Span interface {
+	// SetAttributes adds or replaces attributes.
+	SetAttributes(attrs ...Attribute)
+
+	// RecordError marks the span as failed.
+	RecordError(err error)
+
+	// End finishes the span at end.
+	End(end time.Time)
+}
+
+// Attribute is a span attribute. Value is a string, bool, int, int64,
+// or float64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span names and attribute keys set by a traced Session. Names and
+// gen_ai.* keys follow the OpenTelemetry semantic conventions for
+// generative AI.
+const (
+	spanSession = "invoke_agent" // root span for Session.Run
+	spanTurn    = "chat"         // one model response, plus its tool calls
+	spanTool    = "execute_tool" // one tool_use until its tool_result
+
+	attrOperation     = "gen_ai.operation.name"
+	attrSystem        = "gen_ai.system"
+	attrRequestModel  = "gen_ai.request.model"
+	attrResponseModel = "gen_ai.response.model"
+	attrResponseID    = "gen_ai.response.id"
+	attrFinishReason  = "gen_ai.response.finish_reasons"
+	attrConversation  = "gen_ai.conversation.id"
+	attrInputTokens   = "gen_ai.usage.input_tokens"
+	attrOutputTokens  = "gen_ai.usage.output_tokens"
+	attrCacheRead     = "gen_ai.usage.cache_read.input_tokens"
+	attrCacheCreation = "gen_ai.usage.cache_creation.input_tokens"
+	attrToolName      = "gen_ai.tool.name"
+	attrToolCallID    = "gen_ai.tool.call.id"
+	attrCostUSD       = "claude.cost_usd"
+	attrNumTurns      = "claude.num_turns"
+	attrResultSubtype = "claude.result.subtype"
+	attrToolError     = "claude.tool.is_error"
+)
+
+// SpanData is a finished span, as given to a SpanExporter.
+type SpanData struct {
+	// TraceID is the 32-hex-digit trace identifier.
+	TraceID string `json:"trace_id"`
+
+	// SpanID is the 16-hex-digit span identifier.
+	SpanID string `json:"span_id"`
+
+	// ParentSpanID is the parent's SpanID, empty for a root span.
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+
+	// Name is the span name.
+	Name string `json:"name"`
+
+	// Start and End bound the span.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Attributes are the span's attributes by key.
+	Attributes map[string]any `json:"attributes,omitempty"`
+
+	// Error is the recorded error message, empty if the span succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// SpanExporter receives spans from a Tracer made by NewTracer as they end.
+// Children end, and are exported, before their parents.
+type SpanExporter interface {
+	ExportSpan(SpanData)
+}
+
+// NewTracer returns a Tracer that generates OpenTelemetry-compatible
+// trace and span IDs and passes each span to exporters when it ends.
+func NewTracer(exporters ...SpanExporter) Tracer {
+	return &exportTracer{exporters: exporters}
+}
+
+type exportTracer struct {
+	exporters []SpanExporter
+}
+
+type spanKey struct{}
+
+func (t *exportTracer) Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span) {
+	s := &exportSpan{
+		tracer: t,
+		data: SpanData{
+			SpanID:     randomHex(8),
+			Name:       name,
+			Start:      start,
+			Attributes: make(map[string]any, len(attrs)),
+		},
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*exportSpan); ok {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentSpanID = parent.data.SpanID
+	} else {
+		s.data.TraceID = randomHex(16)
+	}
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type exportSpan struct {
+	tracer *exportTracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *exportSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.data.Attributes[a.Key] = a.Value
+	}
+}
+
+func (s *exportSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+func (s *exportSpan) End(end time.Time) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	data.Attributes = maps.Clone(data.Attributes)
+	s.mu.Unlock()
+
+	for _, e := range s.tracer.exporters {
+		e.ExportSpan(data)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// InMemoryExporter collects spans in memory, for tests and debugging.
+// The zero value is ready to use.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpan implements SpanExporter.
+func (e *InMemoryExporter) ExportSpan(s SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns the spans exported so far, in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset discards all collected spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONExporter writes each span as a line of JSON, for loading into a
+// collector or analysis tool later.
+type JSONExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	err    error
+}
+
+// NewJSONExporter returns a JSONExporter writing to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+// NewJSONFileExporter returns a JSONExporter appending to the file at
+// path, creating it if needed. Close closes the file.
+func NewJSONFileExporter(path string) (*JSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("claude: open trace file: %w", err)
+	}
+	return &JSONExporter{w: f, closer: f}, nil
+}
+
+// ExportSpan implements SpanExporter. Write errors are returned by Close.
+func (e *JSONExporter) ExportSpan(s SpanData) {
+	data, err := json.Marshal(s)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		_, err = e.w.Write(append(data, '\n'))
+	}
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+}
+
+// Close closes the file opened by NewJSONFileExporter and returns the
+// first error exporting a span.
+func (e *JSONExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err := e.err
+	if e.closer != nil {
+		if cerr := e.closer.Close(); err == nil {
+			err = cerr
+		}
+		e.closer = nil
+	}
+	return err
+}
+
+// sessionTrace turns a Session's messages into spans: a root span for the
+// run, a turn span per assistant message ID, and a tool span per tool_use.
+// Subagent messages (with a ParentToolUseID) are ignored.
+//
+// It is used from the session's read goroutine only.
+type sessionTrace struct {
+	tracer  Tracer
+	pricing Pricing
+	now     func() time.Time
+
+	ctx  context.Context
+	root Span
+
+	turn     Span
+	turnCtx  context.Context
+	turnID   string
+	boundary time.Time // when the current turn's last tool result arrived
+	tools    map[string]Span
+	failed   bool // a failed result was recorded on root
+}
+
+// startTrace starts the root span of a session run.
+func startTrace(ctx context.Context, tracer Tracer, sessionID string, opts LaunchOptions) *sessionTrace {
+	t := &sessionTrace{
+		tracer:  tracer,
+		pricing: DefaultPricing(),
+		now:     time.Now,
+		tools:   make(map[string]Span),
+	}
+	attrs := []Attribute{
+		{attrOperation, "invoke_agent"},
+		{attrSystem, "anthropic"},
+		{"claude.session.id", sessionID},
+	}
+	if opts.Model != "" {
+		attrs = append(attrs, Attribute{attrRequestModel, opts.Model})
+	}
+	t.ctx, t.root = tracer.Start(ctx, spanSession, t.now(), attrs...)
+	t.turnCtx = t.ctx
+	t.boundary = t.now()
+	return t
+}
+
+// observe updates spans for msg.
+func (t *sessionTrace) observe(msg *StreamMessage) {
+	if t == nil || msg.ParentToolUseID != nil {
+		return
+	}
+	now := t.now()
+
+	switch msg.Type {
+	case "system":
+		if msg.Subtype == "init" {
+			t.root.SetAttributes(
+				Attribute{attrConversation, msg.SessionID},
+				Attribute{attrResponseModel, msg.Model},
+			)
+		}
+
+	case "assistant":
+		if msg.Message == nil {
+			return
+		}
+		if t.turn == nil || msg.Message.ID != t.turnID {
+			start := t.boundary
+			if t.turn != nil {
+				t.turn.End(start)
+			}
+			t.turnID = msg.Message.ID
+			t.turnCtx, t.turn = t.tracer.Start(t.ctx, spanTurn, start,
+				Attribute{attrOperation, "chat"},
+				Attribute{attrSystem, "anthropic"},
+			)
+		}
+		t.turnAttributes(msg.Message)
+		for _, c := range msg.Message.Content {
+			if c.IsToolUse() && c.ID != "" {
+				_, t.tools[c.ID] = t.tracer.Start(t.turnCtx, spanTool+" "+c.Name, now,
+					Attribute{attrOperation, "execute_tool"},
+					Attribute{attrToolName, c.Name},
+					Attribute{attrToolCallID, c.ID},
+				)
+			}
+		}
+
+	case "user":
+		if msg.Message == nil {
+			return
+		}
+		for _, c := range msg.Message.Content {
+			span, ok := t.tools[c.ToolUseID]
+			if !c.IsToolResult() || !ok {
+				continue
+			}
+			span.SetAttributes(Attribute{attrToolError, c.IsError})
+			if c.IsError {
+				span.RecordError(errors.New(c.ContentText()))
+			}
+			span.End(now)
+			delete(t.tools, c.ToolUseID)
+			t.boundary = now
+		}
+
+	case "result":
+		attrs := []Attribute{
+			{attrCostUSD, msg.TotalCost},
+			{attrNumTurns, msg.NumTurns},
+			{attrResultSubtype, msg.Subtype},
+		}
+		if msg.SessionID != "" {
+			attrs = append(attrs, Attribute{attrConversation, msg.SessionID})
+		}
+		if u := msg.Usage; u != nil {
+			attrs = append(attrs,
+				Attribute{attrInputTokens, u.InputTokens},
+				Attribute{attrOutputTokens, u.OutputTokens},
+				Attribute{attrCacheRead, u.CacheReadInputTokens},
+				Attribute{attrCacheCreation, u.CacheCreationInputTokens},
+			)
+		}
+		t.root.SetAttributes(attrs...)
+		if re := (&Result{Messages: []StreamMessage{*msg}}).resultError(); re != nil {
+			t.root.RecordError(re)
+			t.failed = true
+		}
+	}
+}
+
+// turnAttributes sets the current turn's model, usage, and estimated cost.
+// Each content block of a response arrives as its own message with the
+// same ID, so later messages overwrite earlier ones.
+func (t *sessionTrace) turnAttributes(m *MessageContent) {
+	attrs := []Attribute{{attrResponseID, m.ID}}
+	if m.Model != "" {
+		attrs = append(attrs, Attribute{attrResponseModel, m.Model})
+	}
+	if m.StopReason != "" {
+		attrs = append(attrs, Attribute{attrFinishReason, m.StopReason})
+	}
+	if u := m.Usage; u != nil {
+		attrs = append(attrs,
+			Attribute{attrInputTokens, u.InputTokens},
+			Attribute{attrOutputTokens, u.OutputTokens},
+			Attribute{attrCacheRead, u.CacheReadInputTokens},
+			Attribute{attrCacheCreation, u.CacheCreationInputTokens},
+		)
+		if price, ok := t.pricing.Lookup(m.Model); ok {
+			cost := price.Cost(u.InputTokens, u.OutputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens)
+			attrs = append(attrs, Attribute{attrCostUSD, cost})
+		}
+	}
+	t.turn.SetAttributes(attrs...)
+}
+
+// finish ends all open spans. err is the run's error, if any; a failed
+// result has already been recorded on the root span.
+func (t *sessionTrace) finish(err error) {
+	if t == nil {
+		return
+	}
+	now := t.now()
+	for id, span := range t.tools {
+		span.RecordError(errors.New("claude: tool call did not complete"))
+		span.End(now)
+		delete(t.tools, id)
+	}
+	if t.turn != nil {
+		t.turn.End(now)
+		t.turn = nil
+	}
+	if err != nil && !t.failed {
+		t.root.RecordError(err)
+	}
+	t.root.End(now)
+}
@@ -0,0 +1,184 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Process is a running Claude CLI process as seen by Launcher.
+//
+// The default implementation wraps os/exec. Alternative implementations
+// (in-memory fakes, remote runners, cassette replays) only need to provide
+// the three stdio streams and basic lifecycle control.
+type Process interface {
+	// Stdin returns the write end of the process's standard input.
+	// Writes must fail, rather than block, once the process has exited.
+	Stdin() io.WriteCloser
+
+	// Stdout returns the process's standard output (stream-json lines).
+	Stdout() io.Reader
+
+	// Stderr returns the process's standard error.
+	Stderr() io.Reader
+
+	// Pid returns the OS process ID, or 0 if not applicable.
+	Pid() int
+
+	// Signal delivers sig to the process.
+	Signal(sig os.Signal) error
+
+	// Kill forcefully terminates the process and, where the platform
+	// allows, the processes it started.
+	Kill() error
+
+	// Wait blocks until the process exits. Launcher only calls Wait after
+	// Stdout has reached EOF or the process was killed.
+	//
+	// A non-zero exit should be reported with an error that implements
+	// ExitCode() int (as *exec.ExitError does) so Launcher can surface it
+	// as an *ExitError.
+	Wait() error
+}
+
+// SpawnConfig describes a CLI process for a Spawner to start.
+type SpawnConfig struct {
+	// Path is the binary to run: LaunchOptions.BinaryPath or DefaultBinary.
+	// May be a bare name to be resolved against PATH.
+	Path string
+
+	// Args are the CLI arguments produced from LaunchOptions.
+	Args []string
+
+	// Env is the complete environment for the process.
+	Env []string
+
+	// Dir is the working directory. Empty means the current directory.
+	Dir string
+}
+
+// Spawner starts Claude CLI processes for a Launcher.
+//
+// Set LaunchOptions.Spawner to swap the process layer, e.g. to run a
+// scripted fake in tests. Nil uses ExecSpawner.
+type Spawner interface {
+	Spawn(ctx context.Context, cfg SpawnConfig) (Process, error)
+}
+
+// ExecSpawner spawns the CLI as a local OS process using os/exec.
+//
+// On Unix the CLI runs in its own process group, and killing it kills the
+// whole group, including Bash tools and MCP servers it started. Any group
+// members still running when the CLI exits are killed as well, so they
+// cannot outlive it or hold its output open.
+//
+// The process group is killed if ctx is cancelled. Returns ErrCLINotFound
+// if cfg.Path cannot be resolved.
+type ExecSpawner struct{}
+
+// Spawn implements Spawner.
+func (ExecSpawner) Spawn(ctx context.Context, cfg SpawnConfig) (Process, error) {
+	path, err := exec.LookPath(cfg.Path)
+	if err != nil {
+		return nil, ErrCLINotFound
+	}
+
+	cmd := exec.CommandContext(ctx, path, cfg.Args...)
+	cmd.Env = cfg.Env
+	cmd.Dir = cfg.Dir
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killGroup(cmd.Process) }
+
+	p := &execProcess{cmd: cmd, exited: make(chan struct{})}
+
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+
+	// Own the read ends of stdout and stderr, rather than using
+	// StdoutPipe, so the process can be reaped before they are drained.
+	stdoutW, stderrW, err := p.pipes()
+	if err != nil {
+		p.stdin.Close()
+		return nil, err
+	}
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		p.stdout.Close()
+		p.stderr.Close()
+		return nil, err
+	}
+
+	go p.reap()
+	return p, nil
+}
+
+// execProcess adapts *exec.Cmd to Process.
+type execProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	stderr *os.File
+
+	exited chan struct{} // closed once the process is reaped
+	err    error         // from cmd.Wait, set before exited is closed
+}
+
+// pipes creates the stdout and stderr pipes, returning their write ends.
+func (p *execProcess) pipes() (stdoutW, stderrW *os.File, err error) {
+	if p.stdout, stdoutW, err = os.Pipe(); err != nil {
+		return nil, nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	if p.stderr, stderrW, err = os.Pipe(); err != nil {
+		p.stdout.Close()
+		stdoutW.Close()
+		return nil, nil, fmt.Errorf("stderr pipe: %w", err)
+	}
+	return stdoutW, stderrW, nil
+}
+
+// reap waits for the CLI to exit, then kills whatever is left in its
+// process group. Group members that inherited stdout or stderr would
+// otherwise keep the pipes open, and Launcher reading them, indefinitely.
+//
+// The group outlives its leader while any member is alive, so its ID
+// cannot be reused before the kill lands.
+func (p *execProcess) reap() {
+	p.err = p.cmd.Wait()
+	killGroup(p.cmd.Process)
+	close(p.exited)
+}
+
+func (p *execProcess) Stdin() io.WriteCloser { return p.stdin }
+func (p *execProcess) Stdout() io.Reader     { return p.stdout }
+func (p *execProcess) Stderr() io.Reader     { return p.stderr }
+func (p *execProcess) Pid() int              { return p.cmd.Process.Pid }
+
+func (p *execProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+// Kill kills the CLI's process group.
+func (p *execProcess) Kill() error {
+	select {
+	case <-p.exited:
+		return os.ErrProcessDone
+	default:
+	}
+	return killGroup(p.cmd.Process)
+}
+
+// Wait waits for the process to be reaped and releases its pipes.
+func (p *execProcess) Wait() error {
+	<-p.exited
+	p.stdout.Close()
+	p.stderr.Close()
+	return p.err
+}
@@ -0,0 +1,90 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/MateoSegura/claudesdk-go/schema"
+)
+
+// RunTyped runs a prompt with structured output and decodes the result
+// into T.
+//
+// If cfg.JSONSchema is nil, the schema is derived from T with
+// schema.Reflect, so json, description, and jsonschema struct tags apply.
+// The structured output is validated against the schema before decoding,
+// so a mismatch surfaces as ErrInvalidOutput instead of a zero field.
+//
+// Example:
+//
+//	type Review struct {
+//		Verdict string   `json:"verdict"`
+//		Issues  []string `json:"issues"`
+//	}
+//
+//	review, result, err := claude.RunTyped[Review](ctx, claude.SessionConfig{
+//		LaunchOptions: claude.LaunchOptions{Model: "sonnet", MaxTurns: 3},
+//	}, "Review main.go")
+//
+// The Result is returned whenever the run completed, even if decoding
+// failed, so cost and messages remain available.
+func RunTyped[T any](ctx context.Context, cfg SessionConfig, prompt string) (T, *Result, error) {
+	var out T
+
+	if cfg.JSONSchema == nil {
+		s, err := typeSchema(reflect.TypeOf((*T)(nil)).Elem())
+		if err != nil {
+			return out, nil, err
+		}
+		cfg.JSONSchema = s
+	}
+
+	session, err := NewSession(cfg)
+	if err != nil {
+		return out, nil, err
+	}
+	result, err := session.RunAndCollect(ctx, prompt)
+	if err != nil {
+		return out, result, err
+	}
+
+	if err := decodeStructuredOutput(result.StructuredOutput, cfg.JSONSchema, &out); err != nil {
+		return out, result, err
+	}
+	return out, result, nil
+}
+
+// decodeStructuredOutput validates output against schema s and decodes
+// it into v.
+func decodeStructuredOutput(output, s, v any) error {
+	if output == nil {
+		return ErrNoStructuredOutput
+	}
+
+	if err := schema.Validate(s, output); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOutput, err)
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOutput, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOutput, err)
+	}
+	return nil
+}
+
+// typeSchema derives a JSON Schema from a Go type. The root must be a
+// struct (or pointer to one) because the CLI requires an object schema.
+func typeSchema(t reflect.Type) (map[string]any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("claude: structured output type must be a struct, got %s", t)
+	}
+	return schema.Reflect(t)
+}